@@ -0,0 +1,199 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// mallocFunction is where heap allocations go, with the size in RAX and the
+// type, nil for memory without pointers of no one type, in RBX.  Since Go
+// 1.26 the compiler calls variants of it specialized for a size class, with
+// the same arguments, for small allocations of known size.
+const mallocFunction = "runtime.mallocgc"
+
+// allocFilter limits a breakpoint on mallocFunction to allocations a source
+// line makes itself: those whose innermost frame outside the runtime is on
+// the line, rather than in a function it calls.  entries are the
+// specialized variants the line calls, which have internal breakpoints
+// that stop as this one while it is enabled.
+type allocFilter struct {
+	file    string
+	line    int
+	entries []uint64
+	caught  string // What the allocation being stopped for is.
+}
+
+func (f *allocFilter) String() string {
+	return fmt.Sprintf("%v:%v", f.file, f.line)
+}
+
+// createAllocBreakpoint sets a breakpoint from "break alloc <location> [if
+// <condition>]", stopping when the line at location allocates on the heap,
+// with that line's frame selected and the condition evaluated there.
+func createAllocBreakpoint(pid int, argument string, symbolTable *gosym.Table) (*breakpoint, error) {
+	spec, condition := splitCondition(argument)
+	if spec == "" || strings.Contains(spec, " ") {
+		return nil, fmt.Errorf("usage: break alloc <file:line> [if <condition>]")
+	}
+	loc, err := parseLocation(spec, symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	if err := loc.resolvePC(symbolTable); err != nil {
+		return nil, err
+	}
+	if symbolTable.LookupFunc(mallocFunction) == nil {
+		return nil, fmt.Errorf("%v is not in the binary", mallocFunction)
+	}
+	for _, bp := range breakpoints {
+		if bp.alloc != nil {
+			return nil, fmt.Errorf("breakpoint %v is already on allocations, at %v; delete it first", bp.id, bp.alloc)
+		}
+	}
+
+	// At the entry, where the arguments are still in their registers.
+	location := mallocFunction + "+0"
+	if condition != "" {
+		location += " if " + condition
+	}
+	bp, err := createBreakpoint(pid, location, symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	// The condition is evaluated in the line's frame, not the one compiled
+	// for.
+	bp.fast = nil
+	bp.alloc = &allocFilter{file: loc.file, line: loc.line}
+	if err := bp.alloc.arm(pid, symbolTable); err != nil {
+		deleteBreakpoint(pid, bp)
+		return nil, err
+	}
+	return bp, nil
+}
+
+// arm finds the specialized variants of mallocFunction the line calls and
+// puts internal breakpoints on them.
+func (f *allocFilter) arm(pid int, symbolTable *gosym.Table) error {
+	f.entries = nil
+	pc, _, err := symbolTable.LineToPC(f.file, f.line)
+	if err != nil {
+		return err
+	}
+	fn := symbolTable.PCToFunc(pc)
+	if fn == nil {
+		return fmt.Errorf("no function at %v", f)
+	}
+	code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+	if err != nil {
+		return err
+	}
+	seen := make(map[uint64]bool)
+	for offset := 0; offset < len(code); {
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			break
+		}
+		at := fn.Entry + uint64(offset)
+		offset += inst.Len
+		rel, ok := inst.Args[0].(x86asm.Rel)
+		if inst.Op != x86asm.CALL || !ok {
+			continue
+		}
+		if file, line, _ := symbolTable.PCToLine(at); file != f.file || line != f.line {
+			continue
+		}
+		target := symbolTable.PCToFunc(uint64(int64(at) + int64(inst.Len) + int64(rel)))
+		if target == nil || target.Name == mallocFunction || !strings.HasPrefix(target.Name, mallocFunction) || seen[target.Entry] {
+			continue
+		}
+		if err := setBreakpoint(pid, target.Entry); err != nil {
+			f.disarm(pid)
+			return err
+		}
+		seen[target.Entry] = true
+		f.entries = append(f.entries, target.Entry)
+	}
+	return nil
+}
+
+// disarm removes the internal breakpoints of the entries, leaving any other
+// breakpoint at the same address.
+func (f *allocFilter) disarm(pid int) {
+	for _, address := range f.entries {
+		if bp := findBreakpoint(address); (bp == nil || bp.disabled) && !isCatchAddress(address) {
+			clearBreakpoint(pid, address)
+		}
+	}
+	f.entries = nil
+}
+
+// allocEntryBreakpoint returns the enabled allocation breakpoint one of
+// whose entries is at pc, if there is one.
+func allocEntryBreakpoint(pc uint64) *breakpoint {
+	for _, bp := range breakpoints {
+		if bp.alloc == nil || bp.disabled {
+			continue
+		}
+		for _, address := range bp.alloc.entries {
+			if address == pc {
+				return bp
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the allocation starting is the line's, and if so
+// selects the line's frame and notes what is allocated.  Only the outermost
+// allocation function on the stack counts, so that one allocation passing
+// from mallocgc to a variant isn't counted twice.
+func (f *allocFilter) matches(pid int, symbolTable *gosym.Table) bool {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return true
+	}
+	size, typ := regs.Rax, regs.Rbx
+	frames := unwind(pid, symbolTable, &regs, maxStackDepth)
+	for i := 1; i < len(frames); i++ {
+		frame := frames[i]
+		if strings.HasPrefix(frame.fn.Name, mallocFunction) {
+			return false
+		}
+		if !strings.HasPrefix(frame.fn.Name, "runtime.") {
+			break
+		}
+	}
+	frame, ok := selectFrameOutside(pid, symbolTable, "runtime.", "internal/")
+	if !ok || frame.line != f.line || frame.file != f.file {
+		return false
+	}
+	what := "untyped memory"
+	if typ != 0 {
+		if name, _, err := runtimeTypeName(pid, typ); err == nil {
+			what = name
+		}
+	}
+	f.caught = fmt.Sprintf("Heap allocation of %v bytes (%v) by %v at %v:%v, frame #%v.",
+		size, what, frame.fn.Name, frame.file, frame.line, selection.index)
+	return true
+}
+
+// allocStop returns the allocation breakpoint a thread stopped at, at
+// mallocFunction or one of its entries, if it did.
+func allocStop(pc uint64) *breakpoint {
+	if bp := findBreakpoint(pc); bp != nil && bp.alloc != nil && !bp.disabled {
+		return bp
+	}
+	return allocEntryBreakpoint(pc)
+}
+
+// showAllocStop reports a stop at an allocation breakpoint, listing the
+// line's frame, which matches selected.
+func showAllocStop(bp *breakpoint) {
+	fmt.Printf("\nBreakpoint %v: %v\n", bp.id, bp.alloc.caught)
+	showListing(pcSourceFile, pcSourceLine)
+}
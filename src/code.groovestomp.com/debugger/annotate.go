@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"debug/gosym"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lineAnnotation is what the session knows of one source line.
+type lineAnnotation struct {
+	breakpoints []*breakpoint
+	hits        int
+	stops       int // Times the program stopped on the line.
+	current     bool
+}
+
+// runAnnotateCommand implements "annotate <file.go> <out>", which writes
+// the source file with the breakpoints on each line, how often they were
+// hit, and how often the program stopped there, stepping or otherwise.
+// Output ending in .html or .htm is a page; anything else is plain text.
+func runAnnotateCommand(argument string, symbolTable *gosym.Table) error {
+	fields := strings.Fields(argument)
+	if len(fields) != 2 {
+		return fmt.Errorf("usage: annotate <file.go> <out.html|out.txt>")
+	}
+	file, err := resolveSourceFile(fields[0], symbolTable)
+	if err != nil {
+		return err
+	}
+	source, err := readSource(file)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(source), "\n"), "\n")
+
+	annotations := make(map[int]*lineAnnotation)
+	annotation := func(line int) *lineAnnotation {
+		if annotations[line] == nil {
+			annotations[line] = &lineAnnotation{}
+		}
+		return annotations[line]
+	}
+	for _, bp := range breakpoints {
+		if bp.file == file {
+			a := annotation(bp.line)
+			a.breakpoints = append(a.breakpoints, bp)
+			a.hits += bp.hits
+		}
+	}
+	for line, n := range session.lines[file] {
+		annotation(line).stops = n
+	}
+	if pcSourceFile == file {
+		annotation(pcSourceLine).current = true
+	}
+
+	out, err := os.Create(fields[1])
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	switch strings.ToLower(filepath.Ext(fields[1])) {
+	case ".html", ".htm":
+		writeAnnotatedHTML(w, file, lines, annotations)
+	default:
+		writeAnnotatedText(w, file, lines, annotations)
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	stopped := 0
+	for _, a := range annotations {
+		if a.stops > 0 {
+			stopped++
+		}
+	}
+	fmt.Printf("Wrote %v: %v lines, %v stopped at.\n", fields[1], len(lines), stopped)
+	return nil
+}
+
+// breakpointIDs lists a line's breakpoints, a disabled one in parentheses.
+func (a *lineAnnotation) breakpointIDs() string {
+	if a == nil {
+		return ""
+	}
+	sort.Slice(a.breakpoints, func(i, j int) bool { return a.breakpoints[i].id < a.breakpoints[j].id })
+	ids := make([]string, len(a.breakpoints))
+	for i, bp := range a.breakpoints {
+		ids[i] = strconv.Itoa(bp.id)
+		if bp.disabled {
+			ids[i] = "(" + ids[i] + ")"
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func countOrBlank(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func writeAnnotatedText(w *bufio.Writer, file string, lines []string, annotations map[int]*lineAnnotation) {
+	fmt.Fprintf(w, "%v\n\n", file)
+	fmt.Fprintf(w, "%6v %-8v %6v %6v\n", "line", "break", "hits", "stops")
+	for i, text := range lines {
+		a := annotations[i+1]
+		marker, hits, stops := " ", "", ""
+		if a != nil {
+			hits, stops = countOrBlank(a.hits), countOrBlank(a.stops)
+			if a.current {
+				marker = ">"
+			}
+		}
+		fmt.Fprintf(w, "%6v %-8v %6v %6v %v %v\n", i+1, a.breakpointIDs(), hits, stops, marker, text)
+	}
+}
+
+func writeAnnotatedHTML(w *bufio.Writer, file string, lines []string, annotations map[int]*lineAnnotation) {
+	title := html.EscapeString(file)
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%v</title>
+<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; }
+td { padding: 0 0.5em; white-space: pre; vertical-align: top; }
+td.n { color: #888; text-align: right; }
+tr.stopped td.src { background: #dfd; }
+tr.break td.src { background: #fdd; }
+tr.current td.src { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>%v</h1>
+<table>
+<tr><th>line</th><th>break</th><th>hits</th><th>stops</th><th></th></tr>
+`, title, title)
+	for i, text := range lines {
+		a := annotations[i+1]
+		var classes []string
+		hits, stops := "", ""
+		if a != nil {
+			hits, stops = countOrBlank(a.hits), countOrBlank(a.stops)
+			if a.stops > 0 {
+				classes = append(classes, "stopped")
+			}
+			if len(a.breakpoints) > 0 {
+				classes = append(classes, "break")
+			}
+			if a.current {
+				classes = append(classes, "current")
+			}
+		}
+		fmt.Fprintf(w, "<tr class=%q><td class=\"n\">%v</td><td class=\"n\">%v</td><td class=\"n\">%v</td><td class=\"n\">%v</td><td class=\"src\">%v</td></tr>\n",
+			strings.Join(classes, " "), i+1, a.breakpointIDs(), hits, stops, html.EscapeString(text))
+	}
+	fmt.Fprint(w, "</table>\n</body>\n</html>\n")
+}
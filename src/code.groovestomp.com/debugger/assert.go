@@ -0,0 +1,54 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strings"
+)
+
+// assertion is an invariant checked at every pass of a breakpoint, which
+// stops the program only when it is false.  The breakpoint's condition is
+// the expression negated.
+type assertion struct {
+	expression string
+	passes     int // How many times it held.
+}
+
+// createAssertion sets a breakpoint from "assert <location> <expression>".
+func createAssertion(pid int, argument string, symbolTable *gosym.Table) (*breakpoint, error) {
+	fields := strings.Fields(argument)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("usage: assert <location> <expression>")
+	}
+	expression := strings.TrimSpace(strings.TrimPrefix(argument, fields[0]))
+	if _, err := parseExpression(expression); err != nil {
+		return nil, err
+	}
+	bp, err := createBreakpoint(pid, fmt.Sprintf("%v if !(%v)", fields[0], expression), symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	bp.assertion = &assertion{expression: expression}
+	return bp, nil
+}
+
+// assertionStop returns the assertion breakpoint a thread stopped at, if it
+// did.
+func assertionStop(pc uint64) *breakpoint {
+	if bp := findBreakpoint(pc); bp != nil && bp.assertion != nil && !bp.disabled {
+		return bp
+	}
+	return nil
+}
+
+// showAssertionStop reports an assertion found false, loudly, since it
+// is what the session was set up to catch.
+func showAssertionStop(bp *breakpoint) {
+	fmt.Printf("\n*** Assertion failed (breakpoint %v): %v ***\n", bp.id, bp.assertion.expression)
+	fmt.Printf("*** at %v:%v, thread %v, after holding %v times ***\n", bp.file, bp.line, currentThread, bp.assertion.passes)
+	showListing(pcSourceFile, pcSourceLine)
+}
+
+func (a *assertion) describe() string {
+	return fmt.Sprintf("assert %v, held %v times", a.expression, a.passes)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// inputLine is one line read from stdin, or what a read of a terminal
+// returned, or the error that ended input.
+type inputLine struct {
+	text string
+	err  error
+}
+
+var (
+	// running is true while the tracee runs in the background, after next
+	// gave up waiting for a call to return.
+	running bool
+
+	// backgroundBreakpoint is the temporary breakpoint left in place for a
+	// background tracee to stop at, or zero.
+	backgroundBreakpoint uint64
+
+	input = make(chan inputLine)
+
+	// inputStarted is false when there is no user to ask, as when serving
+	// the Debug Adapter Protocol.
+	inputStarted bool
+)
+
+// startInput reads stdin on its own goroutine, so that the command loop can
+// keep servicing a background tracee while it waits for the user.  A
+// terminal is read as it comes, a line editor putting lines together, and
+// can still be read after the user types Ctrl-D.
+func startInput() {
+	inputStarted = true
+	terminalInput = isTerminal(os.Stdin)
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			var text string
+			var err error
+			if terminalInput {
+				var n int
+				n, err = os.Stdin.Read(buffer)
+				text = string(buffer[:n])
+			} else {
+				text, err = stdin.ReadString('\n')
+			}
+			input <- inputLine{text, err}
+			if err != nil && !(err == io.EOF && terminalInput) {
+				return
+			}
+		}
+	}()
+}
+
+// readLine returns the next line the user typed, including its newline.
+func readLine() (string, error) {
+	if !inputStarted {
+		return "", io.EOF
+	}
+	if !terminalInput {
+		line := <-input
+		return line.text, line.err
+	}
+	editor := newLineEditor("", false)
+	enterRawMode()
+	defer restoreTerminal()
+	for {
+		line := <-input
+		if line.err != nil && line.err != io.EOF {
+			return "", line.err
+		}
+		if text, done, err := editor.feed([]byte(line.text)); done {
+			return text, err
+		}
+	}
+}
+
+// readCommand waits for the next command, which is edited with the history
+// at hand on a terminal.  While the tracee runs in the background it keeps
+// passing signals through to it and reports when it stops.
+func readCommand(pid int, symbolTable *gosym.Table) (string, error) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	editor := newLineEditor("> ", true)
+	noteActivity()
+	if terminalInput {
+		enterRawMode()
+		defer restoreTerminal()
+	}
+	for {
+		select {
+		case line := <-input:
+			pauseDeadline = time.Time{}
+			noteActivity()
+			if !terminalInput || line.err != nil && line.err != io.EOF {
+				return line.text, line.err
+			}
+			if text, done, err := editor.feed([]byte(line.text)); done {
+				return text, err
+			}
+		case <-ticker.C:
+			if pauseOver() {
+				restoreTerminal()
+				fmt.Println("continue")
+				return "continue\n", nil
+			}
+			if command, warned := stopWatchdog(); command != "" {
+				restoreTerminal()
+				fmt.Println(command)
+				return command + "\n", nil
+			} else if warned && terminalInput {
+				editor.redraw()
+			}
+			if running {
+				if status := waitForBackground(pid, symbolTable, time.Now()); status != nil {
+					restoreTerminal()
+					backgroundStopped(currentThread, status, symbolTable)
+					if queueBreakpointCommands() {
+						fmt.Print("> ")
+						command, _ := nextScriptCommand()
+						return command, nil
+					}
+					startPause()
+					noteActivity()
+					fmt.Print("> ")
+					if terminalInput {
+						enterRawMode()
+						editor.redraw()
+					}
+				}
+			} else if nonStop && anyThreadRunning() {
+				if pollOtherThreads(symbolTable) && terminalInput {
+					editor.redraw()
+				}
+			}
+		}
+	}
+}
+
+// pollOtherThreads reports threads that stop while, in non-stop mode, the
+// user works with another one, returning true if one did.  The current
+// thread stays as it was.
+func pollOtherThreads(symbolTable *gosym.Table) bool {
+	current := currentThread
+	status := waitForStop(current, symbolTable, time.Now())
+	if status == nil {
+		return false
+	}
+	tid := currentThread
+	if status.Exited() || status.Signaled() {
+		restoreTerminal()
+		fmt.Println()
+		reportExit(status)
+		notifyStop("The program has exited.", "", 0)
+		os.Exit(0)
+	}
+	if _, ok := threads[current]; ok {
+		currentThread = current
+	}
+	file, line, _ := symbolTable.PCToLine(getPC(tid))
+	fmt.Printf("\nThread %v stopped at %v:%v.\n> ", tid, file, line)
+	notifyStop(fmt.Sprintf("Thread %v stopped at %v:%v.", tid, file, line), file, line)
+	return true
+}
+
+// waitForBackground is waitForStop for the tracee running in the
+// background, finishing a next whose call has returned.  It returns nil
+// while the tracee is still running at deadline, or again once the next
+// went back to the background.
+func waitForBackground(pid int, symbolTable *gosym.Table, deadline time.Time) *syscall.WaitStatus {
+	for {
+		status := waitForStop(pid, symbolTable, deadline)
+		if status == nil || backgroundStep == nil {
+			return status
+		}
+		if status = finishBackgroundStep(status, symbolTable); status != nil || !deadline.IsZero() {
+			return status
+		}
+	}
+}
+
+// waitForeground blocks until a background tracee stops.
+func waitForeground(pid int, symbolTable *gosym.Table) *syscall.WaitStatus {
+	status := waitForBackground(pid, symbolTable, time.Time{})
+	backgroundStopped(currentThread, status, symbolTable)
+	return status
+}
+
+// interruptBackground stops a background tracee.
+func interruptBackground(pid int, symbolTable *gosym.Table) *syscall.WaitStatus {
+	kill(processID, syscall.SIGINT)
+	return waitForeground(pid, symbolTable)
+}
+
+func backgroundStopped(pid int, status *syscall.WaitStatus, symbolTable *gosym.Table) {
+	endBackgroundRun(pid, status)
+	if status.Exited() || status.Signaled() {
+		fmt.Println()
+		reportExit(status)
+		notifyStop("The program has exited.", "", 0)
+		os.Exit(0)
+	}
+	if checkCrash(pid, status, symbolTable) {
+		file, line, _ := symbolTable.PCToLine(getPC(pid))
+		notifyStop(fmt.Sprintf("The program crashed at %v:%v.", file, line), file, line)
+		return
+	}
+	pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(pid))
+	fmt.Printf("\nThe program stopped at %v:%v.\n", pcSourceFile, pcSourceLine)
+	countStop()
+	notifyStop(fmt.Sprintf("The program stopped at %v:%v.", pcSourceFile, pcSourceLine), pcSourceFile, pcSourceLine)
+}
+
+// endBackgroundRun takes down what was left set up for the tracee running in
+// the background once it has stopped or ended.
+func endBackgroundRun(pid int, status *syscall.WaitStatus) {
+	running = false
+	if backgroundBreakpoint != 0 && !status.Exited() && !status.Signaled() {
+		clearBreakpoint(pid, backgroundBreakpoint)
+	}
+	backgroundBreakpoint = 0
+	runToThread, runToAddress, runToStackPointer = 0, 0, 0
+	backgroundStep = nil
+}
+
+// publishStop publishes the stop or exit of the program run for a DAP or
+// Delve client, in the foreground or the background, and each server tells
+// its client from its subscriber.
+func publishStop(status *syscall.WaitStatus, symbolTable *gosym.Table) {
+	if running {
+		endBackgroundRun(currentThread, status)
+	}
+	if !status.Exited() && !status.Signaled() {
+		pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(currentThread))
+		checkCrash(currentThread, status, symbolTable)
+	}
+	countStop()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"debug/gosym"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// breakpointsFileVersion is the version of the format breakpoints save writes.
+// A field is only ever added, with its zero value meaning what the
+// breakpoint did before it; a change a reader has to know about bumps the
+// version.
+const breakpointsFileVersion = 1
+
+// breakpointsFile is what breakpoints save writes, e.g.
+//
+//	{"version": 1, "breakpoints": [{"location": "auth.go:42",
+//	"condition": "user == nil", "hits": 3, "disabled": true}]}
+type breakpointsFile struct {
+	Version     int               `json:"version"`
+	Breakpoints []json.RawMessage `json:"breakpoints"`
+}
+
+// savedBreakpoint is a breakpoint as saved.  kind is empty for a breakpoint
+// on a location, or http or alloc for break http and break alloc, whose
+// location is the method and path pattern or the allocating line, or
+// assert, whose condition is the expression that is to hold.
+type savedBreakpoint struct {
+	Kind      string         `json:"kind,omitempty"`
+	Location  string         `json:"location"`
+	Condition string         `json:"condition,omitempty"`
+	Group     string         `json:"group,omitempty"`
+	CalledBy  string         `json:"calledBy,omitempty"`
+	Goroutine uint64         `json:"goroutine,omitempty"`
+	Hardware  bool           `json:"hardware,omitempty"`
+	Log       *savedLogpoint `json:"log,omitempty"`
+	Pause     string         `json:"pause,omitempty"`
+	Disabled  bool           `json:"disabled,omitempty"`
+	Commands  []string       `json:"commands,omitempty"`
+	Ignore    int            `json:"ignore,omitempty"`
+	Hits      int            `json:"hits,omitempty"`
+}
+
+// savedLogpoint is the message of a -log breakpoint with its -rate and
+// -sample limits, as they were given.
+type savedLogpoint struct {
+	Message string `json:"message"`
+	Rate    string `json:"rate,omitempty"`
+	Sample  string `json:"sample,omitempty"`
+}
+
+// runBreakpointsSave implements "breakpoints save <file>": it writes every
+// breakpoint set with break or hbreak, with all it was set with and its
+// hit count, for breakpoints load to set again in another session.
+func runBreakpointsSave(path string) error {
+	if path == "" {
+		return fmt.Errorf("usage: breakpoints save <file>")
+	}
+	file := breakpointsFile{Version: breakpointsFileVersion, Breakpoints: []json.RawMessage{}}
+	skipped := 0
+	for _, bp := range breakpoints {
+		saved, ok := saveBreakpoint(bp)
+		if !ok {
+			skipped++
+			continue
+		}
+		var data bytes.Buffer
+		if err := encodeJSON(&data, saved, ""); err != nil {
+			return err
+		}
+		file.Breakpoints = append(file.Breakpoints, data.Bytes())
+	}
+	var data bytes.Buffer
+	if err := encodeJSON(&data, file, "  "); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %v breakpoints to %v.\n", len(file.Breakpoints), path)
+	if skipped > 0 {
+		fmt.Printf("%v set by trace or capture aren't saved; those commands set them again.\n", skipped)
+	}
+	return nil
+}
+
+// encodeJSON writes v to w, leaving the < > and & of conditions as they
+// are rather than escaped for HTML.
+func encodeJSON(w io.Writer, v interface{}, indent string) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	return encoder.Encode(v)
+}
+
+// saveBreakpoint describes a breakpoint for the session file, reporting
+// false for the ones other commands set.
+func saveBreakpoint(bp *breakpoint) (savedBreakpoint, bool) {
+	if bp.trace != nil || bp.capture != nil {
+		return savedBreakpoint{}, false
+	}
+	saved := savedBreakpoint{
+		Location:  bp.spec,
+		Condition: bp.condition,
+		Group:     bp.group,
+		Hardware:  bp.hardware,
+		Goroutine: bp.goroutine,
+		Commands:  bp.commands,
+		Ignore:    bp.ignore,
+		Disabled:  bp.disabled,
+		Hits:      bp.hits,
+	}
+	switch {
+	case bp.request != nil:
+		saved.Kind, saved.Location = "http", bp.request.String()
+	case bp.alloc != nil:
+		saved.Kind, saved.Location = "alloc", bp.alloc.String()
+	case bp.assertion != nil:
+		saved.Kind, saved.Condition = "assert", bp.assertion.expression
+	}
+	if bp.calledBy != nil {
+		saved.CalledBy = bp.calledBy.String()
+	}
+	if bp.pause > 0 {
+		saved.Pause = bp.pause.String()
+	}
+	if l := bp.log; l != nil {
+		saved.Log = &savedLogpoint{Message: l.message}
+		if l.rate > 0 {
+			per := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h"}[l.per]
+			if per == "" {
+				per = l.per.String()
+			}
+			saved.Log.Rate = fmt.Sprintf("%v/%v", l.rate, per)
+		}
+		if l.sampleOf > 0 {
+			saved.Log.Sample = fmt.Sprintf("%v/%v", l.sample, l.sampleOf)
+		}
+	}
+	return saved, true
+}
+
+// argument is the break command argument that sets a saved breakpoint
+// again, less its commands, ignore and hit counts and whether it is
+// disabled.
+func (saved savedBreakpoint) argument() string {
+	argument := saved.Location
+	switch saved.Kind {
+	case "http", "alloc":
+		argument = saved.Kind + " " + argument
+	}
+	if saved.Hardware {
+		argument += " -hardware"
+	}
+	if saved.Group != "" {
+		argument += " -group " + saved.Group
+	}
+	if saved.CalledBy != "" {
+		argument += " -calledby " + saved.CalledBy
+	}
+	if saved.Goroutine != 0 {
+		argument += fmt.Sprintf(" -goroutine %v", saved.Goroutine)
+	}
+	if saved.Pause != "" {
+		argument += " -pause " + saved.Pause
+	}
+	if saved.Log != nil {
+		argument += " -log " + strconv.Quote(saved.Log.Message)
+		if saved.Log.Rate != "" {
+			argument += " -rate " + saved.Log.Rate
+		}
+		if saved.Log.Sample != "" {
+			argument += " -sample " + saved.Log.Sample
+		}
+	}
+	if saved.Kind == "assert" {
+		return argument + " " + saved.Condition
+	}
+	if saved.Condition != "" {
+		argument += " if " + saved.Condition
+	}
+	return argument
+}
+
+// runBreakpointsLoad implements "breakpoints load <file>": it sets the
+// breakpoints breakpoints save wrote, reporting on each.  What a newer
+// release saved that this one doesn't know is reported rather than
+// quietly dropped, and the breakpoint is set with the rest.
+func runBreakpointsLoad(pid int, path string, symbolTable *gosym.Table) error {
+	if path == "" {
+		return fmt.Errorf("usage: breakpoints load <file>")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file breakpointsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	if file.Version == 0 {
+		return fmt.Errorf("%v: not a file breakpoints save wrote: it has no version", path)
+	}
+	if file.Version > breakpointsFileVersion {
+		fmt.Printf("%v was saved in version %v of the format, newer than this debugger's %v.\n", path, file.Version, breakpointsFileVersion)
+	}
+
+	loaded := 0
+	for i, raw := range file.Breakpoints {
+		var saved savedBreakpoint
+		if err := json.Unmarshal(raw, &saved); err != nil {
+			fmt.Printf("%v: breakpoint %v: %v\n", path, i+1, err)
+			continue
+		}
+		if unknown := unknownFields(raw); len(unknown) > 0 {
+			fmt.Printf("%v: breakpoint %v: %v not known here, left out\n", path, i+1, strings.Join(unknown, ", "))
+		}
+		create := createBreakpoint
+		if saved.Kind == "assert" {
+			create = createAssertion
+		}
+		bp, err := create(pid, saved.argument(), symbolTable)
+		if err != nil {
+			fmt.Printf("%v: breakpoint %v: %v\n", path, i+1, err)
+			continue
+		}
+		bp.commands, bp.ignore, bp.hits = saved.Commands, saved.Ignore, saved.Hits
+		if saved.Disabled {
+			disableBreakpoint(pid, bp)
+		}
+		loaded++
+	}
+	fmt.Printf("Loaded %v of %v breakpoints from %v.\n", loaded, len(file.Breakpoints), path)
+	return nil
+}
+
+// unknownFields lists the fields of a saved breakpoint that savedBreakpoint
+// has none for, sorted.
+func unknownFields(raw json.RawMessage) []string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		return nil
+	}
+	known := make(map[string]bool)
+	t := reflect.TypeOf(savedBreakpoint{})
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	var unknown []string
+	for name := range fields {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// TestBreakpointsSaveLoad checks that a breakpoint's goroutine filter,
+// ignore count and commands survive breakpoints save and load.
+func TestBreakpointsSaveLoad(t *testing.T) {
+	program := testProgram(t, "threads")
+	path := filepath.Join(testDir, "breakpoints.json")
+	output := runDebugger(t, program,
+		"break main.greeting -goroutine 1", "commands", "print name", "end", "ignore 1 2",
+		"breakpoints save "+path, "delete 1", "breakpoints load "+path, "info breakpoints")
+	pattern := `(?m)^2 .*main\.greeting\)\n +only on goroutine 1\n +ignores the next 2 crossings\n +print name$`
+	if !regexp.MustCompile(pattern).MatchString(output) {
+		t.Errorf("no match for %v in:\n%v", pattern, output)
+	}
+}
+
+// TestBreakpointIgnore checks that a breakpoint passed by its ignore count
+// or goroutine filter lets the program run on.
+func TestBreakpointIgnore(t *testing.T) {
+	program := testProgram(t, "threads")
+	output := runDebugger(t, program,
+		"break main.greeting", "ignore 1 1", "break main.spin -goroutine 1", "continue")
+	if !regexp.MustCompile(`(?m)^Program exited with status 0\.$`).MatchString(output) {
+		t.Errorf("program didn't run to its exit:\n%v", output)
+	}
+}
@@ -0,0 +1,913 @@
+package main
+
+import (
+	"debug/gosym"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// breakpoint is a user breakpoint.  file is always the path recorded in the
+// binary's line table, so files that share a base name in different packages
+// are kept apart.  A breakpoint with a condition only stops the tracee when
+// the condition evaluates to true.  Disabled breakpoints stay in the list but
+// have no INT3 in the tracee.  spec is the location the breakpoint was set
+// with, kept so it can be resolved again when the program is restarted.
+// Breakpoints are numbered from 1 in the order they were set, and keep their
+// numbers and hit counts across restarts.
+type breakpoint struct {
+	id        int
+	hits      int
+	spec      string
+	file      string
+	line      int
+	pc        uint64
+	condition string
+	cond      expr
+	fast      *fastCondition // cond compiled, or nil.
+	group     string
+	disabled  bool
+
+	// previous holds what the $prev calls in cond saw at the last hit.
+	previous map[*callExpr]*value
+
+	// hardware is set on a breakpoint a debug register stops at, rather
+	// than an INT3.
+	hardware bool
+
+	// calledBy, when set, limits the breakpoint to calls from functions
+	// matching it.
+	calledBy *regexp.Regexp
+
+	// request, when set, limits the breakpoint to matching HTTP requests.
+	request *requestFilter
+
+	// alloc, when set, limits the breakpoint to heap allocations a line
+	// makes.
+	alloc *allocFilter
+
+	// trace is set on the entry breakpoint of "trace recursion".
+	trace *recursionTrace
+
+	// log is set on a breakpoint that prints a message rather than stops.
+	log *logpoint
+
+	// capture is set on a breakpoint that records expressions rather than
+	// stops.
+	capture *captureTrace
+
+	// assertion is set on a breakpoint that checks an expression at every
+	// pass, stopping only when it is false.
+	assertion *assertion
+
+	// pause, when set, continues the program that long after it stops
+	// here, unless something is typed first.
+	pause time.Duration
+
+	// commands are run, one after another, each time the program stops
+	// here.
+	commands []string
+
+	// goroutine, when set, limits the breakpoint to the goroutine with
+	// that id.
+	goroutine uint64
+
+	// ignore is how many more times the breakpoint lets the program pass
+	// rather than stop.
+	ignore int
+}
+
+var (
+	breakpoints []*breakpoint
+
+	// lastBreakpointID is the number given to the latest breakpoint.
+	lastBreakpointID int
+)
+
+// errBreakpointExists is returned by addBreakpoint when a user breakpoint
+// is already at the address.
+var errBreakpointExists = errors.New("breakpoint already set")
+
+// setBreakpoint writes an INT3 at address, for a user breakpoint or an
+// internal one.  tracee writes it with writeText, which makes a page ptrace
+// can't write to writable for the moment and refuses text mapped shared,
+// as from a sealed or verity-protected file, so that the error names the
+// mapping instead of leaving a breakpoint that never fires.
+func setBreakpoint(pid int, address uint64) error {
+	return tracee.SetBreakpoint(pid, address)
+}
+
+// isInserted reports whether one of the debugger's INT3s is written at
+// address.
+func isInserted(address uint64) bool {
+	if tracee == nil {
+		return false // A core file.
+	}
+	_, ok := tracee.Breakpoint(address)
+	return ok
+}
+
+// mustSetBreakpoint sets an internal breakpoint the debugger can't do
+// without, such as one it has only just lifted to step over.
+func mustSetBreakpoint(pid int, address uint64) {
+	if err := setBreakpoint(pid, address); err != nil {
+		fatal(err)
+	}
+}
+
+// describeMapping explains, after an error, how the page holding address
+// is mapped, from /proc/<pid>/maps.
+func describeMapping(pid int, address uint64) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		start, err1 := strconv.ParseUint(bounds[0], 16, 64)
+		end, err2 := strconv.ParseUint(bounds[len(bounds)-1], 16, 64)
+		if err1 != nil || err2 != nil || address < start || address >= end {
+			continue
+		}
+		name := "anonymous memory"
+		if len(fields) > 5 {
+			name = strings.Join(fields[5:], " ")
+		}
+		perms := fields[1]
+		if strings.HasSuffix(perms, "s") {
+			return fmt.Sprintf(" (the text is mapped shared from %v, %v, so it can't be patched; run a private copy of the binary)", name, perms)
+		}
+		return fmt.Sprintf(" (the text is mapped from %v, %v)", name, perms)
+	}
+	return " (the address is not mapped)"
+}
+
+func clearBreakpoint(pid int, address uint64) {
+	if err := tracee.ClearBreakpoint(pid, address); err != nil {
+		fatal(err)
+	}
+}
+
+// adjustPCAfterTrap rewinds the PC onto the breakpoint address when the
+// tracee stopped because it executed one of our INT3s, so that everything
+// looking at the PC afterwards sees the logical location of the stop.
+func adjustPCAfterTrap(pid int) {
+	pc := getPC(pid)
+	if isInserted(pc - 1) {
+		setPC(pid, pc-1)
+	}
+}
+
+func findBreakpoint(pc uint64) *breakpoint {
+	for _, bp := range breakpoints {
+		if bp.pc == pc {
+			return bp
+		}
+	}
+	return nil
+}
+
+// addBreakpoint inserts and records a user breakpoint, returning
+// errBreakpointExists if one already exists at pc.
+func addBreakpoint(pid int, file string, line int, pc uint64) error {
+	if findBreakpoint(pc) != nil {
+		return errBreakpointExists
+	}
+	if err := setBreakpoint(pid, pc); err != nil {
+		return err
+	}
+	spec := fmt.Sprintf("%v:%v", file, line)
+	lastBreakpointID++
+	breakpoints = append(breakpoints, &breakpoint{id: lastBreakpointID, spec: spec, file: file, line: line, pc: pc})
+	return nil
+}
+
+// createBreakpoint sets a breakpoint from the argument of a break command:
+// "<location> [-group <name>] [if <condition>]".
+func createBreakpoint(pid int, argument string, symbolTable *gosym.Table) (*breakpoint, error) {
+	if strings.HasPrefix(argument, "http ") {
+		return createHTTPBreakpoint(pid, strings.TrimPrefix(argument, "http "), symbolTable)
+	}
+	if strings.HasPrefix(argument, "alloc ") {
+		return createAllocBreakpoint(pid, strings.TrimPrefix(argument, "alloc "), symbolTable)
+	}
+	argument, message, err := splitLogOption(argument)
+	if err != nil {
+		return nil, err
+	}
+	spec, condition := splitCondition(argument)
+	spec, group, err := splitOption(spec, "-group", "a name")
+	if err != nil {
+		return nil, err
+	}
+	spec, hardware := splitFlag(spec, "-hardware")
+	spec, rate, err := splitOption(spec, "-rate", "a rate like 1/s")
+	if err != nil {
+		return nil, err
+	}
+	spec, sample, err := splitOption(spec, "-sample", "a ratio like 1/100")
+	if err != nil {
+		return nil, err
+	}
+	var log *logpoint
+	if message != "" {
+		if log, err = newLogpoint(message, rate, sample); err != nil {
+			return nil, err
+		}
+	} else if rate != "" || sample != "" {
+		return nil, fmt.Errorf("-rate and -sample limit the messages of a -log breakpoint")
+	}
+	spec, pauseFor, err := splitOption(spec, "-pause", "a duration like 5s")
+	if err != nil {
+		return nil, err
+	}
+	var pause time.Duration
+	if pauseFor != "" {
+		if pause, err = time.ParseDuration(pauseFor); err != nil || pause <= 0 {
+			return nil, fmt.Errorf("expected a duration like 5s after -pause, got %q", pauseFor)
+		}
+		if log != nil {
+			return nil, fmt.Errorf("-pause is for breakpoints that stop, not -log ones")
+		}
+	}
+	spec, goroutineID, err := splitOption(spec, "-goroutine", "a goroutine id")
+	if err != nil {
+		return nil, err
+	}
+	var onGoroutine uint64
+	if goroutineID != "" {
+		if onGoroutine, err = strconv.ParseUint(goroutineID, 10, 64); err != nil || onGoroutine == 0 {
+			return nil, fmt.Errorf("expected a goroutine id after -goroutine, got %q", goroutineID)
+		}
+	}
+	spec, calledBy, err := splitOption(spec, "-calledby", "a function pattern")
+	if err != nil {
+		return nil, err
+	}
+	var callerPattern *regexp.Regexp
+	if calledBy != "" {
+		if callerPattern, err = regexp.Compile(calledBy); err != nil {
+			return nil, err
+		}
+	}
+	loc, err := parseLocation(spec, symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	if err := loc.resolvePC(symbolTable); err != nil {
+		return nil, err
+	}
+	var cond expr
+	if condition != "" {
+		cond, err = parseExpression(condition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	add := addBreakpoint
+	if hardware {
+		add = addHardwareBreakpoint
+	}
+	if err := add(pid, loc.file, loc.line, loc.pc); err == errBreakpointExists {
+		return nil, fmt.Errorf("breakpoint already set at %v:%v", loc.file, loc.line)
+	} else if err != nil {
+		return nil, err
+	}
+	bp := findBreakpoint(loc.pc)
+	bp.condition, bp.cond = condition, cond
+	if cond != nil {
+		bp.fast = compileCondition(cond, loc.pc, symbolTable)
+	}
+	bp.group = group
+	bp.calledBy = callerPattern
+	bp.log = log
+	bp.pause = pause
+	bp.goroutine = onGoroutine
+	if isRelativeSpec(spec) {
+		// Relative locations depend on where the program was stopped.
+		spec = fmt.Sprintf("%v:%v", loc.file, loc.line)
+	}
+	bp.spec = spec
+	publish(breakpointEvent{change: "set", bp: bp})
+	return bp, nil
+}
+
+// createInitBreakpoints sets a breakpoint on each init function of a
+// package from "init <package-path> [<options>] [if <condition>]": the one
+// the compiler writes for the package's variables, pkg.init, and the
+// package's own, pkg.init.0, pkg.init.1 and so on.
+func createInitBreakpoints(pid int, argument string, symbolTable *gosym.Table) ([]*breakpoint, error) {
+	fields := strings.Fields(argument)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("usage: break init <package-path> [<options>] [if <condition>]")
+	}
+	pkg, rest := fields[0], strings.TrimSpace(strings.TrimPrefix(argument, fields[0]))
+
+	// Symbols escape the dots of a package path's last element.
+	prefix := pkg + ".init"
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		prefix = pkg[:i+1] + strings.Replace(pkg[i+1:], ".", "%2e", -1) + ".init"
+	}
+	var inits []string
+	for _, fn := range symbolTable.Funcs {
+		if fn.Name == prefix || strings.HasPrefix(fn.Name, prefix+".") && isDigits(fn.Name[len(prefix)+1:]) {
+			inits = append(inits, fn.Name)
+		}
+	}
+	if len(inits) == 0 {
+		return nil, fmt.Errorf("no init functions for package %v in the binary", pkg)
+	}
+
+	var created []*breakpoint
+	for _, name := range inits {
+		bp, err := createBreakpoint(pid, strings.TrimSpace(name+" "+rest), symbolTable)
+		if err != nil {
+			return created, fmt.Errorf("%v: %v", name, err)
+		}
+		created = append(created, bp)
+	}
+	return created, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// initializing reports whether the tracee is still running package init
+// functions, which only happens once a run.
+func initializing(pid int, symbolTable *gosym.Table) bool {
+	for _, frame := range backtrace(pid, symbolTable) {
+		if frame.fn.Name == "runtime.doInit" || frame.fn.Name == "runtime.doInit1" {
+			return true
+		}
+	}
+	return false
+}
+
+func isRelativeSpec(spec string) bool {
+	if spec == "-entry" {
+		return false
+	}
+	if strings.HasPrefix(spec, "+") || strings.HasPrefix(spec, "-") {
+		return true
+	}
+	_, err := strconv.Atoi(spec)
+	return err == nil
+}
+
+// shouldStop checks the breakpoint's caller and evaluates its condition in
+// the innermost frame.  A condition that can't be evaluated stops the tracee
+// so the user can fix it.
+func (bp *breakpoint) shouldStop(pid int, symbolTable *gosym.Table) bool {
+	if bp.trace != nil {
+		return bp.trace.hit(pid, symbolTable)
+	}
+	if bp.calledBy != nil && !calledFrom(pid, bp.calledBy, symbolTable) {
+		return false
+	}
+	if bp.goroutine != 0 && currentGoroutineID(pid) != bp.goroutine {
+		return false
+	}
+	if bp.request != nil && !bp.request.matches(pid, symbolTable) {
+		return false
+	}
+	if bp.alloc != nil && !bp.alloc.matches(pid, symbolTable) {
+		return false
+	}
+	if bp.cond != nil && !conditionHolds(pid, bp, symbolTable) {
+		if bp.assertion != nil {
+			bp.assertion.passes++
+		}
+		return false
+	}
+	if bp.ignore > 0 {
+		bp.ignore--
+		return false
+	}
+	if bp.log != nil {
+		bp.hits++
+		bp.log.hit(pid, bp.hits, symbolTable)
+		return false
+	}
+	if bp.capture != nil {
+		bp.hits++
+		bp.capture.hit(pid, symbolTable)
+		return false
+	}
+	return true
+}
+
+// conditionHolds evaluates a breakpoint's condition, with its compiled
+// form when it has one.  An error in the condition counts as true, so the
+// breakpoint stops and the error can be looked into.
+func conditionHolds(pid int, bp *breakpoint, symbolTable *gosym.Table) bool {
+	if bp.fast != nil {
+		if holds, err := bp.fast.holds(pid); err == nil {
+			return holds
+		}
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		fmt.Printf("Error in breakpoint condition %q: %v\n", bp.condition, err)
+		return true
+	}
+	current := ctx.previousValues(bp.cond)
+	ctx.previous = bp.previous
+	if ctx.previous == nil {
+		ctx.previous = current
+	}
+	result, err := ctx.eval(bp.cond)
+	endEvaluation()
+	if current != nil {
+		bp.previous = current
+	}
+	if err != nil {
+		fmt.Printf("Error in breakpoint condition %q: %v\n", bp.condition, err)
+		return true
+	}
+	return isTrue(result)
+}
+
+// isPrevCall reports whether a call is $prev(<expression>), which stands
+// in a breakpoint's condition for the value the expression had at the
+// breakpoint's last hit.
+func isPrevCall(e *callExpr) bool {
+	r, ok := e.fun.(*registerExpr)
+	return ok && r.name == "prev"
+}
+
+func (ctx *evalContext) evalPrev(e *callExpr) (*value, error) {
+	if len(e.args) != 1 {
+		return nil, fmt.Errorf("$prev takes one expression")
+	}
+	if ctx.previous == nil {
+		return nil, fmt.Errorf("$prev is only for breakpoint conditions")
+	}
+	v, ok := ctx.previous[e]
+	if !ok {
+		return nil, fmt.Errorf("the argument of $prev could not be evaluated at the last hit")
+	}
+	return v, nil
+}
+
+// previousValues evaluates the arguments of the $prev calls in a condition,
+// for the next hit to compare against, or returns nil if there are none.
+// On the first hit they stand for themselves, so x != $prev(x) only holds
+// once x has changed.  Strings are kept by their text, which the program
+// may not keep.
+func (ctx *evalContext) previousValues(cond expr) map[*callExpr]*value {
+	calls := prevCalls(cond, nil)
+	if len(calls) == 0 {
+		return nil
+	}
+	values := make(map[*callExpr]*value)
+	for _, call := range calls {
+		if len(call.args) != 1 {
+			continue
+		}
+		v, err := ctx.eval(call.args[0])
+		if err != nil {
+			continue
+		}
+		if text, ok, err := stringContents(ctx.pid, v); err == nil && ok {
+			v = &value{typ: untypedStringType, data: []byte(text)}
+		}
+		values[call] = v
+	}
+	return values
+}
+
+func prevCalls(e expr, calls []*callExpr) []*callExpr {
+	switch e := e.(type) {
+	case *unaryExpr:
+		return prevCalls(e.x, calls)
+	case *binaryExpr:
+		return prevCalls(e.y, prevCalls(e.x, calls))
+	case *selectorExpr:
+		return prevCalls(e.x, calls)
+	case *indexExpr:
+		return prevCalls(e.index, prevCalls(e.x, calls))
+	case *callExpr:
+		if isPrevCall(e) {
+			return append(calls, e)
+		}
+		calls = prevCalls(e.fun, calls)
+		for _, arg := range e.args {
+			calls = prevCalls(arg, calls)
+		}
+	}
+	return calls
+}
+
+// calledFrom reports whether the caller of the stopped function, or the
+// caller's caller, matches pattern.  The second frame lets a breakpoint see
+// past a closure or method wrapper in between.
+func calledFrom(pid int, pattern *regexp.Regexp, symbolTable *gosym.Table) bool {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return true
+	}
+	frames := unwind(pid, symbolTable, &regs, 3)
+	for _, frame := range frames[1:] {
+		if pattern.MatchString(frame.fn.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCondition separates "<location> if <condition>".
+func splitCondition(argument string) (string, string) {
+	if i := strings.Index(argument, " if "); i >= 0 {
+		return strings.TrimSpace(argument[:i]), strings.TrimSpace(argument[i+4:])
+	}
+	return argument, ""
+}
+
+// splitOption removes an option like "-group <name>" from a location spec
+// and returns its value.
+func splitOption(spec string, option string, what string) (string, string, error) {
+	fields := strings.Fields(spec)
+	for i, field := range fields {
+		if field != option {
+			continue
+		}
+		if i+1 >= len(fields) {
+			return "", "", fmt.Errorf("%v needs %v", option, what)
+		}
+		rest := append(fields[:i:i], fields[i+2:]...)
+		return strings.Join(rest, " "), fields[i+1], nil
+	}
+	return spec, "", nil
+}
+
+// splitFlag removes an option without a value, like "-hardware", from a
+// location spec and reports whether it was there.
+func splitFlag(spec string, option string) (string, bool) {
+	fields := strings.Fields(spec)
+	for i, field := range fields {
+		if field == option {
+			return strings.Join(append(fields[:i:i], fields[i+1:]...), " "), true
+		}
+	}
+	return spec, false
+}
+
+func enableBreakpoint(pid int, bp *breakpoint) error {
+	if bp.hardware {
+		if !bp.disabled {
+			return nil
+		}
+		if debugRegistersUsed() >= debugRegisters {
+			return fmt.Errorf("all %v debug registers are in use by hardware breakpoints and watchpoints", debugRegisters)
+		}
+		bp.disabled = false
+		return armWatchpoints()
+	}
+	if err := setBreakpoint(pid, bp.pc); err != nil {
+		return err
+	}
+	if bp.alloc != nil && bp.disabled {
+		if err := bp.alloc.arm(pid, listingSymbols); err != nil {
+			return err
+		}
+	}
+	bp.disabled = false
+	return nil
+}
+
+func disableBreakpoint(pid int, bp *breakpoint) {
+	bp.disabled = true
+	if bp.hardware {
+		armWatchpoints()
+		return
+	}
+	// A catchpoint shares the INT3 if it lives at the same address.
+	if !isCatchAddress(bp.pc) {
+		clearBreakpoint(pid, bp.pc)
+	}
+	if bp.alloc != nil {
+		bp.alloc.disarm(pid)
+	}
+}
+
+func deleteBreakpoint(pid int, bp *breakpoint) {
+	disableBreakpoint(pid, bp)
+	for i, other := range breakpoints {
+		if other == bp {
+			breakpoints = append(breakpoints[:i], breakpoints[i+1:]...)
+			publish(breakpointEvent{change: "deleted", bp: bp})
+			return
+		}
+	}
+}
+
+// findBreakpointByID looks a breakpoint up by the number info breakpoints
+// shows.
+func findBreakpointByID(argument string) (*breakpoint, error) {
+	id, err := strconv.Atoi(argument)
+	if err != nil {
+		return nil, fmt.Errorf("invalid breakpoint number %q", argument)
+	}
+	for _, bp := range breakpoints {
+		if bp.id == id {
+			return bp, nil
+		}
+	}
+	return nil, fmt.Errorf("no breakpoint number %v", id)
+}
+
+// runBreakpointNumberCommand applies enable, disable or delete to the
+// breakpoints numbered in its argument: "<action> <n>...".
+func runBreakpointNumberCommand(pid int, action string, argument string) error {
+	fields := strings.Fields(argument)
+	if len(fields) == 0 {
+		return fmt.Errorf("usage: %v <n>...", action)
+	}
+	var selected []*breakpoint
+	for _, field := range fields {
+		bp, err := findBreakpointByID(field)
+		if err != nil {
+			return err
+		}
+		selected = append(selected, bp)
+	}
+	for _, bp := range selected {
+		switch action {
+		case "enable":
+			if err := enableBreakpoint(pid, bp); err != nil {
+				return err
+			}
+		case "disable":
+			disableBreakpoint(pid, bp)
+		case "delete":
+			deleteBreakpoint(pid, bp)
+		}
+	}
+	return nil
+}
+
+// runCommandsCommand implements "commands [<n>]": it reads the commands to
+// run each time the program stops at breakpoint n, or the breakpoint set
+// last, one a line up to "end", from the script it is in or from the user.
+// An empty list removes the breakpoint's commands.
+func runCommandsCommand(argument string, script bool) error {
+	var bp *breakpoint
+	if argument != "" {
+		var err error
+		if bp, err = findBreakpointByID(argument); err != nil {
+			return err
+		}
+	} else if len(breakpoints) > 0 {
+		bp = breakpoints[len(breakpoints)-1]
+	}
+
+	var commands []string
+	if !script {
+		fmt.Println("Type commands, one a line, ending with \"end\".")
+	}
+	for {
+		var line string
+		if script {
+			if len(scriptCommands) == 0 {
+				return fmt.Errorf("commands without an end")
+			}
+			line, scriptCommands = scriptCommands[0], scriptCommands[1:]
+			fmt.Printf(">> %v\n", line)
+		} else {
+			fmt.Print(">> ")
+			text, err := readLine()
+			if err != nil {
+				fmt.Println()
+				return fmt.Errorf("commands without an end")
+			}
+			line = text
+		}
+		line = strings.TrimSpace(line)
+		if line == "end" {
+			break
+		}
+		if line != "" {
+			commands = append(commands, line)
+		}
+	}
+
+	if bp == nil {
+		return fmt.Errorf("no breakpoints")
+	}
+	if bp.log != nil || bp.capture != nil || bp.trace != nil {
+		return fmt.Errorf("breakpoint %v doesn't stop the program, so it has no commands", bp.id)
+	}
+	bp.commands = commands
+	return nil
+}
+
+// runIgnoreCommand implements "ignore <n> <count>": breakpoint n lets the
+// program pass the next count times its other conditions hold.
+func runIgnoreCommand(argument string) error {
+	fields := strings.Fields(argument)
+	if len(fields) != 2 {
+		return fmt.Errorf("usage: ignore <n> <count>")
+	}
+	bp, err := findBreakpointByID(fields[0])
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil || count < 0 {
+		return fmt.Errorf("invalid count %q", fields[1])
+	}
+	bp.ignore = count
+	switch count {
+	case 0:
+		fmt.Printf("Breakpoint %v will stop the next time it is reached.\n", bp.id)
+	case 1:
+		fmt.Printf("Will ignore the next crossing of breakpoint %v.\n", bp.id)
+	default:
+		fmt.Printf("Will ignore the next %v crossings of breakpoint %v.\n", count, bp.id)
+	}
+	return nil
+}
+
+// describe says what a breakpoint is on, as the user set it.
+func (bp *breakpoint) describe() string {
+	what := fmt.Sprintf("%v:%v", bp.file, bp.line)
+	switch {
+	case bp.trace != nil:
+		what = "trace recursion " + bp.trace.fn.Name
+	case bp.request != nil:
+		what = fmt.Sprintf("http %v in %v", bp.request, what)
+	case bp.alloc != nil:
+		what = fmt.Sprintf("alloc %v in %v", bp.alloc, what)
+	case bp.spec != what:
+		what = fmt.Sprintf("%v (%v)", what, bp.spec)
+	}
+	if bp.log != nil {
+		what += ": " + bp.log.describe()
+	}
+	if bp.capture != nil {
+		what += ": " + bp.capture.describe()
+	}
+	if bp.assertion != nil {
+		what += ": " + bp.assertion.describe()
+	}
+	if bp.hardware {
+		what += ", hardware"
+	}
+	return what
+}
+
+// showBreakpoints implements "info breakpoints".
+func showBreakpoints() {
+	if len(breakpoints) == 0 {
+		fmt.Println("No breakpoints.")
+		return
+	}
+	fmt.Println("Num  Enb  Address             Hits  What")
+	for _, bp := range breakpoints {
+		enabled := "y"
+		if bp.disabled {
+			enabled = "n"
+		}
+		fmt.Printf("%-4v %-4v 0x%016x  %-5v %v\n", bp.id, enabled, bp.pc, bp.hits, bp.describe())
+		if bp.condition != "" && bp.assertion == nil {
+			if bp.fast != nil {
+				fmt.Printf("          if %v (compiled)\n", bp.condition)
+			} else {
+				fmt.Printf("          if %v\n", bp.condition)
+			}
+		}
+		if bp.calledBy != nil {
+			fmt.Printf("          called by %v\n", bp.calledBy)
+		}
+		if bp.group != "" {
+			fmt.Printf("          in group %v\n", bp.group)
+		}
+		if bp.goroutine != 0 {
+			fmt.Printf("          only on goroutine %v\n", bp.goroutine)
+		}
+		if bp.ignore > 0 {
+			fmt.Printf("          ignores the next %v crossings\n", bp.ignore)
+		}
+		if bp.pause > 0 {
+			fmt.Printf("          continues after %v\n", bp.pause)
+		}
+		for _, command := range bp.commands {
+			fmt.Printf("          %v\n", command)
+		}
+	}
+}
+
+// runGroupCommand applies enable, disable or delete to every breakpoint in
+// a group: "<action> group <name>".
+func runGroupCommand(pid int, action string, argument string) error {
+	fields := strings.Fields(argument)
+	if len(fields) != 2 || fields[0] != "group" {
+		return fmt.Errorf("usage: %v group <name>", action)
+	}
+	name := fields[1]
+
+	var members []*breakpoint
+	for _, bp := range breakpoints {
+		if bp.group == name {
+			members = append(members, bp)
+		}
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("no breakpoints in group %v", name)
+	}
+
+	done := ""
+	for _, bp := range members {
+		switch action {
+		case "enable":
+			if err := enableBreakpoint(pid, bp); err != nil {
+				return err
+			}
+			done = "Enabled"
+		case "disable":
+			disableBreakpoint(pid, bp)
+			done = "Disabled"
+		case "delete":
+			deleteBreakpoint(pid, bp)
+			done = "Deleted"
+		}
+	}
+	fmt.Printf("%v %v breakpoints in group %v\n", done, len(members), name)
+	return nil
+}
+
+func isBreakpointLine(filename string, line int) bool {
+	for _, bp := range breakpoints {
+		if bp.file == filename && bp.line == line && !bp.disabled {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSourceFile maps a file name given by the user to a path in the line
+// table.  Absolute and relative paths are matched exactly; anything else is
+// matched against trailing path components, and the user is asked to choose
+// when several files qualify.
+func resolveSourceFile(name string, symbolTable *gosym.Table) (string, error) {
+	if _, ok := symbolTable.Files[name]; ok {
+		return name, nil
+	}
+	if absolute, err := filepath.Abs(name); err == nil {
+		if _, ok := symbolTable.Files[absolute]; ok {
+			return absolute, nil
+		}
+	}
+
+	suffix := "/" + filepath.ToSlash(filepath.Clean(name))
+	var matches []string
+	for file := range symbolTable.Files {
+		if strings.HasSuffix(filepath.ToSlash(file), suffix) {
+			matches = append(matches, file)
+		}
+	}
+	sort.Strings(matches)
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no source file named %v", name)
+	case 1:
+		return matches[0], nil
+	}
+
+	fmt.Printf("%v is ambiguous:\n", name)
+	for i, match := range matches {
+		fmt.Printf("  [%v] %v\n", i+1, match)
+	}
+	fmt.Print("Select a file: ")
+	answer, err := readLine()
+	if err != nil {
+		return "", err
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || choice < 1 || choice > len(matches) {
+		return "", fmt.Errorf("no file selected")
+	}
+	return matches[choice-1], nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// TestBreakpointCommands checks that a breakpoint's commands run when the
+// program stops there, a continue among them included.
+func TestBreakpointCommands(t *testing.T) {
+	program := testProgram(t, "threads")
+	output := runDebugger(t, program,
+		"break main.greeting", "commands", "print name", "continue", "end", "continue")
+	for _, pattern := range []string{
+		`(?m)^> print name\n\$1 = "threads"`,
+		`(?m)^> continue\n(.*\n)*Program exited with status 0\.$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Errorf("no match for %v in:\n%v", pattern, output)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// buildIDs are the identifiers the toolchains stamp into an executable.
+// Either may be missing.
+type buildIDs struct {
+	goID  string
+	gnuID string
+}
+
+func readBuildIDs(exe *elf.File) buildIDs {
+	var ids buildIDs
+	if desc := noteDescription(exe, ".note.go.buildid", "Go"); desc != nil {
+		ids.goID = string(desc)
+	}
+	if desc := noteDescription(exe, ".note.gnu.build-id", "GNU"); desc != nil {
+		ids.gnuID = hex.EncodeToString(desc)
+	}
+	return ids
+}
+
+// noteDescription returns the descriptor of the first note with the given
+// owner in an ELF note section.
+func noteDescription(exe *elf.File, section string, owner string) []byte {
+	s := exe.Section(section)
+	if s == nil {
+		return nil
+	}
+	data, err := s.Data()
+	if err != nil {
+		return nil
+	}
+	return findNote(data, exe.ByteOrder, owner)
+}
+
+// findNote returns the descriptor of the first note with the given owner in
+// the contents of a note section.
+func findNote(data []byte, order binary.ByteOrder, owner string) []byte {
+	for len(data) >= 12 {
+		nameSize := int(order.Uint32(data))
+		descSize := int(order.Uint32(data[4:]))
+		nameEnd := 12 + align4(nameSize)
+		descEnd := nameEnd + align4(descSize)
+		if descEnd > len(data) {
+			return nil
+		}
+		name := string(bytes.TrimRight(data[12:12+nameSize], "\x00"))
+		if name == owner {
+			return data[nameEnd : nameEnd+descSize]
+		}
+		data = data[descEnd:]
+	}
+	return nil
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// ignoreBuildID is set by -ignore-build-id, to debug a process or read a
+// core even if the binary given is not the one it runs.
+var ignoreBuildID bool
+
+// verifyBuildID checks that the symbol file is the executable the tracee is
+// running, comparing whichever build IDs both of them carry.
+func verifyBuildID(symbols *elf.File, pid int) error {
+	image, err := elf.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return fmt.Errorf("cannot read the executable of process %v: %v", pid, err)
+	}
+	defer image.Close()
+
+	return compareBuildIDs(readBuildIDs(symbols), readBuildIDs(image), fmt.Sprintf("process %v runs", pid), "the process")
+}
+
+// verifyCoreBuildID checks that the symbol file is the executable a core
+// was dumped from.  The kernel writes the first page of every mapping of
+// an ELF file to the core, and the build ID notes are at the start of the
+// text, so they are read from the core at the addresses the symbol file
+// has them at.
+func verifyCoreBuildID(symbols *elf.File, c *coreDump) error {
+	var got buildIDs
+	dumped := func(section string, owner string) []byte {
+		s := symbols.Section(section)
+		if s == nil {
+			return nil
+		}
+		data := make([]byte, s.Size)
+		if readSegment(c.file, s.Addr, data) != len(data) {
+			return nil
+		}
+		return findNote(data, symbols.ByteOrder, owner)
+	}
+	if desc := dumped(".note.go.buildid", "Go"); desc != nil {
+		got.goID = string(desc)
+	}
+	if desc := dumped(".note.gnu.build-id", "GNU"); desc != nil {
+		got.gnuID = hex.EncodeToString(desc)
+	}
+	return compareBuildIDs(readBuildIDs(symbols), got, fmt.Sprintf("%v was dumped from", c.path), "the core")
+}
+
+// compareBuildIDs compares the build IDs of the symbol file with those of
+// what it is used for, which ran describes in an error and other in a
+// warning when there is nothing to compare.
+func compareBuildIDs(want, got buildIDs, ran string, other string) error {
+	switch {
+	case want.goID != "" && got.goID != "":
+		if want.goID != got.goID {
+			return fmt.Errorf("Go build ID mismatch: symbol file has %v, %v %v", want.goID, ran, got.goID)
+		}
+	case want.gnuID != "" && got.gnuID != "":
+		if want.gnuID != got.gnuID {
+			return fmt.Errorf("GNU build ID mismatch: symbol file has %v, %v %v", want.gnuID, ran, got.gnuID)
+		}
+	default:
+		fmt.Printf("Warning: no build ID to verify the symbol file against %v with.\n", other)
+	}
+	return nil
+}
+
+// takeIgnoreBuildID removes -ignore-build-id from the arguments of a
+// subcommand, setting ignoreBuildID if it was there.
+func takeIgnoreBuildID(args []string) []string {
+	var rest []string
+	for _, arg := range args {
+		if arg == "-ignore-build-id" || arg == "--ignore-build-id" {
+			ignoreBuildID = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest
+}
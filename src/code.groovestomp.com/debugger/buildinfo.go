@@ -0,0 +1,91 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// showBuildInfo prints what the Go toolchain recorded about how the binary
+// was built: its Go version, main package and module, the versions of the
+// modules it depends on, the build flags and the version control state of
+// its sources.
+func showBuildInfo() error {
+	if binaryBuildInfo == nil {
+		return fmt.Errorf("%v has no Go build info", buildInfoBinary)
+	}
+	info := binaryBuildInfo
+	fmt.Printf("Binary:   %v\n", buildInfoBinary)
+	if exe, err := elf.Open(buildInfoBinary); err == nil {
+		ids := readBuildIDs(exe)
+		exe.Close()
+		if ids.goID != "" {
+			fmt.Printf("Build ID: %v\n", ids.goID)
+		}
+		if ids.gnuID != "" {
+			fmt.Printf("GNU ID:   %v\n", ids.gnuID)
+		}
+	}
+	fmt.Printf("Go:       %v\n", info.GoVersion)
+	fmt.Printf("Package:  %v\n", info.Path)
+	if info.Main.Path != "" {
+		fmt.Printf("Module:   %v\n", describeModule(&info.Main))
+	}
+	if revision := buildSetting("vcs.revision"); revision != "" {
+		state := ""
+		if buildSetting("vcs.modified") == "true" {
+			state = ", modified"
+		}
+		if when := buildSetting("vcs.time"); when != "" {
+			state += ", committed " + when
+		}
+		fmt.Printf("Revision: %v %v%v\n", buildSetting("vcs"), revision, state)
+	}
+
+	if len(info.Deps) > 0 {
+		fmt.Printf("\nDependencies (%v):\n", len(info.Deps))
+		for _, dep := range info.Deps {
+			fmt.Printf("  %v\n", describeModule(dep))
+		}
+	}
+	if len(info.Settings) > 0 {
+		fmt.Println("\nBuild settings:")
+		width := 0
+		for _, setting := range info.Settings {
+			if len(setting.Key) > width {
+				width = len(setting.Key)
+			}
+		}
+		for _, setting := range info.Settings {
+			fmt.Printf("  %-*v  %v\n", width, setting.Key, setting.Value)
+		}
+	}
+	return nil
+}
+
+// describeModule writes a module as go version -m does: its path, version
+// and checksum, and what replaced it, if anything.
+func describeModule(m *debug.Module) string {
+	fields := []string{m.Path}
+	if m.Version != "" {
+		fields = append(fields, m.Version)
+	}
+	if m.Sum != "" {
+		fields = append(fields, m.Sum)
+	}
+	if m.Replace != nil {
+		fields = append(fields, "=> "+describeModule(m.Replace))
+	}
+	return strings.Join(fields, " ")
+}
+
+// buildSetting returns the value of a build setting, or "".
+func buildSetting(key string) string {
+	for _, setting := range binaryBuildInfo.Settings {
+		if setting.Key == key {
+			return setting.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// engineEvent is something the engine tells its frontends, the command
+// line and the DAP and Delve servers, through publish.  A frontend
+// subscribes to what it shows rather than the engine knowing about each.
+type engineEvent interface {
+	// describe says what happened the way the command line would.
+	describe() string
+}
+
+// stopEvent is the program stopping where the user is to see it, once per
+// stop: reason is a session reason such as breakpoint, watchpoint, catch,
+// interrupt or signal, or step for the end of a step.  reported is set when
+// the stop was a crash or a catch, whose report has been printed already.
+type stopEvent struct {
+	tid      int
+	reason   string
+	file     string
+	line     int
+	reported bool
+}
+
+func (e stopEvent) describe() string {
+	return fmt.Sprintf("Thread %v stopped (%v) at %v:%v.", e.tid, e.reason, e.file, e.line)
+}
+
+// targetExitedEvent is the program ending, with its exit status or, killed
+// by a signal, 128 plus the signal's number as a shell gives it.
+type targetExitedEvent struct {
+	status int
+	signal syscall.Signal // Zero for a normal exit.
+}
+
+func (e targetExitedEvent) describe() string {
+	if e.signal != 0 {
+		return fmt.Sprintf("Program terminated with signal %v (%v).", signalName(e.signal), e.signal)
+	}
+	return fmt.Sprintf("Program exited with status %v.", e.status)
+}
+
+// breakpointEvent is a breakpoint being set, deleted or hit: change is
+// "set", "deleted" or "hit".
+type breakpointEvent struct {
+	change string
+	bp     *breakpoint
+}
+
+func (e breakpointEvent) describe() string {
+	return fmt.Sprintf("Breakpoint %v %v at %v:%v.", e.bp.id, e.change, e.bp.file, e.bp.line)
+}
+
+// outputEvent is what the program wrote, to stream stdout or stderr, when
+// a frontend rather than the terminal collects it.
+type outputEvent struct {
+	stream string
+	text   string
+}
+
+func (e outputEvent) describe() string {
+	return e.text
+}
+
+// subscriber is what a frontend has an event handed to.
+type subscriber func(engineEvent)
+
+var (
+	// engineEvents has the command line log every event, to see what a
+	// frontend would be told.
+	engineEvents bool
+
+	subscribers []*subscriber
+
+	// busLock guards subscribers; output is published from the goroutine
+	// reading it.
+	busLock sync.Mutex
+
+	// breakpointsReported is set while a command reports each breakpoint
+	// it sets itself, as breakpoints import does with the line it came
+	// from, so the command line doesn't report them twice.
+	breakpointsReported bool
+)
+
+// subscribe has f called with every event published until the function it
+// returns is called.
+func subscribe(f func(engineEvent)) func() {
+	busLock.Lock()
+	defer busLock.Unlock()
+	s := subscriber(f)
+	subscribers = append(subscribers, &s)
+	return func() {
+		busLock.Lock()
+		defer busLock.Unlock()
+		for i, other := range subscribers {
+			if other == &s {
+				subscribers = append(subscribers[:i], subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// publish hands an event to each subscriber in turn, in the order they
+// subscribed.
+func publish(e engineEvent) {
+	busLock.Lock()
+	list := append([]*subscriber(nil), subscribers...)
+	busLock.Unlock()
+	for _, f := range list {
+		(*f)(e)
+	}
+}
+
+// showEngineEvent is the command line's subscriber: it lists the source
+// where the program stopped, says how it ended and which breakpoints are
+// set, and with engine-events logs every event as well.
+func showEngineEvent(e engineEvent) {
+	if engineEvents {
+		fmt.Printf("[event] %v\n", e.describe())
+	}
+	switch e := e.(type) {
+	case stopEvent:
+		if !e.reported {
+			showListing(e.file, e.line)
+		}
+	case targetExitedEvent:
+		fmt.Println(e.describe())
+	case breakpointEvent:
+		if e.change != "set" || breakpointsReported {
+			return
+		}
+		kind := "Breakpoint"
+		if e.bp.hardware {
+			kind = "Hardware breakpoint"
+		}
+		fmt.Printf("%v %v at 0x%x: %v:%v\n", kind, e.bp.id, e.bp.pc, e.bp.file, e.bp.line)
+	}
+}
+
+// exitEvent describes how status says the program ended.
+func exitEvent(status *syscall.WaitStatus) targetExitedEvent {
+	if status.Signaled() {
+		return targetExitedEvent{status: 128 + int(status.Signal()), signal: status.Signal()}
+	}
+	return targetExitedEvent{status: status.ExitStatus()}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+const (
+	// maxSafeCallDepth is how deep the calls a method makes may nest for it
+	// to count as safe to call.
+	maxSafeCallDepth = 4
+
+	// callStackRoom is how much of the goroutine's stack must be free below
+	// the call, beyond the runtime's own margin, so that a callee with a
+	// small frame doesn't have to grow the stack: the runtime can't copy
+	// one with the call on it.
+	callStackRoom = 512
+
+	// callGap is left between the stack pointer and the call's arguments,
+	// in case anything below the stack pointer is still in use.
+	callGap = 256
+
+	// fxsaveXMMOffset is where X0 starts in the FXSAVE area.
+	fxsaveXMMOffset = 160
+)
+
+// callTraps are the runtime functions a call must not get to: a panic or a
+// fatal error would unwind or kill the program, so the call is abandoned
+// instead.
+var callTraps = []string{"runtime.gopanic", "runtime.throw", "runtime.fatal"}
+
+// calling is set while a call made from an expression runs, which a
+// breakpoint condition it hits can't make another of.
+var calling bool
+
+// isMethodCall reports whether a call expression calls a method rather than
+// converting to a type, as main.T(x) does.
+func isMethodCall(e *callExpr) bool {
+	if _, ok := e.fun.(*selectorExpr); !ok {
+		return false
+	}
+	if name, ok := typeExprName(e.fun); ok {
+		if _, err := lookupType(name); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// evalMethodCall calls x.M(args) in the tracee, on the current thread, and
+// returns its result.  Only methods that can't block or run for long are
+// called unless unsafe-calls is on.
+func (ctx *evalContext) evalMethodCall(e *callExpr) (*value, error) {
+	sel := e.fun.(*selectorExpr)
+	x, err := ctx.eval(sel.x)
+	if err != nil {
+		return nil, err
+	}
+	fn, receiver, err := resolveMethod(ctx.pid, x, sel.field, ctx.symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	args := []*value{receiver}
+	for _, a := range e.args {
+		v, err := ctx.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return callFunction(ctx.pid, fn, args, ctx.symbolTable)
+}
+
+// resolveMethod finds the method called name of x's type, and the receiver
+// to pass it: x itself for a value receiver and a pointer to it for a
+// pointer receiver.  An interface's method is that of the value it holds.
+func resolveMethod(pid int, x *value, name string, symbolTable *gosym.Table) (*gosym.Func, *value, error) {
+	if t, ok := resolveTypedef(x.typ).(*dwarf.StructType); ok && (structField(t, "tab") != nil || structField(t, "_type") != nil) {
+		typeAddress, data, err := interfaceWords(pid, x)
+		if err != nil {
+			return nil, nil, err
+		}
+		if typeAddress == 0 {
+			return nil, nil, fmt.Errorf("cannot call %v on a nil %v", name, typeName(x.typ))
+		}
+		_, dv, err := dynamicValue(pid, typeAddress, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		x = dv
+	}
+
+	typ := typeName(x.typ)
+	pointer := strings.HasPrefix(typ, "*")
+	typ = strings.TrimPrefix(typ, "*")
+	dot := strings.LastIndex(typ, ".")
+	if dot < 0 || strings.ContainsAny(typ, "[]") {
+		return nil, nil, fmt.Errorf("%v has no methods to call", typeName(x.typ))
+	}
+	valueMethod := typ + "." + name
+	pointerMethod := typ[:dot] + ".(*" + typ[dot+1:] + ")." + name
+
+	if pointer {
+		if fn := symbolTable.LookupFunc(pointerMethod); fn != nil {
+			return fn, x, nil
+		}
+		if fn := symbolTable.LookupFunc(valueMethod); fn != nil {
+			target, err := dereference(pid, x)
+			return fn, target, err
+		}
+	} else {
+		if fn := symbolTable.LookupFunc(valueMethod); fn != nil {
+			return fn, x, nil
+		}
+		if fn := symbolTable.LookupFunc(pointerMethod); fn != nil {
+			if x.addr == 0 {
+				return nil, nil, fmt.Errorf("%v has a pointer receiver, and the value isn't in memory", pointerMethod)
+			}
+			return fn, newPointer(x.typ, x.addr), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%v has no method %v in the binary", typeName(x.typ), name)
+}
+
+// callFunction runs fn in the tracee with args, on the current thread
+// alone, and returns its result.  The call is made on the goroutine's
+// stack below the frame it is stopped in, returning to a breakpoint at the
+// entry point, which is never run again; the thread's registers are put
+// back afterwards, whatever happened.
+func callFunction(pid int, fn *gosym.Func, args []*value, symbolTable *gosym.Table) (*value, error) {
+	switch {
+	case noCalls:
+		return nil, errors.New("calls are turned off with -no-calls")
+	case calling:
+		return nil, errors.New("a call can't be made while another runs")
+	case running || anyThreadRunning():
+		return nil, errors.New("calls need every thread stopped")
+	case crashed:
+		return nil, errors.New("the program has crashed; it can't make calls")
+	case !usesRegisterABI(symbolTable):
+		return nil, errors.New("calls need a binary built with the register-based calling convention")
+	}
+	if !unsafeCalls {
+		if err := callSafety(pid, fn, symbolTable, 0); err != nil {
+			return nil, fmt.Errorf("%v may not be safe to call: %v; config unsafe-calls on allows it", fn.Name, err)
+		}
+	}
+
+	parameters, err := functionParameters(stackFrame{pc: fn.Entry, fn: fn})
+	if err != nil {
+		return nil, err
+	}
+	var inputs []abiParameter
+	results := 0
+	for _, p := range parameters {
+		if p.result {
+			results++
+		} else {
+			inputs = append(inputs, p)
+		}
+	}
+	if len(inputs) != len(args) {
+		return nil, fmt.Errorf("%v takes %v arguments, got %v", fn.Name, len(inputs)-1, len(args)-1)
+	}
+	if results != 1 {
+		return nil, fmt.Errorf("%v returns %v values; only a call returning one can be printed", fn.Name, results)
+	}
+
+	var saved syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &saved); err != nil {
+		return nil, err
+	}
+	savedFloats, err := readFXSave(pid)
+	if err != nil {
+		return nil, err
+	}
+	g := currentGoroutine(pid)
+	if err := checkCallStack(pid, g, saved.Rsp); err != nil {
+		return nil, err
+	}
+
+	// Arguments go in registers as the calling convention assigns them,
+	// the rest on the stack in order.  Space for the stack-assigned results
+	// and for the callee to spill its register arguments comes after them.
+	regs := saved
+	floats := savedFloats
+	var stack []byte
+	ints, fps := 0, 0
+	for i, p := range inputs {
+		arg, err := convertArgument(args[i], p.typ)
+		if err != nil {
+			return nil, fmt.Errorf("argument %v of %v: %v", i, fn.Name, err)
+		}
+		var pieces []abiPiece
+		if !assignRegisters(resolveTypedef(p.typ), 0, &ints, &fps, &pieces) {
+			offset := alignOffset(int64(len(stack)), typeAlignment(p.typ))
+			stack = append(stack, make([]byte, offset-int64(len(stack)))...)
+			stack = append(stack, arg.data...)
+			continue
+		}
+		for _, piece := range pieces {
+			word := make([]byte, 8)
+			copy(word, arg.data[piece.offset:piece.offset+piece.size])
+			if piece.float {
+				copy(floats[fxsaveXMMOffset+16*piece.reg:], word)
+				copy(floats[fxsaveXMMOffset+16*piece.reg+8:], make([]byte, 8))
+			} else {
+				setIntRegister(&regs, piece.reg, binary.LittleEndian.Uint64(word))
+			}
+		}
+	}
+	for _, p := range parameters {
+		if p.result {
+			stack = append(stack, make([]byte, alignOffset(int64(len(stack)), 8)-int64(len(stack))+p.typ.Size())...)
+		}
+	}
+	stack = append(stack, make([]byte, 8*(abiIntRegisters+abiFloatRegisters))...)
+
+	argumentsAt := (saved.Rsp - callGap - uint64(len(stack))) &^ 15
+	returnSlot := argumentsAt - 8
+	if returnSlot < goroutineStackGuard(pid, g)+callStackRoom {
+		return nil, errors.New("too little of the goroutine's stack is left to make a call on")
+	}
+	frame := make([]byte, 8)
+	binary.LittleEndian.PutUint64(frame, entryPoint)
+	frame = append(frame, stack...)
+	if _, err := ptracePokeData(pid, uintptr(returnSlot), frame); err != nil {
+		return nil, err
+	}
+	regs.Rsp = returnSlot
+	regs.SetPC(fn.Entry)
+	regs.R14 = g
+	regs.Orig_rax = ^uint64(0) // Don't restart a system call it was stopped in.
+	// X15 is always zero in Go code.
+	copy(floats[fxsaveXMMOffset+16*15:], make([]byte, 16))
+
+	var traps []uint64
+	for _, address := range append([]uint64{entryPoint}, callTrapAddresses(symbolTable)...) {
+		if !isInserted(address) {
+			if err := setBreakpoint(pid, address); err != nil {
+				clearBreakpoints(pid, traps)
+				return nil, err
+			}
+			traps = append(traps, address)
+		}
+	}
+	if err := ptraceSetRegs(pid, &regs); err != nil {
+		clearBreakpoints(pid, traps)
+		return nil, err
+	}
+	if err := writeFXSave(pid, floats); err != nil {
+		ptraceSetRegs(pid, &saved)
+		clearBreakpoints(pid, traps)
+		return nil, err
+	}
+
+	status, timedOut := runCall(pid, symbolTable)
+	if status.Exited() || status.Signaled() {
+		return nil, fmt.Errorf("the program ended during the call to %v", fn.Name)
+	}
+	clearBreakpoints(pid, traps)
+
+	var result *value
+	err = nil
+	pc := getPC(pid)
+	_, _, stoppedIn := symbolTable.PCToLine(pc)
+	switch {
+	case isTrapStop(status) && pc == entryPoint:
+		var after syscall.PtraceRegs
+		if err = ptraceGetRegs(pid, &after); err == nil {
+			v := returnValues(pid, parameters, stackFrame{}, &after, symbolTable)[0]
+			result, err = v.val, v.err
+			if result != nil {
+				result.addr = 0 // The stack it may be on is gone.
+			}
+		}
+	case timedOut:
+		err = fmt.Errorf("%v didn't return within %v", fn.Name, callTimeout)
+	case stoppedIn != nil && contains(callTraps, stoppedIn.Name) && pc == stoppedIn.Entry:
+		err = fmt.Errorf("%v called %v, so the call was abandoned", fn.Name, stoppedIn.Name)
+	default:
+		file, line, _ := symbolTable.PCToLine(pc)
+		err = fmt.Errorf("the call to %v stopped at %v:%v, so it was abandoned", fn.Name, file, line)
+	}
+
+	if restoreErr := ptraceSetRegs(pid, &saved); restoreErr != nil {
+		return nil, restoreErr
+	}
+	if restoreErr := writeFXSave(pid, savedFloats); restoreErr != nil {
+		return nil, restoreErr
+	}
+	currentThread = pid
+	return result, err
+}
+
+// runCall lets the current thread alone run the call it has been set up
+// for, interrupting it if it takes longer than call-timeout.  A signal waiting to be
+// delivered to the thread is held back until after.
+func runCall(pid int, symbolTable *gosym.Table) (*syscall.WaitStatus, bool) {
+	calling = true
+	defer func() { calling = false }()
+	// The call isn't a stop for the session to count and report.
+	defer func(ran bool, reason string) { session.ran, session.reason = ran, reason }(session.ran, session.reason)
+	signal := threads[pid].signal
+	threads[pid].signal = 0
+	defer func() {
+		if t := threads[pid]; t != nil {
+			t.signal = signal
+		}
+	}()
+
+	status := resume(pid, true)
+	if status != nil {
+		return status, false
+	}
+	status = waitForStop(pid, symbolTable, time.Now().Add(callTimeout))
+	if status != nil {
+		return status, false
+	}
+	tgkill(processID, pid, syscall.SIGINT)
+	return waitForStop(pid, symbolTable, time.Time{}), true
+}
+
+func callTrapAddresses(symbolTable *gosym.Table) []uint64 {
+	var addresses []uint64
+	for _, name := range callTraps {
+		if fn := symbolTable.LookupFunc(name); fn != nil {
+			addresses = append(addresses, fn.Entry)
+		}
+	}
+	return addresses
+}
+
+func clearBreakpoints(pid int, addresses []uint64) {
+	for _, address := range addresses {
+		clearBreakpoint(pid, address)
+	}
+}
+
+// checkCallStack makes sure the thread is running goroutine g's code, on
+// its stack, and that the runtime hasn't asked g to stop, which its stack
+// guard would make the callee do.
+func checkCallStack(pid int, g, sp uint64) error {
+	if g == 0 {
+		return errors.New("the thread isn't running a goroutine")
+	}
+	lo, err := readUint64(pid, g)
+	if err != nil {
+		return err
+	}
+	hi, err := readUint64(pid, g+8)
+	if err != nil {
+		return err
+	}
+	if sp <= lo || sp > hi {
+		return errors.New("the thread isn't on its goroutine's stack; step into Go code first")
+	}
+	if guard := goroutineStackGuard(pid, g); guard > hi {
+		return errors.New("the goroutine is being preempted; step and try again")
+	}
+	return nil
+}
+
+// goroutineStackGuard returns g.stackguard0, the lowest the stack pointer
+// may go before a function prologue grows the stack.
+func goroutineStackGuard(pid int, g uint64) uint64 {
+	guard, err := readUint64(pid, g+16)
+	if err != nil {
+		return ^uint64(0)
+	}
+	return guard
+}
+
+// callSafety says why fn could block or run for long, or returns nil if it
+// can't: it makes no system calls, has no loops, and makes no calls but to
+// the runtime's stack growth, bounds check panics and write barriers, and
+// to functions that are safe themselves, a few deep.
+func callSafety(pid int, fn *gosym.Func, symbolTable *gosym.Table, depth int) error {
+	if depth > maxSafeCallDepth {
+		return fmt.Errorf("its calls nest more than %v deep", maxSafeCallDepth)
+	}
+	code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+	if err != nil {
+		return err
+	}
+	for pc := fn.Entry; pc < fn.End; {
+		inst, err := x86asm.Decode(code[pc-fn.Entry:], 64)
+		if err != nil {
+			return fmt.Errorf("cannot decode %v at 0x%x", fn.Name, pc)
+		}
+		next := pc + uint64(inst.Len)
+		switch inst.Op {
+		case x86asm.SYSCALL:
+			return fmt.Errorf("%v makes a system call", fn.Name)
+		case x86asm.CALL, x86asm.JMP:
+			if _, ok := inst.Args[0].(x86asm.Rel); !ok {
+				return fmt.Errorf("%v makes an indirect call or jump", fn.Name)
+			}
+		}
+		if rel, ok := inst.Args[0].(x86asm.Rel); ok {
+			target := next + uint64(int64(rel))
+			switch {
+			case target >= fn.Entry && target < fn.End:
+				// The jump back to the entry after growing the stack is
+				// the only backward one a function without loops has.
+				if target <= pc && target != fn.Entry {
+					return fmt.Errorf("%v has a loop", fn.Name)
+				}
+			default:
+				callee := symbolTable.PCToFunc(target)
+				if callee == nil {
+					return fmt.Errorf("%v calls unknown code at 0x%x", fn.Name, target)
+				}
+				if !isSafeRuntimeCall(callee.Name) {
+					if err := callSafety(pid, callee, symbolTable, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		pc = next
+	}
+	return nil
+}
+
+func isSafeRuntimeCall(name string) bool {
+	for _, prefix := range []string{"runtime.morestack", "runtime.panic", "runtime.goPanic", "runtime.gcWriteBarrier", "runtime.duffzero", "runtime.duffcopy"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertArgument makes a value fit a parameter of type typ: numbers are
+// converted, and anything else has to be of that type already.
+func convertArgument(v *value, typ dwarf.Type) (*value, error) {
+	if typeName(v.typ) == typeName(typ) {
+		return v, nil
+	}
+	switch resolveTypedef(typ).(type) {
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.FloatType, *dwarf.BoolType, *dwarf.CharType, *dwarf.UcharType:
+		switch resolveTypedef(v.typ).(type) {
+		case *dwarf.IntType, *dwarf.UintType, *dwarf.FloatType, *dwarf.BoolType, *dwarf.CharType, *dwarf.UcharType:
+			return convert(v, typ)
+		}
+	case *dwarf.PtrType:
+		if _, ok := resolveTypedef(v.typ).(*dwarf.PtrType); ok {
+			return &value{typ: typ, data: v.data}, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot use %v as %v", typeName(v.typ), typeName(typ))
+}
+
+// setIntRegister sets the nth integer register of the calling convention.
+func setIntRegister(regs *syscall.PtraceRegs, n int, v uint64) {
+	*[]*uint64{&regs.Rax, &regs.Rbx, &regs.Rcx, &regs.Rdi, &regs.Rsi, &regs.R8, &regs.R9, &regs.R10, &regs.R11}[n] = v
+}
+
+func readFXSave(pid int) ([512]byte, error) {
+	var area [512]byte
+	if _, errno := ptraceRaw("PTRACE_GETFPREGS", syscall.PTRACE_GETFPREGS, pid, 0, uintptr(unsafe.Pointer(&area[0]))); errno != 0 {
+		return area, errno
+	}
+	return area, nil
+}
+
+func writeFXSave(pid int, area [512]byte) error {
+	if _, errno := ptraceRaw("PTRACE_SETFPREGS", syscall.PTRACE_SETFPREGS, pid, 0, uintptr(unsafe.Pointer(&area[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
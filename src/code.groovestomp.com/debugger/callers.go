@@ -0,0 +1,80 @@
+package main
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxStoredFrames is how many frames of a stored stack trace are shown.
+const maxStoredFrames = 32
+
+// formatStoredStack renders a slice or array of uintptr holding a stack
+// trace, as runtime.Callers fills in and errors packages keep, such as
+// github.com/pkg/errors's stack and StackTrace, as the functions and lines
+// it goes through, innermost first.  It reports false for one holding
+// anything other than the program's return addresses, or none.
+func formatStoredStack(pid int, v *value) (string, bool) {
+	var elem dwarf.Type
+	var data []byte
+	count := 0
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.StructType:
+		// A slice, whose type may have a name of its own.
+		array := structField(t, "array")
+		if array == nil || structField(t, "len") == nil || structField(t, "cap") == nil || len(v.data) < 16 {
+			return "", false
+		}
+		pt, ok := array.Type.(*dwarf.PtrType)
+		if !ok {
+			return "", false
+		}
+		elem = pt.Type
+		count = int(binary.LittleEndian.Uint64(v.data[8:]))
+	case *dwarf.ArrayType:
+		elem, data, count = t.Type, v.data, int(t.Count)
+	default:
+		return "", false
+	}
+	// uintptr, or a type of its own such as pkg/errors's Frame; a uint64
+	// is a number.
+	if u, ok := resolveTypedef(elem).(*dwarf.UintType); !ok || u.ByteSize != 8 || typeName(elem) == "uint64" || count <= 0 || listingSymbols == nil {
+		return "", false
+	}
+	shown := count
+	if shown > maxStoredFrames {
+		shown = maxStoredFrames
+	}
+	if data == nil {
+		var err error
+		if data, err = readMemory(pid, binary.LittleEndian.Uint64(v.data), shown*8); err != nil {
+			return "", false
+		}
+	}
+
+	var frames []string
+	for i := 0; i < shown && (i+1)*8 <= len(data); i++ {
+		pc := binary.LittleEndian.Uint64(data[i*8:])
+		if pc == 0 {
+			// The rest of an array runtime.Callers didn't fill.
+			count = i
+			break
+		}
+		// A return address; the call is the instruction before it.
+		fn := listingSymbols.PCToFunc(pc - 1)
+		if fn == nil {
+			return "", false
+		}
+		file, line, _ := listingSymbols.PCToLine(pc - 1)
+		frames = append(frames, fmt.Sprintf("%v (%v:%v)", fn.Name, filepath.Base(file), line))
+	}
+	if len(frames) == 0 {
+		return "", false
+	}
+	if count > len(frames) {
+		frames = append(frames, fmt.Sprintf("...+%v more", count-len(frames)))
+	}
+	return fmt.Sprintf("stack [%v]", strings.Join(frames, " <- ")), true
+}
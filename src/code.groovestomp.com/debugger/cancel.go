@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Each command runs under commandContext, which Ctrl-C cancels, as does
+// command-timeout passing.  What can take long, reading the tracee's memory,
+// formatting a deep value, going through every symbol, checks it as it goes
+// and stops with what it has, leaving the session as it was.
+var (
+	commandContext = context.Background()
+
+	// commandTimeout is how long a command may work before it is cancelled,
+	// or 0 for as long as it takes.  Time the program runs doesn't count.
+	commandTimeout time.Duration
+
+	// cancelCommand cancels commandContext, nil while no command runs.  The
+	// interrupt handler calls it from its own goroutine.
+	cancelCommand context.CancelFunc
+	commandMutex  sync.Mutex
+
+	// waitingForTracee is set while the debugger waits for the program to
+	// stop, when a Ctrl-C is the program's rather than the command's.
+	waitingForTracee int32
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// beginCommand gives the command about to run a fresh context.
+func beginCommand() {
+	ctx, cancel := context.WithCancel(context.Background())
+	if commandTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), commandTimeout)
+	}
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	commandContext, cancelCommand = ctx, cancel
+}
+
+// endCommand releases the context of the command that has finished.
+func endCommand() {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	if cancelCommand != nil {
+		cancelCommand()
+	}
+	commandContext, cancelCommand = context.Background(), nil
+}
+
+// renewCommand starts the context of a command over once the program has
+// run for it: the timeout counts from the stop, and a Ctrl-C that came
+// meanwhile was for the program.
+func renewCommand() {
+	commandMutex.Lock()
+	running := cancelCommand != nil
+	commandMutex.Unlock()
+	if running {
+		endCommand()
+		beginCommand()
+	}
+}
+
+// interruptCommand cancels the command under way for a Ctrl-C, reporting
+// false if none is, or if the program is running and the Ctrl-C is its.
+func interruptCommand() bool {
+	if atomic.LoadInt32(&waitingForTracee) != 0 {
+		return false
+	}
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+	if cancelCommand == nil {
+		return false
+	}
+	cancelCommand()
+	return true
+}
+
+// commandCancelled returns why the command under way was cancelled, or nil
+// if it wasn't.
+func commandCancelled() error {
+	switch commandContext.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return fmt.Errorf("interrupted: the command took longer than %v (config command-timeout)", commandTimeout)
+	}
+	return errInterrupted
+}
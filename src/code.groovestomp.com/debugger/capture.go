@@ -0,0 +1,206 @@
+package main
+
+import (
+	"debug/gosym"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCaptureRing is how many passes a capture trace keeps without -ring.
+const defaultCaptureRing = 1000
+
+// captureTrace records expressions each time the program passes a
+// breakpoint, without stopping it, keeping the latest passes in a ring.
+type captureTrace struct {
+	names []string
+	exprs []expr
+	ring  []capturedPass
+	next  int // Where the next pass goes once the ring is full.
+	size  int
+	total int
+}
+
+// capturedPass is one pass of a capture trace: when, on which goroutine,
+// and the values of the expressions, or the errors reading them.
+type capturedPass struct {
+	at        time.Time
+	goroutine uint64
+	values    []string
+}
+
+// runCaptureCommand implements "trace <location> -capture <expr>,... [-ring
+// <n>] [if <condition>]".
+func runCaptureCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	spec, condition := splitCondition(argument)
+	spec, ring, err := splitOption(spec, "-ring", "a number of passes")
+	if err != nil {
+		return err
+	}
+	size := defaultCaptureRing
+	if ring != "" {
+		if size, err = strconv.Atoi(ring); err != nil || size < 1 {
+			return fmt.Errorf("-ring expects a positive number of passes, got %q", ring)
+		}
+	}
+	i := strings.Index(spec, "-capture ")
+	if i < 0 {
+		return fmt.Errorf("usage: trace <location> -capture <expr>,... [-ring <n>] [if <condition>]")
+	}
+	location := strings.TrimSpace(spec[:i])
+	c := &captureTrace{size: size}
+	for _, name := range splitExpressions(spec[i+len("-capture "):]) {
+		e, err := parseExpression(name)
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		c.names = append(c.names, name)
+		c.exprs = append(c.exprs, e)
+	}
+	if len(c.exprs) == 0 || location == "" {
+		return fmt.Errorf("usage: trace <location> -capture <expr>,... [-ring <n>] [if <condition>]")
+	}
+
+	if condition != "" {
+		location += " if " + condition
+	}
+	bp, err := createBreakpoint(pid, location, symbolTable)
+	if err != nil {
+		return err
+	}
+	bp.capture = c
+	fmt.Printf("Capturing %v in the last %v passes.\n", strings.Join(c.names, ", "), size)
+	return nil
+}
+
+// splitExpressions splits a comma-separated list of expressions, leaving
+// the commas inside parentheses and brackets alone.
+func splitExpressions(list string) []string {
+	var names []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				names = append(names, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(list[start:]); last != "" {
+		names = append(names, last)
+	}
+	return names
+}
+
+// hit records a pass, overwriting the oldest once the ring is full.
+func (c *captureTrace) hit(pid int, symbolTable *gosym.Table) {
+	pass := capturedPass{at: time.Now()}
+	if g, err := readRuntimeStruct(pid, "runtime.g", currentGoroutine(pid)); err == nil {
+		pass.goroutine = scalarMember(g, "goid")
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	for _, e := range c.exprs {
+		if err != nil {
+			pass.values = append(pass.values, fmt.Sprintf("<%v>", err))
+		} else if v, err := ctx.eval(e); err != nil {
+			pass.values = append(pass.values, fmt.Sprintf("<%v>", err))
+		} else {
+			pass.values = append(pass.values, formatValue(pid, v))
+		}
+	}
+	endEvaluation()
+
+	c.total++
+	if len(c.ring) < c.size {
+		c.ring = append(c.ring, pass)
+		return
+	}
+	c.ring[c.next] = pass
+	c.next = (c.next + 1) % c.size
+}
+
+// passes returns the passes in the ring, oldest first.
+func (c *captureTrace) passes() []capturedPass {
+	return append(append([]capturedPass(nil), c.ring[c.next:]...), c.ring[:c.next]...)
+}
+
+// describe sums up the capture trace for info breakpoints.
+func (c *captureTrace) describe() string {
+	return fmt.Sprintf("capture %v -ring %v, %v of %v passes kept",
+		strings.Join(c.names, ","), c.size, len(c.ring), c.total)
+}
+
+// tracedPass is a pass with the breakpoint it was at, for dumping the
+// passes of every capture trace in the order they happened.
+type tracedPass struct {
+	bp *breakpoint
+	capturedPass
+}
+
+// runTraceDump implements "trace dump [<file>]": it prints what the capture
+// traces recorded, oldest first, or writes it to a file as JSON, one pass
+// to a line.
+func runTraceDump(argument string) error {
+	var all []tracedPass
+	for _, bp := range breakpoints {
+		if bp.capture == nil {
+			continue
+		}
+		for _, pass := range bp.capture.passes() {
+			all = append(all, tracedPass{bp, pass})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].at.Before(all[j].at) })
+
+	if argument == "" {
+		if len(all) == 0 {
+			fmt.Println("Nothing captured.")
+		}
+		for _, p := range all {
+			var values []string
+			for i, name := range p.bp.capture.names {
+				values = append(values, name+"="+p.values[i])
+			}
+			fmt.Printf("%v  #%v %v:%v  goroutine %v  %v\n", p.at.Format("15:04:05.000000"),
+				p.bp.id, p.bp.file, p.bp.line, p.goroutine, strings.Join(values, " "))
+		}
+		return nil
+	}
+
+	f, err := os.Create(argument)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, p := range all {
+		values := make(map[string]string)
+		for i, name := range p.bp.capture.names {
+			values[name] = p.values[i]
+		}
+		err := encoder.Encode(struct {
+			Time       time.Time         `json:"time"`
+			Breakpoint int               `json:"breakpoint"`
+			Location   string            `json:"location"`
+			Goroutine  uint64            `json:"goroutine"`
+			Values     map[string]string `json:"values"`
+		}{p.at, p.bp.id, fmt.Sprintf("%v:%v", p.bp.file, p.bp.line), p.goroutine, values})
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v passes to %v.\n", len(all), argument)
+	return nil
+}
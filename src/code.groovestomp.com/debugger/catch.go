@@ -0,0 +1,278 @@
+package main
+
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// throwFunctions are the runtime functions every fatal error goes through:
+// throw for broken runtime invariants, fatal for errors in the program such
+// as concurrent map writes or a deadlock.
+var throwFunctions = []string{"runtime.throw", "runtime.fatal"}
+
+// exitFunctions is where every exit of the process ends up, from os.Exit
+// or main returning.  It is written in assembly, so its code is on the
+// stack rather than in a register.
+var exitFunctions = []string{"runtime.exit", "runtime.exit.abi0"}
+
+// schedinitFunction is the runtime's scheduler initialization, which rt0_go
+// calls once the arguments and the OS have been looked at.  When it returns
+// the heap, the GC, the environment and GODEBUG are set up, but no package
+// has been initialized.
+const schedinitFunction = "runtime.schedinit"
+
+var (
+	// catchThrow is set by "catch throw" and survives restarts.
+	catchThrow bool
+
+	// catchExit is set by "catch exit" and survives restarts.
+	catchExit bool
+
+	// catchRuntimeInit is set by "catch runtime-init" and survives
+	// restarts.
+	catchRuntimeInit bool
+
+	// runtimeInitAddress is where rt0_go continues after schedinit while
+	// the catch is armed, until the stop there.
+	runtimeInitAddress uint64
+
+	// exitAddress is the entry of runtime.exit while the catch is armed.
+	exitAddress uint64
+
+	// throwAddresses are the entries of throwFunctions while the catch is
+	// armed.
+	throwAddresses = make(map[uint64]string)
+)
+
+// runCatchCommand implements "catch throw", "catch exit", "catch
+// runtime-init", "catch log", "catch output", "catch gc" and "catch chan".
+func runCatchCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "log" || strings.HasPrefix(argument, "log ") {
+		return runCatchLogCommand(pid, strings.TrimSpace(strings.TrimPrefix(argument, "log")), symbolTable)
+	}
+	if argument == "gc" || strings.HasPrefix(argument, "gc ") {
+		return runCatchGCCommand(pid, strings.TrimSpace(strings.TrimPrefix(argument, "gc")), symbolTable)
+	}
+	if argument == "chan" || strings.HasPrefix(argument, "chan ") {
+		return runCatchChanCommand(pid, strings.TrimSpace(strings.TrimPrefix(argument, "chan")), symbolTable)
+	}
+	if argument == "output" || strings.HasPrefix(argument, "output ") {
+		return runCatchOutputCommand(pid, strings.TrimSpace(strings.TrimPrefix(argument, "output")), symbolTable)
+	}
+	switch argument {
+	case "throw":
+		if err := armThrowCatcher(pid, symbolTable); err != nil {
+			return err
+		}
+		catchThrow = true
+		fmt.Println("Catchpoint on fatal runtime errors.")
+		return nil
+	case "exit":
+		if err := armExitCatcher(pid, symbolTable); err != nil {
+			return err
+		}
+		catchExit = true
+		if exitAddress == 0 {
+			return fmt.Errorf("no runtime.exit in the binary")
+		}
+		fmt.Println("Catchpoint on the process exiting.")
+		return nil
+	case "runtime-init":
+		if err := armRuntimeInitCatcher(pid, symbolTable); err != nil {
+			return err
+		}
+		catchRuntimeInit = true
+		fmt.Println("Catchpoint on the runtime finishing schedinit, before any package is initialized; restart to stop there.")
+		return nil
+	}
+	return fmt.Errorf("usage: catch throw|exit|runtime-init|log <regexp>|output <regexp>|gc [start|end]|chan <expr> [send|recv]")
+}
+
+// armThrowCatcher puts an internal breakpoint on each of throwFunctions.
+func armThrowCatcher(pid int, symbolTable *gosym.Table) error {
+	for _, name := range throwFunctions {
+		fn := symbolTable.LookupFunc(name)
+		if fn == nil {
+			continue
+		}
+		if err := setBreakpoint(pid, fn.Entry); err != nil {
+			return err
+		}
+		throwAddresses[fn.Entry] = name
+	}
+	return nil
+}
+
+// armExitCatcher puts an internal breakpoint on runtime.exit.
+func armExitCatcher(pid int, symbolTable *gosym.Table) error {
+	for _, name := range exitFunctions {
+		if fn := symbolTable.LookupFunc(name); fn != nil {
+			if err := setBreakpoint(pid, fn.Entry); err != nil {
+				return err
+			}
+			exitAddress = fn.Entry
+			return nil
+		}
+	}
+	return nil
+}
+
+// armRuntimeInitCatcher puts an internal breakpoint where rt0_go continues
+// after calling schedinit.  Hitting it once disarms it.
+func armRuntimeInitCatcher(pid int, symbolTable *gosym.Table) error {
+	// With the register-based calling convention, rt0_go calls the ABI0
+	// wrapper of schedinit, which has the same name in the symbol table.
+	rt0 := symbolTable.LookupFunc("runtime.rt0_go")
+	targets := make(map[uint64]bool)
+	for i := range symbolTable.Funcs {
+		if fn := &symbolTable.Funcs[i]; fn.Name == schedinitFunction {
+			targets[fn.Entry] = true
+		}
+	}
+	if rt0 == nil || len(targets) == 0 {
+		return fmt.Errorf("no runtime.rt0_go or runtime.schedinit in the binary")
+	}
+	returns, err := callReturns(pid, rt0, targets)
+	if err != nil {
+		return err
+	}
+	if len(returns) != 1 {
+		return fmt.Errorf("found %v calls of runtime.schedinit in %v, expected one", len(returns), rt0.Name)
+	}
+	if err := setBreakpoint(pid, returns[0]); err != nil {
+		return err
+	}
+	runtimeInitAddress = returns[0]
+	return nil
+}
+
+// showRuntimeInitStop reports a stop after schedinit and disarms the
+// catch; the runtime only initializes once per run.
+func showRuntimeInitStop(pid int, symbolTable *gosym.Table) {
+	if bp := findBreakpoint(runtimeInitAddress); bp == nil || bp.disabled {
+		clearBreakpoint(pid, runtimeInitAddress)
+	}
+	pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(runtimeInitAddress)
+	runtimeInitAddress = 0
+	fmt.Println("\nThe runtime has finished schedinit; main.main and the package initializers haven't run yet.")
+}
+
+// catcher is a set of internal breakpoints, each mapped to what it catches.
+// filter, if any, reports whether a thread at one of them is to stop; one
+// that isn't steps over it and runs on.
+type catcher struct {
+	addresses *map[uint64]string
+	filter    func(tid int, kind string, symbolTable *gosym.Table) bool
+}
+
+// catchers are the internal breakpoints of catch, goroutine-events and
+// watch -growth that are set at more than one address.
+var catchers = []catcher{
+	{&throwAddresses, nil},
+	{&goroutineEventAddresses, func(tid int, kind string, symbolTable *gosym.Table) bool {
+		logGoroutineEvent(tid, kind, symbolTable)
+		return false
+	}},
+	{&logCatchAddresses, logMessageMatches},
+	{&gcCatchAddresses, func(tid int, phase string, symbolTable *gosym.Table) bool {
+		return gcStopWanted(tid, phase)
+	}},
+	{&chanCatchAddresses, func(tid int, op string, symbolTable *gosym.Table) bool {
+		return chanStopWanted(tid)
+	}},
+	{&growthAddresses, func(tid int, name string, symbolTable *gosym.Table) bool {
+		return growthMatches(tid, name)
+	}},
+	{&outputCatchAddresses, func(tid int, name string, symbolTable *gosym.Table) bool {
+		return outputMatches(tid)
+	}},
+}
+
+// findCatcher returns the catcher with an internal breakpoint at pc and what
+// it catches there, or nil.
+func findCatcher(pc uint64) (*catcher, string) {
+	for i := range catchers {
+		if kind, ok := (*catchers[i].addresses)[pc]; ok {
+			return &catchers[i], kind
+		}
+	}
+	return nil, ""
+}
+
+// disarmCatcher removes the internal breakpoints of a catcher again, leaving
+// any user breakpoint at the same address.
+func disarmCatcher(pid int, addresses map[uint64]string) {
+	for address := range addresses {
+		if bp := findBreakpoint(address); bp == nil || bp.disabled {
+			clearBreakpoint(pid, address)
+		}
+		delete(addresses, address)
+	}
+}
+
+// isCatchAddress reports whether an address holds an internal breakpoint of
+// catch, goroutine-events or watch -growth: one of the catchers, or the
+// single-address catches of a fatal panic, catch exit and catch
+// runtime-init.
+func isCatchAddress(pc uint64) bool {
+	c, _ := findCatcher(pc)
+	return c != nil || fatalPanicAddress != 0 && pc == fatalPanicAddress || exitAddress != 0 && pc == exitAddress ||
+		runtimeInitAddress != 0 && pc == runtimeInitAddress
+}
+
+// throwMessage reads the string argument of runtime.throw or runtime.fatal
+// from the registers at the function's entry.
+func throwMessage(pid int) (string, error) {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return "", err
+	}
+	length := int64(regs.Rbx)
+	if length < 0 || length > maxStringLength {
+		return "", fmt.Errorf("invalid message length %v", length)
+	}
+	data, err := readMemory(pid, regs.Rax, int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// showExitStop reports a stop at runtime.exit: the exit code and where the
+// program asked to exit, so its globals can be looked at one last time.
+func showExitStop(pid int, symbolTable *gosym.Table) {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		fmt.Println(err)
+		return
+	}
+	code := "unknown"
+	if data, err := readMemory(pid, regs.Rsp+8, 4); err == nil {
+		code = fmt.Sprint(int32(binary.LittleEndian.Uint32(data)))
+	}
+
+	frames := backtrace(pid, symbolTable)
+	how := ""
+	for _, frame := range frames {
+		if frame.fn.Name == "os.Exit" {
+			how = " via os.Exit"
+		}
+	}
+	if len(frames) > 1 && frames[1].fn.Name == "runtime.main" {
+		fmt.Printf("\nProgram is exiting with code %v after main returned.\n", code)
+		fmt.Println("Continue to let it exit.")
+		return
+	}
+	fmt.Printf("\nProgram is exiting with code %v%v.\n", code, how)
+	if len(frames) == 0 {
+		return
+	}
+	frame := frames[userFrame(frames)]
+	pcSourceFile, pcSourceLine = frame.file, frame.line
+	fmt.Printf("Called from %v at %v:%v.\n", frame.fn.Name, frame.file, frame.line)
+	showListing(frame.file, frame.line)
+	fmt.Println("Continue to let it exit.")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// chanFunctions are where a send or a receive on a channel goes, with the
+// channel's runtime.hchan in RAX at their entry: the statements, and a
+// select with one case and a default.  A select of several cases works
+// the channels in selectgo instead, and isn't caught.
+var chanFunctions = map[string]string{"runtime.chansend": "send", "runtime.chanrecv": "recv"}
+
+// chanCatch is the channel "catch chan" stops on.
+type chanCatch struct {
+	expression string
+	hchan      uint64
+	ops        string // send, recv or both.
+}
+
+var (
+	// catchChan is set by "catch chan" and, the channel being the old
+	// process's, is dropped on restart.
+	catchChan *chanCatch
+
+	// chanCatchAddresses are the entries of chanFunctions, with their
+	// operation, while the catch is armed.
+	chanCatchAddresses = make(map[uint64]string)
+)
+
+// runCatchChanCommand implements "catch chan <expr> [send|recv]" and
+// "catch chan off".
+func runCatchChanCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "off" {
+		disarmCatcher(pid, chanCatchAddresses)
+		catchChan = nil
+		fmt.Println("Catchpoint on the channel removed.")
+		return nil
+	}
+	fields := strings.Fields(argument)
+	if len(fields) == 0 {
+		return fmt.Errorf("usage: catch chan <expr> [send|recv] | off")
+	}
+	ops := "both"
+	if last := fields[len(fields)-1]; len(fields) > 1 && (last == "send" || last == "recv") {
+		ops = last
+		argument = strings.TrimSpace(strings.TrimSuffix(argument, last))
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, argument)
+	if err != nil {
+		return err
+	}
+	name := typeName(v.typ)
+	if !strings.HasPrefix(name, "chan") && !strings.HasPrefix(name, "<-chan") {
+		return fmt.Errorf("%v is a %v, not a channel", argument, name)
+	}
+	hchan := zeroExtend(v.data)
+	if hchan == 0 {
+		return fmt.Errorf("%v is nil; nothing communicates on it without blocking for ever", argument)
+	}
+
+	disarmCatcher(pid, chanCatchAddresses)
+	catchChan = &chanCatch{expression: argument, hchan: hchan, ops: ops}
+	if err := armChanCatcher(pid, symbolTable); err != nil {
+		catchChan = nil
+		return err
+	}
+	what := map[string]string{"send": "sends on", "recv": "receives from", "both": "sends on and receives from"}[ops]
+	fmt.Printf("Catchpoint on %v %v (0x%x).\n", what, argument, hchan)
+	return nil
+}
+
+// armChanCatcher puts internal breakpoints on the entries of the
+// chanFunctions catchChan stops at.
+func armChanCatcher(pid int, symbolTable *gosym.Table) error {
+	for name, op := range chanFunctions {
+		if catchChan.ops != "both" && catchChan.ops != op {
+			continue
+		}
+		fn := symbolTable.LookupFunc(name)
+		if fn == nil {
+			return fmt.Errorf("no %v in the binary", name)
+		}
+		if err := setBreakpoint(pid, fn.Entry); err != nil {
+			return err
+		}
+		chanCatchAddresses[fn.Entry] = op
+	}
+	return nil
+}
+
+// chanStopWanted reports whether a thread at one of chanCatchAddresses is
+// communicating on the caught channel.
+func chanStopWanted(tid int) bool {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(tid, &regs); err != nil {
+		return true
+	}
+	return catchChan != nil && regs.Rax == catchChan.hchan
+}
+
+// showChanStop reports a send or receive on the caught channel, with the
+// goroutine communicating and how full the channel is, selecting the
+// frame that does it.
+func showChanStop(pid int, symbolTable *gosym.Table) {
+	op := chanCatchAddresses[getPC(pid)]
+	goroutine := "?"
+	if g, err := readRuntimeStruct(pid, "runtime.g", currentGoroutine(pid)); err == nil {
+		goroutine = fmt.Sprint(scalarMember(g, "goid"))
+	}
+	what := map[string]string{"send": "Send on", "recv": "Receive from"}[op]
+	fmt.Printf("\n%v %v (0x%x) by goroutine %v", what, catchChan.expression, catchChan.hchan, goroutine)
+	if c, err := readRuntimeStruct(pid, "runtime.hchan", catchChan.hchan); err == nil {
+		fmt.Printf(", %v of %v buffered", scalarMember(c, "qcount"), scalarMember(c, "dataqsiz"))
+		if scalarMember(c, "closed") != 0 {
+			fmt.Print(", closed")
+		}
+	}
+	fmt.Println(".")
+	if frame, ok := selectFrameOutside(pid, symbolTable, "runtime.", "internal/"); ok {
+		fmt.Printf("In %v at %v:%v, frame #%v.\n", frame.fn.Name, frame.file, frame.line, selection.index)
+		showListing(frame.file, frame.line)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a debugger option changed with the config command.
+type setting struct {
+	name        string
+	description string
+	get         func() string
+	set         func(string) error
+}
+
+var (
+	// stepDefers makes next step into deferred calls instead of over them.
+	stepDefers bool
+
+	// renderTimes shows time.Time, time.Duration and integers that look like
+	// Unix timestamps in human form.
+	renderTimes = true
+
+	// showInlineValues notes the values of the variables the current line
+	// uses at the end of it in listings.
+	showInlineValues = true
+
+	// stepTimeout is how long next waits for a call it steps over before
+	// handing control back with the program running in the background.
+	stepTimeout time.Duration
+
+	// schedulerLocking is off, step or on: whether only the current thread
+	// runs during next, or always.
+	schedulerLocking = "off"
+
+	// unsafeCalls lets expressions call any method, not only those that
+	// can't block or run for long.
+	unsafeCalls bool
+)
+
+var settings = []setting{
+	{
+		name:        "step-defers",
+		description: "next steps into deferred calls run at function exit",
+		get:         func() string { return formatBool(stepDefers) },
+		set:         func(v string) error { return parseBool(v, &stepDefers) },
+	},
+	{
+		name:        "render-times",
+		description: "print times, durations and timestamp-like integers in human form",
+		get:         func() string { return formatBool(renderTimes) },
+		set:         func(v string) error { return parseBool(v, &renderTimes) },
+	},
+	{
+		name:        "inline-values",
+		description: "show the values of the variables the current line uses beside it",
+		get:         func() string { return formatBool(showInlineValues) },
+		set:         func(v string) error { return parseBool(v, &showInlineValues) },
+	},
+	{
+		name:        "goroutine-events",
+		description: "log goroutines starting, with the stack that started them, and exiting",
+		get:         func() string { return formatBool(goroutineEvents) },
+		set:         parseGoroutineEvents,
+	},
+	{
+		name:        "thread-events",
+		description: "log OS threads starting and exiting",
+		get:         func() string { return formatBool(threadEvents) },
+		set:         func(v string) error { return parseBool(v, &threadEvents) },
+	},
+	{
+		name:        "show-provenance",
+		description: "tag each printed value with the goroutine, frame and pc it was read in",
+		get:         func() string { return formatBool(showProvenance) },
+		set:         func(v string) error { return parseBool(v, &showProvenance) },
+	},
+	{
+		name:        "engine-events",
+		description: "log the events the engine publishes to frontends",
+		get:         func() string { return formatBool(engineEvents) },
+		set:         func(v string) error { return parseBool(v, &engineEvents) },
+	},
+	{
+		name:        "step-timeout",
+		description: "how long next waits for a call to return, 0 for ever",
+		get:         func() string { return stepTimeout.String() },
+		set:         func(v string) error { return parseDuration(v, &stepTimeout) },
+	},
+	{
+		name:        "non-stop",
+		description: "a stop in one thread leaves the other threads running",
+		get:         func() string { return formatBool(nonStop) },
+		set:         func(v string) error { return parseBool(v, &nonStop) },
+	},
+	{
+		name:        "displaced-stepping",
+		description: "step off a breakpoint by running a copy of its instruction elsewhere",
+		get:         func() string { return formatBool(displacedStepping) },
+		set:         func(v string) error { return parseBool(v, &displacedStepping) },
+	},
+	{
+		name:        "scheduler-locking",
+		description: "whether other threads run during next (step) or at all (on)",
+		get:         func() string { return schedulerLocking },
+		set:         parseSchedulerLocking,
+	},
+	{
+		name:        "unsafe-calls",
+		description: "expressions may call methods that could block or run for long",
+		get:         func() string { return formatBool(unsafeCalls) },
+		set:         func(v string) error { return parseBool(v, &unsafeCalls) },
+	},
+	{
+		name:        "call-timeout",
+		description: "how long a call from an expression may run before it is abandoned",
+		get:         func() string { return callTimeout.String() },
+		set:         parseCallTimeout,
+	},
+	{
+		name:        "eval-read-limit",
+		description: "how much memory evaluating an expression may read, or off",
+		get:         func() string { return formatByteSize(evalReadLimit) },
+		set:         func(v string) error { return parseByteSize(v, &evalReadLimit) },
+	},
+	{
+		name:        "command-timeout",
+		description: "how long a command may work before it is cancelled, 0 for ever",
+		get:         func() string { return commandTimeout.String() },
+		set:         func(v string) error { return parseDuration(v, &commandTimeout) },
+	},
+	{
+		name:        "max-stop-duration",
+		description: "how long a process attached to may stay stopped at an idle prompt, 0 for ever",
+		get:         func() string { return maxStopDuration.String() },
+		set:         func(v string) error { return parseDuration(v, &maxStopDuration) },
+	},
+	{
+		name:        "max-stop-action",
+		description: "what max-stop-duration running out does: warn, continue or detach",
+		get:         func() string { return maxStopAction },
+		set:         parseMaxStopAction,
+	},
+	{
+		name:        "notify-cmd",
+		description: "a shell command run when the program stops in the background, or off",
+		get:         formatNotifyCommand,
+		set:         parseNotifyCommand,
+	},
+	{
+		name:        "wait-flags",
+		description: "the wait4 flags threads' status changes are collected with",
+		get:         formatWaitFlags,
+		set:         parseWaitFlags,
+	},
+	{
+		name:        "source-server",
+		description: "a URL template to fetch sources missing here from, or off",
+		get:         formatSourceServer,
+		set:         parseSourceServer,
+	},
+}
+
+func findSetting(name string) *setting {
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+// runConfigCommand lists, shows or changes settings:
+//
+//	config
+//	config <name>
+//	config <name> <value>
+func runConfigCommand(argument string) {
+	if argument == "" {
+		for _, s := range settings {
+			fmt.Printf("%-18v %-8v %v\n", s.name, s.get(), s.description)
+		}
+		return
+	}
+
+	name, value := argument, ""
+	if i := strings.Index(argument, " "); i >= 0 {
+		name, value = argument[:i], strings.TrimSpace(argument[i+1:])
+	}
+	s := findSetting(name)
+	if s == nil {
+		fmt.Printf("unknown setting %v\n", name)
+		return
+	}
+	if value == "" {
+		fmt.Printf("%v %v\n", s.name, s.get())
+		return
+	}
+	if err := s.set(value); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func parseDuration(value string, target *time.Duration) error {
+	if value == "0" {
+		*target = 0
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return fmt.Errorf("expected a duration like 5s, got %q", value)
+	}
+	*target = d
+	return nil
+}
+
+func parseBool(value string, target *bool) error {
+	switch value {
+	case "on", "yes":
+		*target = true
+		return nil
+	case "off", "no":
+		*target = false
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected on or off, got %q", value)
+	}
+	*target = b
+	return nil
+}
+
+func parseSchedulerLocking(value string) error {
+	switch value {
+	case "off", "step", "on":
+		schedulerLocking = value
+		return nil
+	}
+	return fmt.Errorf("expected off, step or on, got %q", value)
+}
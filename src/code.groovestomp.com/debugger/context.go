@@ -0,0 +1,236 @@
+package main
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tflagExtraStar and tflagDirectIface are flags of internal/abi.Type: the
+// name carries a leading * to drop, and the value is stored in the
+// interface word itself rather than pointed to.
+const (
+	tflagExtraStar   = 1 << 1
+	tflagDirectIface = 1 << 5
+)
+
+// maxContextChain bounds the walk up a context's parents.
+const maxContextChain = 64
+
+// runtimeTypeName reads the name of the runtime type descriptor at address,
+// the string form reflect would print, such as *context.cancelCtx.
+func runtimeTypeName(pid int, address uint64) (string, bool, error) {
+	t, err := readRuntimeStruct(pid, "internal/abi.Type", address)
+	if err != nil {
+		return "", false, err
+	}
+	module, err := readGlobal(pid, "runtime.firstmoduledata")
+	if err != nil {
+		return "", false, err
+	}
+	flags := scalarMember(t, "TFlag")
+	offset := uint64(int64(int32(scalarMember(t, "Str"))))
+	header, err := readMemory(pid, scalarMember(module, "types")+offset, 1+binary.MaxVarintLen32)
+	if err != nil {
+		return "", false, err
+	}
+	length, n := binary.Uvarint(header[1:])
+	if n <= 0 || length > maxStringLength {
+		return "", false, fmt.Errorf("invalid type name at 0x%x", address)
+	}
+	data, err := readMemory(pid, scalarMember(module, "types")+offset+1+uint64(n), int(length))
+	if err != nil {
+		return "", false, err
+	}
+	name := string(data)
+	if flags&tflagExtraStar != 0 {
+		name = name[1:]
+	}
+	return name, flags&tflagDirectIface != 0, nil
+}
+
+// dynamicValue reads what an interface holds from its type descriptor and
+// data word.
+func dynamicValue(pid int, typeAddress, data uint64) (string, *value, error) {
+	name, direct, err := runtimeTypeName(pid, typeAddress)
+	if err != nil {
+		return "", nil, err
+	}
+	typ, err := lookupRuntimeType(name)
+	if err != nil {
+		return name, nil, err
+	}
+	if direct {
+		word := make([]byte, 8)
+		binary.LittleEndian.PutUint64(word, data)
+		return name, &value{typ: typ, data: word[:typ.Size()]}, nil
+	}
+	if typ.Size() < 0 {
+		return name, nil, fmt.Errorf("%v has no size", name)
+	}
+	contents, err := readMemory(pid, data, int(typ.Size()))
+	if err != nil {
+		return name, nil, err
+	}
+	return name, &value{typ: typ, addr: data, data: contents}, nil
+}
+
+// lookupRuntimeType finds the debug information type for a runtime type
+// name.  The runtime qualifies names by package name rather than path, so
+// fs.PathError may be io/fs.PathError.
+func lookupRuntimeType(name string) (dwarf.Type, error) {
+	if typ, err := lookupType(name); err == nil {
+		return typ, nil
+	}
+	base := strings.TrimPrefix(name, "*")
+	var matches []string
+	for candidate := range namedTypes {
+		if strings.HasSuffix(candidate, "/"+base) && !strings.HasPrefix(candidate, "*") {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("no type named %v", name)
+	}
+	return lookupType(strings.TrimSuffix(name, base) + matches[0])
+}
+
+// interfaceWords splits an interface value, empty or not, into its type
+// descriptor and data word.
+func interfaceWords(pid int, v *value) (uint64, uint64, error) {
+	if v == nil || len(v.data) < 16 {
+		return 0, 0, errors.New("not an interface")
+	}
+	first := binary.LittleEndian.Uint64(v.data)
+	data := binary.LittleEndian.Uint64(v.data[8:])
+	if first == 0 {
+		return 0, data, nil
+	}
+	if t, ok := resolveTypedef(v.typ).(*dwarf.StructType); ok && structField(t, "tab") != nil {
+		// A non-empty interface points at an itab, whose _type field is
+		// the dynamic type.
+		itab, err := readRuntimeStruct(pid, "internal/abi.ITab", first)
+		if err != nil {
+			return 0, 0, err
+		}
+		return scalarMember(itab, "Type"), data, nil
+	}
+	return first, data, nil
+}
+
+// formatDynamic renders what an interface value holds, as type and value.
+func formatDynamic(pid int, v *value) string {
+	typeAddress, data, err := interfaceWords(pid, v)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	if typeAddress == 0 {
+		return "nil"
+	}
+	name, dv, err := dynamicValue(pid, typeAddress, data)
+	if err != nil {
+		return fmt.Sprintf("%v <%v>", name, err)
+	}
+	if strings.HasPrefix(name, "*") {
+		if target, err := dereference(pid, dv); err == nil {
+			return "&" + formatValue(pid, target)
+		}
+	}
+	return fmt.Sprintf("%v(%v)", name, formatValue(pid, dv))
+}
+
+// showContext implements print -ctx: it walks a context.Context from the
+// innermost wrapper out to its root, showing each one's deadline,
+// cancellation and stored value.
+func showContext(pid int, v *value) error {
+	if _, ok := resolveTypedef(v.typ).(*dwarf.StructType); !ok || len(v.data) != 16 {
+		return fmt.Errorf("%v is not a context.Context", typeName(v.typ))
+	}
+	for i := 0; i < maxContextChain; i++ {
+		typeAddress, data, err := interfaceWords(pid, v)
+		if err != nil {
+			return err
+		}
+		if typeAddress == 0 {
+			fmt.Println("nil")
+			return nil
+		}
+		name, ctx, err := dynamicValue(pid, typeAddress, data)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(name, "*") {
+			if ctx, err = dereference(pid, ctx); err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("#%-2d %v", i, name)
+		var parent *value
+		switch name {
+		case "context.backgroundCtx", "context.todoCtx":
+			fmt.Println()
+			return nil
+		case "*context.valueCtx":
+			fmt.Printf(" %v = %v\n", formatDynamic(pid, member(ctx, "key")), formatDynamic(pid, member(ctx, "val")))
+			parent = member(ctx, "Context")
+		case "*context.cancelCtx", "*context.timerCtx", "*context.afterFuncCtx":
+			cancel := ctx
+			if embedded := member(ctx, "cancelCtx"); embedded != nil {
+				cancel = embedded
+			}
+			if deadline := member(ctx, "deadline"); deadline != nil {
+				if t, ok := decodeTime(pid, deadline); ok {
+					fmt.Printf(" deadline %v", t.Format("2006-01-02 15:04:05.999 -0700 MST"))
+					wallNow := time.Now()
+					if t.After(wallNow) {
+						fmt.Printf(" (in %v)", t.Sub(wallNow).Round(time.Millisecond))
+					} else {
+						fmt.Printf(" (%v ago)", wallNow.Sub(t).Round(time.Millisecond))
+					}
+				}
+			}
+			showCancellation(pid, cancel)
+			parent = member(cancel, "Context")
+		case "context.withoutCancelCtx":
+			fmt.Println(" (parent's cancellation ignored)")
+			parent = member(ctx, "c")
+		case "*context.stopCtx":
+			fmt.Println()
+			parent = member(ctx, "Context")
+		default:
+			fmt.Println(" (not a context package type)")
+			return nil
+		}
+		if parent == nil {
+			return fmt.Errorf("unsupported runtime: %v has no parent context", name)
+		}
+		v = parent
+	}
+	fmt.Println("...")
+	return nil
+}
+
+// showCancellation ends a cancelCtx's line with whether it was cancelled,
+// and why.  The error is kept in an atomic.Value, so as an any.
+func showCancellation(pid int, cancel *value) {
+	err := member(cancel, "err", "v")
+	if err == nil {
+		fmt.Println()
+		return
+	}
+	if typeAddress, _, e := interfaceWords(pid, err); e != nil || typeAddress == 0 {
+		fmt.Println(" not cancelled")
+		return
+	}
+	fmt.Printf(" cancelled: %v", formatErrorChain(pid, err, 0))
+	if cause := member(cancel, "cause"); cause != nil {
+		if typeAddress, _, e := interfaceWords(pid, cause); e == nil && typeAddress != 0 {
+			fmt.Printf(", cause %v", formatErrorChain(pid, cause, 0))
+		}
+	}
+	fmt.Println()
+}
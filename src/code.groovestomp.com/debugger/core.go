@@ -0,0 +1,342 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// Note types of a Linux core file: NT_PRSTATUS has a thread's registers and
+// the signal it got, NT_SIGINFO the siginfo of the one that killed it.
+const (
+	noteProcessStatus = 1
+	noteSignalInfo    = 0x53494749
+
+	// Offsets into the amd64 elf_prstatus.
+	prstatusSignal    = 12
+	prstatusPid       = 32
+	prstatusRegisters = 112
+)
+
+// coreDump is a core file or snapshot and the binary it is of.  While one
+// is open, the ptrace wrappers read its memory and registers instead of a
+// live tracee's.
+type coreDump struct {
+	path    string
+	file    *elf.File // The core file, or nil for a snapshot.
+	exe     *elf.File
+	threads []coreThread
+
+	// regions is the memory a snapshot kept, in order of address.
+	regions []memoryRegion
+
+	signal     syscall.Signal
+	faultAddr  uint64
+	hasSiginfo bool
+}
+
+type coreThread struct {
+	tid  int
+	regs syscall.PtraceRegs
+}
+
+type memoryRegion struct {
+	address uint64
+	data    []byte
+}
+
+// core is the core file being read, or nil.
+var core *coreDump
+
+// openCore reads the threads and signal of a core file.  The first thread
+// is the one that got the signal.
+func openCore(path string, exe *elf.File) (*coreDump, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != elf.ET_CORE {
+		f.Close()
+		return nil, fmt.Errorf("%v is not a core file", path)
+	}
+	c := &coreDump{path: path, file: f, exe: exe}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		c.readNotes(data)
+	}
+	if len(c.threads) == 0 {
+		f.Close()
+		return nil, fmt.Errorf("%v has no threads in it", path)
+	}
+	if err := verifyCoreBuildID(exe, c); err != nil {
+		if !ignoreBuildID {
+			f.Close()
+			return nil, fmt.Errorf("%v\nRefusing to read the core; pass -ignore-build-id to override.", err)
+		}
+		fmt.Printf("WARNING: %v\nLine numbers and variables will be wrong.\n", err)
+	}
+	return c, nil
+}
+
+// readNotes goes through the notes of a PT_NOTE segment: a name size,
+// description size and type, then the name and description, each padded
+// to four bytes.
+func (c *coreDump) readNotes(data []byte) {
+	align := func(n uint32) int { return int((n + 3) &^ 3) }
+	for len(data) >= 12 {
+		nameSize := binary.LittleEndian.Uint32(data)
+		descSize := binary.LittleEndian.Uint32(data[4:])
+		kind := binary.LittleEndian.Uint32(data[8:])
+		data = data[12:]
+		if align(nameSize)+align(descSize) > len(data) {
+			return
+		}
+		desc := data[align(nameSize) : align(nameSize)+int(descSize)]
+		data = data[align(nameSize)+align(descSize):]
+
+		switch kind {
+		case noteProcessStatus:
+			var regs syscall.PtraceRegs
+			size := int(unsafe.Sizeof(regs))
+			if len(desc) < prstatusRegisters+size {
+				continue
+			}
+			copy((*[1 << 10]byte)(unsafe.Pointer(&regs))[:size], desc[prstatusRegisters:])
+			if len(c.threads) == 0 {
+				c.signal = syscall.Signal(binary.LittleEndian.Uint16(desc[prstatusSignal:]))
+			}
+			tid := int(binary.LittleEndian.Uint32(desc[prstatusPid:]))
+			c.threads = append(c.threads, coreThread{tid: tid, regs: regs})
+		case noteSignalInfo:
+			if len(desc) >= 24 && !c.hasSiginfo {
+				c.faultAddr = binary.LittleEndian.Uint64(desc[16:])
+				c.hasSiginfo = true
+			}
+		}
+	}
+}
+
+// registers returns the registers of a thread of the core.
+func (c *coreDump) registers(tid int, regs *syscall.PtraceRegs) error {
+	for _, t := range c.threads {
+		if t.tid == tid {
+			*regs = t.regs
+			return nil
+		}
+	}
+	return syscall.ESRCH
+}
+
+// read fills out from memory at address.  What the kernel wrote to the core,
+// or the snapshot kept, comes first; the code and read-only data left out
+// are read from the binary.
+func (c *coreDump) read(address uint64, out []byte) (int, error) {
+	n := 0
+	for n < len(out) {
+		m := 0
+		if c.file != nil {
+			m = readSegment(c.file, address+uint64(n), out[n:])
+		} else {
+			m = c.readRegion(address+uint64(n), out[n:])
+		}
+		if m == 0 {
+			m = readSegment(c.exe, address+uint64(n), out[n:])
+		}
+		if m == 0 {
+			break
+		}
+		n += m
+	}
+	if n == 0 {
+		return 0, syscall.EIO
+	}
+	return n, nil
+}
+
+// readRegion reads what it can at address from the snapshot's regions.
+func (c *coreDump) readRegion(address uint64, out []byte) int {
+	i := sort.Search(len(c.regions), func(i int) bool {
+		r := c.regions[i]
+		return r.address+uint64(len(r.data)) > address
+	})
+	if i == len(c.regions) || c.regions[i].address > address {
+		return 0
+	}
+	r := c.regions[i]
+	return copy(out, r.data[address-r.address:])
+}
+
+// readSegment reads what it can at address from the PT_LOAD segment of f
+// that has it in its file, returning how many bytes it read.
+func readSegment(f *elf.File, address uint64, out []byte) int {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || address < prog.Vaddr || address >= prog.Vaddr+prog.Filesz {
+			continue
+		}
+		size := prog.Vaddr + prog.Filesz - address
+		if size > uint64(len(out)) {
+			size = uint64(len(out))
+		}
+		n, _ := prog.ReadAt(out[:size], int64(address-prog.Vaddr))
+		return n
+	}
+	return 0
+}
+
+// runTriage implements "triage [-ignore-build-id] <binary> <core>": a report
+// of why the process died, without prompting.
+func runTriage(args []string) {
+	args = takeIgnoreBuildID(args)
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %v triage [-ignore-build-id] <binary> <core>\n", os.Args[0])
+		os.Exit(2)
+	}
+	exe, symbolTable, err := reloadBinary(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	c, err := openCore(args[1], exe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	useCore(c, symbolTable)
+	fmt.Printf("Core:     %v\n", c.path)
+	showTriage(args[0], symbolTable)
+}
+
+// useCore makes c the process commands read, its first thread the current
+// one.
+func useCore(c *coreDump, symbolTable *gosym.Table) {
+	core = c
+	listingSymbols = symbolTable
+	threads = make(map[int]*thread)
+	for _, t := range c.threads {
+		threads[t.tid] = &thread{tid: t.tid, stopped: true}
+	}
+	processID, currentThread = c.threads[0].tid, c.threads[0].tid
+}
+
+// showTriage reports on the core or snapshot open: the signal, the frame
+// that crashed with its locals, the stack of every goroutine, the runtime's
+// memory statistics and the build info of the binary.
+func showTriage(binary string, symbolTable *gosym.Table) {
+	pid := processID
+	fmt.Printf("Binary:   %v\n", binary)
+	fmt.Printf("Threads:  %v\n", len(core.threads))
+	if core.signal != 0 {
+		fmt.Printf("Signal:   %v (%v)", signalName(core.signal), core.signal)
+		if core.hasSiginfo && core.signal != syscall.SIGABRT {
+			fmt.Printf(", fault address 0x%x", core.faultAddr)
+		}
+		fmt.Println()
+		fmt.Printf("\nThread %v, which got the signal:\n", pid)
+	} else {
+		fmt.Printf("\nThread %v, which was current:\n", pid)
+	}
+	showFaultContext(pid, symbolTable)
+	frames := backtrace(pid, symbolTable)
+	showBacktrace(frames)
+	if len(frames) > 0 {
+		index := crashingFrame(frames)
+		frame := frames[index]
+		var regs *syscall.PtraceRegs
+		if index == 0 {
+			regs = &core.threads[0].regs
+		}
+		fmt.Printf("\nLocals of %v (frame #%v) at %v:%v:\n", frame.fn.Name, index, frame.file, frame.line)
+		variables, err := frameVariables(pid, frame, regs)
+		if err != nil {
+			fmt.Println(err)
+		}
+		showVariables(pid, variables)
+	}
+
+	if list, err := readGoroutines(pid); err != nil {
+		fmt.Printf("\nGoroutines: %v\n", err)
+	} else {
+		fmt.Printf("\nGoroutines (%v):\n", len(list))
+		for _, g := range list {
+			fmt.Printf("\ngoroutine %v [%v]:\n", g.id, g.state())
+			showBacktrace(goroutineFrames(pid, g, symbolTable))
+		}
+	}
+
+	fmt.Println("\nMemory statistics:")
+	if err := showMemoryStatistics(pid); err != nil {
+		fmt.Println(err)
+	}
+
+	fmt.Println("\nBuild info:")
+	if err := showBuildInfo(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// statistic is a counter of the runtime's, by name.
+type statistic struct {
+	name  string
+	value string
+}
+
+// showMemoryStatistics prints the counters of runtime.memstats and how big
+// the heap was, as the garbage collector saw it.
+func showMemoryStatistics(pid int) error {
+	stats, err := memoryStatistics(pid)
+	for _, s := range stats {
+		fmt.Printf("  %-18v %v\n", s.name, s.value)
+	}
+	return err
+}
+
+func memoryStatistics(pid int) ([]statistic, error) {
+	memstats, err := readGlobal(pid, "runtime.memstats")
+	if err != nil {
+		return nil, err
+	}
+	st, ok := resolveTypedef(memstats.typ).(*dwarf.StructType)
+	if !ok {
+		return nil, errors.New("runtime.memstats is not a struct")
+	}
+	var stats []statistic
+	for _, field := range st.Field {
+		v := fieldValue(memstats, field)
+		if v == nil || !isScalarCounter(v.typ) {
+			continue
+		}
+		stats = append(stats, statistic{field.Name, formatValue(pid, v)})
+	}
+	if controller, err := readGlobal(pid, "runtime.gcController"); err == nil {
+		for _, name := range []string{"heapLive", "heapMarked", "heapGoal"} {
+			if member(controller, name) != nil {
+				stats = append(stats, statistic{name, fmt.Sprint(scalarMember(controller, name))})
+			}
+		}
+	}
+	return stats, nil
+}
+
+// isScalarCounter reports whether a memstats field is a number, possibly
+// under a named type like sysMemStat, rather than an array or a struct.
+func isScalarCounter(typ dwarf.Type) bool {
+	switch resolveTypedef(typ).(type) {
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.FloatType:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// fatalSignals are the signals that kill the tracee unless something
+// recovers from them.  The runtime turns a fault in Go code into a panic,
+// so only one elsewhere puts the debugger in post-mortem mode.
+var fatalSignals = map[syscall.Signal]bool{
+	syscall.SIGSEGV: true,
+	syscall.SIGBUS:  true,
+	syscall.SIGFPE:  true,
+	syscall.SIGILL:  true,
+	syscall.SIGABRT: true,
+}
+
+var (
+	// crashed is set while the tracee is stopped at a fatal signal or panic.
+	crashed bool
+
+	fatalPanicAddress uint64
+)
+
+// armFatalPanicCatcher puts an internal breakpoint on the runtime function
+// that reports unrecovered panics, so the process can be inspected before
+// the runtime prints the panic and exits.
+func armFatalPanicCatcher(pid int, symbolTable *gosym.Table) {
+	fn := symbolTable.LookupFunc("runtime.fatalpanic")
+	if fn == nil {
+		return
+	}
+	if err := setBreakpoint(pid, fn.Entry); err != nil {
+		fmt.Printf("Warning: %v; unrecovered panics won't be caught.\n", err)
+		return
+	}
+	fatalPanicAddress = fn.Entry
+}
+
+// checkCrash inspects the status of a stopped tracee and, when it stopped
+// because it is about to die or at a catch, reports that.  It returns true
+// when it did, and the stop is then published as reported already.
+func checkCrash(pid int, status *syscall.WaitStatus, symbolTable *gosym.Table) bool {
+	if !reportCrash(pid, status, symbolTable) {
+		return false
+	}
+	session.reported = true
+	return true
+}
+
+// reportCrash prints a crash report and enters post-mortem mode when the
+// tracee is about to die, or reports a catch it stopped at, returning true
+// if it did either.
+func reportCrash(pid int, status *syscall.WaitStatus, symbolTable *gosym.Table) bool {
+	if !status.Stopped() {
+		return false
+	}
+
+	signal := status.StopSignal()
+	recoverable := false
+	switch {
+	case fatalSignals[signal]:
+		recoverable = signal != syscall.SIGABRT && symbolTable.PCToFunc(getPC(pid)) != nil
+		setPendingSignal(pid, signal)
+		fmt.Printf("\nProgram received signal %v (%v)", signalName(signal), signal)
+		if address, ok := faultAddress(pid, signal); ok {
+			fmt.Printf(", fault address 0x%x", address)
+		}
+		fmt.Println(".")
+		showFaultContext(pid, symbolTable)
+	case signal == syscall.SIGTRAP && fatalPanicAddress != 0 && getPC(pid) == fatalPanicAddress:
+		// The catcher is one-shot; put the instruction back so the runtime
+		// can print the panic and exit normally if the user continues.
+		clearBreakpoint(pid, fatalPanicAddress)
+		fatalPanicAddress = 0
+		fmt.Println("\nProgram is terminating with a fatal panic.")
+	case signal == syscall.SIGTRAP && throwAddresses[getPC(pid)] != "":
+		fn := throwAddresses[getPC(pid)]
+		message, err := throwMessage(pid)
+		if err != nil {
+			message = err.Error()
+		}
+		// Continuing should let the runtime report the error and exit.
+		disarmCatcher(pid, throwAddresses)
+		fmt.Printf("\nProgram is terminating with a fatal error in %v: %v\n", fn, message)
+	case signal == syscall.SIGTRAP && logCatchAddresses[getPC(pid)] != "" && findBreakpoint(getPC(pid)) == nil:
+		showLogStop(pid, symbolTable)
+		return true
+	case signal == syscall.SIGTRAP && outputCatchAddresses[getPC(pid)] != "" && findBreakpoint(getPC(pid)) == nil:
+		showOutputStop(pid, symbolTable)
+		return true
+	case signal == syscall.SIGTRAP && gcCatchAddresses[getPC(pid)] != "" && findBreakpoint(getPC(pid)) == nil:
+		showGCStop(pid, symbolTable)
+		return true
+	case signal == syscall.SIGTRAP && chanCatchAddresses[getPC(pid)] != "" && findBreakpoint(getPC(pid)) == nil:
+		showChanStop(pid, symbolTable)
+		return true
+	case signal == syscall.SIGTRAP && assertionStop(getPC(pid)) != nil:
+		showAssertionStop(assertionStop(getPC(pid)))
+		return true
+	case signal == syscall.SIGTRAP && allocStop(getPC(pid)) != nil:
+		showAllocStop(allocStop(getPC(pid)))
+		return true
+	case signal == syscall.SIGTRAP && isGrowthAddress(getPC(pid)) && findBreakpoint(getPC(pid)) == nil:
+		showGrowthStop(pid, symbolTable)
+		return true
+	case signal == syscall.SIGTRAP && exitAddress != 0 && getPC(pid) == exitAddress:
+		showExitStop(pid, symbolTable)
+		return true
+	case signal == syscall.SIGTRAP && runtimeInitAddress != 0 && getPC(pid) == runtimeInitAddress:
+		showRuntimeInitStop(pid, symbolTable)
+		showListing(pcSourceFile, pcSourceLine)
+		return true
+	default:
+		return false
+	}
+
+	crashed = !recoverable
+	frames := backtrace(pid, symbolTable)
+	showBacktrace(frames)
+	if len(frames) == 0 {
+		return true
+	}
+
+	index := crashingFrame(frames)
+	frame := frames[index]
+	pcSourceFile = frame.file
+	pcSourceLine = frame.line
+
+	var regs *syscall.PtraceRegs
+	if index == 0 {
+		regs = new(syscall.PtraceRegs)
+		if err := ptraceGetRegs(pid, regs); err != nil {
+			regs = nil
+		}
+	}
+	fmt.Printf("\nLocals of %v (frame #%v):\n", frame.fn.Name, index)
+	variables, err := frameVariables(pid, frame, regs)
+	if err != nil {
+		fmt.Println(err)
+	}
+	showVariables(pid, variables)
+
+	showListing(frame.file, frame.line)
+	if recoverable {
+		fmt.Println("Continue to deliver the fault; the runtime turns it into a panic, which the program may recover from.")
+	} else {
+		fmt.Println("The process is kept alive for inspection. Continue to let it die, or quit.")
+	}
+	return true
+}
+
+// cantStep returns why the current thread can't be stepped, or "" if it
+// can: the program has crashed, or the thread is at a fault that a step
+// would only run into again, as continuing delivers it.
+func cantStep(pid int) string {
+	if crashed {
+		return "The program has crashed; continue to let it die, or quit."
+	}
+	if t, ok := threads[pid]; ok && fatalSignals[t.signal] {
+		return "The program is stopped at a fault; continue to deliver it, or quit."
+	}
+	return ""
+}
+
+// showFaultContext prints the faulting instruction.  A fault in C code gets
+// the registers too: the runtime only sees it after the fact, and the Go
+// backtrace cannot walk C frames.
+func showFaultContext(pid int, symbolTable *gosym.Table) {
+	pc := getPC(pid)
+	location := ""
+	fn := symbolTable.PCToFunc(pc)
+	native := fn == nil
+	if fn != nil {
+		location = fmt.Sprintf(" <%v+%v>", fn.Name, pc-fn.Entry)
+	} else if name, ok := nativeSymbol(pc); ok {
+		location = fmt.Sprintf(" <%v>", name)
+	}
+	text, err := disassembleAt(pid, pc)
+	if err != nil {
+		text = err.Error()
+	}
+	fmt.Printf("=> 0x%x%v:\t%v\n", pc, location, text)
+	if !native {
+		return
+	}
+
+	fmt.Println("The fault is in C code.")
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return
+	}
+	names := []string{"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "rbp", "rsp", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}
+	for i, name := range names {
+		fmt.Printf("  %-4v 0x%016x", name, *registerNames[name](&regs))
+		if i%2 == 1 {
+			fmt.Println()
+		}
+	}
+}
+
+// crashingFrame picks the innermost frame outside the runtime, which is where
+// a nil dereference or panic originated from the user's point of view.
+func crashingFrame(frames []stackFrame) int {
+	for i, frame := range frames {
+		if !strings.HasPrefix(frame.fn.Name, "runtime.") {
+			return i
+		}
+	}
+	return 0
+}
+
+// faultAddress reads si_addr from the siginfo of the signal the tracee is
+// stopped with.
+func faultAddress(pid int, signal syscall.Signal) (uint64, bool) {
+	if signal != syscall.SIGSEGV && signal != syscall.SIGBUS && signal != syscall.SIGFPE && signal != syscall.SIGILL {
+		return 0, false
+	}
+
+	var siginfo [128]byte
+	_, errno := ptraceRaw("PTRACE_GETSIGINFO", syscall.PTRACE_GETSIGINFO, pid, 0, uintptr(unsafe.Pointer(&siginfo[0])))
+	if errno != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(siginfo[16:]), true
+}
+
+func signalName(signal syscall.Signal) string {
+	switch signal {
+	case syscall.SIGSEGV:
+		return "SIGSEGV"
+	case syscall.SIGBUS:
+		return "SIGBUS"
+	case syscall.SIGFPE:
+		return "SIGFPE"
+	case syscall.SIGILL:
+		return "SIGILL"
+	case syscall.SIGABRT:
+		return "SIGABRT"
+	case syscall.SIGTRAP:
+		return "SIGTRAP"
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGSTOP:
+		return "SIGSTOP"
+	case syscall.SIGURG:
+		return "SIGURG"
+	case syscall.SIGCHLD:
+		return "SIGCHLD"
+	case syscall.SIGPIPE:
+		return "SIGPIPE"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	}
+	return fmt.Sprintf("signal %d", int(signal))
+}
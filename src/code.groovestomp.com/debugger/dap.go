@@ -0,0 +1,773 @@
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// dapFlag is the -dap flag.  Alone it serves the Debug Adapter Protocol on
+// stdin and stdout; -dap=<address> listens for one client over TCP.
+type dapFlag struct {
+	mode string // "stdio", a TCP address, or empty for the command line.
+}
+
+func (f *dapFlag) String() string { return f.mode }
+
+func (f *dapFlag) IsBoolFlag() bool { return true }
+
+func (f *dapFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.mode = "stdio"
+	case "false":
+		f.mode = ""
+	default:
+		f.mode = value
+	}
+	return nil
+}
+
+// dapPollInterval is how often a running tracee is checked for a stop
+// between requests.
+const dapPollInterval = 10 * time.Millisecond
+
+// dapMessage is a request from the client.
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// dapFrameRef is what a frame id handed to the client stands for.  Ids
+// last until the tracee next runs.
+type dapFrameRef struct {
+	tid   int
+	index int
+	frame stackFrame
+}
+
+// dapServer maps Debug Adapter Protocol requests onto the command loop's
+// machinery.  Requests are served one at a time on the main goroutine,
+// which is the ptrace thread.
+type dapServer struct {
+	out     io.Writer
+	outLock sync.Mutex
+	seq     int
+
+	// While a request is served its events are held back, since the client
+	// expects them after the response.
+	holding bool
+	held    []*dapEvent
+
+	pid         int
+	exe         *elf.File
+	symbolTable *gosym.Table
+	started     bool
+	attached    bool
+	stopOnEntry bool
+	exited      bool
+
+	frames              []dapFrameRef
+	functionBreakpoints []*breakpoint
+
+	// settingBreakpoints is set while a setBreakpoints request replaces
+	// breakpoints, whose changes the client needn't be told of.
+	settingBreakpoints bool
+}
+
+// serveDAP runs the debugger as a debug adapter until the client
+// disconnects.  Everything the debugger and the tracee print goes to the
+// client as output events.
+func serveDAP(mode string) {
+	var in io.Reader
+	var out io.Writer
+	if mode == "stdio" {
+		in, out = os.Stdin, os.Stdout
+	} else {
+		listener, err := net.Listen("tcp", mode)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "DAP server listening at %v\n", listener.Addr())
+		conn, err := listener.Accept()
+		if err != nil {
+			fatal(err)
+		}
+		listener.Close()
+		in, out = conn, conn
+	}
+	s := &dapServer{out: out}
+	defer subscribe(s.publishEvent)()
+	s.captureOutput()
+
+	// A call next steps over can take as long as it likes; unless told
+	// otherwise, hand control back so that a pause request is seen.
+	if stepTimeout == 0 {
+		stepTimeout = time.Second
+	}
+
+	requests := make(chan *dapMessage)
+	go readDAPMessages(in, requests)
+	for {
+		var request *dapMessage
+		if running {
+			select {
+			case request = <-requests:
+			case <-time.After(dapPollInterval):
+				if status := waitForBackground(currentThread, s.symbolTable, time.Now()); status != nil {
+					publishStop(status, s.symbolTable)
+				}
+				continue
+			}
+		} else {
+			request = <-requests
+		}
+		if request == nil || !s.handle(request) {
+			s.end()
+			return
+		}
+	}
+}
+
+// readDAPMessages reads the client's messages, each a Content-Length header
+// and a JSON body, sending nil once the client has gone.
+func readDAPMessages(r io.Reader, requests chan<- *dapMessage) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	for {
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			break
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			break
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader.R, body); err != nil {
+			break
+		}
+		var message dapMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			fmt.Fprintf(os.Stderr, "DAP: %v\n", err)
+			continue
+		}
+		if message.Type == "request" {
+			requests <- &message
+		}
+	}
+	requests <- nil
+}
+
+func (s *dapServer) send(message interface{}) {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	s.write(message)
+}
+
+func (s *dapServer) write(message interface{}) {
+	s.seq++
+	switch m := message.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *dapServer) event(name string, body interface{}) {
+	e := &dapEvent{Type: "event", Event: name, Body: body}
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	if s.holding {
+		s.held = append(s.held, e)
+		return
+	}
+	s.write(e)
+}
+
+// hold holds back events until respond.
+func (s *dapServer) hold() {
+	s.outLock.Lock()
+	s.holding = true
+	s.outLock.Unlock()
+}
+
+// respond sends a response followed by the events held back while it was
+// prepared.
+func (s *dapServer) respond(response *dapResponse) {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	s.write(response)
+	for _, e := range s.held {
+		s.write(e)
+	}
+	s.held = nil
+	s.holding = false
+}
+
+// captureOutput points the process's standard output, which the tracee
+// inherits when launched, at a pipe whose contents become output events.
+func (s *dapServer) captureOutput() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fatal(err)
+	}
+	os.Stdout = w
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := r.Read(buffer)
+			if n > 0 {
+				publish(outputEvent{stream: "stdout", text: string(buffer[:n])})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// handle serves one request, returning false once the session is over.
+func (s *dapServer) handle(request *dapMessage) bool {
+	response := &dapResponse{Type: "response", RequestSeq: request.Seq, Command: request.Command, Success: true}
+	s.hold()
+	body, err := s.dispatch(request)
+	if err != nil {
+		response.Success = false
+		response.Message = err.Error()
+	} else {
+		response.Body = body
+	}
+	s.respond(response)
+
+	switch request.Command {
+	case "launch", "attach":
+		if err == nil {
+			s.event("initialized", nil)
+		}
+	case "configurationDone":
+		s.configured()
+	case "disconnect":
+		return false
+	}
+	return true
+}
+
+func (s *dapServer) dispatch(request *dapMessage) (interface{}, error) {
+	var args map[string]json.RawMessage
+	if len(request.Arguments) > 0 {
+		if err := json.Unmarshal(request.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+	decode := func(name string, v interface{}) {
+		if raw, ok := args[name]; ok {
+			json.Unmarshal(raw, v)
+		}
+	}
+
+	switch request.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsEvaluateForHovers":        true,
+		}, nil
+	case "launch":
+		var program string
+		var env map[string]string
+		decode("program", &program)
+		decode("args", &programArgs)
+		decode("env", &env)
+		decode("stopOnEntry", &s.stopOnEntry)
+		for name, value := range env {
+			programEnv = append(programEnv, name+"="+value)
+		}
+		return nil, s.launch(program, 0)
+	case "attach":
+		var pid int
+		var program string
+		decode("processId", &pid)
+		decode("program", &program)
+		if pid == 0 {
+			return nil, errors.New("attach needs a processId")
+		}
+		s.attached = true
+		return nil, s.launch(program, pid)
+	case "disconnect", "configurationDone":
+		return nil, nil
+	}
+
+	if !s.started || s.exited {
+		return nil, errors.New("the program is not running")
+	}
+	switch request.Command {
+	case "threads":
+		var list []map[string]interface{}
+		for _, t := range sortedThreads() {
+			list = append(list, map[string]interface{}{"id": t.tid, "name": fmt.Sprintf("thread %v", t.tid)})
+		}
+		return map[string]interface{}{"threads": list}, nil
+	case "pause":
+		if running {
+			kill(processID, syscall.SIGINT)
+		}
+		return nil, nil
+	}
+
+	if running {
+		return nil, errors.New("the program is running")
+	}
+	switch request.Command {
+	case "setBreakpoints":
+		var source dapSource
+		var wanted []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		}
+		decode("source", &source)
+		decode("breakpoints", &wanted)
+		return s.setBreakpoints(source.Path, func(i int) (string, string) {
+			return fmt.Sprintf("%v:%v", source.Path, wanted[i].Line), wanted[i].Condition
+		}, len(wanted)), nil
+	case "setFunctionBreakpoints":
+		var wanted []struct {
+			Name      string `json:"name"`
+			Condition string `json:"condition"`
+		}
+		decode("breakpoints", &wanted)
+		return s.setBreakpoints("", func(i int) (string, string) {
+			return wanted[i].Name, wanted[i].Condition
+		}, len(wanted)), nil
+	case "continue":
+		s.resume()
+		return map[string]bool{"allThreadsContinued": !nonStop}, nil
+	case "next", "stepIn", "stepOut":
+		var tid int
+		decode("threadId", &tid)
+		if err := s.step(request.Command, tid); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case "stackTrace":
+		var tid, start, levels int
+		decode("threadId", &tid)
+		decode("startFrame", &start)
+		decode("levels", &levels)
+		return s.stackTrace(tid, start, levels)
+	case "scopes":
+		var id int
+		decode("frameId", &id)
+		if _, err := s.frame(id); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"scopes": []map[string]interface{}{
+			{"name": "Locals", "variablesReference": id, "expensive": false},
+		}}, nil
+	case "variables":
+		var id int
+		decode("variablesReference", &id)
+		return s.variables(id)
+	case "evaluate":
+		var expression string
+		var id int
+		decode("expression", &expression)
+		decode("frameId", &id)
+		return s.evaluate(expression, id)
+	}
+	return nil, fmt.Errorf("unsupported request %v", request.Command)
+}
+
+// launch starts the program, or attaches to pid, as the command line
+// would.
+func (s *dapServer) launch(program string, pid int) error {
+	if s.started {
+		return errors.New("a program is already being debugged")
+	}
+	if program == "" && pid != 0 {
+		program = fmt.Sprintf("/proc/%d/exe", pid)
+	}
+	exe, err := elf.Open(program)
+	if err != nil {
+		return err
+	}
+	if pid != 0 {
+		err = verifyBuildID(exe, pid)
+	}
+	exe.Close()
+	if err != nil {
+		return err
+	}
+	s.pid, s.exe, s.symbolTable = startTracee(program, pid, true)
+	s.started = true
+	return nil
+}
+
+// configured lets the program go once the client has set its breakpoints.
+func (s *dapServer) configured() {
+	if !s.started {
+		return
+	}
+	switch {
+	case s.attached:
+		s.stopped("pause")
+	case s.stopOnEntry:
+		s.stopped("entry")
+	default:
+		s.resume()
+	}
+}
+
+// setBreakpoints replaces the breakpoints of a file, or the function
+// breakpoints when file is empty, with those spec gives.
+func (s *dapServer) setBreakpoints(file string, spec func(int) (string, string), n int) interface{} {
+	s.settingBreakpoints = true
+	defer func() { s.settingBreakpoints = false }()
+	var old []*breakpoint
+	if file == "" {
+		old = s.functionBreakpoints
+		s.functionBreakpoints = nil
+	} else {
+		for _, bp := range breakpoints {
+			if bp.file == file && bp.trace == nil && bp.request == nil && bp.alloc == nil && !s.isFunctionBreakpoint(bp) {
+				old = append(old, bp)
+			}
+		}
+	}
+	for _, bp := range old {
+		deleteBreakpoint(currentThread, bp)
+	}
+
+	result := []map[string]interface{}{}
+	for i := 0; i < n; i++ {
+		location, condition := spec(i)
+		if condition != "" {
+			location += " if " + condition
+		}
+		bp, err := createBreakpoint(currentThread, location, s.symbolTable)
+		if err != nil {
+			result = append(result, map[string]interface{}{"verified": false, "message": err.Error()})
+			continue
+		}
+		if file == "" {
+			s.functionBreakpoints = append(s.functionBreakpoints, bp)
+		}
+		result = append(result, map[string]interface{}{
+			"id":       bp.id,
+			"verified": true,
+			"line":     bp.line,
+			"source":   dapSource{Name: pathBase(bp.file), Path: bp.file},
+		})
+	}
+	return map[string]interface{}{"breakpoints": result}
+}
+
+func (s *dapServer) isFunctionBreakpoint(bp *breakpoint) bool {
+	for _, other := range s.functionBreakpoints {
+		if other == bp {
+			return true
+		}
+	}
+	return false
+}
+
+// resume continues the program, leaving it to the polling in serveDAP to
+// notice it stop.
+func (s *dapServer) resume() {
+	s.frames = nil
+	if status := resume(currentThread, threadsLocked()); status != nil {
+		publishStop(status, s.symbolTable)
+		return
+	}
+	running = true
+}
+
+func (s *dapServer) step(command string, tid int) error {
+	if tid != 0 && tid != currentThread {
+		if err := selectThread(strconv.Itoa(tid)); err != nil {
+			return err
+		}
+	}
+	pid := currentThread
+	s.frames = nil
+	var status *syscall.WaitStatus
+	switch command {
+	case "next":
+		status = next(pid, s.symbolTable)
+	case "stepIn":
+		status = stepLine(pid, s.symbolTable)
+	case "stepOut":
+		frames := unwind(pid, s.symbolTable, currentRegisters(pid), 2)
+		if len(frames) < 2 {
+			return errors.New("no caller to return to")
+		}
+		status = finishCall(pid, frames[1].pc, frames[0].cfa, s.symbolTable)
+	}
+	if status == nil {
+		running = true // Still in a call; poll for the stop.
+		return nil
+	}
+	publishStop(status, s.symbolTable)
+	return nil
+}
+
+// stepLine steps into calls until the program reaches another source line,
+// past the prologue of a function it enters.
+func stepLine(pid int, symbolTable *gosym.Table) *syscall.WaitStatus {
+	startFile, startLine, _ := symbolTable.PCToLine(getPC(pid))
+	for i := 0; i < maxDeferSteps; i++ {
+		status := step(pid)
+		if !isTrapStop(status) {
+			return status
+		}
+		pc := getPC(pid)
+		file, line, fn := symbolTable.PCToLine(pc)
+		if fn != nil && pc == fn.Entry {
+			return enterFunction(pid, fn, status, symbolTable)
+		}
+		if fn != nil && (line != startLine || file != startFile) {
+			return status
+		}
+	}
+	return step(pid)
+}
+
+// enterFunction moves a thread stopped at fn's entry past its prologue.  A
+// prologue that grows the stack calls into the runtime, which starts fn
+// again once it has; the thread is run back to the entry in that case.
+func enterFunction(pid int, fn *gosym.Func, status *syscall.WaitStatus, symbolTable *gosym.Table) *syscall.WaitStatus {
+	for {
+		status = skipPrologue(pid, fn, status, symbolTable)
+		if !isTrapStop(status) {
+			return status
+		}
+		_, _, current := symbolTable.PCToLine(getPC(pid))
+		if current == nil || !strings.HasPrefix(current.Name, "runtime.morestack") {
+			return status
+		}
+		status = runToPC(pid, fn.Entry, symbolTable)
+		if status == nil || !isTrapStop(status) || currentThread != pid || getPC(pid) != fn.Entry {
+			return status
+		}
+	}
+}
+
+func currentRegisters(pid int) *syscall.PtraceRegs {
+	var regs syscall.PtraceRegs
+	ptraceGetRegs(pid, &regs)
+	return &regs
+}
+
+// publishEvent passes on the engine's events as the client's.
+func (s *dapServer) publishEvent(e engineEvent) {
+	switch e := e.(type) {
+	case stopEvent:
+		reason := map[string]string{
+			"breakpoint": "breakpoint",
+			"watchpoint": "data breakpoint",
+			"interrupt":  "pause",
+			"signal":     "exception",
+			"catch":      "exception",
+		}[e.reason]
+		switch {
+		case crashed:
+			reason = "exception"
+		case e.reason == "signal" && interruptRequested:
+			reason = "pause" // Ctrl-C during a step.
+		case reason == "":
+			reason = "step"
+		}
+		s.stopped(reason)
+	case targetExitedEvent:
+		s.exited = true
+		s.event("exited", map[string]int{"exitCode": e.status})
+		s.event("terminated", nil)
+	case outputEvent:
+		s.event("output", map[string]interface{}{"category": e.stream, "output": e.text})
+	case breakpointEvent:
+		if s.settingBreakpoints || e.change == "hit" {
+			return
+		}
+		if e.change == "deleted" {
+			s.event("breakpoint", map[string]interface{}{"reason": "removed", "breakpoint": map[string]interface{}{"id": e.bp.id, "verified": false}})
+			return
+		}
+		s.event("breakpoint", map[string]interface{}{"reason": "new", "breakpoint": map[string]interface{}{
+			"id":       e.bp.id,
+			"verified": true,
+			"line":     e.bp.line,
+			"source":   dapSource{Name: pathBase(e.bp.file), Path: e.bp.file},
+		}})
+	}
+}
+
+func (s *dapServer) stopped(reason string) {
+	s.frames = nil
+	s.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          currentThread,
+		"allThreadsStopped": !nonStop,
+	})
+}
+
+func (s *dapServer) stackTrace(tid, start, levels int) (interface{}, error) {
+	if tid == 0 {
+		tid = currentThread
+	}
+	if t, ok := threads[tid]; !ok || !t.stopped {
+		return nil, fmt.Errorf("thread %v is not stopped", tid)
+	}
+	frames := backtrace(tid, s.symbolTable)
+	total := len(frames)
+	if start > len(frames) {
+		start = len(frames)
+	}
+	frames = frames[start:]
+	if levels > 0 && levels < len(frames) {
+		frames = frames[:levels]
+	}
+	list := []map[string]interface{}{}
+	for i, frame := range frames {
+		s.frames = append(s.frames, dapFrameRef{tid: tid, index: start + i, frame: frame})
+		list = append(list, map[string]interface{}{
+			"id":     len(s.frames),
+			"name":   frame.fn.Name,
+			"source": dapSource{Name: pathBase(frame.file), Path: frame.file},
+			"line":   frame.line,
+			"column": 1,
+		})
+	}
+	return map[string]interface{}{"stackFrames": list, "totalFrames": total}, nil
+}
+
+func (s *dapServer) frame(id int) (dapFrameRef, error) {
+	if id < 1 || id > len(s.frames) {
+		return dapFrameRef{}, fmt.Errorf("no frame %v", id)
+	}
+	return s.frames[id-1], nil
+}
+
+// evalContext returns a context for a frame id, or the current thread's
+// innermost frame for zero.
+func (s *dapServer) evalContext(id int) (*evalContext, error) {
+	if id == 0 {
+		return newEvalContext(currentThread, s.symbolTable)
+	}
+	ref, err := s.frame(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &evalContext{pid: ref.tid, frame: ref.frame, symbolTable: s.symbolTable, index: ref.index}
+	if ref.index == 0 {
+		ctx.regs = currentRegisters(ref.tid)
+	}
+	return ctx, nil
+}
+
+func (s *dapServer) variables(id int) (interface{}, error) {
+	ctx, err := s.evalContext(id)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := frameVariables(ctx.pid, ctx.frame, ctx.regs)
+	if err != nil {
+		return nil, err
+	}
+	list := []map[string]interface{}{}
+	for _, v := range variables {
+		if strings.HasPrefix(v.name, "~") {
+			continue // Results not yet set.
+		}
+		text := ""
+		if v.err != nil {
+			text = fmt.Sprintf("<%v>", v.err)
+		} else {
+			text = formatValue(ctx.pid, v.val)
+		}
+		list = append(list, map[string]interface{}{"name": v.name, "value": text, "variablesReference": 0})
+	}
+	return map[string]interface{}{"variables": list}, nil
+}
+
+func (s *dapServer) evaluate(expression string, id int) (interface{}, error) {
+	ctx, err := s.evalContext(id)
+	if err != nil {
+		return nil, err
+	}
+	beginEvaluation()
+	defer endEvaluation()
+	v, err := evaluate(ctx, expression)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"result": formatValue(ctx.pid, v), "variablesReference": 0}
+	if showProvenance {
+		result["provenance"] = ctx.provenance()
+	}
+	return result, nil
+}
+
+// end finishes the session: an attached process is left running, a
+// launched one is killed.
+func (s *dapServer) end() {
+	if s.exe != nil {
+		s.exe.Close()
+	}
+	if !s.started || s.exited {
+		return
+	}
+	if s.attached {
+		if running {
+			interruptBackground(currentThread, s.symbolTable)
+		}
+		detachThreads()
+		return
+	}
+	killTracee()
+}
+
+func pathBase(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
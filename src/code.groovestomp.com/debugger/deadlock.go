@@ -0,0 +1,216 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"sort"
+)
+
+// maxCycleLength bounds the cycles searched for, which keeps the search
+// cheap in programs with many goroutines.
+const maxCycleLength = 16
+
+// blockedGoroutine is a waiting goroutine together with where it blocked
+// and the objects its stack refers to.
+type blockedGoroutine struct {
+	g     *goroutine
+	frame stackFrame
+
+	// references maps the address of every object some other goroutine
+	// waits on to the variable of this goroutine that refers to it.
+	references map[uint64]reference
+}
+
+// reference is a variable on a goroutine's stack that refers to an object.
+type reference struct {
+	variable string
+	function string
+}
+
+func (r reference) String() string {
+	return fmt.Sprintf("%v in %v", r.variable, r.function)
+}
+
+// runDeadlockCommand implements "deadlock check".
+//
+// The runtime does not record who holds a mutex or who will next use a
+// channel, so the check works from what the stacks show: a goroutine that
+// waits on an object depends on the blocked goroutines whose variables refer
+// to that same object.  A cycle of such dependencies is reported as a
+// deadlock.
+func runDeadlockCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument != "check" {
+		return fmt.Errorf("usage: deadlock check")
+	}
+	list, err := readGoroutines(pid)
+	if err != nil {
+		return err
+	}
+
+	objects := make(map[uint64]bool)
+	var blocked []*blockedGoroutine
+	for _, g := range list {
+		if g.status&^goroutineScan != goroutineWaiting || len(g.waits) == 0 {
+			continue
+		}
+		for _, w := range g.waits {
+			objects[w.address] = true
+		}
+		blocked = append(blocked, &blockedGoroutine{g: g})
+	}
+	for _, b := range blocked {
+		frames := goroutineBacktrace(pid, b.g, symbolTable)
+		if len(frames) > 0 {
+			b.frame = frames[userFrame(frames)]
+		}
+		b.references = stackReferences(pid, frames[userFrame(frames):], objects)
+	}
+
+	// dependsOn[i] lists the goroutines that refer to what goroutine i
+	// waits on.
+	dependsOn := make([][]int, len(blocked))
+	for i, b := range blocked {
+		for j, other := range blocked {
+			if i == j {
+				continue
+			}
+			for _, w := range b.g.waits {
+				if _, ok := other.references[w.address]; ok {
+					dependsOn[i] = append(dependsOn[i], j)
+					break
+				}
+			}
+		}
+	}
+
+	cycles := findCycles(dependsOn)
+	if len(cycles) == 0 {
+		fmt.Printf("No deadlock cycle found among %v blocked goroutines.\n", len(blocked))
+		if len(blocked) == len(list) && len(list) > 0 {
+			fmt.Println("Every goroutine is blocked, though.")
+		}
+		return nil
+	}
+	for _, cycle := range cycles {
+		fmt.Printf("Deadlock cycle of %v goroutines:\n", len(cycle))
+		for k, i := range cycle {
+			b, next := blocked[i], blocked[cycle[(k+1)%len(cycle)]]
+			w := b.g.waits[0]
+			for _, candidate := range b.g.waits {
+				if _, ok := next.references[candidate.address]; ok {
+					w = candidate
+				}
+			}
+			fmt.Printf("  goroutine %v [%v] on %v 0x%x\n", b.g.id, b.g.state(), waitObjectKind(w), w.address)
+			if b.frame.fn != nil {
+				fmt.Printf("      at %v:%v in %v\n", b.frame.file, b.frame.line, b.frame.fn.Name)
+			}
+			fmt.Printf("    referred to by goroutine %v as %v\n", next.g.id, next.references[w.address])
+		}
+	}
+	return nil
+}
+
+func waitObjectKind(w waitObject) string {
+	if w.channel {
+		return "channel"
+	}
+	return "semaphore"
+}
+
+// stackReferences finds the objects among wanted that the variables of the
+// given frames refer to, either by pointing at them or by containing them.
+func stackReferences(pid int, frames []stackFrame, wanted map[uint64]bool) map[uint64]reference {
+	found := make(map[uint64]reference)
+	for _, frame := range frames {
+		variables, _ := frameVariables(pid, frame, nil)
+		for _, v := range variables {
+			if v.err == nil {
+				collectReferences(pid, v.val, wanted, reference{v.name, frame.fn.Name}, found, 0)
+			}
+		}
+	}
+	return found
+}
+
+func collectReferences(pid int, v *value, wanted map[uint64]bool, ref reference, found map[uint64]reference, depth int) {
+	if depth > maxValueDepth {
+		return
+	}
+	note := func(start, size uint64) {
+		for address := range wanted {
+			if address >= start && address < start+size {
+				if _, ok := found[address]; !ok {
+					found[address] = ref
+				}
+			}
+		}
+	}
+	if v.addr != 0 {
+		note(v.addr, uint64(len(v.data)))
+	}
+
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.PtrType:
+		target := zeroExtend(v.data)
+		if target == 0 {
+			return
+		}
+		size := uint64(1)
+		if t.Type != nil && t.Type.Size() > 0 {
+			size = uint64(t.Type.Size())
+		}
+		note(target, size)
+	case *dwarf.StructType:
+		for _, field := range t.Field {
+			if fv := fieldValue(v, field); fv != nil {
+				collectReferences(pid, fv, wanted, ref, found, depth+1)
+			}
+		}
+	}
+}
+
+// findCycles returns each elementary cycle of the graph once, starting from
+// its lowest node.
+func findCycles(edges [][]int) [][]int {
+	var cycles [][]int
+	seen := make(map[string]bool)
+	var path []int
+	onPath := make(map[int]bool)
+
+	var visit func(start, node int)
+	visit = func(start, node int) {
+		for _, next := range edges[node] {
+			if next == start {
+				cycle := append([]int(nil), path...)
+				key := fmt.Sprint(sortedCopy(cycle))
+				if !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+				continue
+			}
+			if next < start || onPath[next] || len(path) >= maxCycleLength {
+				continue
+			}
+			path = append(path, next)
+			onPath[next] = true
+			visit(start, next)
+			onPath[next] = false
+			path = path[:len(path)-1]
+		}
+	}
+	for start := range edges {
+		path = []int{start}
+		onPath = map[int]bool{start: true}
+		visit(start, start)
+	}
+	return cycles
+}
+
+func sortedCopy(list []int) []int {
+	sorted := append([]int(nil), list...)
+	sort.Ints(sorted)
+	return sorted
+}
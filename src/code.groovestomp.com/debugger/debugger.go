@@ -7,211 +7,644 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var (
-	breakpoints  map[string][]int
 	pcSourceLine int
 	pcSourceFile string
-)
 
-func initTracee(path string) int {
-	cmd := exec.Command(path)
-	cmd.Args = []string{path}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
-	err := cmd.Start()
-	if err != nil {
-		log.Fatal(err)
-	}
+	// stdin is only read by startInput; everything else uses readLine.
+	stdin = bufio.NewReader(os.Stdin)
 
-	returnStatus := cmd.Wait()
-	if returnStatus == nil {
-		log.Fatal("Program exited")
-	}
+	// binaryModTime is when the binary was built, as far as its file can
+	// tell.  Sources changed after it may no longer match the line table.
+	binaryModTime time.Time
 
-	return cmd.Process.Pid
-}
+	staleSources = make(map[string]bool)
 
-func step(pid int) *syscall.WaitStatus {
-	err := syscall.PtraceSingleStep(pid)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// listingSymbols is the symbol table of the binary being debugged, for
+	// listings that fall back to disassembly.
+	listingSymbols *gosym.Table
+)
 
-	var ws syscall.WaitStatus
-	_, err = syscall.Wait4(pid, &ws, syscall.WALL, nil)
-	if err != nil {
-		log.Fatal(err)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		startReaper()
+		runProbe(os.Args[2:])
+		return
 	}
-
-	return &ws
-}
-
-func cont(pid int) *syscall.WaitStatus {
-	err := syscall.PtraceCont(pid, 0)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "k8s" {
+		runK8s(os.Args[2:])
+		return
 	}
-
-	var ws syscall.WaitStatus
-	_, err = syscall.Wait4(pid, &ws, syscall.WALL, nil)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "triage" {
+		runTriage(os.Args[2:])
+		return
 	}
-
-	return &ws
-}
-
-func setPC(pid int, pc uint64) {
-	var regs syscall.PtraceRegs
-	err := syscall.PtraceGetRegs(pid, &regs)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		runSnapshot(os.Args[2:])
+		return
 	}
-	regs.SetPC(pc)
-	err = syscall.PtraceSetRegs(pid, &regs)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "ps" {
+		showGoProcesses()
+		return
 	}
-}
-
-func getPC(pid int) uint64 {
-	var regs syscall.PtraceRegs
-	err := syscall.PtraceGetRegs(pid, &regs)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Args = prepareVerify(os.Args[2:])
 	}
-	return regs.PC()
-}
-
-func setBreakpoint(pid int, breakpoint uintptr) []byte {
-	original := make([]byte, 1)
-	_, err := syscall.PtracePeekData(pid, breakpoint, original)
-	if err != nil {
-		log.Fatal(err)
+	attach := flag.Int("attach", 0, "attach to the running process with this pid")
+	attachName := flag.String("attach-name", "", "attach to the running process whose executable's name matches this regexp")
+	attachPgid := flag.Int("attach-pgid", 0, "attach to the Go processes of this process group, one at a time")
+	attachCgroup := flag.String("attach-cgroup", "", "attach to the Go processes of this cgroup, one at a time")
+	wait := flag.Bool("wait", false, "with -attach-name, wait for a new matching process to start and attach to it at once")
+	flag.BoolVar(&ignoreBuildID, "ignore-build-id", false, "attach even if the binary doesn't match the process")
+	ptraceLogPath := flag.String("log-ptrace", "", "log every ptrace request, wait status and signal to this file")
+	flag.Var(&programEnv, "env", "set NAME=value in the program's environment; may be repeated")
+	commandFile := flag.String("command", "", "run the debugger commands in this file at startup, after those in ~/"+initFile)
+	flag.StringVar(&stdinPath, "stdin", "", "give the program this file as its standard input")
+	flag.BoolVar(&noCalls, "no-calls", false, "never call functions in the program from expressions, to leave a process being inspected undisturbed")
+	flag.StringVar(&waitExec, "wait-exec", "", "run the program given, a wrapper such as a script, with its arguments, and debug the binary of this name once it executes it")
+	var dap dapFlag
+	flag.Var(&dap, "dap", "serve the Debug Adapter Protocol on stdin and stdout, or with -dap=<address> over TCP")
+	delveAddress := flag.String("delve", "", "serve the most-used part of Delve's JSON-RPC API at this address, for editor plugins written for dlv")
+	profileName := flag.String("profile", "", "debug with this profile of the module's "+profileFile+": the program, its arguments and environment, breakpoints and settings")
+	flag.Parse()
+	if *ptraceLogPath != "" {
+		if err := openPtraceLog(*ptraceLogPath); err != nil {
+			fatal(err)
+		}
 	}
-	_, err = syscall.PtracePokeData(pid, breakpoint, []byte{0xCC})
-	if err != nil {
-		log.Fatal(err)
+	startReaper()
+	if dap.mode != "" {
+		serveDAP(dap.mode)
+		return
 	}
-	return original
-}
-
-func clearBreakpoint(pid int, breakpoint uintptr, original []byte) {
-	_, err := syscall.PtracePokeData(pid, breakpoint, original)
-	if err != nil {
-		log.Fatal(err)
+	var err error
+	if *attach, err = processToAttach(*attach, *attachName, *wait, *attachPgid, *attachCgroup); err != nil {
+		fatal(err)
+	}
+	startInput()
+	handleInterrupts(*attach != 0)
+	attachedToProcess = *attach != 0
+	if *delveAddress == "" {
+		subscribe(showEngineEvent)
 	}
-}
-
-func main() {
-	breakpoints = make(map[string][]int)
-	flag.Parse()
 	filepath := flag.Arg(0)
-	exe, err := elf.Open(filepath)
-	if err != nil {
-		log.Fatal(err)
+	if flag.NArg() > 1 {
+		// Flags after the program's path are its own; a "--" may set them
+		// apart.
+		programArgs = flag.Args()[1:]
+		if programArgs[0] == "--" {
+			programArgs = programArgs[1:]
+		}
+	}
+	if *profileName != "" {
+		p, err := loadProfile(*profileName)
+		if err != nil {
+			fatal(err)
+		}
+		filepath, programArgs = useProfile(p, filepath, programArgs)
+	}
+	if filepath == "" && *attach != 0 {
+		filepath = fmt.Sprintf("/proc/%d/exe", *attach)
+	}
+	if verifying {
+		// A session is verified as recorded, without the init file.
+	} else if err := loadScripts(*commandFile); err != nil {
+		fatal(err)
+	}
+	pid, exe, symbolTable := startTracee(filepath, *attach, ignoreBuildID)
+	defer func() { exe.Close() }()
+	if *delveAddress != "" {
+		serveDelve(*delveAddress, symbolTable)
+		return
 	}
-	defer exe.Close()
-
-	pid := initTracee(filepath)
-
-	symbolTable := getSymbolTable(exe)
-	symbol := symbolTable.LookupFunc("main.main")
-	filename, lineno, _ := symbolTable.PCToLine(symbol.Entry)
-
-	runToSourceLine(pid, filename, lineno, symbolTable)
 
 	pc := getPC(pid)
-	showListing(filename, lineno)
+	if !countStop() {
+		showListing(pcSourceFile, pcSourceLine) // Attached to; it hasn't run.
+	}
+	filename, lineno := pcSourceFile, pcSourceLine
 
 	for {
-		reader := bufio.NewReader(os.Stdin)
+		pid = currentThread
+		restoreOutput()
+		endCapture()
+		endEvaluation()
+		endCommand()
+		countStop()
+		queueBreakpointCommands()
+		startPause()
 		fmt.Print("> ")
-		command, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				fmt.Println()
-				break
+		command, script := nextScriptCommand()
+		if !script && verifying {
+			finishVerify()
+		}
+		if !script {
+			var err error
+			command, err = readCommand(pid, symbolTable)
+			if err != nil {
+				if err == io.EOF {
+					// Ctrl-D quits.
+					fmt.Println()
+					leaveTracee(pid, symbolTable)
+					break
+				}
+				fatal(err)
+			}
+		}
+		command = strings.TrimSuffix(command, "\n")
+		if !script {
+			// An empty line repeats the last command, like in gdb.
+			if command == "" {
+				command = lastCommand
+			} else if isRepeatable(command) {
+				lastCommand = command
+			} else {
+				lastCommand = ""
 			}
-			log.Fatal(err)
 		}
-		command = command[:len(command)-1] // Strip trailing newline.
+		if command == "" {
+			continue
+		}
+		beginCapture(command)
+		beginCommand()
+		command, err := redirectOutput(command)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
 
 		if isHelpCommand(command) {
 			showHelp()
+		} else if running && isWaitCommand(command) {
+			if status := waitForeground(pid, symbolTable); status.Stopped() && status.StopSignal() == syscall.SIGINT {
+				fmt.Println("Interrupted.")
+			}
+		} else if running && isInterruptCommand(command) {
+			interruptBackground(pid, symbolTable)
+		} else if isWaitCommand(command) || isInterruptCommand(command) {
+			fmt.Println("The program is not running.")
+		} else if isShellCommand(command) {
+			runShell(shellCommandLine(command))
+		} else if running && !isConfigCommand(command) && !isQuitCommand(command) {
+			fmt.Println("The program is running in the background; use wait or interrupt first.")
 		} else if isBreakpointCommand(command) {
-			filename, lineNumber, err := parseBreakpointCommand(command, filename)
+			if argument := commandArgument(command); strings.HasPrefix(argument, "init ") {
+				_, err := createInitBreakpoints(pid, strings.TrimPrefix(argument, "init "), symbolTable)
+				if err != nil {
+					fmt.Println(err)
+				} else if !initializing(pid, symbolTable) {
+					fmt.Println("Packages have been initialized already; restart to stop there.")
+				}
+				continue
+			}
+			if argument := commandArgument(command); strings.HasPrefix(argument, "-at-diff") {
+				created, err := createDiffBreakpoints(pid, strings.TrimSpace(strings.TrimPrefix(argument, "-at-diff")), symbolTable)
+				if len(created) > 0 {
+					fmt.Printf("%v breakpoints on the lines changed, in group %v.\n", len(created), diffBreakpointGroup)
+				}
+				if err != nil {
+					fmt.Println(err)
+				}
+				continue
+			}
+			bp, err := createBreakpoint(pid, commandArgument(command), symbolTable)
 			if err != nil {
-				log.Fatal(err)
+				fmt.Println(err)
+				continue
 			}
-
-			breakpoints[filename] = append(breakpoints[filename], lineNumber)
-
-			pc, _, err := symbolTable.LineToPC(filename, lineNumber)
+			showListing(bp.file, bp.line)
+		} else if isHardwareBreakpointCommand(command) {
+			bp, err := createBreakpoint(pid, "-hardware "+commandArgument(command), symbolTable)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			showListing(bp.file, bp.line)
+		} else if isBreakpointsSaveCommand(command) {
+			if err := runBreakpointsSave(strings.TrimSpace(strings.TrimPrefix(command, "breakpoints save"))); err != nil {
+				fmt.Println(err)
+			}
+		} else if isBreakpointsLoadCommand(command) {
+			if err := runBreakpointsLoad(pid, strings.TrimSpace(strings.TrimPrefix(command, "breakpoints load")), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isBreakpointsImportCommand(command) {
+			if err := runBreakpointsImport(pid, strings.TrimSpace(strings.TrimPrefix(command, "breakpoints import")), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isBreakReturnCommand(command) {
+			fn, err := lookupFunction(commandArgument(command), symbolTable)
 			if err != nil {
-				log.Fatal(err)
+				fmt.Println(err)
+				continue
 			}
 
-			setBreakpoint(pid, uintptr(pc))
-			showListing(filename, lineNumber)
+			returns, err := functionReturns(pid, fn)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if len(returns) == 0 {
+				fmt.Printf("%v never returns\n", fn.Name)
+			}
+			for _, pc := range returns {
+				file, line, _ := symbolTable.PCToLine(pc)
+				if err := addBreakpoint(pid, file, line, pc); err == nil {
+					publish(breakpointEvent{change: "set", bp: findBreakpoint(pc)})
+				} else if err != errBreakpointExists {
+					fmt.Println(err)
+				}
+			}
 
+		} else if cantStep(pid) != "" && (isStepIntoCommand(command) || isStepOverCommand(command) || isFinishCommand(command)) {
+			fmt.Println(cantStep(pid))
 		} else if isStepIntoCommand(command) {
-			step(pid)
+			status := step(pid)
+			if reportExit(status) {
+				break
+			}
+			if checkCrash(pid, status, symbolTable) {
+				continue
+			}
 			pc = getPC(pid)
-			filename, lineno, _ := symbolTable.PCToLine(pc)
-			showListing(filename, lineno - 1)
+			pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(pc)
 		} else if isStepOverCommand(command) {
-			lineno = lineno + 1
-			status := runToSourceLine(pid, filename, lineno, symbolTable)
-			if status.Exited() {
+			status := next(pid, symbolTable)
+			pid = currentThread
+			if status == nil {
+				fmt.Printf("The call didn't return within %v; the program keeps running in the background.\n", stepTimeout)
+				fmt.Println("Use wait to wait for it or interrupt to stop it.")
+				continue
+			}
+			if reportExit(status) {
 				break
 			}
-			showListing(filename, lineno)
+			if checkCrash(pid, status, symbolTable) {
+				continue
+			}
+			pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(pid))
+		} else if isFinishCommand(command) {
+			status, returned, err := finish(pid, symbolTable)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			pid = currentThread
+			if status == nil {
+				fmt.Printf("The function didn't return within %v; the program keeps running in the background.\n", stepTimeout)
+				fmt.Println("Use wait to wait for it or interrupt to stop it.")
+				continue
+			}
+			if reportExit(status) {
+				break
+			}
+			if checkCrash(pid, status, symbolTable) {
+				continue
+			}
+			pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(pid))
+			countStop() // The listing comes before the values.
+			if returned != nil {
+				showReturnValues(pid, *returned, symbolTable)
+			}
 		} else if isContinueCommand(command) {
-			status := cont(pid)
-			if status.Exited() {
+			status := cont(pid, symbolTable)
+			pid = currentThread
+			if status.Stopped() && status.StopSignal() == syscall.SIGINT {
+				fmt.Println("\nInterrupted.")
+			}
+			if reportExit(status) {
 				break
+			} else if checkCrash(pid, status, symbolTable) {
+				continue
 			} else {
 				pc = getPC(pid)
-				filename, lineno, _ := symbolTable.PCToLine(pc)
-				pcSourceLine = lineno
-				pcSourceFile = filename
-				showListing(filename, lineno)
+				pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(pc)
+			}
+		} else if cantStep(pid) != "" && (isUntilCommand(command) || isJumpCommand(command)) {
+			fmt.Println(cantStep(pid))
+		} else if isUntilCommand(command) {
+			loc, err := parseLocation(commandArgument(command), symbolTable)
+			if err == nil {
+				err = loc.resolvePC(symbolTable)
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			status := runToPC(pid, loc.pc, symbolTable)
+			pid = currentThread
+			if reportExit(status) {
+				break
+			}
+			if checkCrash(pid, status, symbolTable) {
+				continue
+			}
+			pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(pid))
+		} else if isJumpCommand(command) {
+			loc, err := parseLocation(commandArgument(command), symbolTable)
+			if err == nil {
+				err = loc.resolvePC(symbolTable)
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			setPC(pid, loc.pc)
+			pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(loc.pc)
+			showListing(pcSourceFile, pcSourceLine)
+		} else if isBacktraceCommand(command) {
+			if err := runBacktraceCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isFrameCommand(command) {
+			if err := runFrameCommand(pid, command, symbolTable); err != nil {
+				fmt.Println(err)
 			}
 		} else if isListingCommand(command) {
-			pc = getPC(pid)
-			filename, lineno, _ = symbolTable.PCToLine(pc)
+			filename, lineno = selectedLocation(pid, symbolTable)
 
-			parts := strings.Split(command, " ")
-			if len(parts) == 2 {
-				lineno, err = strconv.Atoi(parts[len(parts)-1])
+			if argument := commandArgument(command); argument != "" {
+				loc, err := parseLocation(argument, symbolTable)
 				if err != nil {
-					log.Fatal(err)
+					fmt.Println(err)
+					continue
 				}
+				filename, lineno = loc.file, loc.line
 			}
 
 			showListing(filename, lineno)
-		} else if isQuitCommand(command) {
-			process, err := os.FindProcess(pid)
+		} else if isGroupCommand(command) {
+			action := strings.Fields(command)[0]
+			if err := runGroupCommand(pid, action, commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isBreakpointNumberCommand(command) {
+			action := strings.Fields(command)[0]
+			if err := runBreakpointNumberCommand(pid, action, commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isIgnoreCommand(command) {
+			if err := runIgnoreCommand(commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isCommandsCommand(command) {
+			if err := runCommandsCommand(commandArgument(command), script); err != nil {
+				fmt.Println(err)
+			}
+		} else if isInfoBreakpointsCommand(command) {
+			showBreakpoints()
+		} else if isFrameVariablesCommand(command) {
+			if err := showFrameVariables(pid, command == "info args", symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isPrintCommand(command) {
+			if err := runPrintCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isExploreCommand(command) {
+			if err := runExploreCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isSetCommand(command) {
+			ctx, err := newEvalContext(pid, symbolTable)
+			if err == nil {
+				err = runSetCommand(ctx, commandArgument(command))
+			}
 			if err != nil {
-				log.Fatal(err)
+				fmt.Println(err)
 			}
-			process.Kill()
+		} else if isDisassembleCommand(command) {
+			if err := runDisassembleCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isRegistersCommand(command) {
+			if err := showRegisters(pid, symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isStatsCommand(command) {
+			if err := runStatsCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isMemdiffCommand(command) {
+			if err := runMemdiffCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isExamineCommand(command) {
+			if err := examineMemory(pid, command, symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isConfigCommand(command) {
+			runConfigCommand(commandArgument(command))
+		} else if isCatchCommand(command) {
+			if err := runCatchCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isWatchCommand(command) {
+			if err := runWatchCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isUnwatchCommand(command) {
+			if err := runUnwatchCommand(pid, commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isMonitorCommand(command) {
+			if err := runMonitorCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isUnmonitorCommand(command) {
+			if err := runUnmonitorCommand(commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isAssertCommand(command) {
+			bp, err := createAssertion(pid, commandArgument(command), symbolTable)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("Stopping when %v is false.\n", bp.assertion.expression)
+		} else if isTraceCommand(command) {
+			if err := runTraceCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isGoroutinesCommand(command) {
+			if err := showGoroutines(pid, symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isGoroutineCommand(command) {
+			if err := showGoroutine(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isFinalizersCommand(command) {
+			if err := showFinalizers(pid, strings.TrimSpace(strings.TrimPrefix(command, "info finalizers")), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isFindSymbolCommand(command) {
+			if err := runFindSymbol(commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isInfoSourcesCommand(command) {
+			if err := showSources(strings.TrimSpace(strings.TrimPrefix(command, "info sources")), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isBuildInfoCommand(command) {
+			if err := showBuildInfo(); err != nil {
+				fmt.Println(err)
+			}
+		} else if isSchedCommand(command) {
+			if err := showSched(pid); err != nil {
+				fmt.Println(err)
+			}
+		} else if isTimersCommand(command) {
+			if err := showTimers(pid); err != nil {
+				fmt.Println(err)
+			}
+		} else if isDeadlockCommand(command) {
+			if err := runDeadlockCommand(pid, commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isTargetsCommand(command) {
+			showTargets()
+		} else if isTargetCommand(command) {
+			member, err := strconv.Atoi(commandArgument(command))
+			if err != nil {
+				fmt.Println("usage: target <pid>")
+				continue
+			}
+			pid, exe, symbolTable, err = switchTarget(pid, member, exe, symbolTable)
+			if err != nil {
+				if exe == nil {
+					fatal(err)
+				}
+				fmt.Println(err)
+				continue
+			}
+			*attach = pid
+			pc = getPC(pid)
+			filename, lineno = pcSourceFile, pcSourceLine
+			fmt.Printf("Attached to %v.\n", pid)
+			showListing(pcSourceFile, pcSourceLine)
+		} else if isSessionSummaryCommand(command) {
+			showSessionSummary()
+		} else if isSessionRecordCommand(command) {
+			if err := runSessionRecordCommand(strings.TrimPrefix(command, "session record ")); err != nil {
+				fmt.Println(err)
+			}
+		} else if isSnapshotSaveCommand(command) {
+			if err := saveSnapshot(pid, strings.TrimPrefix(command, "snapshot save ")); err != nil {
+				fmt.Println(err)
+			}
+		} else if isStopsCommand(command) {
+			if err := showStops(commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isStopGotoCommand(command) {
+			if err := gotoStop(strings.TrimSpace(strings.TrimPrefix(command, "stop goto"))); err != nil {
+				fmt.Println(err)
+			}
+		} else if isHistoryCommand(command) {
+			if err := runHistoryCommand(commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isRecordCommand(command) {
+			if err := runRecordCommand(commandArgument(command)); err != nil {
+				fmt.Println(err)
+			}
+		} else if isAnnotateCommand(command) {
+			if err := runAnnotateCommand(commandArgument(command), symbolTable); err != nil {
+				fmt.Println(err)
+			}
+		} else if isThreadsCommand(command) {
+			showThreads(symbolTable)
+		} else if isThreadCommand(command) {
+			if err := selectThread(commandArgument(command)); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			pid = currentThread
+			pc = getPC(pid)
+			pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(pc)
+			showListing(pcSourceFile, pcSourceLine)
+		} else if isRestartCommand(command) {
+			if *attach != 0 {
+				fmt.Println("Cannot restart a process that was attached to.")
+				continue
+			}
+			pid, exe, symbolTable = restartTracee(pid, filepath, exe)
+			pc = getPC(pid)
+			if !countStop() {
+				showListing(pcSourceFile, pcSourceLine)
+			}
+		} else if isQuitCommand(command) {
+			leaveTracee(pid, symbolTable)
 			break
 		} else {
 			fmt.Println("command unknown")
 		}
 	}
+	restoreOutput()
+	endCapture()
+	finishVerify()
+}
+
+// startTracee launches the program at filepath and runs it to the start
+// of main.main, or attaches to the process attach, and loads the binary's
+// debug information.
+func startTracee(filepath string, attach int, ignoreBuildID bool) (int, *elf.File, *gosym.Table) {
+	var pid int
+	if waitExec != "" {
+		pid = initTracee(filepath)
+		filepath = tracedBinary(pid, filepath)
+	}
+	exe, err := elf.Open(filepath)
+	if err != nil {
+		fatal(err)
+	}
+	if info, err := os.Stat(filepath); err == nil {
+		binaryModTime = info.ModTime()
+	}
+	loadBuildInfo(filepath)
+
+	if attach != 0 {
+		pid = attach
+		if err := verifyBuildID(exe, pid); err != nil {
+			if !ignoreBuildID {
+				fatalf("%v\nRefusing to attach; pass -ignore-build-id to override.", err)
+			}
+			fmt.Printf("WARNING: %v\nLine numbers and variables will be wrong.\n", err)
+		}
+		attachTracee(pid)
+	} else if waitExec == "" {
+		pid = initTracee(filepath)
+	}
+
+	symbolTable := loadSymbols(exe)
+	entryPoint = exe.Entry
+	symbol := symbolTable.LookupFunc("main.main")
+	filename, _, _ := symbolTable.PCToLine(symbol.Entry)
+	if warnIfOptimized() && offerRebuild(filepath, filename) {
+		// Start over with the rebuilt binary.
+		killTracee()
+		exe.Close()
+		return startTracee(filepath, attach, ignoreBuildID)
+	}
+	armFatalPanicCatcher(pid, symbolTable)
+	loadBreakpointPresets(pid, filename, symbolTable)
+
+	if attach != 0 {
+		pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(pid))
+	} else {
+		runToMain(pid, symbolTable)
+	}
+	return currentThread, exe, symbolTable
 }
 
 func isBreakpointCommand(command string) bool {
@@ -220,6 +653,43 @@ func isBreakpointCommand(command string) bool {
 		strings.HasPrefix(command, "b ")
 }
 
+func isHardwareBreakpointCommand(command string) bool {
+	return strings.HasPrefix(command, "hbreak ")
+}
+
+func isBreakpointsSaveCommand(command string) bool {
+	return command == "breakpoints save" || strings.HasPrefix(command, "breakpoints save ")
+}
+
+func isBreakpointsLoadCommand(command string) bool {
+	return command == "breakpoints load" || strings.HasPrefix(command, "breakpoints load ")
+}
+
+func isBreakpointsImportCommand(command string) bool {
+	return command == "breakpoints import" || strings.HasPrefix(command, "breakpoints import ")
+}
+
+func isBreakReturnCommand(command string) bool {
+	return strings.HasPrefix(command, "breakret ")
+}
+
+func isUntilCommand(command string) bool {
+	return strings.HasPrefix(command, "until ") || strings.HasPrefix(command, "u ")
+}
+
+func isJumpCommand(command string) bool {
+	return strings.HasPrefix(command, "jump ") || strings.HasPrefix(command, "j ")
+}
+
+// commandArgument returns everything after the command word.
+func commandArgument(command string) string {
+	i := strings.Index(command, " ")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(command[i+1:])
+}
+
 func isStepIntoCommand(command string) bool {
 	return command == "step" || command == "s"
 }
@@ -228,6 +698,10 @@ func isStepOverCommand(command string) bool {
 	return command == "next" || command == "n"
 }
 
+func isFinishCommand(command string) bool {
+	return command == "finish" || command == "out"
+}
+
 func isContinueCommand(command string) bool {
 	return command == "continue" || command == "c"
 }
@@ -236,6 +710,22 @@ func isHelpCommand(command string) bool {
 	return command == "help" || command == "h" || command == "?"
 }
 
+func isBacktraceCommand(command string) bool {
+	return strings.HasPrefix(command, "backtrace ") ||
+		strings.HasPrefix(command, "bt ") ||
+		command == "backtrace" ||
+		command == "bt"
+}
+
+func isFrameCommand(command string) bool {
+	for _, name := range []string{"frame", "up", "down"} {
+		if command == name || strings.HasPrefix(command, name+" ") {
+			return true
+		}
+	}
+	return false
+}
+
 func isListingCommand(command string) bool {
 	return strings.HasPrefix(command, "listing ") ||
 		strings.HasPrefix(command, "list ") ||
@@ -245,6 +735,198 @@ func isListingCommand(command string) bool {
 		command == "l"
 }
 
+func isGroupCommand(command string) bool {
+	return strings.HasPrefix(command, "enable group ") ||
+		strings.HasPrefix(command, "disable group ") ||
+		strings.HasPrefix(command, "delete group ")
+}
+
+func isBreakpointNumberCommand(command string) bool {
+	return strings.HasPrefix(command, "enable ") ||
+		strings.HasPrefix(command, "disable ") ||
+		strings.HasPrefix(command, "delete ")
+}
+
+func isIgnoreCommand(command string) bool {
+	return command == "ignore" || strings.HasPrefix(command, "ignore ")
+}
+
+func isCommandsCommand(command string) bool {
+	return command == "commands" || strings.HasPrefix(command, "commands ")
+}
+
+func isInfoBreakpointsCommand(command string) bool {
+	return command == "info breakpoints" || command == "info b"
+}
+
+func isFrameVariablesCommand(command string) bool {
+	return command == "info args" || command == "info locals"
+}
+
+func isPrintCommand(command string) bool {
+	return strings.HasPrefix(command, "print ") || strings.HasPrefix(command, "p ")
+}
+
+func isExploreCommand(command string) bool {
+	return command == "explore" || strings.HasPrefix(command, "explore ")
+}
+
+func isSetCommand(command string) bool {
+	return strings.HasPrefix(command, "set ")
+}
+
+func isDisassembleCommand(command string) bool {
+	return command == "disas" || strings.HasPrefix(command, "disas ") ||
+		command == "disassemble" || strings.HasPrefix(command, "disassemble ")
+}
+
+func isRegistersCommand(command string) bool {
+	return command == "regs" || command == "info registers"
+}
+
+func isExamineCommand(command string) bool {
+	return strings.HasPrefix(command, "x/") || strings.HasPrefix(command, "x ")
+}
+
+func isStatsCommand(command string) bool {
+	return strings.HasPrefix(command, "stats ")
+}
+
+func isMemdiffCommand(command string) bool {
+	return strings.HasPrefix(command, "memdiff ")
+}
+
+func isConfigCommand(command string) bool {
+	return command == "config" || strings.HasPrefix(command, "config ")
+}
+
+func isRestartCommand(command string) bool {
+	return command == "restart" || command == "r"
+}
+
+func isCatchCommand(command string) bool {
+	return strings.HasPrefix(command, "catch ")
+}
+
+func isWatchCommand(command string) bool {
+	return command == "watch" || strings.HasPrefix(command, "watch ")
+}
+
+func isUnwatchCommand(command string) bool {
+	return strings.HasPrefix(command, "unwatch ")
+}
+
+func isMonitorCommand(command string) bool {
+	return command == "monitor" || strings.HasPrefix(command, "monitor ")
+}
+
+func isUnmonitorCommand(command string) bool {
+	return strings.HasPrefix(command, "unmonitor ")
+}
+
+func isAssertCommand(command string) bool {
+	return command == "assert" || strings.HasPrefix(command, "assert ")
+}
+
+func isTraceCommand(command string) bool {
+	return command == "trace" || strings.HasPrefix(command, "trace ")
+}
+
+func isGoroutinesCommand(command string) bool {
+	return command == "goroutines" || command == "info goroutines"
+}
+
+func isGoroutineCommand(command string) bool {
+	return strings.HasPrefix(command, "goroutine ")
+}
+
+func isFinalizersCommand(command string) bool {
+	return command == "info finalizers" || strings.HasPrefix(command, "info finalizers ")
+}
+
+func isFindSymbolCommand(command string) bool {
+	return strings.HasPrefix(command, "find-symbol ")
+}
+
+func isInfoSourcesCommand(command string) bool {
+	return command == "info sources" || strings.HasPrefix(command, "info sources ")
+}
+
+func isBuildInfoCommand(command string) bool {
+	return command == "info build"
+}
+
+func isSchedCommand(command string) bool {
+	return command == "info sched" || command == "sched"
+}
+
+func isTimersCommand(command string) bool {
+	return command == "info timers" || command == "timers"
+}
+
+func isDeadlockCommand(command string) bool {
+	return strings.HasPrefix(command, "deadlock ")
+}
+
+func isTargetsCommand(command string) bool {
+	return command == "targets"
+}
+
+func isTargetCommand(command string) bool {
+	return strings.HasPrefix(command, "target ")
+}
+
+func isSessionSummaryCommand(command string) bool {
+	return command == "session summary"
+}
+
+func isStopsCommand(command string) bool {
+	return command == "stops" || strings.HasPrefix(command, "stops ")
+}
+
+func isStopGotoCommand(command string) bool {
+	return command == "stop goto" || strings.HasPrefix(command, "stop goto ")
+}
+
+func isHistoryCommand(command string) bool {
+	return command == "history" || strings.HasPrefix(command, "history ")
+}
+
+func isRecordCommand(command string) bool {
+	return strings.HasPrefix(command, "record ")
+}
+
+func isAnnotateCommand(command string) bool {
+	return strings.HasPrefix(command, "annotate ")
+}
+
+func isThreadsCommand(command string) bool {
+	return command == "threads" || command == "info threads"
+}
+
+func isThreadCommand(command string) bool {
+	return strings.HasPrefix(command, "thread ")
+}
+
+func isWaitCommand(command string) bool {
+	return command == "wait"
+}
+
+func isInterruptCommand(command string) bool {
+	return command == "interrupt"
+}
+
+func isShellCommand(command string) bool {
+	return strings.HasPrefix(command, "!") || strings.HasPrefix(command, "shell ")
+}
+
+func shellCommandLine(command string) string {
+	if strings.HasPrefix(command, "!") {
+		return strings.TrimSpace(command[1:])
+	}
+	return commandArgument(command)
+}
+
 func isQuitCommand(command string) bool {
 	return command == "q" || command == "quit" || command == "exit"
 }
@@ -253,11 +935,437 @@ func showHelp() {
 	text := `
 Set Breakpoint
 
-  b <location>
-  break <location>
-  breakpoint <location>
+  b <location> [<options>] [if <condition>]
+  break <location> [<options>] [if <condition>]
+  breakpoint <location> [<options>] [if <condition>]
+
+  <location> is one of:
+
+    <line>           a line in the current file
+    +<n>, -<n>       a line relative to the current line
+    <file>:<line>    a line in the named file
+    <func>           the first line of a function, after its prologue,
+                     e.g. main.greeting or net/http.(*Server).Serve
+    <func>:<offset>  a line relative to the start of a function
+    <func>+<bytes>   an instruction at a byte offset into a function, e.g.
+                     main.greeting+0x1c
+    *<address>       an instruction address
+    -entry           the ELF entry point, before the runtime has set up
+                     anything; restart to stop there
+
+  With a <condition> the breakpoint only stops the program when the
+  expression is true, e.g. break main.greeting if $rdi == 0.  depth, or
+  $depth if a variable is called depth, is the number of frames on the
+  stack, e.g. break main.walk if depth > 50 catches runaway recursion.
+  $prev(<expr>) is the value <expr> had the last time the breakpoint was
+  hit, so break main.go:20 if state != $prev(state) stops only when state
+  has changed; on the first hit $prev(<expr>) is <expr> itself.
+  Comparisons of integer variables with each other or with numbers, joined
+  by && and ||, are compiled so that each hit costs a single read of the
+  program's memory, which keeps a condition in a hot loop usable.  info
+  breakpoints marks them (compiled).
+
+  <options> are:
+
+    -group <name>         adds the breakpoint to a group
+    -calledby <pattern>   only stops when the caller, or its caller, is a
+                          function matching the regular expression
+    -goroutine <id>       only stops the goroutine with that id
+    -log "<message>"      prints the message rather than stopping, with
+                          each {<expr>} in it replaced by its value, e.g.
+                          -log "n is {n}"
+    -rate <n>/<per>       prints at most <n> messages of a -log breakpoint
+                          each second, minute or hour for s, m or h, or
+                          each duration, e.g. -rate 1/s or -rate 5/100ms
+    -sample <n>/<m>       prints the messages of the first <n> of every <m>
+                          hits of a -log breakpoint, e.g. -sample 1/100
+    -pause <duration>     continues the program that long after it stops,
+                          and its commands have run, unless something is
+                          typed first, for snapshots of a live system that
+                          only stall it briefly
+    -hardware             stops with a debug register instead of an INT3,
+                          as hbreak does
+
+  The hits -rate and -sample drop aren't evaluated; the next message
+  printed says how many were, and info breakpoints counts them all.
+
+Hardware Breakpoints
+
+  Sets a breakpoint that one of the CPU's debug registers stops at, leaving
+  the program's text unchanged, for code that checksums itself or can't be
+  written, like text mapped shared or read-only.  It takes the same
+  <options> and <condition> as break.  There are four debug registers,
+  shared with watchpoints; info breakpoints marks hardware breakpoints.
+
+  hbreak <location> [<options>] [if <condition>]
+
+Breakpoint Files
+
+  Sets the breakpoints of a file of break and hbreak commands, one a line,
+  with their options and conditions, as a teammate might send or a script
+  write.  Blank lines and lines starting with # are skipped.  Each line is
+  reported, set or with why it couldn't be, and the rest are still set.
+
+  breakpoints import <file>
+
+  save writes every breakpoint to a JSON file with all it was set with, its
+  condition, options, -log message and limits, commands, ignore count,
+  whether it is disabled and its hit count, for load to set again in a
+  later session.  The file has a
+  version; load reports whatever in it this release doesn't know instead of
+  dropping it quietly, and sets the breakpoint with the rest.
+
+  breakpoints save <file>
+  breakpoints load <file>
+
+Profiles
+
+  Debugs with a named setup of the module's .godbg/profiles.json, found
+  from the current directory: the program, its arguments and environment,
+  where to read the binary's source files from, breakpoints and settings,
+  so that switching between the services of a repository is one flag.
+
+  godebugger -profile <name> [<program> [<args>]]
+
+  e.g. {"server": {"program": "bin/server", "args": ["-listen", ":8080"],
+  "env": ["LOG_LEVEL=debug"], "substitutePaths": [{"from": "/build/src",
+  "to": "."}], "breakpoints": [{"location": "auth.go:42", "condition":
+  "user == nil"}], "settings": {"inline-values": "off"}}}
+
+  Relative paths are from the module root.  A program given on the command
+  line is used instead of the profile's, with its own arguments.  The
+  breakpoints are set and the settings changed, as with break and config,
+  after the commands of ~/.godebuggerrc and before those of -command.
+
+Breakpoints On A Diff
+
+  break -at-diff <git-ref>
+
+  Sets a breakpoint on every line with code that git diff <git-ref> finds
+  added or changed, in the repository of the program's main package, e.g.
+  break -at-diff HEAD~1 for the last commit.  They are in group diff, to
+  disable or delete together; at most 200 are set.
+
+Assertions
+
+  assert <location> <expr>
+
+  Sets a breakpoint that evaluates <expr> every time the program passes
+  <location> and only stops, with a loud report of how many times it held
+  first, when it is false, e.g. assert cache.go:88 len(c.items) <= c.max.
+  An <expr> that can't be evaluated stops too.  info breakpoints shows how
+  many times each assertion held.
+
+Package Initialization Breakpoints
+
+  break init <package-path> [<options>] [if <condition>]
+
+  Sets a breakpoint on each init function of a package, e.g. break init
+  main or break init net/http: the one initializing its variables and
+  those it declares.  The program is started up to main.main, past
+  initialization, so restart to stop in them.
+
+HTTP Request Breakpoints
+
+  break http <method> <path-pattern> [if <condition>]
+
+  Stops in net/http's dispatch of each request to the server's handler
+  whose method is <method>, or any method for *, and whose URL path
+  matches <path-pattern>, a pattern as for path.Match, e.g.
+  break http POST /login or break http * /api/*.  The request is req.
+
+Allocation Breakpoints
+
+  break alloc <file:line> [if <condition>]
+
+  Stops when the code of a line allocates on the heap, to confirm or refute
+  that it does, and see what: each allocation whose innermost frame outside
+  the runtime is on the line, not those of functions it calls.  The program
+  stops in runtime.mallocgc with the line's frame selected, where the
+  condition is evaluated, and the size and type allocated are printed.
+  Only one allocation breakpoint can be set at a time.
+
+Managing Breakpoints
+
+  Lists the breakpoints with their numbers and how often each has stopped
+  the program, or enables, disables or deletes breakpoints by number.
+
+  info breakpoints
+  info b
+  enable <n>...
+  disable <n>...
+  delete <n>...
+  ignore <n> <count>
+
+  ignore has breakpoint <n> let the program pass the next <count> times
+  it would otherwise stop there.
+
+Breakpoint Commands
+
+  commands [<n>]
+  <command>
+  ...
+  end
+
+  Gives breakpoint <n>, or the one set last, commands to run each time
+  the program stops there, as if typed at the prompt, e.g. print and bt
+  to record the state, then continue.  With -pause they run before the
+  pause starts.  An empty list removes them; info breakpoints shows them.
+
+Breakpoint Groups
+
+  Breakpoints set with -group <name> can be handled together.
+
+  enable group <name>
+  disable group <name>
+  delete group <name>
+
+Break On Returns
+
+  Sets a breakpoint on every return instruction of a function.
+
+  breakret <func>
+
+Catch Fatal Errors
+
+  Stops the program when the runtime is about to die of a fatal error, such
+  as concurrent map writes or all goroutines being asleep, so it can still be
+  inspected.  Unrecovered panics are always caught.
+
+  catch throw
+
+Core Dumps
+
+  Run from the shell, triage reports on a core file without prompting: the
+  signal the process died of, the backtrace of the thread that got it, the
+  locals of the frame that crashed, every goroutine's stack, the runtime's
+  memory statistics and the build info of the binary.  Go programs write a
+  core on a fatal error when run with GOTRACEBACK=crash.
+
+  godebugger triage <binary> <core>
+
+  A snapshot is far smaller than a core, for sharing: snapshot save keeps
+  the registers, the stacks of every thread and goroutine, the globals and
+  the heap they point to, a few pointers deep, but not the rest of the
+  address space.  snapshot open reports on it as triage does.
+
+  snapshot save <file>
+  godebugger snapshot open <file> [<binary>]
+
+  snapshot diff compares two snapshots or cores of the same binary, say of
+  a service when healthy and when hung: the number of goroutines, the
+  stacks whose goroutines grew or shrank, the globals of the program that
+  changed and the runtime's memory statistics.  Cores need the binary
+  named.
+
+  godebugger snapshot diff <a> <b> [<binary>]
+
+Catch Exit
+
+  Stops the program just before the process exits, whether from os.Exit or
+  main returning, reporting the exit code so the final state can still be
+  inspected.
+
+  catch exit
+
+Catch Runtime Initialization
+
+  Stops the program once the runtime's schedinit has set up the heap, the
+  environment and GODEBUG, before any package init or main.main runs.  It
+  takes effect from the next restart.
+
+  catch runtime-init
+
+Catch Log Output
+
+  Stops the program when it logs a message matching <regexp> through the
+  log or log/slog package, in the function that logged it, to go from an
+  error line in the logs straight to the code and state that wrote it.  A
+  log line is matched with its prefix and header, a slog record by its
+  message.  Another catch log replaces the pattern.
+
+  catch log <regexp>
+  catch log off
+
+Catch Output
+
+  Stops the program when it is about to write a line matching <regexp> to
+  stdout or stderr through package os, fmt's and log's printing included,
+  in the function that printed it, for when all there is to go on is a line
+  of output.  A line written in pieces is matched as a whole, at the write
+  that finishes it; the line appears once the program continues.  The
+  pattern of another catch output replaces it.
+
+  catch output <regexp>
+  catch output off
+
+Catch Garbage Collection
+
+  Stops the program at the boundaries of a garbage collection cycle: when
+  one starts, in the goroutine whose allocation or runtime.GC call
+  triggered it, and when marking is done, before the pause that ends it.
+  Each stop reports the live heap against the goal that triggers the next
+  cycle, what the last cycle left marked, any memory limit, and the last
+  and average stop-the-world pauses, so the state the collector decided by
+  can be looked at.  Without start or end it stops at both.
+
+  catch gc [start|end]
+  catch gc off
+
+Catch Channel
+
+  Stops the program whenever a goroutine sends on or receives from the
+  channel <expr> evaluates to, or only sends or receives, in the goroutine
+  doing it, reporting which it is and how full the channel is.  Sends and
+  receives in a select of more than one channel case aren't caught.
+  The catch is for the channel of this run; restart removes it.
+
+  catch chan <expr> [send|recv]
+  catch chan off
+
+Watchpoints
+
+  Stops the program after a write changes a variable, printing what changed
+  field by field.  Other than a global, <expression> is evaluated in the
+  selected frame, and the watchpoint stays on the memory it named, after a
+  local's function has returned too.  With a <condition> a write only stops
+  the program when the condition is true.  With -init, the program stops at
+  the first write to a global, whatever it writes, and the watchpoint is
+  removed; set before a restart, it shows which package initializer sets
+  the global, and to what.  The CPU's four debug registers cover at most 32
+  bytes in all.  Without an argument, lists the watchpoints.
+
+  With -growth, the program stops instead when a slice or map gets a bigger
+  backing store: append reallocating the slice's array, or the map growing
+  its tables.  It is stopped in the runtime before the growth, with the
+  frame that appended or assigned selected, to find what makes a collection
+  grow unexpectedly.  It takes no debug registers, and follows the slice to
+  each new array.
+
+  watch [<expression> [if <condition>]]
+  watch -init <global>
+  watch -growth <map-or-slice>
+  unwatch <expression>
 
-  <location> is the name of a function or a line number.
+Monitors
+
+  Samples an expression while the program runs: every <duration> the
+  program is stopped just long enough to evaluate it, and the value is
+  logged with the time.  Other than a global, <expression> is evaluated on
+  the thread the program was continued from, wherever it is.  Without an
+  argument, lists the monitors.
+
+  monitor [<expression> every <duration>]
+  unmonitor <expression>
+
+Trace Recursion
+
+  Logs how deeply a function is nested in itself each time the nesting
+  reaches a new maximum and, with -stop, stops the program once it nests
+  deeper than <depth>, listing the arguments of every nested call.  Without
+  a function, lists the traces.
+
+  trace recursion [<func>]
+  trace recursion <func> -stop <depth>
+  trace recursion <func> off
+
+Capture Traces
+
+  Records the values of expressions each time the program passes
+  <location>, without stopping it, as a flight recorder does: the last <n>
+  passes are kept, 1000 without -ring.  With a <condition> only the passes
+  where it is true are recorded.  trace dump prints the passes of every
+  capture trace in the order they happened, with the time and goroutine,
+  or writes them to <file> as JSON, one pass to a line.  Deleting the
+  breakpoint discards what it recorded.
+
+  trace <location> -capture <expr>,<expr>,... [-ring <n>] [if <condition>]
+  trace dump [<file>]
+
+Goroutines
+
+  Lists the goroutines with their state and where each is in its own code,
+  marking the selected one with *, or shows one in detail: what it is
+  blocked on, including each case of a select, and its stack.  goroutine
+  <id> also selects it, so backtrace, frame, list and print work in its
+  stack until the program moves again.
+
+  goroutines
+  info goroutines
+  goroutine <id>
+
+Find Symbol
+
+  Lists the functions and package-level variables whose names match best,
+  <count> of them or 20, for the full names break and print need.  Case,
+  the package path and a method receiver's parentheses and star don't
+  matter, and the letters of <name> in order match as a last resort.
+
+  find-symbol <name> [<count>]
+
+Source Files
+
+  Lists the source files compiled into the binary, or those whose path
+  matches <regexp>, under the package whose functions each holds, for the
+  path break and list take when the files here aren't laid out as they
+  were for the build.
+
+  info sources [<regexp>]
+
+Build Info
+
+  Shows what the Go toolchain recorded in the binary: its build IDs, Go
+  version, main package and module, the versions of the modules it
+  depends on, the build flags and the commit its sources were at.
+
+  info build
+
+Scheduler
+
+  Shows GOMAXPROCS, how many Ms are idle or spinning, the length of the
+  global run queue, and the state, M and local run queue of every P.
+
+  sched
+  info sched
+
+Timers
+
+  Lists the pending runtime timers, soonest first, with when each fires,
+  its period and the function it calls: a sleeping goroutine, a channel of
+  time.After or time.NewTicker, or a function given to time.AfterFunc.
+
+  timers
+  info timers
+
+Finalizers
+
+  Shows the finalizer and cleanups registered with runtime.SetFinalizer and
+  runtime.AddCleanup for the heap object <expr> points into, and whether the
+  finalizer is already queued because the object became unreachable.
+
+  info finalizers <expr>
+
+Deadlock Check
+
+  Looks for goroutines blocked on channels or locks in a cycle: each waits
+  on an object that the next one's variables refer to, and so may be
+  holding or about to use.
+
+  deadlock check
+
+Until
+
+  Continues until the program reaches <location>.
+
+  u <location>
+  until <location>
+
+Jump
+
+  Resumes execution at <location> the next time the program runs.
+
+  j <location>
+  jump <location>
 
 Step
 
@@ -273,6 +1381,14 @@ Next Source Line
   n
   next
 
+Finish
+
+  Runs until the selected function returns, then shows the caller's line
+  and the values the function returned.
+
+  finish
+  out
+
 Continue
 
   c
@@ -282,11 +1398,394 @@ Listing
 
   Display source code centered around the current instruction.
 
-  l <lineno>
-  list <lineno>
+  l <location>
+  list <location>
+
+  <location> is optional; when given the display will be centered around the
+  given location.
+
+  The line the program is stopped at ends with the values of the variables
+  it uses, e.g. if greeted {   // greeted = true.  config inline-values off
+  leaves them out.
+
+Backtrace
+
+  Shows the call stack of the current thread, innermost frame first, or
+  only its innermost <n> frames.  The selected frame is marked with *.
+
+  bt [<n>]
+  backtrace [<n>]
+
+Frames
+
+  Selects the frame that print, info args, info locals and list work in:
+  frame <n> picks one by its number in the backtrace, up and down move
+  <n> frames, one by default, towards the caller or the callee.  The
+  selection lasts until the program moves again.  Registers are only
+  known in the innermost frame of a goroutine that is on a thread.
+
+  frame [<n>]
+  up [<n>]
+  down [<n>]
+
+Print
+
+  Evaluates an expression and prints its value.
+
+  p [<mode>] <expr>
+  print [<mode>] <expr>
+
+  Expressions are written in Go syntax over variables, constants and the
+  registers $rax through $r15, $rip, $rsp and $rbp; $pc, $sp and $fp are
+  aliases.  Comparisons, && || !, and conversions like uint32($rax) or
+  (*main.T)(0xc000010000) are supported.
+
+  Methods can be called, as in user.Name() or buf.Len(), on the current
+  thread; an interface's are those of the value it holds.  Only methods
+  that make no system calls, have no loops and call nothing that does are
+  called, unless config unsafe-calls is on, and none with -no-calls.  A
+  call that panics, stops at a breakpoint or runs longer than call-timeout
+  is abandoned, and the thread is left as it was.  How much memory one
+  expression may read is capped by eval-read-limit.
+
+  An error is shown with the chain of errors it wraps, each with its type
+  and message.  A bytes.Buffer or strings.Builder is shown as its unread
+  contents, a net.IP in text form, a url.URL as the URL and a big.Int in
+  decimal, and pointers to them with what they point to.  Byte slices and
+  arrays are shown as an escaped string next to their hex bytes.  A slice
+  or array of return addresses, as runtime.Callers fills in and errors
+  packages keep, is shown as the functions and lines of the stack trace,
+  innermost first.  <mode> renders a string or byte buffer differently:
+
+    -s        as an escaped string
+    -utf8     as decoded UTF-8 text
+    -hexdump  as a hexdump -C style dump
+
+  print -ctx <expr> walks the context.Context <expr> up to its root,
+  showing each wrapper's deadline, whether and why it was cancelled, and
+  the key and value it stores.
+
+  Lists the arguments, including results, or the local variables of the
+  current function.
+
+  info args
+  info locals
+
+Explore
 
-  <lineno> is optional; when given the display will be centered around the given
-  line number.
+  Shows the value of <expr> one level at a time, numbering its fields, the
+  target of a pointer, the value an interface holds or the elements of a
+  slice or array, 20 at a time.  At the explore> prompt, a number opens
+  what it numbers, [<index>] opens an element, u goes back up, n and p show
+  the next and previous elements, and q leaves.
+
+  explore <expr>
+
+Convenience Variables
+
+  Stores the value of <expr> in $<name> for use in later expressions.
+
+  set $<name> = <expr>
+
+  $_ holds the last value printed and $__ its address.  Every printed value is
+  numbered, and $1, $2, ... refer back to them.
+
+Examine Memory
+
+  Displays memory at the address <expr> evaluates to.
+
+  x <expr>
+  x/<count><format><unit> <expr>
+  x <expr> <len> [hex|word|string]
+  x -type <type> <expr>
+
+  <format> is x (hex), d (decimal), u (unsigned), o (octal), t (binary),
+  c (char), a (address) or s (string); <unit> is b, h, w or g for 1, 2, 4 or
+  8 bytes.  <expr> is evaluated as for print, e.g. $sp+0x20 or &arr[2].  The
+  third form dumps <len> bytes, by default as a hexdump.  -type dumps a
+  value of <type> at the address, a row per field starting at the field's
+  offset, with the name, type and value of each field and the padding
+  between them marked, to see which field stray bytes fell in.
+
+Statistics
+
+  Summarizes a slice or array of integers or floats: the number of
+  elements, the smallest and largest, mean, standard deviation, median,
+  90th and 99th percentiles, and a sparkline of how the values spread from
+  the smallest to the largest.  NaNs are counted and left out.
+
+  stats <expr>
+
+Memory Diff
+
+  Snapshots <len> bytes at the address <expr> evaluates to.  Run again on
+  the same range, it shows the 16-byte rows that changed since, old and new
+  with their offsets, the changed bytes marked with ^^, and snapshots the
+  range afresh.
+
+  memdiff <expr> <len>
+
+Disassemble
+
+  Disassembles the function the PC is in, or the function named or the one
+  containing the address <expr> evaluates to, with the source lines the
+  instructions come from.  => marks the PC and * a breakpoint.  With
+  -annotate, the instructions are grouped by source line instead, in line
+  order with each line's text above its group and ... where the group's
+  code is not contiguous; instructions jumped to within the function are
+  labeled L1, L2, ... and each jump names the label it goes to.
+
+  disas [<function>|<expr>|-func <name>] [-annotate]
+  disassemble [<function>|<expr>|-func <name>] [-annotate]
+
+Registers
+
+  Shows every register of the current thread.
+
+  regs
+  info registers
+
+Configuration
+
+  Lists settings, or shows or changes one.
+
+  config
+  config <name>
+  config <name> <value>
+
+  step-defers on|off    next steps into deferred calls at function exit
+  render-times on|off   print times, durations and timestamp-like integers
+                        in human form
+  goroutine-events on|off
+                        log each goroutine started while the program runs,
+                        with the function it runs and the stack that
+                        started it, and each goroutine exiting
+  thread-events on|off  log each OS thread started or exiting
+  show-provenance on|off
+                        tag each value print shows, dimmed, with the
+                        goroutine, frame and pc it was read in, as DAP
+                        evaluate results are in their provenance; on unless
+                        set
+  engine-events on|off  log each stop, exit and breakpoint change as the
+                        event the DAP and Delve servers are told of
+  step-timeout <d>      how long next waits for a call to return, e.g. 5s;
+                        0 waits for ever
+  non-stop on|off       a breakpoint stops only the thread that hit it
+  displaced-stepping on|off
+                        step a thread off a breakpoint by running a copy of
+                        the instruction in a page of its own, so that the
+                        INT3 stays in place for the threads still running;
+                        off puts the byte back for the step.  On unless set;
+                        system calls are always stepped in place.
+  scheduler-locking off|step|on
+                        off lets every thread run while the program runs;
+                        step keeps the others stopped during next, and on
+                        keeps them stopped always.  Single instructions are
+                        always stepped with the other threads stopped.
+  unsafe-calls on|off   expressions may call any method, not only those that
+                        can't block or run for long
+  call-timeout <d>      how long a call from an expression may run before
+                        it is interrupted and undone, 5s unless set
+  eval-read-limit <size>|off
+                        the most memory evaluating one expression, or a
+                        breakpoint's condition, may read, e.g. 64K or 16M
+  command-timeout <d>   how long a command may work, reading memory or
+                        formatting a value, before it is cancelled, 0 for ever
+  max-stop-duration <d> how long a process that was attached to, such as a
+                        live service, may stay stopped with no one typing
+                        at the prompt before a loud warning, repeated as
+                        long as it stays so; 0, the default, for ever
+  max-stop-action warn|continue|detach
+                        what else max-stop-duration running out does:
+                        continue or detach 10s after the warning unless
+                        something is typed; warn unless set
+  notify-cmd "<command>"|off
+                        a shell command run when the program stops, crashes
+                        or exits while running in the background, or when
+                        another thread stops in non-stop mode; it finds the
+                        message in GODEBUGGER_STOP and the location in
+                        GODEBUGGER_FILE and GODEBUGGER_LINE
+  source-server "<url>"|off
+                        where to fetch source files that aren't on this
+                        machine; {module}, {version} and {path} are the
+                        module a file is in, as found in the module cache
+                        path or the binary's build info, its version and
+                        the file's path in it, {revision} is the commit the
+                        binary was built from and {file} the path the
+                        binary records.  A URL ending in .zip is a module
+                        zip the file is taken from, e.g.
+                        https://proxy.golang.org/{module}/@v/{version}.zip.
+                        Standard library files are read from the local Go
+                        installation of the binary's Go version first
+  wait-flags <flag>|... the wait4 flags the program's status changes are
+                        collected with: __WALL, which is needed for its
+                        threads, and WUNTRACED and WCONTINUED, whose extra
+                        statuses show in -log-ptrace.  __WALL unless set
+
+Threads
+
+  Lists the threads, marking the current one with *, or makes a stopped
+  thread current.  In non-stop mode the other threads keep running when one
+  stops, and a stop in another thread is reported as it happens.
+
+  threads
+  info threads
+  thread <id>
+
+Process Groups
+
+  Started with -attach-pgid <id> or -attach-cgroup <path>, the debugger
+  finds the Go processes of a process group or cgroup, such as the workers
+  of a service, and attaches to the first.  It debugs one at a time: target
+  detaches from the current one, leaving it running without breakpoints,
+  and attaches to another, setting the same breakpoints and watchpoints
+  there.  targets lists them, marking the current one with *.  The other
+  members aren't attached to: their breakpoints aren't set, so one they
+  would have hit goes unseen, and goroutines and breakpoints are shown for
+  the current member only.  To watch several at once, run a debugger for
+  each.
+
+  targets
+  target <pid>
+
+  For a service in Kubernetes, k8s copies the debugger into the pod, finds
+  the Go process in the container and attaches to it, at the terminal or,
+  with -dap <port>, as a debug adapter reached through a forwarded port.
+  ps lists the Go processes where it runs.
+
+  godebugger k8s [-c <container>] [-pid <pid>] [-dap <port>] <namespace>/<pod>
+  godebugger ps
+
+Delve Clients
+
+  Started with -delve <address>, the debugger starts the program, or
+  attaches with -attach, and serves the most-used part of Delve's JSON-RPC
+  API there (State, ListGoroutines, Stacktrace, CreateBreakpoint,
+  ListBreakpoints, ClearBreakpoint, Command and Detach), so that editor
+  plugins written for a headless dlv can connect unchanged.  Command
+  knows continue, next, step, stepOut, halt, switchThread and
+  switchGoroutine.
+
+  godebugger -delve 127.0.0.1:4040 <program> [<args>]
+
+Background Execution
+
+  With step-timeout set, next hands control back when a call it steps over
+  doesn't return in time, and the program keeps running in the background.
+  Ctrl-C stops a running program, or a next that is taking too long.
+  Otherwise it cancels the command at work, such as a print of a huge value
+  or an x of much memory, which shows what it had got and the prompt comes
+  back; so does command-timeout running out.
+
+  wait
+  interrupt
+
+Restart
+
+  Starts the program again, reloading the binary, with the same arguments,
+  environment and standard input.  Breakpoints are resolved again from
+  their locations and a report shows which moved.
+
+  r
+  restart
+
+Session Summary
+
+  Shows how long the session has run, how much of it the program spent
+  running and stopped, how many times it stopped for each reason, and the
+  most hit breakpoints.  Time the debugger spends deciding whether to stop,
+  such as evaluating breakpoint conditions, counts as stopped.
+
+  session summary
+
+Stop History
+
+  Lists the last <n> places the program stopped, 10 by default, each with
+  an id, when, the thread and why, to retrace a long stepping session; the
+  last 100 are kept.  goto lists the source of one again.  The program has
+  run on since, so it shows where the program was, not its state then; save
+  a snapshot at a stop to keep that.
+
+  stops [<n>]
+  stop goto <id>
+
+Record
+
+  Records the last <n> instructions the program executes, with their
+  source lines, showing the path it took to where it stopped.  While
+  recording, continuing single-steps the current thread with the other
+  threads stopped, which is slow and hangs if the thread waits for another
+  one; Ctrl-C stops it.  show lists the last <count> instructions recorded,
+  or all of them, oldest first.
+
+  record pcs <n>
+  record show [<count>]
+  record off
+
+Branch History
+
+  Has the CPU record the branches each thread takes, with perf and the last
+  branch records (LBR) of CPUs that have them, at almost no cost to the
+  program.  A sample of the last branches is taken every <period> branches,
+  1000 by default; history shows the newest sample of the current thread,
+  newest branch first, so the branches shown end at most <period> branches
+  before the stop.  A smaller period is more exact and slower.
+
+  history on [<period>]
+  history [<count>]
+  history off
+
+Annotate
+
+  Writes a source file with the breakpoints on each line, how many times
+  they were hit, and how many times the program stopped on the line,
+  stepping or otherwise, this session.  The line the program is stopped at
+  is marked.  An output file ending in .html is a page, with the lines
+  stopped at highlighted; anything else is plain text.
+
+  annotate <file.go> <out.html|out.txt>
+
+Command Line
+
+  On a terminal, commands can be edited with the arrow keys and the emacs
+  keys of readline, and Up and Down go through the earlier ones.  An empty
+  line repeats the last command, except restart.  Commands in ~/.godebuggerrc
+  and then in the file given with -command run at startup, one a line;
+  lines starting with # are comments.
+
+Record And Verify Sessions
+
+  Records the commands typed to a file, each with where the program stopped
+  after it and the values it printed.  Run from the shell, verify runs the
+  commands of the file again, against the binary recorded or the one given
+  once rebuilt, and reports every stop and value that differs, exiting
+  with status 1 if any did: a regression test for a bug fixed in a session.
+
+  session record <file>
+  session record off
+  godebugger verify <file> [<binary>] [-- <args>]
+
+Shell
+
+  Runs a command with /bin/sh, whether the program is stopped or running,
+  its output shown among the debugger's.  It can't read from the terminal.
+
+  shell <command>
+  !<command>
+
+Pipelines And Redirection
+
+  Any command's output can go to a shell command instead of the terminal,
+  or to a file, overwritten with > or appended to with >>.  A | is only
+  taken for a pipeline when the word after it is a program on the PATH, and
+  a file has to be named from /, ./, ../ or ~/, so that expressions like
+  a | b and x > y are left alone.
+
+  <command> | <shell command>
+  <command> > <path>
+  <command> >> <path>
+
+  e.g. info functions | grep handler, or bt > /tmp/bt.txt.
 
 Help
 
@@ -308,35 +1807,38 @@ func getSymbolTable(exe *elf.File) *gosym.Table {
 
 	exeSection = exe.Section(".gopclntab")
 	if exeSection == nil {
-		log.Fatal("Cannot read .gpclntab section")
+		fatal("Cannot read .gpclntab section")
 	}
 	lineTableData, err := exeSection.Data()
 
+	var symbolTableData []byte
 	exeSection = exe.Section(".gosymtab")
-	if exeSection == nil {
-		log.Fatal("Cannot read .gosymtab section")
+	if exeSection != nil {
+		symbolTableData, err = exeSection.Data()
 	}
-	symbolTableData, err := exeSection.Data()
 
 	exeSection = exe.Section(".text")
 	if exeSection == nil {
-		log.Fatal("Cannot read .text section")
+		fatal("Cannot read .text section")
 	}
 	textSectionAddress := exeSection.Addr
 
 	lineTable := gosym.NewLineTable(lineTableData, textSectionAddress)
 	symbolTable, err := gosym.NewTable(symbolTableData, lineTable)
 	if err != nil {
-		log.Fatalf("Cannot create symbol table: %v", err)
+		fatalf("Cannot create symbol table: %v", err)
 	}
+	listingSymbols = symbolTable
 
 	return symbolTable
 }
 
 func showListing(filename string, lineNumber int) {
-	fileBytes, err := ioutil.ReadFile(filename)
+	fileBytes, err := readSource(filename)
 	if err != nil {
-		log.Fatal(err)
+		// Production binaries are often debugged without their sources.
+		showDisassemblyListing(filename, lineNumber)
+		return
 	}
 	fstring := string(fileBytes)
 	lines := strings.Split(fstring, "\n")
@@ -350,62 +1852,128 @@ func showListing(filename string, lineNumber int) {
 		end = len(lines) - 1
 	}
 
+	stale := isStaleSource(filename)
 	fmt.Println()
+	if stale {
+		fmt.Printf("Warning: %v is newer than the binary; lines marked ~ may have shifted.\n", filename)
+	}
 	for i := start; i < end; i++ {
-
-		isBreakpoint := false
-		for j := 0; j < len(breakpoints[filename]); j++ {
-			if breakpoints[filename][j] == i + 1 {
-				isBreakpoint = true
-			}
-		}
-
-		if (i + 1) == pcSourceLine && filename == pcSourceFile {
+		note := ""
+		if (i+1) == pcSourceLine && filename == pcSourceFile {
 			fmt.Print("> ")
-		} else if isBreakpoint {
+			note = inlineValues(lines[i])
+		} else if isBreakpointLine(filename, i+1) {
 			fmt.Print("* ")
 		} else {
 			fmt.Print("  ")
 		}
-		fmt.Printf("%v %v\n", i+1, lines[i])
+		if stale {
+			fmt.Printf("~%v %v%v\n", i+1, lines[i], note)
+		} else {
+			fmt.Printf("%v %v%v\n", i+1, lines[i], note)
+		}
 	}
 	fmt.Println()
 }
 
+// isStaleSource reports whether a source file was modified after the binary
+// was built.
+func isStaleSource(filename string) bool {
+	if stale, ok := staleSources[filename]; ok {
+		return stale
+	}
+	stale := false
+	if info, err := os.Stat(filename); err == nil && !binaryModTime.IsZero() {
+		stale = info.ModTime().After(binaryModTime)
+	}
+	staleSources[filename] = stale
+	return stale
+}
+
 func runToSourceLine(pid int, filename string, lineNumber int, symbolTable *gosym.Table) *syscall.WaitStatus {
 	pc, _, err := symbolTable.LineToPC(filename, lineNumber)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	original := setBreakpoint(pid, uintptr(pc))
-	status := cont(pid)
-	clearBreakpoint(pid, uintptr(pc), original)
-	setPC(pid, uint64(pc))
-	pcSourceLine = lineNumber
-	pcSourceFile = filename
-
-	return status
+	return runToPC(pid, pc, symbolTable)
 }
 
-func parseBreakpointCommand(command string, filename string) (string, int, error) {
-	parts := strings.Split(command, " ")
-	command = parts[len(parts)-1]
-
-	var num string
-
-	if strings.Contains(command, ":") {
-		parts = strings.Split(parts[len(parts)-1], ":")
-		filename = parts[0]
-		num = parts[1]
+// runToMain runs a tracee that has just started to the start of main.main.
+// A breakpoint or catch on the way stops it there instead, including one on
+// the entry point it is at before running anything.
+func runToMain(pid int, symbolTable *gosym.Table) {
+	pc := getPC(pid)
+	if bp := findBreakpoint(pc); bp != nil && !bp.disabled {
+		bp.hits++
+		pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(pc)
+		fmt.Printf("Stopped at breakpoint %v before the program ran, at 0x%x.\n", bp.id, pc)
+		return
+	}
+	symbol := symbolTable.LookupFunc("main.main")
+	filename, lineno, _ := symbolTable.PCToLine(symbol.Entry)
+	status := runToSourceLine(pid, filename, lineno, symbolTable)
+	if status == nil || !status.Stopped() {
+		return
+	}
+	pc = getPC(currentThread)
+	if runtimeInitAddress != 0 && pc == runtimeInitAddress {
+		showRuntimeInitStop(currentThread, symbolTable)
 	} else {
-		num = command
+		pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(pc)
 	}
+}
 
-	lineNumber, err := strconv.Atoi(num)
-	if err != nil {
-		return "", -1, err
+// runToThread and runToAddress are the thread runToPC is running and where
+// to, kept while the tracee goes on in the background.  waitForStop stops
+// there whatever the condition of a user breakpoint at the same address,
+// unless finishCall set runToStackPointer and the thread is deeper in the
+// stack than that.
+var (
+	runToThread       int
+	runToAddress      uint64
+	runToStackPointer uint64
+)
+
+// runToPC continues the tracee until it reaches pc, using a temporary
+// breakpoint.
+func runToPC(pid int, pc uint64, symbolTable *gosym.Table) *syscall.WaitStatus {
+	// An existing breakpoint at the same address doubles as the temporary one.
+	existing := isInserted(pc)
+	if !existing {
+		mustSetBreakpoint(pid, pc)
+	}
+	runToThread, runToAddress = pid, pc
+	status := cont(pid, symbolTable)
+	if status == nil {
+		// Still running after stepDeadline; the breakpoint stays so the
+		// tracee stops when it gets there.
+		running = true
+		if !existing {
+			backgroundBreakpoint = pc
+		}
+		return nil
 	}
+	runToThread, runToAddress, runToStackPointer = 0, 0, 0
+	if status.Exited() || status.Signaled() {
+		return status
+	}
+	if !existing {
+		clearBreakpoint(pid, pc)
+	}
+	if status.StopSignal() != syscall.SIGTRAP || currentThread != pid || getPC(pid) != pc {
+		return status // Stopped somewhere else, e.g. on a crash.
+	}
+	pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(pc)
 
-	return filename, lineNumber, nil
+	return status
+}
+
+// reportExit tells the user when the tracee is gone and returns true if so.
+func reportExit(status *syscall.WaitStatus) bool {
+	if !status.Exited() && !status.Signaled() {
+		return false
+	}
+	countStop()
+	return true
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDir holds the debugger and the programs of testdata, built once by
+// TestMain.
+var testDir string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "debugger-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	testDir = dir
+	code := 1
+	if err := buildTestBinary("debugger", "."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		code = m.Run()
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// buildTestBinary builds the package in dir into testDir under name, with
+// the -N -l a program is debugged with.
+func buildTestBinary(name, dir string) error {
+	cmd := exec.Command("go", "build", "-gcflags=all=-N -l", "-o", filepath.Join(testDir, name), dir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go build %v: %v\n%s", dir, err, output)
+	}
+	return nil
+}
+
+// testProgram builds the program of testdata/<name> and returns its path.
+func testProgram(t *testing.T, name string) string {
+	path := filepath.Join(testDir, name)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	if err := buildTestBinary(name, "./testdata/"+name); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runDebugger debugs program with the commands given on stdin, as when they
+// are piped in, and returns everything the session printed.
+func runDebugger(t *testing.T, program string, commands ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, filepath.Join(testDir, "debugger"), program)
+	cmd.Dir = testDir
+	cmd.Env = append(os.Environ(), "HOME="+testDir)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%v: %v\n%s", strings.Join(commands, "; "), err, output.String())
+	}
+	return output.String()
+}
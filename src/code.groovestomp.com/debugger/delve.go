@@ -0,0 +1,586 @@
+package main
+
+import (
+	"debug/gosym"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// delveAPIVersion is the version of Delve's JSON-RPC API the shim speaks.
+const delveAPIVersion = 2
+
+// delveRequest is a JSON-RPC 1.0 call, as Delve's clients send them:
+// {"method": "RPCServer.State", "params": [{...}], "id": 1}.
+type delveRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     json.RawMessage   `json:"id"`
+}
+
+type delveResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+// The types below are the parts of Delve's service/api types the shim
+// fills in, with the JSON names Delve gives them.
+
+type delveFunction struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+type delveLocation struct {
+	PC       uint64         `json:"pc"`
+	File     string         `json:"file"`
+	Line     int            `json:"line"`
+	Function *delveFunction `json:"function,omitempty"`
+}
+
+type delveBreakpoint struct {
+	ID            int      `json:"id"`
+	Name          string   `json:"name"`
+	Addr          uint64   `json:"addr"`
+	Addrs         []uint64 `json:"addrs"`
+	File          string   `json:"file"`
+	Line          int      `json:"line"`
+	FunctionName  string   `json:"functionName,omitempty"`
+	Cond          string   `json:"Cond"`
+	TotalHitCount int      `json:"totalHitCount"`
+	Disabled      bool     `json:"disabled"`
+}
+
+type delveThread struct {
+	ID          int              `json:"id"`
+	PC          uint64           `json:"pc"`
+	File        string           `json:"file"`
+	Line        int              `json:"line"`
+	Function    *delveFunction   `json:"function,omitempty"`
+	GoroutineID uint64           `json:"goroutineID"`
+	Breakpoint  *delveBreakpoint `json:"breakPoint,omitempty"`
+}
+
+type delveGoroutine struct {
+	ID             uint64        `json:"id"`
+	CurrentLoc     delveLocation `json:"currentLoc"`
+	UserCurrentLoc delveLocation `json:"userCurrentLoc"`
+	GoStatementLoc delveLocation `json:"goStatementLoc"`
+	StartLoc       delveLocation `json:"startLoc"`
+	ThreadID       int           `json:"threadID"`
+	Status         uint64        `json:"status"`
+}
+
+type delveVariable struct {
+	Name       string          `json:"name"`
+	Addr       uint64          `json:"addr"`
+	Type       string          `json:"type"`
+	RealType   string          `json:"realType"`
+	Value      string          `json:"value"`
+	Children   []delveVariable `json:"children"`
+	Unreadable string          `json:"unreadable"`
+}
+
+type delveStackframe struct {
+	delveLocation
+	Locals    []delveVariable `json:"Locals"`
+	Arguments []delveVariable `json:"Arguments"`
+	Bottom    bool            `json:"Bottom"`
+	Err       string          `json:"Err"`
+}
+
+type delveState struct {
+	Running           bool            `json:"Running"`
+	Threads           []*delveThread  `json:"Threads"`
+	CurrentThread     *delveThread    `json:"currentThread,omitempty"`
+	SelectedGoroutine *delveGoroutine `json:"currentGoroutine,omitempty"`
+	Exited            bool            `json:"exited"`
+	ExitStatus        int             `json:"exitStatus"`
+}
+
+// delveServer answers the most-used part of Delve's JSON-RPC API, so that
+// editor plugins written for a headless dlv can drive the debugger.  As
+// with the DAP server, requests are served one at a time on the main
+// goroutine, which is the ptrace thread; the responses to continue and
+// halt are held until the program stops.
+type delveServer struct {
+	out         *json.Encoder
+	symbolTable *gosym.Table
+	waiting     []*delveRequest
+
+	exited     bool
+	exitStatus int
+}
+
+// serveDelve listens at address for one client of Delve's API and serves
+// it until it detaches or goes, with the program started and stopped at
+// main.main.
+func serveDelve(address string, symbolTable *gosym.Table) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "API server listening at: %v\n", listener.Addr())
+	conn, err := listener.Accept()
+	if err != nil {
+		fatal(err)
+	}
+	listener.Close()
+	defer conn.Close()
+
+	s := &delveServer{out: json.NewEncoder(conn), symbolTable: symbolTable}
+	defer subscribe(s.publishEvent)()
+	requests := make(chan *delveRequest)
+	go readDelveRequests(conn, requests)
+	for {
+		var request *delveRequest
+		if running {
+			select {
+			case request = <-requests:
+			case <-time.After(dapPollInterval):
+				if status := waitForBackground(currentThread, symbolTable, time.Now()); status != nil {
+					publishStop(status, symbolTable)
+				}
+				continue
+			}
+		} else {
+			request = <-requests
+		}
+		if request == nil {
+			s.end(false)
+			return
+		}
+		if !s.handle(request) {
+			return
+		}
+	}
+}
+
+// readDelveRequests decodes the client's calls, sending nil once it has
+// gone.
+func readDelveRequests(r io.Reader, requests chan<- *delveRequest) {
+	decoder := json.NewDecoder(r)
+	for {
+		var request delveRequest
+		if err := decoder.Decode(&request); err != nil {
+			break
+		}
+		requests <- &request
+	}
+	requests <- nil
+}
+
+func (s *delveServer) respond(request *delveRequest, result interface{}, err error) {
+	response := &delveResponse{ID: request.ID, Result: result}
+	if err != nil {
+		response.Result, response.Error = nil, err.Error()
+	}
+	if err := s.out.Encode(response); err != nil {
+		fmt.Fprintf(os.Stderr, "API server: %v\n", err)
+	}
+}
+
+// handle serves one call, returning false once the client has detached.
+func (s *delveServer) handle(request *delveRequest) bool {
+	var params map[string]json.RawMessage
+	if len(request.Params) > 0 {
+		if err := json.Unmarshal(request.Params[0], &params); err != nil {
+			s.respond(request, nil, err)
+			return true
+		}
+	}
+	decode := func(name string, v interface{}) {
+		if raw, ok := params[name]; ok {
+			json.Unmarshal(raw, v)
+		}
+	}
+
+	switch request.Method {
+	case "RPCServer.GetVersion":
+		s.respond(request, map[string]interface{}{"DelveVersion": "godebugger", "APIVersion": delveAPIVersion}, nil)
+		return true
+	case "RPCServer.SetApiVersion":
+		var version int
+		decode("APIVersion", &version)
+		if version != 0 && version != delveAPIVersion {
+			s.respond(request, nil, fmt.Errorf("only API version %v is supported", delveAPIVersion))
+		} else {
+			s.respond(request, struct{}{}, nil)
+		}
+		return true
+	case "RPCServer.State":
+		s.respond(request, map[string]interface{}{"State": s.state()}, nil)
+		return true
+	case "RPCServer.Detach":
+		var kill bool
+		decode("Kill", &kill)
+		s.end(kill)
+		s.respond(request, struct{}{}, nil)
+		return false
+	case "RPCServer.Command":
+		var name string
+		decode("name", &name)
+		if name == "halt" {
+			if running {
+				kill(processID, syscall.SIGINT)
+				s.waiting = append(s.waiting, request)
+			} else {
+				s.respond(request, map[string]interface{}{"State": s.state()}, nil)
+			}
+			return true
+		}
+	}
+
+	if s.exited {
+		s.respond(request, nil, errors.New("the program has exited"))
+		return true
+	}
+	if running {
+		s.respond(request, nil, errors.New("the program is running"))
+		return true
+	}
+	switch request.Method {
+	case "RPCServer.ListGoroutines":
+		var start, count int
+		decode("Start", &start)
+		decode("Count", &count)
+		list, next, err := s.goroutines(start, count)
+		s.respond(request, map[string]interface{}{"Goroutines": list, "Nextg": next}, err)
+	case "RPCServer.Stacktrace":
+		var id int64
+		var depth int
+		var full bool
+		decode("Id", &id)
+		decode("Depth", &depth)
+		decode("Full", &full)
+		frames, err := s.stacktrace(id, depth, full)
+		s.respond(request, map[string]interface{}{"Locations": frames}, err)
+	case "RPCServer.CreateBreakpoint":
+		var wanted delveBreakpoint
+		decode("Breakpoint", &wanted)
+		bp, err := s.createBreakpoint(wanted)
+		s.respond(request, map[string]interface{}{"Breakpoint": bp}, err)
+	case "RPCServer.ListBreakpoints":
+		list := []*delveBreakpoint{}
+		for _, bp := range breakpoints {
+			list = append(list, delveBreakpointOf(bp, s.symbolTable))
+		}
+		s.respond(request, map[string]interface{}{"Breakpoints": list}, nil)
+	case "RPCServer.ClearBreakpoint":
+		var id int
+		decode("Id", &id)
+		bp, err := findBreakpointByID(strconv.Itoa(id))
+		if err != nil {
+			s.respond(request, nil, err)
+			break
+		}
+		cleared := delveBreakpointOf(bp, s.symbolTable)
+		deleteBreakpoint(currentThread, bp)
+		s.respond(request, map[string]interface{}{"Breakpoint": cleared}, nil)
+	case "RPCServer.Command":
+		var name string
+		var threadID int
+		var goroutineID uint64
+		decode("name", &name)
+		decode("threadID", &threadID)
+		decode("goroutineID", &goroutineID)
+		if err := s.command(request, name, threadID, goroutineID); err != nil {
+			s.respond(request, nil, err)
+		}
+	default:
+		s.respond(request, nil, fmt.Errorf("unsupported method %v", request.Method))
+	}
+	return true
+}
+
+// command runs a DebuggerCommand.  The response is sent once the program
+// stops again, straight away unless it is left running.
+func (s *delveServer) command(request *delveRequest, name string, threadID int, goroutineID uint64) error {
+	pid := currentThread
+	var status *syscall.WaitStatus
+	switch name {
+	case "continue":
+		status = resume(pid, threadsLocked())
+	case "next":
+		status = next(pid, s.symbolTable)
+	case "step":
+		status = stepLine(pid, s.symbolTable)
+	case "stepOut":
+		frames := unwind(pid, s.symbolTable, currentRegisters(pid), 2)
+		if len(frames) < 2 {
+			return errors.New("no caller to return to")
+		}
+		status = finishCall(pid, frames[1].pc, frames[0].cfa, s.symbolTable)
+	case "switchThread":
+		if err := selectThread(strconv.Itoa(threadID)); err != nil {
+			return err
+		}
+		s.respond(request, map[string]interface{}{"State": s.state()}, nil)
+		return nil
+	case "switchGoroutine":
+		g, err := findGoroutine(pid, goroutineID)
+		if err != nil {
+			return err
+		}
+		if err := selectGoroutine(pid, g); err != nil {
+			return err
+		}
+		s.respond(request, map[string]interface{}{"State": s.state()}, nil)
+		return nil
+	default:
+		return fmt.Errorf("unsupported command %v", name)
+	}
+	s.waiting = append(s.waiting, request)
+	if status == nil {
+		running = true // Poll in serveDelve for the stop.
+		return nil
+	}
+	publishStop(status, s.symbolTable)
+	return nil
+}
+
+// publishEvent answers the calls waiting for the program to stop once it
+// has, noting its exit for the state calls report.
+func (s *delveServer) publishEvent(e engineEvent) {
+	switch e := e.(type) {
+	case targetExitedEvent:
+		s.exited = true
+		s.exitStatus = e.status
+		s.stopped()
+	case stopEvent:
+		s.stopped()
+	}
+}
+
+// stopped answers the calls waiting for the program to stop.
+func (s *delveServer) stopped() {
+	state := map[string]interface{}{"State": s.state()}
+	for _, request := range s.waiting {
+		s.respond(request, state, nil)
+	}
+	s.waiting = nil
+}
+
+func (s *delveServer) state() *delveState {
+	state := &delveState{Running: running, Exited: s.exited, ExitStatus: s.exitStatus, Threads: []*delveThread{}}
+	if running || s.exited {
+		return state
+	}
+	for _, t := range sortedThreads() {
+		if !t.stopped {
+			continue
+		}
+		thread := s.thread(t.tid)
+		state.Threads = append(state.Threads, thread)
+		if t.tid == currentThread {
+			state.CurrentThread = thread
+		}
+	}
+	address := currentGoroutine(currentThread)
+	if selection.goroutine != 0 {
+		address = selection.goroutine
+	}
+	if list, err := readGoroutines(currentThread); err == nil {
+		for _, g := range list {
+			if g.address == address {
+				state.SelectedGoroutine = s.goroutine(g)
+			}
+		}
+	}
+	return state
+}
+
+func (s *delveServer) thread(tid int) *delveThread {
+	pc := getPC(tid)
+	location := s.location(pc)
+	thread := &delveThread{ID: tid, PC: pc, File: location.File, Line: location.Line, Function: location.Function}
+	if g, err := readRuntimeStruct(tid, "runtime.g", currentGoroutine(tid)); err == nil {
+		thread.GoroutineID = scalarMember(g, "goid")
+	}
+	if bp := findBreakpoint(pc); bp != nil {
+		thread.Breakpoint = delveBreakpointOf(bp, s.symbolTable)
+	}
+	return thread
+}
+
+func (s *delveServer) location(pc uint64) delveLocation {
+	file, line, fn := s.symbolTable.PCToLine(pc)
+	location := delveLocation{PC: pc, File: file, Line: line}
+	if fn != nil {
+		location.Function = &delveFunction{Name: fn.Name, Value: fn.Entry}
+	}
+	return location
+}
+
+func (s *delveServer) frameLocation(frame stackFrame) delveLocation {
+	location := delveLocation{PC: frame.pc, File: frame.file, Line: frame.line}
+	if frame.fn != nil {
+		location.Function = &delveFunction{Name: frame.fn.Name, Value: frame.fn.Entry}
+	}
+	return location
+}
+
+func (s *delveServer) goroutine(g *goroutine) *delveGoroutine {
+	result := &delveGoroutine{ID: g.id, ThreadID: goroutineThread(g), Status: g.status &^ goroutineScan}
+	frames := goroutineFrames(currentThread, g, s.symbolTable)
+	if len(frames) > 0 {
+		result.CurrentLoc = s.frameLocation(frames[0])
+		result.UserCurrentLoc = s.frameLocation(frames[userFrame(frames)])
+		result.StartLoc = s.frameLocation(frames[len(frames)-1])
+	} else {
+		result.CurrentLoc = s.location(g.pc)
+		result.UserCurrentLoc = result.CurrentLoc
+	}
+	return result
+}
+
+// goroutines returns count goroutines from start, all of them for a count
+// of zero, and where the next page starts, or -1 after the last.
+func (s *delveServer) goroutines(start, count int) ([]*delveGoroutine, int, error) {
+	list, err := readGoroutines(currentThread)
+	if err != nil {
+		return nil, -1, err
+	}
+	if start > len(list) {
+		start = len(list)
+	}
+	list = list[start:]
+	next := -1
+	if count > 0 && count < len(list) {
+		list = list[:count]
+		next = start + count
+	}
+	result := []*delveGoroutine{}
+	for _, g := range list {
+		result = append(result, s.goroutine(g))
+	}
+	return result, next, nil
+}
+
+// stacktrace unwinds a goroutine, the selected one for an id of -1, to
+// depth frames, with their variables when full is set.
+func (s *delveServer) stacktrace(id int64, depth int, full bool) ([]delveStackframe, error) {
+	var frames []stackFrame
+	tid := currentThread
+	if id < 0 {
+		frames = backtrace(tid, s.symbolTable)
+	} else {
+		g, err := findGoroutine(tid, uint64(id))
+		if err != nil {
+			return nil, err
+		}
+		frames = goroutineFrames(tid, g, s.symbolTable)
+		if onThread := goroutineThread(g); onThread != 0 {
+			tid = onThread
+		} else {
+			tid = 0 // The innermost frame has no live registers.
+		}
+	}
+	if depth > 0 && depth+1 < len(frames) {
+		frames = frames[:depth+1]
+	}
+
+	beginEvaluation()
+	defer endEvaluation()
+	result := []delveStackframe{}
+	for i, frame := range frames {
+		f := delveStackframe{delveLocation: s.frameLocation(frame), Bottom: i == len(frames)-1}
+		if full {
+			var regs *syscall.PtraceRegs
+			if i == 0 && tid != 0 {
+				regs = currentRegisters(tid)
+			}
+			variables, err := frameVariables(currentThread, frame, regs)
+			if err != nil {
+				f.Err = err.Error()
+			}
+			f.Locals, f.Arguments = []delveVariable{}, []delveVariable{}
+			for _, v := range variables {
+				dv := delveVariable{Name: v.name, Children: []delveVariable{}}
+				if v.err != nil {
+					dv.Unreadable = v.err.Error()
+				} else {
+					dv.Addr = v.val.addr
+					dv.Type = typeName(v.val.typ)
+					dv.RealType = dv.Type
+					dv.Value = formatValue(currentThread, v.val)
+				}
+				if v.parameter {
+					f.Arguments = append(f.Arguments, dv)
+				} else {
+					f.Locals = append(f.Locals, dv)
+				}
+			}
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// createBreakpoint sets a breakpoint at a function, or a file and line, as
+// Delve's CreateBreakpoint does.
+func (s *delveServer) createBreakpoint(wanted delveBreakpoint) (*delveBreakpoint, error) {
+	location := wanted.FunctionName
+	if location == "" {
+		if wanted.File == "" || wanted.Line == 0 {
+			return nil, errors.New("a breakpoint needs a functionName, or a file and line")
+		}
+		location = fmt.Sprintf("%v:%v", wanted.File, wanted.Line)
+	}
+	if wanted.Cond != "" {
+		location += " if " + wanted.Cond
+	}
+	bp, err := createBreakpoint(currentThread, location, s.symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	// A client setting breakpoints again from a session it saved gives their
+	// hit counts and whether they were disabled.
+	bp.hits = wanted.TotalHitCount
+	if wanted.Disabled {
+		disableBreakpoint(currentThread, bp)
+	}
+	result := delveBreakpointOf(bp, s.symbolTable)
+	result.Name = wanted.Name
+	return result, nil
+}
+
+func delveBreakpointOf(bp *breakpoint, symbolTable *gosym.Table) *delveBreakpoint {
+	result := &delveBreakpoint{
+		ID:            bp.id,
+		Addr:          bp.pc,
+		Addrs:         []uint64{bp.pc},
+		File:          bp.file,
+		Line:          bp.line,
+		Cond:          bp.condition,
+		TotalHitCount: bp.hits,
+		Disabled:      bp.disabled,
+	}
+	if fn := symbolTable.PCToFunc(bp.pc); fn != nil {
+		result.FunctionName = fn.Name
+	}
+	return result
+}
+
+// end finishes the session: the program is killed if asked, and otherwise
+// left running.
+func (s *delveServer) end(kill bool) {
+	if s.exited {
+		return
+	}
+	if kill {
+		killTracee()
+		return
+	}
+	if running {
+		interruptBackground(currentThread, s.symbolTable)
+	}
+	detachThreads()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDiffBreakpoints is how many breakpoints break -at-diff sets at most,
+// so that a large change doesn't stop the program at every turn.
+const maxDiffBreakpoints = 200
+
+// diffBreakpointGroup is the -group of the breakpoints break -at-diff sets.
+const diffBreakpointGroup = "diff"
+
+// hunkHeader is the header of a hunk of git diff -U0 output, with where its
+// lines start in the new file and how many there are if not one.
+var hunkHeader = regexp.MustCompile(`^@@ -[0-9,]+ \+([0-9]+)(?:,([0-9]+))? @@`)
+
+// createDiffBreakpoints implements "break -at-diff <git-ref>": it sets a
+// breakpoint on every line with code that git diff finds added or changed
+// since the commit, in the repository of the program's main package.
+func createDiffBreakpoints(pid int, ref string, symbolTable *gosym.Table) ([]*breakpoint, error) {
+	if ref == "" || strings.Contains(ref, " ") {
+		return nil, fmt.Errorf("usage: break -at-diff <git-ref>")
+	}
+	root, changed, err := changedLines(ref, projectDirectory(symbolTable))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(changed))
+	for file := range changed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var created []*breakpoint
+	lines, skipped := 0, 0
+	for _, name := range files {
+		file, ok := lineTableFile(root, name, symbolTable)
+		if !ok {
+			continue
+		}
+		for _, line := range changed[name] {
+			if _, _, err := symbolTable.LineToPC(file, line); err != nil {
+				// A comment, a declaration or a blank line.
+				continue
+			}
+			lines++
+			if len(created) == maxDiffBreakpoints {
+				skipped++
+				continue
+			}
+			bp, err := createBreakpoint(pid, fmt.Sprintf("%v:%v -group %v", file, line, diffBreakpointGroup), symbolTable)
+			if err != nil {
+				// Several changed lines can share the instruction of one.
+				continue
+			}
+			created = append(created, bp)
+		}
+	}
+	if lines == 0 {
+		return nil, fmt.Errorf("no code in the binary was added or changed since %v", ref)
+	}
+	if skipped > 0 {
+		return created, fmt.Errorf("%v more changed lines left without breakpoints; the most set at once is %v", skipped, maxDiffBreakpoints)
+	}
+	return created, nil
+}
+
+// projectDirectory is where the program's main package is, for git to find
+// its repository from, or the current directory if that isn't here.
+func projectDirectory(symbolTable *gosym.Table) string {
+	if fn := symbolTable.LookupFunc("main.main"); fn != nil {
+		if file, _, _ := symbolTable.PCToLine(fn.Entry); file != "" {
+			if info, err := os.Stat(filepath.Dir(file)); err == nil && info.IsDir() {
+				return filepath.Dir(file)
+			}
+		}
+	}
+	return "."
+}
+
+// changedLines runs git diff against ref in the repository dir is in,
+// returning the root of the repository and the lines added or changed in
+// each Go file, named by its path in the repository.
+func changedLines(ref, dir string) (string, map[string][]int, error) {
+	top, err := commandOutput("git", "-C", dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", nil, fmt.Errorf("%v is not in a git repository: %v", dir, err)
+	}
+	root := strings.TrimSpace(string(top))
+	out, err := commandOutput("git", "-C", root, "diff", "--no-color", "--no-ext-diff", "-U0", ref, "--", "*.go")
+	if err != nil {
+		return "", nil, fmt.Errorf("git diff %v: %v", ref, err)
+	}
+
+	changed := make(map[string][]int)
+	file := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			// /dev/null for a deleted file.
+			file = ""
+			if name := strings.TrimPrefix(text, "+++ "); strings.HasPrefix(name, "b/") {
+				file = strings.TrimPrefix(name, "b/")
+			}
+		case file != "":
+			m := hunkHeader.FindStringSubmatch(text)
+			if m == nil {
+				continue
+			}
+			start, _ := strconv.Atoi(m[1])
+			count := 1
+			if m[2] != "" {
+				count, _ = strconv.Atoi(m[2])
+			}
+			// A hunk that only removes lines has no new ones to stop at.
+			for line := start; line < start+count; line++ {
+				changed[file] = append(changed[file], line)
+			}
+		}
+	}
+	return root, changed, scanner.Err()
+}
+
+// lineTableFile finds the file of the line table a changed file is: at
+// its path in the repository at root, or, for a binary built elsewhere,
+// the one file ending in its path in the repository.
+func lineTableFile(root, name string, symbolTable *gosym.Table) (string, bool) {
+	if path := filepath.Join(root, name); symbolTable.Files[path] != nil {
+		return path, true
+	}
+	match := ""
+	for file := range symbolTable.Files {
+		if strings.HasSuffix(filepath.ToSlash(file), "/"+name) {
+			if match != "" {
+				return "", false
+			}
+			match = file
+		}
+	}
+	return match, match != ""
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// nativeSymbols are the ELF function symbols sorted by address, which name
+// C code linked in through cgo where the Go symbol table has nothing.
+var nativeSymbols []elf.Symbol
+
+func loadNativeSymbols(exe *elf.File) {
+	nativeSymbols = nil
+	symbols, err := exe.Symbols()
+	if err != nil {
+		return
+	}
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) == elf.STT_FUNC && symbol.Value != 0 {
+			nativeSymbols = append(nativeSymbols, symbol)
+		}
+	}
+	sort.Slice(nativeSymbols, func(i, j int) bool { return nativeSymbols[i].Value < nativeSymbols[j].Value })
+}
+
+// nativeSymbol names the ELF function containing pc as name+offset.
+func nativeSymbol(pc uint64) (string, bool) {
+	i := sort.Search(len(nativeSymbols), func(i int) bool { return nativeSymbols[i].Value > pc }) - 1
+	if i < 0 {
+		return "", false
+	}
+	symbol := nativeSymbols[i]
+	if symbol.Size != 0 && pc >= symbol.Value+symbol.Size {
+		return "", false
+	}
+	return fmt.Sprintf("%v+%v", symbol.Name, pc-symbol.Value), true
+}
+
+// disassembleAt decodes the instruction at pc in GNU syntax.
+func disassembleAt(pid int, pc uint64) (string, error) {
+	code, err := readText(pid, pc, 15)
+	if err != nil {
+		return "", err
+	}
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode instruction at 0x%x: %v", pc, err)
+	}
+	return x86asm.GNUSyntax(inst, pc, nil), nil
+}
+
+// Instructions shown before and after the address in a disassembly listing.
+const (
+	listingBefore = 4
+	listingAfter  = 6
+)
+
+// showDisassemblyListing stands in for a source listing when the file
+// can't be read.  It shows the instructions around the current thread's PC
+// when that is the line listed, or around the line's first instruction.
+func showDisassemblyListing(filename string, lineNumber int) {
+	fmt.Printf("\n%v:%v (source not available)\n", filename, lineNumber)
+	pid := currentThread
+	pc := getPC(pid)
+	if filename != pcSourceFile || lineNumber != pcSourceLine {
+		var err error
+		if pc, _, err = listingSymbols.LineToPC(filename, lineNumber); err != nil {
+			fmt.Printf("%v\n\n", err)
+			return
+		}
+	}
+	fn := listingSymbols.PCToFunc(pc)
+	if fn == nil {
+		fmt.Printf("No function at 0x%x.\n\n", pc)
+		return
+	}
+	code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+	if err != nil {
+		fmt.Printf("%v\n\n", err)
+		return
+	}
+
+	var addresses []uint64
+	var lines []string
+	current := -1
+	for offset := 0; offset < len(code); {
+		address := fn.Entry + uint64(offset)
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			break
+		}
+		if address <= pc {
+			current = len(addresses)
+		}
+		addresses = append(addresses, address)
+		lines = append(lines, x86asm.GNUSyntax(inst, address, nil))
+		offset += inst.Len
+	}
+	start, end := current-listingBefore, current+listingAfter+1
+	if start < 0 {
+		start = 0
+	}
+	if end > len(addresses) {
+		end = len(addresses)
+	}
+	for i := start; i < end; i++ {
+		marker := "  "
+		if addresses[i] == getPC(pid) {
+			marker = "=>"
+		} else if findBreakpoint(addresses[i]) != nil {
+			marker = "* "
+		}
+		fmt.Printf("%v 0x%x <%v+%v>:\t%v\n", marker, addresses[i], fn.Name, addresses[i]-fn.Entry, lines[i])
+	}
+	fmt.Println()
+}
+
+// runDisassembleCommand implements "disas [<function>|<expr>|-func <name>]
+// [-annotate]": it lists the whole function, the one the current PC is in
+// by default, with the source line each run of instructions comes from, or
+// with -annotate each line's instructions together.
+func runDisassembleCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	argument, name, err := splitOption(argument, "-func", "a function name")
+	if err != nil {
+		return err
+	}
+	annotate := false
+	var rest []string
+	for _, field := range strings.Fields(argument) {
+		if field == "-annotate" {
+			annotate = true
+		} else {
+			rest = append(rest, field)
+		}
+	}
+	argument = strings.Join(rest, " ")
+	if name != "" {
+		if argument != "" {
+			return fmt.Errorf("usage: disas [<function>|<expr>|-func <name>] [-annotate]")
+		}
+		if _, err := lookupFunction(name, symbolTable); err != nil {
+			return err
+		}
+		argument = name
+	}
+
+	pc := getPC(pid)
+	address := pc
+	if argument != "" {
+		if fn, err := lookupFunction(argument, symbolTable); err == nil {
+			address = fn.Entry
+		} else {
+			ctx, err := newEvalContext(pid, symbolTable)
+			if err != nil {
+				return err
+			}
+			v, err := evaluate(ctx, argument)
+			if err != nil {
+				return err
+			}
+			if address, err = valueAddress(v); err != nil {
+				return err
+			}
+		}
+	}
+	fn := symbolTable.PCToFunc(address)
+	if fn == nil {
+		return fmt.Errorf("no function contains address 0x%x", address)
+	}
+	code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+	if err != nil {
+		return err
+	}
+
+	// Name the targets of calls and jumps.
+	lookup := func(address uint64) (string, uint64) {
+		if target := symbolTable.PCToFunc(address); target != nil {
+			return target.Name, target.Entry
+		}
+		return "", 0
+	}
+	if annotate {
+		return showAnnotatedDisassembly(fn, code, pc, symbolTable, lookup)
+	}
+
+	fmt.Printf("Dump of assembler code for function %v:\n", fn.Name)
+	sources := make(map[string][]string)
+	lastFile, lastLine := "", 0
+	for offset := 0; offset < len(code); {
+		address := fn.Entry + uint64(offset)
+		file, line, _ := symbolTable.PCToLine(address)
+		if line < 0 {
+			break // The padding after the function.
+		}
+		if file != lastFile || line != lastLine {
+			fmt.Printf("%v:%v\t%v\n", file, line, sourceText(sources, file, line))
+			lastFile, lastLine = file, line
+		}
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			fmt.Printf("   0x%x <+%v>:\t(bad)\n", address, offset)
+			break
+		}
+		marker := "  "
+		if address == pc {
+			marker = "=>"
+		} else if findBreakpoint(address) != nil {
+			marker = "* "
+		}
+		fmt.Printf("%v 0x%x <+%v>:\t%v\n", marker, address, offset, x86asm.GNUSyntax(inst, address, lookup))
+		offset += inst.Len
+	}
+	fmt.Println("End of assembler dump.")
+	return nil
+}
+
+// decodedInstruction is an instruction of a function being disassembled
+// with -annotate.
+type decodedInstruction struct {
+	address    uint64
+	file       string
+	line       int
+	text       string
+	target     uint64 // Where a jump within the function goes, or 0.
+	contiguous bool   // Follows the one before it in its line's group.
+}
+
+// showAnnotatedDisassembly lists a function's instructions grouped by the
+// source line they come from, each line's text above its group, in line
+// order.  Instructions the function jumps to are labeled, and jumps name
+// the label they go to, so loops and branches can be followed.
+func showAnnotatedDisassembly(fn *gosym.Func, code []byte, pc uint64, symbolTable *gosym.Table, lookup func(uint64) (string, uint64)) error {
+	var instructions []*decodedInstruction
+	for offset := 0; offset < len(code); {
+		address := fn.Entry + uint64(offset)
+		file, line, _ := symbolTable.PCToLine(address)
+		if line < 0 {
+			break
+		}
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			return fmt.Errorf("cannot decode instruction at 0x%x: %v", address, err)
+		}
+		d := &decodedInstruction{address: address, file: file, line: line, text: x86asm.GNUSyntax(inst, address, lookup)}
+		if rel, ok := inst.Args[0].(x86asm.Rel); ok && inst.Op != x86asm.CALL {
+			target := uint64(int64(address) + int64(inst.Len) + int64(rel))
+			if target >= fn.Entry && target < fn.End {
+				d.target = target
+			}
+		}
+		instructions = append(instructions, d)
+		offset += inst.Len
+	}
+
+	var targets []uint64
+	for _, d := range instructions {
+		if d.target != 0 {
+			targets = append(targets, d.target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+	labels := make(map[uint64]string)
+	for _, target := range targets {
+		if _, ok := labels[target]; !ok {
+			labels[target] = fmt.Sprintf("L%v", len(labels)+1)
+		}
+	}
+
+	// Files are taken in the order the function first reaches them, so
+	// its own comes before any inlined into it.
+	fileOrder := make(map[string]int)
+	groups := make(map[string][]*decodedInstruction)
+	var keys []string
+	for i, d := range instructions {
+		if _, ok := fileOrder[d.file]; !ok {
+			fileOrder[d.file] = len(fileOrder)
+		}
+		key := fmt.Sprintf("%v:%v", d.file, d.line)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		group := groups[key]
+		d.contiguous = len(group) > 0 && group[len(group)-1] == instructions[i-1]
+		groups[key] = append(group, d)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := groups[keys[i]][0], groups[keys[j]][0]
+		if a.file != b.file {
+			return fileOrder[a.file] < fileOrder[b.file]
+		}
+		return a.line < b.line
+	})
+
+	fmt.Printf("Dump of assembler code for function %v by source line:\n", fn.Name)
+	sources := make(map[string][]string)
+	for _, key := range keys {
+		group := groups[key]
+		fmt.Printf("\n%v\t%v\n", key, sourceText(sources, group[0].file, group[0].line))
+		for i, d := range group {
+			if i > 0 && !d.contiguous {
+				fmt.Println("   ...")
+			}
+			marker := "  "
+			if d.address == pc {
+				marker = "=>"
+			} else if findBreakpoint(d.address) != nil {
+				marker = "* "
+			}
+			label := ""
+			if l, ok := labels[d.address]; ok {
+				label = l + ":"
+			}
+			jump := ""
+			if d.target != 0 {
+				jump = "\t-> " + labels[d.target]
+			}
+			fmt.Printf("%v %-5v 0x%x <+%v>:\t%v%v\n", marker, label, d.address, d.address-fn.Entry, d.text, jump)
+		}
+	}
+	fmt.Println("End of assembler dump.")
+	return nil
+}
+
+// sourceText returns line of file, caching files in sources, or nothing
+// when the file can't be read.
+func sourceText(sources map[string][]string, file string, line int) string {
+	lines, ok := sources[file]
+	if !ok {
+		if data, err := readSource(file); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		sources[file] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
+
+// isInstructionStart reports whether address begins an instruction of fn,
+// as decoded from its entry.
+func isInstructionStart(pid int, fn *gosym.Func, address uint64) bool {
+	code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+	if err != nil {
+		return false
+	}
+	for offset := 0; offset < len(code); {
+		if fn.Entry+uint64(offset) == address {
+			return true
+		}
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			return false
+		}
+		offset += inst.Len
+	}
+	return false
+}
+
+// readText reads instruction bytes from the tracee with any of our INT3s
+// replaced by the bytes they hide.
+func readText(pid int, address uint64, size int) ([]byte, error) {
+	data, err := readMemory(pid, address, size)
+	if err != nil {
+		return nil, err
+	}
+	for i := range data {
+		if tracee == nil {
+			break // A core file.
+		}
+		if original, ok := tracee.Breakpoint(address + uint64(i)); ok {
+			data[i] = original
+		}
+	}
+	return data, nil
+}
+
+// callReturns decodes a function's instructions and returns the address
+// each direct call to one of targets returns to.
+func callReturns(pid int, fn *gosym.Func, targets map[uint64]bool) ([]uint64, error) {
+	code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+	if err != nil {
+		return nil, err
+	}
+
+	var returns []uint64
+	for offset := 0; offset < len(code); {
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode instruction at 0x%x: %v", fn.Entry+uint64(offset), err)
+		}
+		next := fn.Entry + uint64(offset+inst.Len)
+		if rel, ok := inst.Args[0].(x86asm.Rel); ok && inst.Op == x86asm.CALL && targets[uint64(int64(next)+int64(rel))] {
+			returns = append(returns, next)
+		}
+		offset += inst.Len
+	}
+	return returns, nil
+}
+
+// functionReturns decodes a function's instructions and returns the address
+// of every RET in it.
+func functionReturns(pid int, fn *gosym.Func) ([]uint64, error) {
+	code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+	if err != nil {
+		return nil, err
+	}
+
+	var returns []uint64
+	for offset := 0; offset < len(code); {
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode instruction at 0x%x: %v", fn.Entry+uint64(offset), err)
+		}
+		if inst.Op == x86asm.RET {
+			returns = append(returns, fn.Entry+uint64(offset))
+		}
+		offset += inst.Len
+	}
+	return returns, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/binary"
+	"syscall"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+var (
+	// displacedStepping steps a thread off a breakpoint by running a copy of
+	// the instruction elsewhere, so that the INT3 stays in place for the
+	// threads that keep running.
+	displacedStepping = true
+
+	// displacedBuffer is the page in the tracee that displaced instructions
+	// are copied to, mapped the first time one is needed; 0 until then, and
+	// if it couldn't be.
+	displacedBuffer  uint64
+	displacedProcess int
+	displacedFailed  bool
+)
+
+// displacedStep single-steps the thread at the breakpoint at pc by running
+// the instruction the INT3 hides from displacedBuffer, and moves the thread
+// back to where it would have been had the instruction run in place.  It
+// reports false, having done nothing, for an instruction it can't move:
+// a system call, which would block in the copy or return to it, or one
+// that addresses memory relative to the PC too far from the buffer.
+func displacedStep(pid int, pc uint64) (*syscall.WaitStatus, bool) {
+	buffer := displacedPage(pid)
+	if buffer == 0 {
+		return nil, false
+	}
+	code, err := readText(pid, pc, 15)
+	if err != nil {
+		return nil, false
+	}
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil {
+		return nil, false
+	}
+	code = code[:inst.Len]
+
+	relative := false
+	for _, arg := range inst.Args {
+		switch a := arg.(type) {
+		case x86asm.Rel:
+			relative = true
+		case x86asm.Mem:
+			if a.Base != x86asm.RIP {
+				continue
+			}
+			// Keep the operand's address the same from the buffer.
+			displacement := int64(int32(binary.LittleEndian.Uint32(code[inst.PCRelOff:]))) + int64(pc) - int64(buffer)
+			if inst.PCRel != 4 || displacement != int64(int32(displacement)) {
+				return nil, false
+			}
+			binary.LittleEndian.PutUint32(code[inst.PCRelOff:], uint32(int32(displacement)))
+		}
+	}
+	switch inst.Op {
+	case x86asm.SYSCALL, x86asm.SYSENTER, x86asm.INT, x86asm.INTO:
+		return nil, false
+	}
+	if _, err := ptracePokeData(pid, uintptr(buffer), code); err != nil {
+		return nil, false
+	}
+
+	setPC(pid, buffer)
+	status := singleStep(pid)
+	if status.Exited() || status.Signaled() {
+		return status, true
+	}
+	end := buffer + uint64(len(code))
+	next := getPC(pid)
+	if relative || next >= buffer && next <= end {
+		// Where the instruction finished, or faulted, or a relative jump
+		// went, from the breakpoint instead.
+		setPC(pid, next-buffer+pc)
+	}
+	if inst.Op == x86asm.CALL {
+		var regs syscall.PtraceRegs
+		returnAddress := make([]byte, 8)
+		if ptraceGetRegs(pid, &regs) == nil {
+			if _, err := ptracePeekData(pid, uintptr(regs.Rsp), returnAddress); err == nil && binary.LittleEndian.Uint64(returnAddress) == end {
+				binary.LittleEndian.PutUint64(returnAddress, pc+uint64(len(code)))
+				ptracePokeData(pid, uintptr(regs.Rsp), returnAddress)
+			}
+		}
+	}
+	return status, true
+}
+
+// displacedPage returns displacedBuffer, mapping it in the tracee first if
+// it isn't yet: a private page that may be executed, near the program's
+// text so that PC-relative operands reach it.
+func displacedPage(pid int) uint64 {
+	if displacedProcess != processID {
+		displacedBuffer, displacedProcess, displacedFailed = 0, processID, false
+	}
+	if displacedBuffer != 0 || displacedFailed {
+		return displacedBuffer
+	}
+	hint := uint64(0)
+	if m, ok := findMapping(pid, entryPoint); ok && m.start > 16*pageSize {
+		hint = m.start - 16*pageSize
+	}
+	address, err := remoteSyscall(pid, syscall.SYS_MMAP, hint, pageSize,
+		syscall.PROT_READ|syscall.PROT_EXEC, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS, ^uint64(0), 0)
+	if err != nil {
+		displacedFailed = true
+		return 0
+	}
+	displacedBuffer = address
+	return address
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// errorMessageFields are the fields the standard library's error types keep
+// their message in.
+var errorMessageFields = []string{"msg", "s"}
+
+// wrappedErrorFields are the fields the standard library's error types keep
+// the error they wrap in, as errors.Unwrap would find it.
+var wrappedErrorFields = []string{"err", "Err"}
+
+// formatErrorChain renders an error and the errors it wraps, innermost
+// last, as "type message -> type message".  The program can't be made to
+// call Unwrap or Error, so the chain is followed through the fields the
+// standard library's wrappers use, and a layer's message is its message
+// field, or its other fields for types like *fs.PathError.
+func formatErrorChain(pid int, v *value, depth int) string {
+	var layers []string
+	for len(layers) <= maxValueDepth {
+		typeAddress, data, err := interfaceWords(pid, v)
+		if err != nil {
+			return fmt.Sprintf("error <%v>", err)
+		}
+		if typeAddress == 0 {
+			layers = append(layers, "nil")
+			break
+		}
+		name, dv, err := dynamicValue(pid, typeAddress, data)
+		if err != nil {
+			layers = append(layers, fmt.Sprintf("%v <%v>", name, err))
+			break
+		}
+		if strings.HasPrefix(name, "*") {
+			if target, err := dereference(pid, dv); err == nil {
+				dv = target
+			}
+		}
+		layer, next := describeError(pid, name, dv, depth)
+		layers = append(layers, layer)
+		if next == nil {
+			break
+		}
+		v = next
+	}
+	if len(layers) > maxValueDepth {
+		layers = append(layers, "...")
+	}
+	return "error " + strings.Join(layers, " -> ")
+}
+
+// describeError renders one layer of an error chain and returns the error
+// it wraps, if any.
+func describeError(pid int, name string, v *value, depth int) (string, *value) {
+	if typeName(v.typ) == "syscall.Errno" {
+		n := zeroExtend(v.data)
+		return fmt.Sprintf("%v %v %q", name, n, syscall.Errno(n).Error()), nil
+	}
+	if name == "context.deadlineExceededError" {
+		return name + ` "context deadline exceeded"`, nil
+	}
+	t, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+	if !ok {
+		return fmt.Sprintf("%v(%v)", name, formatValueDepth(pid, v, depth+1)), nil
+	}
+
+	var next *value
+	var message string
+	var others []string
+	for _, field := range t.Field {
+		fv := fieldValue(v, field)
+		if fv == nil {
+			continue
+		}
+		switch {
+		case contains(errorMessageFields, field.Name) && typeName(field.Type) == "string":
+			message = formatValue(pid, fv)
+		case contains(wrappedErrorFields, field.Name) && typeName(field.Type) == "error":
+			next = fv
+		case field.Name == "errs" && typeName(field.Type) == "[]error":
+			// errors.Join and fmt.Errorf with several %w wrap a list.
+			message = strings.TrimSpace(message + " " + formatJoinedErrors(pid, fv, depth))
+		default:
+			others = append(others, field.Name+": "+formatValueDepth(pid, fv, depth+1))
+		}
+	}
+	layer := name
+	if message != "" {
+		layer += " " + message
+	} else if len(others) > 0 {
+		layer += " {" + strings.Join(others, ", ") + "}"
+	}
+	return layer, next
+}
+
+// formatJoinedErrors renders a []error as the chains of its elements.
+func formatJoinedErrors(pid int, v *value, depth int) string {
+	if depth >= maxValueDepth {
+		return "[...]"
+	}
+	elements, err := sliceElements(pid, v, maxArrayValues)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	chains := make([]string, len(elements))
+	for i, element := range elements {
+		chains[i] = formatErrorChain(pid, element, depth+1)
+	}
+	return "[" + strings.Join(chains, "; ") + "]"
+}
+
+func contains(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
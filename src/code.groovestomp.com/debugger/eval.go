@@ -0,0 +1,1080 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+)
+
+// The expression evaluator understands a subset of Go expression syntax over
+// the tracee's variables, plus $-prefixed pseudo-variables for registers.
+
+type expr interface{}
+
+type (
+	numberExpr   struct{ text string }
+	stringExpr   struct{ value string }
+	identExpr    struct{ name string }
+	registerExpr struct{ name string }
+	unaryExpr    struct {
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		op   string
+		x, y expr
+	}
+	selectorExpr struct {
+		x     expr
+		field string
+	}
+	indexExpr struct {
+		x, index expr
+	}
+	callExpr struct {
+		fun  expr
+		args []expr
+	}
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenDollar
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// operators lists every operator token, longest first so that the lexer
+// prefers "<<" over "<".
+var operators = []string{
+	"&^", "<<", ">>", "&&", "||", "==", "!=", "<=", ">=",
+	"+", "-", "*", "/", "%", "&", "|", "^", "<", ">", "!",
+	"(", ")", "[", "]", ".", ",",
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(input) && (isIdentChar(rune(input[j])) || input[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, input[i:j]})
+			i = j
+		case c == '"' || c == '`':
+			j := i + 1
+			for j < len(input) && rune(input[j]) != c {
+				if input[j] == '\\' && c == '"' {
+					j++
+				}
+				j++
+			}
+			if j >= len(input) {
+				return nil, errors.New("unterminated string literal")
+			}
+			text, err := strconv.Unquote(input[i : j+1])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokenString, text})
+			i = j + 1
+		case c == '$':
+			j := i + 1
+			for j < len(input) && (isIdentChar(rune(input[j])) || input[j] == '$') {
+				j++
+			}
+			tokens = append(tokens, token{tokenDollar, input[i+1 : j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(input) && isIdentChar(rune(input[j])) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, input[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(input[i:], op) {
+					tokens = append(tokens, token{tokenOperator, op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type parser struct {
+	tokens   []token
+	position int
+}
+
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4, "|": 4, "^": 4,
+	"*": 5, "/": 5, "%": 5, "<<": 5, ">>": 5, "&": 5, "&^": 5,
+}
+
+func parseExpression(input string) (expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	return e, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.position]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.position]
+	if t.kind != tokenEOF {
+		p.position++
+	}
+	return t
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.kind != tokenOperator || t.text != op {
+		return fmt.Errorf("expected %q", op)
+	}
+	return nil
+}
+
+func (p *parser) parseBinary(minPrecedence int) (expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		precedence, ok := binaryPrecedence[t.text]
+		if t.kind != tokenOperator || !ok || precedence < minPrecedence {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	t := p.peek()
+	if t.kind == tokenOperator {
+		switch t.text {
+		case "-", "+", "^", "!", "*", "&":
+			p.next()
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryExpr{op: t.text, x: x}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (expr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator {
+			return x, nil
+		}
+		switch t.text {
+		case ".":
+			p.next()
+			field := p.next()
+			if field.kind != tokenIdent {
+				return nil, errors.New("expected field name after '.'")
+			}
+			x = &selectorExpr{x: x, field: field.text}
+		case "[":
+			p.next()
+			index, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{x: x, index: index}
+		case "(":
+			p.next()
+			call := &callExpr{fun: x}
+			for !(p.peek().kind == tokenOperator && p.peek().text == ")") {
+				arg, err := p.parseBinary(1)
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if p.peek().kind != tokenOperator || p.peek().text != "," {
+					break
+				}
+				p.next()
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			x = call
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return &numberExpr{text: t.text}, nil
+	case tokenString:
+		return &stringExpr{value: t.text}, nil
+	case tokenIdent:
+		return &identExpr{name: t.text}, nil
+	case tokenDollar:
+		return &registerExpr{name: t.text}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			x, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	case tokenEOF:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// evalContext is the frame an expression is evaluated in.  regs is nil for
+// frames other than the innermost one.
+type evalContext struct {
+	pid         int
+	frame       stackFrame
+	regs        *syscall.PtraceRegs
+	symbolTable *gosym.Table
+	variables   []variable
+	loaded      bool
+
+	// index is the frame's on the stack, and goroutine the runtime.g of a
+	// goroutine selected other than the thread's own, or zero.
+	index     int
+	goroutine uint64
+
+	// previous has the values $prev calls stand for, when evaluating a
+	// breakpoint's condition.
+	previous map[*callExpr]*value
+}
+
+// newEvalContext returns a context for the selected frame of the stopped
+// tracee, normally the innermost.  The registers of outer frames and of
+// descheduled goroutines are not known, so their contexts have none.
+func newEvalContext(pid int, symbolTable *gosym.Table) (*evalContext, error) {
+	regs, index, live, err := selectedStack(pid)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &evalContext{pid: pid, regs: regs, symbolTable: symbolTable, index: index, goroutine: selection.goroutine}
+	if frames := unwind(pid, symbolTable, regs, index+1); len(frames) > index {
+		ctx.frame = frames[index]
+		if index > 0 || !live {
+			ctx.regs = nil
+		}
+	}
+	beginEvaluation()
+	return ctx, nil
+}
+
+func evaluate(ctx *evalContext, input string) (*value, error) {
+	e, err := parseExpression(input)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.eval(e)
+}
+
+// Types given to values that don't come from the tracee's debug info.
+var (
+	intType     = &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "int"}}}
+	uint64Type  = &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "uint64"}}}
+	uint8Type   = &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "uint8"}}}
+	float64Type = &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "float64"}}}
+	boolType    = &dwarf.BoolType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "bool"}}}
+
+	// untypedStringType marks string literals, whose bytes are held in the
+	// value's data rather than in the tracee.
+	untypedStringType = &dwarf.UnspecifiedType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{Name: "untyped string"}}}
+)
+
+// basicTypes stands in for predeclared types the binary has no debug info
+// for, so that conversions like uint16($rax) always work.
+var basicTypes = map[string]dwarf.Type{
+	"int":     intType,
+	"int8":    &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "int8"}}},
+	"int16":   &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 2, Name: "int16"}}},
+	"int32":   &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "int32"}}},
+	"int64":   &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "int64"}}},
+	"uint":    &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "uint"}}},
+	"uint8":   uint8Type,
+	"byte":    uint8Type,
+	"uint16":  &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 2, Name: "uint16"}}},
+	"uint32":  &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "uint32"}}},
+	"uint64":  uint64Type,
+	"uintptr": &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "uintptr"}}},
+	"rune":    &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "int32"}}},
+	"float32": &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "float32"}}},
+	"float64": float64Type,
+	"bool":    boolType,
+}
+
+func newBool(b bool) *value {
+	if b {
+		return &value{typ: boolType, data: []byte{1}}
+	}
+	return &value{typ: boolType, data: []byte{0}}
+}
+
+func newInt(n int64) *value {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(n))
+	return &value{typ: intType, data: data}
+}
+
+func newUint(n uint64) *value {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, n)
+	return &value{typ: uint64Type, data: data}
+}
+
+func newFloat(f float64) *value {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, math.Float64bits(f))
+	return &value{typ: float64Type, data: data}
+}
+
+func newPointer(target dwarf.Type, address uint64) *value {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, address)
+	typ := &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "*" + typeName(target)}, Type: target}
+	return &value{typ: typ, data: data}
+}
+
+func (ctx *evalContext) eval(e expr) (*value, error) {
+	switch e := e.(type) {
+	case *numberExpr:
+		return parseNumber(e.text)
+	case *stringExpr:
+		return &value{typ: untypedStringType, data: []byte(e.value)}, nil
+	case *registerExpr:
+		return ctx.register(e.name)
+	case *identExpr:
+		return ctx.lookup(e.name)
+	case *unaryExpr:
+		return ctx.evalUnary(e)
+	case *binaryExpr:
+		return ctx.evalBinary(e)
+	case *selectorExpr:
+		return ctx.evalSelector(e)
+	case *indexExpr:
+		return ctx.evalIndex(e)
+	case *callExpr:
+		if isPrevCall(e) {
+			return ctx.evalPrev(e)
+		}
+		if isMethodCall(e) {
+			return ctx.evalMethodCall(e)
+		}
+		return ctx.evalConversion(e)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+func parseNumber(text string) (*value, error) {
+	if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return newInt(n), nil
+	}
+	if n, err := strconv.ParseUint(text, 0, 64); err == nil {
+		return newUint(n), nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return newFloat(f), nil
+	}
+	return nil, fmt.Errorf("invalid number %q", text)
+}
+
+// registerNames maps register pseudo-variable names, including the $pc, $sp
+// and $fp aliases, to accessors on the saved register set.
+var registerNames = map[string]func(*syscall.PtraceRegs) *uint64{
+	"rax": func(r *syscall.PtraceRegs) *uint64 { return &r.Rax },
+	"rbx": func(r *syscall.PtraceRegs) *uint64 { return &r.Rbx },
+	"rcx": func(r *syscall.PtraceRegs) *uint64 { return &r.Rcx },
+	"rdx": func(r *syscall.PtraceRegs) *uint64 { return &r.Rdx },
+	"rsi": func(r *syscall.PtraceRegs) *uint64 { return &r.Rsi },
+	"rdi": func(r *syscall.PtraceRegs) *uint64 { return &r.Rdi },
+	"rbp": func(r *syscall.PtraceRegs) *uint64 { return &r.Rbp },
+	"rsp": func(r *syscall.PtraceRegs) *uint64 { return &r.Rsp },
+	"r8":  func(r *syscall.PtraceRegs) *uint64 { return &r.R8 },
+	"r9":  func(r *syscall.PtraceRegs) *uint64 { return &r.R9 },
+	"r10": func(r *syscall.PtraceRegs) *uint64 { return &r.R10 },
+	"r11": func(r *syscall.PtraceRegs) *uint64 { return &r.R11 },
+	"r12": func(r *syscall.PtraceRegs) *uint64 { return &r.R12 },
+	"r13": func(r *syscall.PtraceRegs) *uint64 { return &r.R13 },
+	"r14": func(r *syscall.PtraceRegs) *uint64 { return &r.R14 },
+	"r15": func(r *syscall.PtraceRegs) *uint64 { return &r.R15 },
+	"rip": func(r *syscall.PtraceRegs) *uint64 { return &r.Rip },
+	"pc":  func(r *syscall.PtraceRegs) *uint64 { return &r.Rip },
+	"sp":  func(r *syscall.PtraceRegs) *uint64 { return &r.Rsp },
+	"fp":  func(r *syscall.PtraceRegs) *uint64 { return &r.Rbp },
+}
+
+// convenienceVariables holds the $-variables created with set, along with
+// $_ and $__, which print binds to the last value and its address.
+var convenienceVariables = make(map[string]*value)
+
+// valueHistory holds every value printed, so that $1, $2, ... can refer back
+// to them.
+var valueHistory []*value
+
+func (ctx *evalContext) register(name string) (*value, error) {
+	accessor, ok := registerNames[name]
+	if !ok {
+		if v, ok := convenienceVariables[name]; ok {
+			return v, nil
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			if n < 1 || n > len(valueHistory) {
+				return nil, fmt.Errorf("history has no value $%v", n)
+			}
+			return valueHistory[n-1], nil
+		}
+		if name == "depth" {
+			return ctx.depth()
+		}
+		return nil, fmt.Errorf("no register or convenience variable named $%v", name)
+	}
+	if ctx.regs == nil {
+		return nil, fmt.Errorf("$%v is not available in this frame", name)
+	}
+	return newUint(*accessor(ctx.regs)), nil
+}
+
+// lookup resolves an identifier to a local variable of the frame or, failing
+// that, to a package-level variable.
+func (ctx *evalContext) lookup(name string) (*value, error) {
+	if !ctx.loaded && ctx.frame.fn != nil {
+		ctx.variables, _ = frameVariables(ctx.pid, ctx.frame, ctx.regs)
+		ctx.loaded = true
+	}
+	for i := len(ctx.variables) - 1; i >= 0; i-- {
+		v := ctx.variables[i]
+		if v.name == name {
+			if v.err != nil {
+				return nil, fmt.Errorf("%v: %v", name, v.err)
+			}
+			return v.val, nil
+		}
+	}
+
+	candidates := []string{name}
+	if ctx.frame.fn != nil {
+		candidates = append(candidates, ctx.frame.fn.PackageName()+"."+name)
+	}
+	candidates = append(candidates, "main."+name)
+	for _, candidate := range candidates {
+		if global, ok := globals[candidate]; ok {
+			return readVariable(ctx.pid, global.entry, global.unit, stackFrame{}, 0, nil)
+		}
+	}
+	if name == "depth" {
+		return ctx.depth() // Unless a variable is called depth, as $depth.
+	}
+	return nil, fmt.Errorf("no variable named %v", name)
+}
+
+// depth is the number of frames on the stopped goroutine's stack.
+func (ctx *evalContext) depth() (*value, error) {
+	if ctx.regs == nil {
+		return nil, errors.New("the stack depth is only known in the innermost frame")
+	}
+	return newInt(int64(stackDepth(ctx.pid, ctx.regs, ctx.symbolTable))), nil
+}
+
+func (ctx *evalContext) evalUnary(e *unaryExpr) (*value, error) {
+	x, err := ctx.eval(e.x)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "+":
+		return x, nil
+	case "-":
+		if isFloat(x) {
+			return newFloat(-floatValue(x)), nil
+		}
+		return arithmetic("-", newInt(0), x)
+	case "!":
+		return newBool(!isTrue(x)), nil
+	case "^":
+		n, _, err := integerValue(x)
+		if err != nil {
+			return nil, err
+		}
+		return newUint(^n), nil
+	case "*":
+		return dereference(ctx.pid, x)
+	case "&":
+		if x.addr == 0 {
+			return nil, errors.New("cannot take the address of a value not in memory")
+		}
+		return newPointer(x.typ, x.addr), nil
+	}
+	return nil, fmt.Errorf("unsupported operator %v", e.op)
+}
+
+func dereference(pid int, x *value) (*value, error) {
+	ptr, ok := resolveTypedef(x.typ).(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("cannot dereference %v", typeName(x.typ))
+	}
+	address := zeroExtend(x.data)
+	if address == 0 {
+		return nil, errors.New("nil pointer dereference")
+	}
+	size := ptr.Type.Size()
+	if size < 0 {
+		return nil, fmt.Errorf("cannot dereference %v", typeName(x.typ))
+	}
+	data, err := readMemory(pid, address, int(size))
+	if err != nil {
+		return nil, err
+	}
+	return &value{typ: ptr.Type, addr: address, data: data}, nil
+}
+
+func (ctx *evalContext) evalBinary(e *binaryExpr) (*value, error) {
+	x, err := ctx.eval(e.x)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "&&", "||":
+		if isTrue(x) == (e.op == "||") {
+			return newBool(isTrue(x)), nil
+		}
+		y, err := ctx.eval(e.y)
+		if err != nil {
+			return nil, err
+		}
+		return newBool(isTrue(y)), nil
+	}
+
+	y, err := ctx.eval(e.y)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return compare(ctx.pid, e.op, x, y)
+	}
+	return arithmetic(e.op, x, y)
+}
+
+func (ctx *evalContext) evalSelector(e *selectorExpr) (*value, error) {
+	// "pkg.Var" parses as a selector, but isn't one when pkg is no variable.
+	if ident, ok := e.x.(*identExpr); ok {
+		if _, err := ctx.lookup(ident.name); err != nil {
+			if global, ok := globals[ident.name+"."+e.field]; ok {
+				return readVariable(ctx.pid, global.entry, global.unit, stackFrame{}, 0, nil)
+			}
+			return nil, err
+		}
+	}
+
+	x, err := ctx.eval(e.x)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := resolveTypedef(x.typ).(*dwarf.PtrType); ok {
+		x, err = dereference(ctx.pid, x)
+		if err != nil {
+			return nil, err
+		}
+	}
+	st, ok := resolveTypedef(x.typ).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%v has no field %v", typeName(x.typ), e.field)
+	}
+	field := structField(st, e.field)
+	if field == nil {
+		return nil, fmt.Errorf("%v has no field %v", typeName(x.typ), e.field)
+	}
+	fv := fieldValue(x, field)
+	if fv == nil {
+		return nil, fmt.Errorf("cannot read field %v", e.field)
+	}
+	return fv, nil
+}
+
+func (ctx *evalContext) evalIndex(e *indexExpr) (*value, error) {
+	x, err := ctx.eval(e.x)
+	if err != nil {
+		return nil, err
+	}
+	i, err := ctx.eval(e.index)
+	if err != nil {
+		return nil, err
+	}
+	index, _, err := integerValue(i)
+	if err != nil {
+		return nil, err
+	}
+	return indexValue(ctx.pid, x, index)
+}
+
+// indexValue reads an element of an array, a pointer to one, a slice or a
+// string.
+func indexValue(pid int, x *value, index uint64) (*value, error) {
+	var err error
+	if ptr, ok := resolveTypedef(x.typ).(*dwarf.PtrType); ok {
+		if _, ok := resolveTypedef(ptr.Type).(*dwarf.ArrayType); ok {
+			x, err = dereference(pid, x)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch t := resolveTypedef(x.typ).(type) {
+	case *dwarf.ArrayType:
+		if int64(index) >= t.Count {
+			return nil, fmt.Errorf("index %v out of range [0:%v]", int64(index), t.Count)
+		}
+		size := t.Type.Size()
+		start := int64(index) * size
+		elem := &value{typ: t.Type, data: x.data[start : start+size]}
+		if x.addr != 0 {
+			elem.addr = x.addr + uint64(start)
+		}
+		return elem, nil
+	case *dwarf.StructType:
+		name := typeName(x.typ)
+		var elemType dwarf.Type
+		var address, length uint64
+		switch {
+		case name == "string":
+			elemType = uint8Type
+			address = binary.LittleEndian.Uint64(x.data)
+			length = binary.LittleEndian.Uint64(x.data[8:])
+		case strings.HasPrefix(name, "[]"):
+			arrayField := structField(t, "array")
+			lenField := structField(t, "len")
+			if arrayField == nil || lenField == nil {
+				break
+			}
+			elemType = arrayField.Type.(*dwarf.PtrType).Type
+			address = zeroExtend(fieldValue(x, arrayField).data)
+			length = zeroExtend(fieldValue(x, lenField).data)
+		}
+		if elemType == nil {
+			break
+		}
+		if index >= length {
+			return nil, fmt.Errorf("index %v out of range [0:%v]", int64(index), length)
+		}
+		elemAddress := address + index*uint64(elemType.Size())
+		data, err := readMemory(pid, elemAddress, int(elemType.Size()))
+		if err != nil {
+			return nil, err
+		}
+		return &value{typ: elemType, addr: elemAddress, data: data}, nil
+	}
+	return nil, fmt.Errorf("cannot index %v", typeName(x.typ))
+}
+
+func isFloat(v *value) bool {
+	_, ok := resolveTypedef(v.typ).(*dwarf.FloatType)
+	return ok
+}
+
+func floatValue(v *value) float64 {
+	if len(v.data) == 4 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(v.data)))
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(v.data))
+}
+
+// integerValue returns the bits of an integer-like value and whether it
+// should be treated as signed.  Pointers count as unsigned integers.
+func integerValue(v *value) (uint64, bool, error) {
+	switch resolveTypedef(v.typ).(type) {
+	case *dwarf.IntType, *dwarf.CharType:
+		return uint64(signExtend(v.data)), true, nil
+	case *dwarf.UintType, *dwarf.UcharType, *dwarf.PtrType, *dwarf.BoolType, *dwarf.UnspecifiedType:
+		return zeroExtend(v.data), false, nil
+	case *dwarf.FloatType:
+		return uint64(int64(floatValue(v))), true, nil
+	}
+	return 0, false, fmt.Errorf("%v is not a number", typeName(v.typ))
+}
+
+// arithmetic applies a binary operator.  Mixed signed and unsigned operands
+// give an unsigned result; pointers take part as plain addresses.
+func arithmetic(op string, x, y *value) (*value, error) {
+	if isFloat(x) || isFloat(y) {
+		a, b := toFloat(x), toFloat(y)
+		switch op {
+		case "+":
+			return newFloat(a + b), nil
+		case "-":
+			return newFloat(a - b), nil
+		case "*":
+			return newFloat(a * b), nil
+		case "/":
+			return newFloat(a / b), nil
+		}
+		return nil, fmt.Errorf("operator %v not defined on floats", op)
+	}
+
+	a, signedA, err := integerValue(x)
+	if err != nil {
+		return nil, err
+	}
+	b, signedB, err := integerValue(y)
+	if err != nil {
+		return nil, err
+	}
+	signed := signedA && signedB
+
+	var result uint64
+	switch op {
+	case "+":
+		result = a + b
+	case "-":
+		result = a - b
+	case "*":
+		result = a * b
+	case "/", "%":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		switch {
+		case signed && op == "/":
+			result = uint64(int64(a) / int64(b))
+		case signed:
+			result = uint64(int64(a) % int64(b))
+		case op == "/":
+			result = a / b
+		default:
+			result = a % b
+		}
+	case "&":
+		result = a & b
+	case "|":
+		result = a | b
+	case "^":
+		result = a ^ b
+	case "&^":
+		result = a &^ b
+	case "<<":
+		result = a << b
+	case ">>":
+		if signed {
+			result = uint64(int64(a) >> b)
+		} else {
+			result = a >> b
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator %v", op)
+	}
+
+	if signed {
+		return newInt(int64(result)), nil
+	}
+	return newUint(result), nil
+}
+
+func toFloat(v *value) float64 {
+	if isFloat(v) {
+		return floatValue(v)
+	}
+	n, signed, _ := integerValue(v)
+	if signed {
+		return float64(int64(n))
+	}
+	return float64(n)
+}
+
+// isTrue reports whether a value counts as true in a condition: booleans as
+// themselves, and numbers and pointers when they are non-zero.
+func isTrue(v *value) bool {
+	for _, b := range v.data {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stringContents returns the text of a Go string or string literal.
+func stringContents(pid int, v *value) (string, bool, error) {
+	if v.typ == untypedStringType {
+		return string(v.data), true, nil
+	}
+	if typeName(v.typ) != "string" || len(v.data) < 16 {
+		return "", false, nil
+	}
+	length := binary.LittleEndian.Uint64(v.data[8:])
+	if length == 0 {
+		return "", true, nil
+	}
+	if length > maxStringLength {
+		return "", true, fmt.Errorf("string of length %v is too long to compare", length)
+	}
+	data, err := readMemory(pid, binary.LittleEndian.Uint64(v.data), int(length))
+	if err != nil {
+		return "", true, err
+	}
+	return string(data), true, nil
+}
+
+func compare(pid int, op string, x, y *value) (*value, error) {
+	var order int
+	a, isString, err := stringContents(pid, x)
+	if err != nil {
+		return nil, err
+	}
+	if isString {
+		b, ok, err := stringContents(pid, y)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %v", typeName(y.typ))
+		}
+		order = strings.Compare(a, b)
+	} else if isFloat(x) || isFloat(y) {
+		a, b := toFloat(x), toFloat(y)
+		switch {
+		case a < b:
+			order = -1
+		case a > b:
+			order = 1
+		}
+	} else {
+		a, signedA, err := integerValue(x)
+		if err != nil {
+			return nil, err
+		}
+		b, signedB, err := integerValue(y)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case a == b:
+		case signedA && signedB && int64(a) < int64(b), !(signedA && signedB) && a < b:
+			order = -1
+		default:
+			order = 1
+		}
+	}
+
+	switch op {
+	case "==":
+		return newBool(order == 0), nil
+	case "!=":
+		return newBool(order != 0), nil
+	case "<":
+		return newBool(order < 0), nil
+	case "<=":
+		return newBool(order <= 0), nil
+	case ">":
+		return newBool(order > 0), nil
+	}
+	return newBool(order >= 0), nil
+}
+
+// evalConversion evaluates T(x), the only kind of call the evaluator
+// understands.
+func (ctx *evalContext) evalConversion(e *callExpr) (*value, error) {
+	name, ok := typeExprName(e.fun)
+	if !ok || len(e.args) != 1 {
+		return nil, errors.New("function calls are not supported")
+	}
+	typ, err := lookupType(name)
+	if err != nil {
+		return nil, err
+	}
+	x, err := ctx.eval(e.args[0])
+	if err != nil {
+		return nil, err
+	}
+	return convert(x, typ)
+}
+
+// typeExprName spells out an expression that names a type, such as main.T
+// or *main.T.
+func typeExprName(e expr) (string, bool) {
+	switch e := e.(type) {
+	case *identExpr:
+		return e.name, true
+	case *selectorExpr:
+		if pkg, ok := typeExprName(e.x); ok {
+			return pkg + "." + e.field, true
+		}
+	case *unaryExpr:
+		if e.op == "*" {
+			if elem, ok := typeExprName(e.x); ok {
+				return "*" + elem, true
+			}
+		}
+	}
+	return "", false
+}
+
+func lookupType(name string) (dwarf.Type, error) {
+	if offset, ok := namedTypes[name]; ok {
+		return dwarfData.Type(offset)
+	}
+	if offset, ok := namedTypes["main."+name]; ok {
+		return dwarfData.Type(offset)
+	}
+	if typ, ok := basicTypes[name]; ok {
+		return typ, nil
+	}
+	if strings.HasPrefix(name, "*") {
+		elem, err := lookupType(name[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "*" + typeName(elem)}, Type: elem}, nil
+	}
+	return nil, fmt.Errorf("no type named %v", name)
+}
+
+// convert implements Go conversions between numeric and pointer types, and
+// reinterprets values whose size matches the target type.
+func convert(x *value, typ dwarf.Type) (*value, error) {
+	size := int(typ.Size())
+	switch t := resolveTypedef(typ).(type) {
+	case *dwarf.FloatType:
+		f := toFloat(x)
+		data := make([]byte, 8)
+		if size == 4 {
+			binary.LittleEndian.PutUint32(data, math.Float32bits(float32(f)))
+		} else {
+			binary.LittleEndian.PutUint64(data, math.Float64bits(f))
+		}
+		return &value{typ: typ, data: data[:size]}, nil
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType, *dwarf.PtrType, *dwarf.BoolType:
+		n, _, err := integerValue(x)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, n)
+		if _, ok := t.(*dwarf.BoolType); ok {
+			return newBool(n != 0), nil
+		}
+		return &value{typ: typ, data: data[:size]}, nil
+	}
+	if size == len(x.data) {
+		return &value{typ: typ, addr: x.addr, data: x.data}, nil
+	}
+	return nil, fmt.Errorf("cannot convert %v to %v", typeName(x.typ), typeName(typ))
+}
+
+// runSetCommand implements "set $<name> = <expr>".
+func runSetCommand(ctx *evalContext, argument string) error {
+	if !strings.HasPrefix(argument, "$") {
+		return errors.New("usage: set $<name> = <expr>")
+	}
+	i := strings.Index(argument, "=")
+	if i < 0 || strings.HasPrefix(argument[i:], "==") {
+		return errors.New("usage: set $<name> = <expr>")
+	}
+	name := strings.TrimSpace(argument[1:i])
+	if name == "" || strings.IndexFunc(name, func(c rune) bool { return !isIdentChar(c) }) >= 0 {
+		return fmt.Errorf("invalid variable name $%v", name)
+	}
+	if _, ok := registerNames[name]; ok {
+		return fmt.Errorf("cannot assign to register $%v", name)
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return fmt.Errorf("cannot assign to history value $%v", name)
+	}
+
+	v, err := evaluate(ctx, argument[i+1:])
+	if err != nil {
+		return err
+	}
+	convenienceVariables[name] = v
+	return nil
+}
+
+// recordLastValue adds a printed value to the history, binds $_ to it and $__
+// to its address, and returns its history number.
+func recordLastValue(v *value) int {
+	valueHistory = append(valueHistory, v)
+	convenienceVariables["_"] = v
+	if v.addr != 0 {
+		convenienceVariables["__"] = newPointer(v.typ, v.addr)
+	} else {
+		delete(convenienceVariables, "__")
+	}
+	return len(valueHistory)
+}
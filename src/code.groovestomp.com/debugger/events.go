@@ -0,0 +1,140 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// maxCreatorFrames is how much of the stack that created a goroutine the
+// goroutine-events log shows.
+const maxCreatorFrames = 4
+
+var (
+	// goroutineEvents logs goroutines starting and exiting while the
+	// program runs, and threadEvents OS threads.
+	goroutineEvents bool
+	threadEvents    bool
+
+	// goroutineEventAddresses are the internal breakpoints goroutine
+	// events are seen at: the returns of runtime.newproc1, which has just
+	// made a goroutine, and the entry of runtime.goexit1, which every
+	// goroutine ends in.
+	goroutineEventAddresses = make(map[uint64]string)
+)
+
+func parseGoroutineEvents(value string) error {
+	if err := parseBool(value, &goroutineEvents); err != nil {
+		return err
+	}
+	if processID == 0 || core != nil {
+		return nil
+	}
+	disarmCatcher(processID, goroutineEventAddresses)
+	if goroutineEvents {
+		return armGoroutineEvents(processID, listingSymbols)
+	}
+	return nil
+}
+
+// armGoroutineEvents puts the internal breakpoints of goroutine-events in.
+func armGoroutineEvents(pid int, symbolTable *gosym.Table) error {
+	newproc := symbolTable.LookupFunc("runtime.newproc1")
+	goexit := symbolTable.LookupFunc("runtime.goexit1")
+	if newproc == nil || goexit == nil {
+		return fmt.Errorf("no runtime.newproc1 or runtime.goexit1 in the binary")
+	}
+	returns, err := functionReturns(pid, newproc)
+	if err != nil {
+		return err
+	}
+	for _, address := range returns {
+		goroutineEventAddresses[address] = "create"
+	}
+	goroutineEventAddresses[goexit.Entry] = "exit"
+	for address := range goroutineEventAddresses {
+		if err := setBreakpoint(pid, address); err != nil {
+			disarmCatcher(pid, goroutineEventAddresses)
+			return err
+		}
+	}
+	return nil
+}
+
+// logGoroutineEvent reports the goroutine a thread stopped at one of the
+// goroutine-events breakpoints has made or is ending.  newproc1 runs on the
+// system stack, returning the new g; the goroutine that called go is the
+// one the thread's m is running.
+func logGoroutineEvent(tid int, kind string, symbolTable *gosym.Table) {
+	stamp := time.Now().Format("15:04:05.000")
+	switch kind {
+	case "create":
+		var regs syscall.PtraceRegs
+		if err := ptraceGetRegs(tid, &regs); err != nil {
+			return
+		}
+		g, err := readRuntimeStruct(tid, "runtime.g", regs.Rax)
+		if err != nil {
+			return
+		}
+		fmt.Printf("%v goroutine %v created by goroutine %v to run %v\n", stamp,
+			scalarMember(g, "goid"), scalarMember(g, "parentGoid"), functionName(symbolTable, scalarMember(g, "startpc")))
+		creator := creatingGoroutine(tid)
+		if creator == nil {
+			return
+		}
+		frames := goroutineBacktrace(tid, creator, symbolTable)
+		frames = frames[userFrame(frames):]
+		for i, frame := range frames {
+			if i == maxCreatorFrames {
+				break
+			}
+			fmt.Printf("    %v at %v:%v\n", frame.fn.Name, frame.file, frame.line)
+		}
+	case "exit":
+		address := currentGoroutine(tid)
+		g, err := readRuntimeStruct(tid, "runtime.g", address)
+		if err != nil {
+			return
+		}
+		fmt.Printf("%v goroutine %v exited, which ran %v\n", stamp,
+			scalarMember(g, "goid"), functionName(symbolTable, scalarMember(g, "startpc")))
+	}
+}
+
+// creatingGoroutine is the goroutine the m of a thread on its system stack
+// is running.
+func creatingGoroutine(tid int) *goroutine {
+	g0, err := readRuntimeStruct(tid, "runtime.g", currentGoroutine(tid))
+	if err != nil {
+		return nil
+	}
+	m, err := readRuntimeStruct(tid, "runtime.m", scalarMember(g0, "m"))
+	if err != nil {
+		return nil
+	}
+	curg := scalarMember(m, "curg")
+	if curg == 0 {
+		return nil
+	}
+	g, err := readRuntimeStruct(tid, "runtime.g", curg)
+	if err != nil {
+		return nil
+	}
+	return readGoroutine(tid, g)
+}
+
+// logThreadEvent reports an OS thread starting or exiting.
+func logThreadEvent(tid int, what string) {
+	if threadEvents {
+		fmt.Printf("%v thread %v %v\n", time.Now().Format("15:04:05.000"), tid, what)
+	}
+}
+
+func functionName(symbolTable *gosym.Table, pc uint64) string {
+	if fn := symbolTable.PCToFunc(pc); fn != nil {
+		return fn.Name
+	}
+	return fmt.Sprintf("0x%x", pc)
+}
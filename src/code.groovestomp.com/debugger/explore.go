@@ -0,0 +1,267 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// explorePage is how many fields or elements explore lists at a time.
+	explorePage = 20
+
+	// maxExploreSummary is how much of a value explore shows on its line.
+	maxExploreSummary = 100
+)
+
+// exploreNode is a value explore has reached, with the expression that
+// reaches it.
+type exploreNode struct {
+	path string
+	v    *value
+	page int // The first element listed.
+}
+
+// runExploreCommand implements "explore <expression>": it shows a value
+// one level at a time, with a number for each field, element or pointer
+// target to open, rather than printing all of it at once.
+func runExploreCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "" {
+		return fmt.Errorf("usage: explore <expression>")
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	root, err := evaluate(ctx, argument)
+	if err != nil {
+		return err
+	}
+
+	trail := []*exploreNode{{path: argument, v: root}}
+	show := true
+	for {
+		node := trail[len(trail)-1]
+		beginEvaluation() // Each step reads within eval-read-limit.
+		children, total := exploreChildren(pid, node)
+		if show {
+			showExploreNode(pid, node, children, total)
+		}
+		show = true
+		fmt.Print("explore> ")
+		line, err := readLine()
+		if err != nil {
+			fmt.Println()
+			return nil
+		}
+		answer := strings.TrimSpace(line)
+		switch {
+		case answer == "q" || answer == "quit":
+			return nil
+		case answer == "":
+		case answer == "u" || answer == "..":
+			if len(trail) == 1 {
+				return nil
+			}
+			trail = trail[:len(trail)-1]
+		case answer == "n" || answer == "p":
+			page := node.page + explorePage
+			if answer == "p" {
+				page = node.page - explorePage
+			}
+			if page < 0 || page >= total {
+				fmt.Println("No more.")
+				show = false
+				continue
+			}
+			node.page = page
+		case strings.HasPrefix(answer, "[") && strings.HasSuffix(answer, "]"):
+			index, err := strconv.Atoi(strings.TrimSpace(answer[1 : len(answer)-1]))
+			if err != nil || index < 0 || index >= total || !isIndexable(node.v) {
+				fmt.Printf("No element %v.\n", answer)
+				show = false
+				continue
+			}
+			child, err := exploreElement(pid, node, index)
+			if err != nil {
+				fmt.Println(err)
+				show = false
+				continue
+			}
+			trail = append(trail, child)
+		default:
+			choice, err := strconv.Atoi(answer)
+			if err != nil || choice < 1 || choice > len(children) {
+				fmt.Println("Type a number to open, [<index>], u to go back up, n or p for the next or previous page, or q.")
+				show = false
+				continue
+			}
+			if children[choice-1].v == nil {
+				fmt.Printf("%v can't be read.\n", children[choice-1].path)
+				show = false
+				continue
+			}
+			trail = append(trail, children[choice-1])
+		}
+	}
+}
+
+// showExploreNode prints where explore is, with what it holds numbered.
+func showExploreNode(pid int, node *exploreNode, children []*exploreNode, total int) {
+	fmt.Printf("%v (%v) = %v\n", node.path, typeName(node.v.typ), exploreSummary(pid, node.v))
+	for i, child := range children {
+		text := "?"
+		if child.v != nil {
+			text = exploreSummary(pid, child.v)
+		}
+		fmt.Printf("  [%v] %v = %v\n", i+1, child.path, text)
+	}
+	if total > len(children) {
+		fmt.Printf("  %v to %v of %v\n", node.page, node.page+len(children)-1, total)
+	}
+}
+
+func exploreSummary(pid int, v *value) string {
+	text := formatValueDepth(pid, v, maxValueDepth)
+	if len(text) > maxExploreSummary {
+		text = text[:maxExploreSummary] + "..."
+	}
+	return text
+}
+
+// exploreChildren returns what a value can be opened into, one page of
+// them for slices and arrays, and how many there are in all: the fields of
+// a struct, the target of a pointer, the dynamic value of an interface, the
+// elements of a slice or array.
+func exploreChildren(pid int, node *exploreNode) ([]*exploreNode, int) {
+	v := node.v
+	name := typeName(v.typ)
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.PtrType:
+		if zeroExtend(v.data) == 0 || strings.HasPrefix(name, "map[") || strings.HasPrefix(name, "chan ") {
+			return nil, 0
+		}
+		target, err := dereference(pid, v)
+		if err == nil && isPlainStruct(target) {
+			// Fields are reached through the pointer, as in Go.
+			return exploreChildren(pid, &exploreNode{path: node.path, v: target})
+		}
+		return []*exploreNode{{path: "*" + node.path, v: target}}, 1
+	case *dwarf.StructType:
+		switch {
+		case name == "string" || isStringStruct(t):
+			return nil, 0
+		case strings.HasPrefix(name, "[]"):
+			return exploreElements(pid, node)
+		case isInterface(v):
+			typeAddress, data, err := interfaceWords(pid, v)
+			if err != nil || typeAddress == 0 {
+				return nil, 0
+			}
+			dynamic, dv, err := dynamicValue(pid, typeAddress, data)
+			if err != nil {
+				dv = nil
+			}
+			return []*exploreNode{{path: fmt.Sprintf("%v.(%v)", parenthesize(node.path), dynamic), v: dv}}, 1
+		}
+		var children []*exploreNode
+		for _, field := range t.Field {
+			children = append(children, &exploreNode{path: parenthesize(node.path) + "." + field.Name, v: fieldValue(v, field)})
+		}
+		return children, len(children)
+	case *dwarf.ArrayType:
+		return exploreElements(pid, node)
+	}
+	return nil, 0
+}
+
+// exploreElements lists the page of a slice's or array's elements explore
+// is on.
+func exploreElements(pid int, node *exploreNode) ([]*exploreNode, int) {
+	total := elementCount(node.v)
+	var children []*exploreNode
+	for i := node.page; i < total && i < node.page+explorePage; i++ {
+		child, err := exploreElement(pid, node, i)
+		if err != nil {
+			child = &exploreNode{path: fmt.Sprintf("%v[%v]", parenthesize(node.path), i)}
+		}
+		children = append(children, child)
+	}
+	return children, total
+}
+
+func exploreElement(pid int, node *exploreNode, index int) (*exploreNode, error) {
+	v, err := indexValue(pid, node.v, uint64(index))
+	if err != nil {
+		return nil, err
+	}
+	return &exploreNode{path: fmt.Sprintf("%v[%v]", parenthesize(node.path), index), v: v}, nil
+}
+
+// elementCount is the length of a slice or array.
+func elementCount(v *value) int {
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.ArrayType:
+		if t.Count > 0 {
+			return int(t.Count)
+		}
+	case *dwarf.StructType:
+		if lenField := structField(t, "len"); lenField != nil && strings.HasPrefix(typeName(v.typ), "[]") {
+			if n := signExtend(fieldValue(v, lenField).data); n > 0 {
+				return int(n)
+			}
+		}
+	}
+	return 0
+}
+
+// isPlainStruct reports whether a value is a struct that explore opens
+// into its fields, rather than a string, slice or interface.
+func isPlainStruct(v *value) bool {
+	t, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+	if !ok {
+		return false
+	}
+	name := typeName(v.typ)
+	return !(name == "string" || isStringStruct(t) || strings.HasPrefix(name, "[]") || isInterface(v))
+}
+
+func isInterface(v *value) bool {
+	t, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+	if !ok {
+		return false
+	}
+	name := typeName(v.typ)
+	return name == "error" || name == "runtime.iface" || name == "runtime.eface" ||
+		t.StructName == "runtime.iface" || t.StructName == "runtime.eface" || strings.HasPrefix(name, "interface {")
+}
+
+func isIndexable(v *value) bool {
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.ArrayType:
+		return true
+	case *dwarf.StructType:
+		return strings.HasPrefix(typeName(v.typ), "[]") && structField(t, "array") != nil
+	}
+	return false
+}
+
+// parenthesize wraps an expression in parentheses if it has an operator
+// outside of them, so that a selector or index after it applies to all of
+// it.
+func parenthesize(path string) string {
+	depth := 0
+	for i, c := range path {
+		switch {
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case depth == 0 && (strings.ContainsRune(" +-/%|^<>=!&", c) || c == '*' && i == 0):
+			return "(" + path + ")"
+		}
+	}
+	return path
+}
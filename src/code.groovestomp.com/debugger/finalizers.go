@@ -0,0 +1,165 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"errors"
+	"fmt"
+)
+
+// Heap layout constants for linux/amd64 from the runtime's malloc.go.
+const (
+	heapArenaBytes  = 1 << 26
+	heapPageSize    = 8192
+	arenaBaseOffset = 0xffff800000000000
+	mSpanInUse      = 1
+)
+
+// Kinds of special records from the runtime's mheap.go.
+const (
+	specialFinalizer = 2
+	specialCleanup   = 7
+)
+
+// showFinalizers implements "info finalizers <expr>": the finalizer and
+// cleanups registered for the heap object <expr> points into, and whether
+// a finalizer has already been queued to run.
+func showFinalizers(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "" {
+		return errors.New("info finalizers needs an address expression")
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, argument)
+	if err != nil {
+		return err
+	}
+	address, err := valueAddress(v)
+	if err != nil {
+		return err
+	}
+
+	span, err := findSpan(pid, address)
+	if err != nil {
+		return err
+	}
+	start := scalarMember(span, "startAddr")
+	size := scalarMember(span, "elemsize")
+	base := address
+	if size > 0 {
+		base = start + (address-start)/size*size
+	}
+	fmt.Printf("Object 0x%x, %v bytes in span 0x%x\n", base, size, span.addr)
+
+	found := false
+	special := scalarMember(span, "specials")
+	for i := 0; special != 0 && i < maxGoroutines; i++ {
+		sv, err := readRuntimeStruct(pid, "runtime.special", special)
+		if err != nil {
+			return err
+		}
+		// Tiny allocations share a block, so their records carry the exact
+		// offset instead of the block's.
+		offset := scalarMember(sv, "offset")
+		if offset == base-start || offset == address-start {
+			switch scalarMember(sv, "kind") {
+			case specialFinalizer:
+				if f, err := readRuntimeStruct(pid, "runtime.specialfinalizer", special); err == nil {
+					fmt.Printf("  finalizer %v\n", formatFunction(pid, scalarMember(f, "fn")))
+					found = true
+				}
+			case specialCleanup:
+				if c, err := readRuntimeStruct(pid, "runtime.specialCleanup", special); err == nil {
+					fmt.Printf("  cleanup %v %v\n", scalarMember(c, "id"), formatFunction(pid, scalarMember(c, "cleanup", "fn")))
+					found = true
+				}
+			}
+		}
+		special = scalarMember(sv, "next")
+	}
+
+	if fn, ok := queuedFinalizer(pid, base); ok {
+		fmt.Printf("  finalizer %v is queued to run: the object became unreachable\n", fn)
+		found = true
+	}
+	if !found {
+		fmt.Println("  no finalizer or cleanup registered")
+	}
+	return nil
+}
+
+// findSpan looks up the in-use mspan holding address through the heap's
+// arena index, as the runtime's spanOf does.
+func findSpan(pid int, address uint64) (*value, error) {
+	mheap, err := readGlobal(pid, "runtime.mheap_")
+	if err != nil {
+		return nil, err
+	}
+	arenas := member(mheap, "arenas")
+	if arenas == nil || len(arenas.data) < 8 {
+		return nil, errors.New("unsupported runtime: mheap has no arena index")
+	}
+	l2 := zeroExtend(arenas.data[:8])
+	index := (address - arenaBaseOffset) / heapArenaBytes
+	arena, err := readUint64(pid, l2+index*8)
+	if err != nil || arena == 0 {
+		return nil, fmt.Errorf("0x%x is not in the Go heap", address)
+	}
+
+	arenaType, err := lookupType("runtime.heapArena")
+	if err != nil {
+		return nil, err
+	}
+	st, ok := resolveTypedef(arenaType).(*dwarf.StructType)
+	if !ok || structField(st, "spans") == nil {
+		return nil, errors.New("unsupported runtime: heapArena has no spans")
+	}
+	// heapArena is large, so only the one entry of spans is read.
+	page := address % heapArenaBytes / heapPageSize
+	spanAddress, err := readUint64(pid, arena+uint64(structField(st, "spans").ByteOffset)+page*8)
+	if err != nil || spanAddress == 0 {
+		return nil, fmt.Errorf("0x%x is not in the Go heap", address)
+	}
+	span, err := readRuntimeStruct(pid, "runtime.mspan", spanAddress)
+	if err != nil {
+		return nil, err
+	}
+	start := scalarMember(span, "startAddr")
+	if scalarMember(span, "state") != mSpanInUse || address < start || address >= scalarMember(span, "limit") {
+		return nil, fmt.Errorf("0x%x is not in an allocated heap object", address)
+	}
+	return span, nil
+}
+
+// queuedFinalizer looks for object among the finalizers in runtime.finq,
+// which the garbage collector fills with those of unreachable objects.
+func queuedFinalizer(pid int, object uint64) (string, bool) {
+	finq, err := readGlobal(pid, "runtime.finq")
+	if err != nil {
+		return "", false
+	}
+	block := zeroExtend(finq.data)
+	for i := 0; block != 0 && i < maxGoroutines; i++ {
+		bv, err := readRuntimeStruct(pid, "runtime.finBlock", block)
+		if err != nil {
+			return "", false
+		}
+		fins := member(bv, "fin")
+		count := scalarMember(bv, "cnt")
+		finType, err := lookupType("runtime.finalizer")
+		if fins == nil || err != nil || finType.Size() <= 0 {
+			return "", false
+		}
+		size := uint64(finType.Size())
+		for j := uint64(0); j < count && (j+1)*size <= uint64(len(fins.data)); j++ {
+			f := &value{typ: finType, data: fins.data[j*size : (j+1)*size]}
+			if scalarMember(f, "arg") == object {
+				return formatFunction(pid, scalarMember(f, "fn")), true
+			}
+		}
+		block = scalarMember(bv, "next")
+	}
+	return "", false
+}
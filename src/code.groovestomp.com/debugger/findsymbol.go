@@ -0,0 +1,159 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSymbolMatches is how many matches find-symbol lists unless told.
+const maxSymbolMatches = 20
+
+// symbolMatch is a function or global whose name matches a search, with
+// what it ranks by: how closely it matched, lowest first, then how spread
+// out the letters of a fuzzy match were.
+type symbolMatch struct {
+	name   string
+	fn     *gosym.Func
+	rank   int
+	spread int
+}
+
+// runFindSymbol implements "find-symbol <name> [<count>]": it lists the
+// functions and package-level variables whose names match the name best,
+// so that break and print can be given the full one.  Case, receiver
+// parentheses and stars and the package path are ignored, and any name
+// with the letters of the search in order matches, if poorly.
+func runFindSymbol(argument string, symbolTable *gosym.Table) error {
+	fields := strings.Fields(argument)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("usage: find-symbol <name> [<count>]")
+	}
+	limit := maxSymbolMatches
+	if len(fields) == 2 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("expected a number of matches, got %q", fields[1])
+		}
+		limit = n
+	}
+	query := normalizeSymbol(fields[0])
+
+	var matches []symbolMatch
+	for i := range symbolTable.Funcs {
+		if err := commandCancelled(); err != nil {
+			return err
+		}
+		fn := &symbolTable.Funcs[i]
+		if rank, spread, ok := matchSymbol(fn.Name, query); ok {
+			matches = append(matches, symbolMatch{name: fn.Name, fn: fn, rank: rank, spread: spread})
+		}
+	}
+	for name := range globals {
+		if rank, spread, ok := matchSymbol(name, query); ok {
+			matches = append(matches, symbolMatch{name: name, rank: rank, spread: spread})
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no function or variable matches %v", fields[0])
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if am, bm := strings.HasPrefix(a.name, "main."), strings.HasPrefix(b.name, "main."); am != bm {
+			return am
+		}
+		if a.spread != b.spread {
+			return a.spread < b.spread
+		}
+		if len(a.name) != len(b.name) {
+			return len(a.name) < len(b.name)
+		}
+		return a.name < b.name
+	})
+
+	shown := matches
+	if len(shown) > limit {
+		shown = shown[:limit]
+	}
+	for _, m := range shown {
+		if m.fn != nil {
+			file, line, _ := symbolTable.PCToLine(m.fn.Entry)
+			fmt.Printf("  func %v  %v:%v\n", m.name, file, line)
+			continue
+		}
+		what := ""
+		if offset, ok := globals[m.name].entry.Val(dwarf.AttrType).(dwarf.Offset); ok {
+			if typ, err := dwarfData.Type(offset); err == nil {
+				what = "  " + typeName(typ)
+			}
+		}
+		fmt.Printf("  var  %v%v\n", m.name, what)
+	}
+	if len(matches) > limit {
+		fmt.Printf("and %v more.\n", len(matches)-limit)
+	}
+	return nil
+}
+
+// normalizeSymbol lowers a name's case and drops the parentheses and star of
+// a method's receiver, so (*T).M, T.M and t.m are alike, and the type
+// arguments of a generic one, which would match most anything.
+func normalizeSymbol(name string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range name {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case depth > 0 || r == '(' || r == ')' || r == '*':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strings.ToLower(b.String())
+}
+
+// matchSymbol ranks how well a name matches a normalized search: in full,
+// with or without its package path; by its last element; by the end of
+// it; by the start of the last element; anywhere in it; and last, only as
+// letters in order after the package path, by how far apart they are.
+func matchSymbol(name, query string) (int, int, bool) {
+	n := normalizeSymbol(name)
+	short := n[strings.LastIndex(n, "/")+1:]
+	last := n[strings.LastIndex(n, ".")+1:]
+	switch {
+	case n == query || short == query:
+		return 0, 0, true
+	case last == query:
+		return 1, 0, true
+	case strings.HasSuffix(n, "."+query):
+		return 2, 0, true
+	case strings.HasPrefix(last, query):
+		return 3, 0, true
+	case strings.Contains(short, query):
+		return 4, 0, true
+	case strings.Contains(n, query):
+		return 5, 0, true
+	}
+	// A subsequence, matching each letter as soon as possible.
+	spread, at := 0, 0
+	for j, r := range query {
+		i := strings.IndexRune(short[at:], r)
+		if i < 0 {
+			return 0, 0, false
+		}
+		if j > 0 {
+			spread += i
+		}
+		at += i + len(string(r))
+	}
+	return 6, spread, true
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Integer and floating point registers of Go's register-based calling
+// convention, in the order arguments and results are assigned to them.
+const (
+	abiIntRegisters   = 9 // RAX, RBX, RCX, RDI, RSI, R8, R9, R10, R11.
+	abiFloatRegisters = 15
+)
+
+// abiParameter is a parameter or result from a function's DWARF entry.
+type abiParameter struct {
+	name   string
+	typ    dwarf.Type
+	result bool
+}
+
+// abiPiece is a part of a register-assigned value: size bytes at offset
+// within it come from integer or floating point register reg.
+type abiPiece struct {
+	offset int64
+	size   int64
+	float  bool
+	reg    int
+}
+
+// finish runs the selected function until it returns to its caller, stopping
+// at the return address.  When it got there it also returns the frame left,
+// in which the results can be decoded.  The status is nil when the function
+// didn't return within step-timeout and keeps running in the background.
+func finish(pid int, symbolTable *gosym.Table) (*syscall.WaitStatus, *stackFrame, error) {
+	regs, index, live, err := selectedStack(pid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !live {
+		return nil, nil, errors.New("finish works in the goroutine the thread is running; select it with goroutine")
+	}
+	frames := unwind(pid, symbolTable, regs, index+2)
+	if len(frames) < index+2 {
+		return nil, nil, errors.New("\"finish\" not meaningful in the outermost frame")
+	}
+	callee, caller := frames[index], frames[index+1]
+
+	interruptRequested = false
+	stepping = true
+	defer func() { stepping = false }()
+	if stepTimeout > 0 {
+		stepDeadline = time.Now().Add(stepTimeout)
+		defer func() { stepDeadline = time.Time{} }()
+	}
+	fmt.Printf("Run till exit from %v\n", callee.fn.Name)
+	status := finishCall(pid, caller.pc, callee.cfa, symbolTable)
+	if status == nil || !isTrapStop(status) || currentThread != pid || getPC(pid) != caller.pc {
+		return status, nil, nil // Stopped on the way, or still running.
+	}
+	return status, &callee, nil
+}
+
+// showReturnValues prints the results of the function frame describes,
+// which has just returned.
+func showReturnValues(pid int, frame stackFrame, symbolTable *gosym.Table) {
+	parameters, err := functionParameters(frame)
+	if err != nil {
+		return // Without DWARF there is just the caller's line to show.
+	}
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return
+	}
+	var results []string
+	var values []string
+	named := false
+	for _, p := range returnValues(pid, parameters, frame, &regs, symbolTable) {
+		results = append(results, p.name)
+		if p.err != nil {
+			values = append(values, fmt.Sprintf("<%v>", p.err))
+		} else {
+			values = append(values, formatValue(pid, p.val))
+		}
+		named = named || !strings.HasPrefix(p.name, "~")
+	}
+	switch {
+	case len(values) == 0:
+	case len(values) == 1 && !named:
+		fmt.Printf("%v returned %v\n", frame.fn.Name, values[0])
+	default:
+		for i := range values {
+			values[i] = results[i] + " = " + values[i]
+		}
+		fmt.Printf("%v returned (%v)\n", frame.fn.Name, strings.Join(values, ", "))
+	}
+}
+
+// functionParameters lists the parameters and results of the function
+// frame is in, in declaration order, the receiver first.
+func functionParameters(frame stackFrame) ([]abiParameter, error) {
+	if dwarfData == nil {
+		return nil, errors.New("no DWARF debug information in binary")
+	}
+	sub := findSubprogram(frame.pc)
+	if sub == nil {
+		return nil, fmt.Errorf("no debug information for %v", frame.fn.Name)
+	}
+	reader := dwarfData.Reader()
+	reader.Seek(sub.offset)
+	if _, err := reader.Next(); err != nil {
+		return nil, err
+	}
+	var parameters []abiParameter
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil || entry.Tag == 0 {
+			break
+		}
+		if entry.Tag == dwarf.TagFormalParameter {
+			typeOffset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+			if !ok {
+				return nil, errors.New("parameter has no type")
+			}
+			typ, err := dwarfData.Type(typeOffset)
+			if err != nil {
+				return nil, err
+			}
+			name, _ := entry.Val(dwarf.AttrName).(string)
+			result, _ := entry.Val(dwarf.AttrVarParam).(bool)
+			parameters = append(parameters, abiParameter{name: name, typ: typ, result: result})
+		}
+		if entry.Children {
+			reader.SkipChildren()
+		}
+	}
+	return parameters, nil
+}
+
+// returnValues decodes a function's results just after it has returned.
+// With the register-based calling convention results are in registers, or
+// on the stack after the caller's stack-assigned arguments when they don't
+// fit.  With the older stack-based one they are where the callee's DWARF
+// says, in the caller's frame.
+func returnValues(pid int, parameters []abiParameter, frame stackFrame, regs *syscall.PtraceRegs, symbolTable *gosym.Table) []variable {
+	if !usesRegisterABI(symbolTable) {
+		return frameResultVariables(pid, frame)
+	}
+
+	// Arguments use registers of their own, but their stack-assigned part
+	// comes before the results'.
+	stackOffset := int64(0)
+	ints, fps := 0, 0
+	for _, p := range parameters {
+		var pieces []abiPiece
+		if !p.result && !assignRegisters(resolveTypedef(p.typ), 0, &ints, &fps, &pieces) {
+			stackOffset = alignOffset(stackOffset, typeAlignment(p.typ)) + p.typ.Size()
+		}
+	}
+	stackOffset = alignOffset(stackOffset, 8)
+
+	var results []variable
+	var floats []uint64
+	ints, fps = 0, 0
+	for _, p := range parameters {
+		if !p.result {
+			continue
+		}
+		v := variable{name: p.name}
+		var pieces []abiPiece
+		if assignRegisters(resolveTypedef(p.typ), 0, &ints, &fps, &pieces) {
+			if floats == nil && hasFloatPiece(pieces) {
+				floats, v.err = readFloatRegisters(pid)
+			}
+			if v.err == nil {
+				v.val = registerValue(p.typ, pieces, regs, floats)
+			}
+		} else {
+			stackOffset = alignOffset(stackOffset, typeAlignment(p.typ))
+			address := regs.Rsp + uint64(stackOffset)
+			stackOffset += p.typ.Size()
+			if data, err := readMemory(pid, address, int(p.typ.Size())); err != nil {
+				v.err = err
+			} else {
+				v.val = &value{typ: p.typ, addr: address, data: data}
+			}
+		}
+		results = append(results, v)
+	}
+	return results
+}
+
+// frameResultVariables reads the results of the stack-based calling
+// convention from the locations the callee's DWARF gives, relative to its
+// CFA, which is still the caller's stack pointer.
+func frameResultVariables(pid int, frame stackFrame) []variable {
+	variables, err := frameVariables(pid, frame, nil)
+	if err != nil {
+		return nil
+	}
+	parameters, err := functionParameters(frame)
+	if err != nil {
+		return nil
+	}
+	isResult := make(map[string]bool)
+	for _, p := range parameters {
+		if p.result {
+			isResult[p.name] = true
+		}
+	}
+	var results []variable
+	for _, v := range variables {
+		if v.parameter && isResult[v.name] {
+			results = append(results, v)
+		}
+	}
+	return results
+}
+
+// usesRegisterABI reports whether the binary was built with Go's
+// register-based calling convention, which amd64 has had since Go 1.17 and
+// which came with the ABI0 wrappers for assembly functions.  Newer
+// toolchains no longer name those in the symbol table.
+func usesRegisterABI(symbolTable *gosym.Table) bool {
+	if binaryBuildInfo != nil {
+		var minor int
+		if _, err := fmt.Sscanf(binaryBuildInfo.GoVersion, "go1.%d", &minor); err == nil {
+			return minor >= 17
+		}
+	}
+	for _, name := range []string{"runtime.exit.abi0", "runtime.morestack_noctxt.abi0"} {
+		if symbolTable.LookupFunc(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// assignRegisters assigns the parts of a value of type typ at offset to the
+// next free registers, as the calling convention does.  It returns false,
+// leaving the counts as they were, when the value has to go on the stack.
+func assignRegisters(typ dwarf.Type, offset int64, ints, fps *int, pieces *[]abiPiece) bool {
+	savedInts, savedFloats, savedPieces := *ints, *fps, len(*pieces)
+	fail := func() bool {
+		*ints, *fps, *pieces = savedInts, savedFloats, (*pieces)[:savedPieces]
+		return false
+	}
+	switch t := typ.(type) {
+	case *dwarf.FloatType:
+		if *fps >= abiFloatRegisters {
+			return fail()
+		}
+		*pieces = append(*pieces, abiPiece{offset: offset, size: t.ByteSize, float: true, reg: *fps})
+		*fps++
+	case *dwarf.ComplexType:
+		half := t.ByteSize / 2
+		if *fps+2 > abiFloatRegisters {
+			return fail()
+		}
+		*pieces = append(*pieces,
+			abiPiece{offset: offset, size: half, float: true, reg: *fps},
+			abiPiece{offset: offset + half, size: half, float: true, reg: *fps + 1})
+		*fps += 2
+	case *dwarf.StructType:
+		for _, field := range t.Field {
+			if !assignRegisters(resolveTypedef(field.Type), offset+field.ByteOffset, ints, fps, pieces) {
+				return fail()
+			}
+		}
+	case *dwarf.ArrayType:
+		switch t.Count {
+		case 0:
+		case 1:
+			if !assignRegisters(resolveTypedef(t.Type), offset, ints, fps, pieces) {
+				return fail()
+			}
+		default:
+			return fail()
+		}
+	case *dwarf.TypedefType:
+		return assignRegisters(resolveTypedef(t), offset, ints, fps, pieces)
+	default:
+		// Integers, booleans, pointers, maps, channels and functions.
+		if typ.Size() == 0 {
+			return true
+		}
+		if *ints >= abiIntRegisters || typ.Size() > 8 {
+			return fail()
+		}
+		*pieces = append(*pieces, abiPiece{offset: offset, size: typ.Size(), reg: *ints})
+		*ints++
+	}
+	return true
+}
+
+func hasFloatPiece(pieces []abiPiece) bool {
+	for _, piece := range pieces {
+		if piece.float {
+			return true
+		}
+	}
+	return false
+}
+
+// registerValue puts a register-assigned value back together.
+func registerValue(typ dwarf.Type, pieces []abiPiece, regs *syscall.PtraceRegs, floats []uint64) *value {
+	intRegisters := []uint64{regs.Rax, regs.Rbx, regs.Rcx, regs.Rdi, regs.Rsi, regs.R8, regs.R9, regs.R10, regs.R11}
+	data := make([]byte, typ.Size())
+	word := make([]byte, 8)
+	for _, piece := range pieces {
+		if piece.float {
+			binary.LittleEndian.PutUint64(word, floats[piece.reg])
+		} else {
+			binary.LittleEndian.PutUint64(word, intRegisters[piece.reg])
+		}
+		copy(data[piece.offset:piece.offset+piece.size], word)
+	}
+	return &value{typ: typ, data: data}
+}
+
+// readFloatRegisters returns the low 8 bytes of each of X0 to X14, from the
+// FXSAVE area PTRACE_GETFPREGS fills in.
+func readFloatRegisters(pid int) ([]uint64, error) {
+	area, err := readFXSave(pid)
+	if err != nil {
+		return nil, err
+	}
+	floats := make([]uint64, abiFloatRegisters)
+	for i := range floats {
+		floats[i] = binary.LittleEndian.Uint64(area[fxsaveXMMOffset+16*i:])
+	}
+	return floats, nil
+}
+
+// typeAlignment is the alignment Go gives a value of typ on amd64.
+func typeAlignment(typ dwarf.Type) int64 {
+	switch t := resolveTypedef(typ).(type) {
+	case *dwarf.StructType:
+		align := int64(1)
+		for _, field := range t.Field {
+			if a := typeAlignment(field.Type); a > align {
+				align = a
+			}
+		}
+		return align
+	case *dwarf.ArrayType:
+		return typeAlignment(t.Type)
+	case *dwarf.ComplexType:
+		return t.ByteSize / 2
+	}
+	if size := typ.Size(); size > 0 && size < 8 {
+		return size
+	}
+	return 8
+}
+
+func alignOffset(offset, align int64) int64 {
+	return (offset + align - 1) / align * align
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// gcFunctions are the phase boundaries catch gc stops at: gcStart when a
+// cycle is triggered, before the sweep termination pause, and gcMarkDone
+// when marking is done, before the mark termination pause.  Both are broken
+// at past their prologue, which a stack that has to grow first runs twice.
+var gcFunctions = map[string]string{"runtime.gcStart": "start", "runtime.gcMarkDone": "end"}
+
+var (
+	// catchGC is the phases "catch gc" stops at, start, end or both, or
+	// empty when it is off.  It survives restarts.
+	catchGC string
+
+	// gcCatchAddresses are the prologue ends of gcFunctions, with their phase,
+	// while the catch is armed.
+	gcCatchAddresses = make(map[uint64]string)
+
+	// gcStartedCycle and gcMarkedCycle are the last cycles catch gc stopped
+	// for at gcStart and gcMarkDone, which may each be called more than
+	// once for a cycle: gcStart by other goroutines allocating, the mark
+	// workers its first call starts among them, and gcMarkDone by each.
+	gcStartedCycle, gcMarkedCycle uint64
+)
+
+// The runtime's gcphase while no cycle is running and while marking.
+const (
+	gcPhaseOff  = 0
+	gcPhaseMark = 1
+)
+
+// runCatchGCCommand implements "catch gc [start|end]" and "catch gc off".
+func runCatchGCCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	switch argument {
+	case "off":
+		disarmCatcher(pid, gcCatchAddresses)
+		catchGC = ""
+		fmt.Println("Catchpoint on garbage collection removed.")
+		return nil
+	case "":
+		argument = "both"
+	case "start", "end":
+	default:
+		return fmt.Errorf("usage: catch gc [start|end|off]")
+	}
+	disarmCatcher(pid, gcCatchAddresses)
+	catchGC = argument
+	if err := armGCCatcher(pid, symbolTable); err != nil {
+		catchGC = ""
+		return err
+	}
+	switch argument {
+	case "start":
+		fmt.Println("Catchpoint on garbage collection cycles starting.")
+	case "end":
+		fmt.Println("Catchpoint on garbage collection cycles finishing marking.")
+	default:
+		fmt.Println("Catchpoint on garbage collection cycles starting and finishing marking.")
+	}
+	return nil
+}
+
+// armGCCatcher puts internal breakpoints on the gcFunctions of the phases
+// catchGC names.
+func armGCCatcher(pid int, symbolTable *gosym.Table) error {
+	for name, phase := range gcFunctions {
+		if catchGC != "both" && catchGC != phase {
+			continue
+		}
+		fn := symbolTable.LookupFunc(name)
+		if fn == nil {
+			return fmt.Errorf("no %v in the binary", name)
+		}
+		address := prologueEnd(fn, symbolTable)
+		if err := setBreakpoint(pid, address); err != nil {
+			return err
+		}
+		gcCatchAddresses[address] = phase
+	}
+	return nil
+}
+
+// gcStopWanted reports whether a thread at one of gcCatchAddresses is at a
+// phase boundary for catch gc to stop at: the first gcStart of a cycle with
+// none running, or the first gcMarkDone of a cycle still marking.
+func gcStopWanted(tid int, phase string) bool {
+	gcphase, err := readGlobal(tid, "runtime.gcphase")
+	if err != nil {
+		return true
+	}
+	work, err := readGlobal(tid, "runtime.work")
+	if err != nil {
+		return true
+	}
+	cycle, last, want := scalarMember(work, "cycles"), &gcMarkedCycle, uint64(gcPhaseMark)
+	if phase == "start" {
+		// work.cycles counts the cycle once gcStart has begun it.
+		cycle, last, want = cycle+1, &gcStartedCycle, gcPhaseOff
+	}
+	if zeroExtend(gcphase.data) != want || cycle == *last {
+		return false
+	}
+	*last = cycle
+	return true
+}
+
+// showGCStop reports a stop at a phase boundary with what the collector
+// decides by: the heap in use against its goal, what the last cycle left
+// marked, and the pauses so far, from which the next may be guessed.
+func showGCStop(pid int, symbolTable *gosym.Table) {
+	phase := gcCatchAddresses[getPC(pid)]
+	controller, err := readGlobal(pid, "runtime.gcController")
+	if err != nil {
+		fmt.Printf("\nGarbage collection %v: %v\n", phase, err)
+		return
+	}
+	cycles := uint64(0)
+	if work, err := readGlobal(pid, "runtime.work"); err == nil {
+		cycles = scalarMember(work, "cycles")
+	}
+	if phase == "start" {
+		fmt.Printf("\nGarbage collection cycle %v starting.\n", cycles+1)
+	} else {
+		fmt.Printf("\nGarbage collection cycle %v finished marking.\n", cycles)
+	}
+
+	live, marked := scalarMember(controller, "heapLive"), scalarMember(controller, "heapMarked")
+	goal := scalarMember(controller, "gcPercentHeapGoal")
+	fmt.Printf("  heap live %v, goal %v (GOGC=%v), marked by the last cycle %v\n",
+		formatHeapSize(live), formatHeapSize(goal), int32(scalarMember(controller, "gcPercent")), formatHeapSize(marked))
+	if limit := scalarMember(controller, "memoryLimit"); member(controller, "memoryLimit") != nil && limit != math.MaxInt64 {
+		fmt.Printf("  memory limit %v, which may lower the goal\n", formatHeapSize(limit))
+	}
+	if stats, err := readGlobal(pid, "runtime.memstats"); err == nil {
+		showGCPauses(stats)
+	}
+
+	if frame, ok := selectFrameOutside(pid, symbolTable, "runtime.", "internal/"); ok {
+		how := "Triggered"
+		if phase == "end" {
+			how = "Marking finished"
+		}
+		fmt.Printf("%v from %v at %v:%v, frame #%v.\n", how, frame.fn.Name, frame.file, frame.line, selection.index)
+		showListing(frame.file, frame.line)
+	}
+}
+
+// showGCPauses prints the last stop-the-world pause and the average of those
+// memstats remembers, up to 256.
+func showGCPauses(stats *value) {
+	count := scalarMember(stats, "numgc")
+	pauses := member(stats, "pause_ns")
+	if count == 0 || pauses == nil {
+		fmt.Println("  no cycle has finished yet")
+		return
+	}
+	n := uint64(len(pauses.data) / 8)
+	last := binary.LittleEndian.Uint64(pauses.data[(count+n-1)%n*8:])
+	remembered := count
+	if remembered > n {
+		remembered = n
+	}
+	total := uint64(0)
+	for i := uint64(0); i < remembered; i++ {
+		total += binary.LittleEndian.Uint64(pauses.data[(count+n-1-i)%n*8:])
+	}
+	fmt.Printf("  cycles finished %v, last pause %v, average pause %v over the last %v\n",
+		count, time.Duration(last), time.Duration(total/remembered), remembered)
+}
+
+// formatHeapSize gives a size in the largest binary unit under it.
+func formatHeapSize(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%vB", n)
+}
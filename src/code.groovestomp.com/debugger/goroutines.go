@@ -0,0 +1,463 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Goroutine states from the runtime's runtime2.go.  _Gscan is a bit that is
+// or'ed into the others while the garbage collector scans the stack.
+const (
+	goroutineIdle      = 0
+	goroutineRunnable  = 1
+	goroutineRunning   = 2
+	goroutineSyscall   = 3
+	goroutineWaiting   = 4
+	goroutineDead      = 6
+	goroutineCopystack = 8
+	goroutinePreempted = 9
+	goroutineScan      = 0x1000
+)
+
+const maxGoroutines = 100000
+
+var goroutineStatusNames = map[uint64]string{
+	goroutineIdle:      "idle",
+	goroutineRunnable:  "runnable",
+	goroutineRunning:   "running",
+	goroutineSyscall:   "syscall",
+	goroutineWaiting:   "waiting",
+	goroutineDead:      "dead",
+	goroutineCopystack: "copystack",
+	goroutinePreempted: "preempted",
+}
+
+// goroutine is the part of a runtime.g the debugger works with.
+type goroutine struct {
+	id         uint64
+	address    uint64
+	status     uint64
+	waitReason string
+
+	// pc, sp and bp are the context saved when the goroutine was last
+	// descheduled; they are stale while it runs.
+	pc, sp, bp uint64
+
+	// waits are what a waiting goroutine is blocked on, from its list of
+	// sudogs: a channel, or the semaphore behind a sync primitive.
+	waits []waitObject
+}
+
+// waitObject is one sudog of a blocked goroutine.
+type waitObject struct {
+	sudog    uint64
+	address  uint64
+	channel  bool
+	isSelect bool
+}
+
+func (g *goroutine) statusName() string {
+	if name, ok := goroutineStatusNames[g.status&^goroutineScan]; ok {
+		return name
+	}
+	return fmt.Sprintf("status %v", g.status)
+}
+
+// state is the reason shown for a goroutine: why it waits, or its status.
+func (g *goroutine) state() string {
+	if g.status&^goroutineScan == goroutineWaiting && g.waitReason != "" {
+		return g.waitReason
+	}
+	return g.statusName()
+}
+
+// readGoroutines returns every goroutine in runtime.allgs that is not dead.
+func readGoroutines(pid int) ([]*goroutine, error) {
+	allgs, err := readGlobal(pid, "runtime.allgs")
+	if err != nil {
+		return nil, err
+	}
+	pointers, err := sliceElements(pid, allgs, maxGoroutines)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*goroutine
+	for _, pointer := range pointers {
+		gv, err := dereference(pid, pointer)
+		if err != nil {
+			continue
+		}
+		g := readGoroutine(pid, gv)
+		if g.status&^goroutineScan != goroutineDead {
+			list = append(list, g)
+		}
+	}
+	return list, nil
+}
+
+func readGoroutine(pid int, gv *value) *goroutine {
+	g := &goroutine{
+		id:      scalarMember(gv, "goid"),
+		address: gv.addr,
+		status:  scalarMember(gv, "atomicstatus"),
+		pc:      scalarMember(gv, "sched", "pc"),
+		sp:      scalarMember(gv, "sched", "sp"),
+		bp:      scalarMember(gv, "sched", "bp"),
+	}
+	if g.status&^goroutineScan == goroutineWaiting {
+		g.waitReason = waitReasonName(pid, scalarMember(gv, "waitreason"))
+	}
+
+	// g.waiting links the sudogs of a channel operation or select through
+	// waitlink; a semaphore wait has a single one.
+	sudog := scalarMember(gv, "waiting")
+	for i := 0; sudog != 0 && i < 1024; i++ {
+		sv, err := readRuntimeStruct(pid, "runtime.sudog", sudog)
+		if err != nil {
+			break
+		}
+		object := waitObject{
+			sudog:    sudog,
+			address:  scalarMember(sv, "c"),
+			channel:  true,
+			isSelect: scalarMember(sv, "isSelect") != 0,
+		}
+		if object.address == 0 {
+			object.address = scalarMember(sv, "elem")
+			object.channel = false
+		}
+		g.waits = append(g.waits, object)
+		sudog = scalarMember(sv, "waitlink")
+	}
+	return g
+}
+
+func waitReasonName(pid int, reason uint64) string {
+	names, err := readGlobal(pid, "runtime.waitReasonStrings")
+	if err != nil {
+		return fmt.Sprintf("wait reason %v", reason)
+	}
+	array, ok := resolveTypedef(names.typ).(*dwarf.ArrayType)
+	if !ok || int64(reason) >= array.Count {
+		return fmt.Sprintf("wait reason %v", reason)
+	}
+	size := array.Type.Size()
+	elem := &value{typ: array.Type, data: names.data[int64(reason)*size : int64(reason+1)*size]}
+	name, _, err := stringContents(pid, elem)
+	if err != nil || name == "" {
+		return fmt.Sprintf("wait reason %v", reason)
+	}
+	return name
+}
+
+// findGoroutine looks up a goroutine by id.
+func findGoroutine(pid int, id uint64) (*goroutine, error) {
+	list, err := readGoroutines(pid)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range list {
+		if g.id == id {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("no goroutine %v", id)
+}
+
+// goroutineBacktrace unwinds a goroutine's stack from its saved context.
+func goroutineBacktrace(pid int, g *goroutine, symbolTable *gosym.Table) []stackFrame {
+	regs := syscall.PtraceRegs{Rip: g.pc, Rsp: g.sp, Rbp: g.bp}
+	return unwind(pid, symbolTable, &regs, maxStackDepth)
+}
+
+// userFrame picks the innermost frame outside the standard library, which
+// is where a goroutine blocked from its own point of view.  The library's
+// source directory is found from the runtime's frames.
+func userFrame(frames []stackFrame) int {
+	library := ""
+	for _, frame := range frames {
+		if i := strings.LastIndex(frame.file, "/runtime/"); strings.HasPrefix(frame.fn.Name, "runtime.") && i >= 0 {
+			library = frame.file[:i+1]
+			break
+		}
+	}
+	for i, frame := range frames {
+		if strings.HasPrefix(frame.fn.Name, "runtime.") || library != "" && strings.HasPrefix(frame.file, library) {
+			continue
+		}
+		return i
+	}
+	return 0
+}
+
+func readGlobal(pid int, name string) (*value, error) {
+	global, ok := globals[name]
+	if !ok {
+		return nil, fmt.Errorf("no variable named %v in the debug information", name)
+	}
+	return readVariable(pid, global.entry, global.unit, stackFrame{}, 0, nil)
+}
+
+// readRuntimeStruct reads the runtime structure of the named type at address.
+func readRuntimeStruct(pid int, typeName string, address uint64) (*value, error) {
+	typ, err := lookupType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if typ.Size() <= 0 {
+		return nil, fmt.Errorf("%v has no size", typeName)
+	}
+	data, err := readMemory(pid, address, int(typ.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return &value{typ: typ, addr: address, data: data}, nil
+}
+
+// member follows a path of field names through nested structures.
+func member(v *value, names ...string) *value {
+	for _, name := range names {
+		st, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+		if !ok {
+			return nil
+		}
+		field := structField(st, name)
+		if field == nil {
+			return nil
+		}
+		if v = fieldValue(v, field); v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+// scalarMember reads an integer or pointer field.  Fields the runtime wraps
+// in a struct, like atomic.Uint32 or maybeTraceablePtr, are unwrapped to
+// the word inside.
+func scalarMember(v *value, names ...string) uint64 {
+	v = member(v, names...)
+	for v != nil {
+		st, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+		if !ok {
+			return zeroExtend(v.data)
+		}
+		if len(st.Field) == 0 {
+			return 0
+		}
+		next := member(v, "value")
+		if next == nil {
+			next = member(v, "vu")
+		}
+		if next == nil {
+			next = fieldValue(v, st.Field[len(st.Field)-1])
+		}
+		v = next
+	}
+	return 0
+}
+
+// sliceElements reads up to limit elements of a slice.
+func sliceElements(pid int, v *value, limit int64) ([]*value, error) {
+	st, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+	if !ok || !strings.HasPrefix(typeName(v.typ), "[]") {
+		return nil, fmt.Errorf("%v is not a slice", typeName(v.typ))
+	}
+	arrayField := structField(st, "array")
+	lenField := structField(st, "len")
+	if arrayField == nil || lenField == nil {
+		return nil, errors.New("malformed slice type")
+	}
+	elemType := arrayField.Type.(*dwarf.PtrType).Type
+	address := zeroExtend(fieldValue(v, arrayField).data)
+	length := signExtend(fieldValue(v, lenField).data)
+	if length > limit {
+		length = limit
+	}
+	size := elemType.Size()
+	if length <= 0 || size <= 0 {
+		return nil, nil
+	}
+	data, err := readMemory(pid, address, int(length*size))
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]*value, length)
+	for i := range elements {
+		start := int64(i) * size
+		elements[i] = &value{typ: elemType, addr: address + uint64(start), data: data[start : start+size]}
+	}
+	return elements, nil
+}
+
+// currentGoroutine returns the address of the runtime.g a thread is
+// running, which the runtime keeps just below the thread's TLS base.
+func currentGoroutine(tid int) uint64 {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(tid, &regs); err != nil {
+		return 0
+	}
+	address, err := readUint64(tid, regs.Fs_base-8)
+	if err != nil {
+		return 0
+	}
+	return address
+}
+
+// currentGoroutineID returns the id of the goroutine a thread is running,
+// or zero when it can't be read.
+func currentGoroutineID(tid int) uint64 {
+	g, err := readRuntimeStruct(tid, "runtime.g", currentGoroutine(tid))
+	if err != nil {
+		return 0
+	}
+	return scalarMember(g, "goid")
+}
+
+// goroutineThread returns the stopped thread running g, or zero when g is
+// not on a thread.
+func goroutineThread(g *goroutine) int {
+	for _, t := range sortedThreads() {
+		if t.stopped && currentGoroutine(t.tid) == g.address {
+			return t.tid
+		}
+	}
+	return 0
+}
+
+// goroutineFrames unwinds a goroutine from the live registers of the thread
+// running it, or from its saved context when it is descheduled.
+func goroutineFrames(pid int, g *goroutine, symbolTable *gosym.Table) []stackFrame {
+	if tid := goroutineThread(g); tid != 0 {
+		return backtrace(tid, symbolTable)
+	}
+	return goroutineBacktrace(pid, g, symbolTable)
+}
+
+// showGoroutines implements "goroutines", listing each goroutine with where
+// it is from its own point of view.  The selected goroutine, normally the
+// current thread's, is marked with *.
+func showGoroutines(pid int, symbolTable *gosym.Table) error {
+	list, err := readGoroutines(pid)
+	if err != nil {
+		return err
+	}
+	if _, _, _, err := selectedStack(pid); err != nil {
+		return err
+	}
+	current := currentGoroutine(pid)
+	if selection.goroutine != 0 {
+		current = selection.goroutine
+	}
+	for _, g := range list {
+		if err := commandCancelled(); err != nil {
+			return err
+		}
+		marker := " "
+		if g.address == current {
+			marker = "*"
+		}
+		fmt.Printf("%v %-4v [%v]", marker, g.id, g.state())
+		frames := goroutineFrames(pid, g, symbolTable)
+		if len(frames) > 0 {
+			frame := frames[userFrame(frames)]
+			fmt.Printf(" %v:%v in %v", frame.file, frame.line, frame.fn.Name)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// showGoroutine implements "goroutine <id>": its state, what exactly it is
+// blocked on, and its stack.  It also selects the goroutine, so that bt,
+// frame, list and print work in it until the program moves again.
+func showGoroutine(pid int, argument string, symbolTable *gosym.Table) error {
+	id, err := strconv.ParseUint(argument, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid goroutine id %q", argument)
+	}
+	g, err := findGoroutine(pid, id)
+	if err != nil {
+		return err
+	}
+	if err := selectGoroutine(pid, g); err != nil {
+		return err
+	}
+	frames := goroutineFrames(pid, g, symbolTable)
+
+	fmt.Printf("Goroutine %v [%v]", g.id, g.state())
+	if tid := goroutineThread(g); tid != 0 {
+		fmt.Printf(" on thread %v", tid)
+	}
+	fmt.Println(":")
+	if len(g.waits) > 0 {
+		wanted := make(map[uint64]bool)
+		for _, w := range g.waits {
+			wanted[w.address] = true
+		}
+		references := stackReferences(pid, frames[userFrame(frames):], wanted)
+		if g.waits[0].isSelect {
+			fmt.Printf("  Waiting in a select on %v cases:\n", len(g.waits))
+		}
+		for _, w := range g.waits {
+			fmt.Printf("    %v\n", describeWait(pid, w, references))
+		}
+	}
+	showBacktrace(frames)
+	return nil
+}
+
+// describeWait explains one sudog: the operation, the object and, when a
+// variable on the goroutine's stack refers to it, that variable.
+func describeWait(pid int, w waitObject, references map[uint64]reference) string {
+	name := ""
+	if ref, ok := references[w.address]; ok {
+		name = fmt.Sprintf(" (%v)", ref)
+	}
+	if !w.channel {
+		return fmt.Sprintf("semaphore 0x%x%v", w.address, name)
+	}
+
+	ch, err := readRuntimeStruct(pid, "runtime.hchan", w.address)
+	if err != nil {
+		return fmt.Sprintf("channel 0x%x%v: %v", w.address, name, err)
+	}
+	operation := "waiting on"
+	switch {
+	case waitQueueContains(pid, member(ch, "recvq"), w.sudog):
+		operation = "receive from"
+	case waitQueueContains(pid, member(ch, "sendq"), w.sudog):
+		operation = "send to"
+	}
+	state := fmt.Sprintf("len %v, cap %v", scalarMember(ch, "qcount"), scalarMember(ch, "dataqsiz"))
+	if scalarMember(ch, "closed") != 0 {
+		state += ", closed"
+	}
+	return fmt.Sprintf("%v channel 0x%x%v, %v", operation, w.address, name, state)
+}
+
+// waitQueueContains reports whether a channel's send or receive queue holds
+// the given sudog.
+func waitQueueContains(pid int, queue *value, sudog uint64) bool {
+	if queue == nil {
+		return false
+	}
+	next := scalarMember(queue, "first")
+	for i := 0; next != 0 && i < maxGoroutines; i++ {
+		if next == sudog {
+			return true
+		}
+		sv, err := readRuntimeStruct(pid, "runtime.sudog", next)
+		if err != nil {
+			return false
+		}
+		next = scalarMember(sv, "next")
+	}
+	return false
+}
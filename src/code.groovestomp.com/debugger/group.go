@@ -0,0 +1,178 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// groupMembers are the Go processes of the process group or cgroup given
+// with -attach-pgid or -attach-cgroup, by pid.  The debugger is attached to
+// one of them at a time; target moves it to another, leaving the one before
+// running as if it had never been stopped.  The rest of the debugger holds
+// one tracee, so the members are never attached to together, nor shown as
+// one.
+var groupMembers []int
+
+// findGroup lists the Go processes, other than the debugger, in process
+// group pgid or in the cgroup at path, which is under /sys/fs/cgroup unless
+// it says otherwise.
+func findGroup(pgid int, cgroup string) ([]int, error) {
+	var pids []int
+	switch {
+	case cgroup != "":
+		if !strings.HasPrefix(cgroup, "/sys/fs/cgroup/") {
+			cgroup = filepath.Join("/sys/fs/cgroup", cgroup)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range strings.Fields(string(data)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	default:
+		names, err := ioutil.ReadDir("/proc")
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range names {
+			pid, err := strconv.Atoi(info.Name())
+			if err == nil && processGroup(pid) == pgid {
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	var members []int
+	for _, pid := range pids {
+		if pid != os.Getpid() && isGoProcess(pid) {
+			members = append(members, pid)
+		}
+	}
+	sort.Ints(members)
+	if len(members) == 0 {
+		if cgroup != "" {
+			return nil, fmt.Errorf("no Go processes in cgroup %v", cgroup)
+		}
+		return nil, fmt.Errorf("no Go processes in process group %v", pgid)
+	}
+	return members, nil
+}
+
+// showGoProcesses implements "ps": it lists the Go processes here, other
+// than the debugger, each with its executable, for finding the one to
+// attach to where there is no ps, as in many containers.
+func showGoProcesses() {
+	names, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var pids []int
+	for _, info := range names {
+		if pid, err := strconv.Atoi(info.Name()); err == nil && pid != os.Getpid() && isGoProcess(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+		fmt.Printf("%v %v\n", pid, exe)
+	}
+}
+
+// processGroup reads the process group of pid from /proc, or returns -1.
+func processGroup(pid int) int {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return -1
+	}
+	// The command name in parentheses may have spaces in it; the state,
+	// parent and process group follow it.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 3 {
+		return -1
+	}
+	pgrp, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return -1
+	}
+	return pgrp
+}
+
+// isGoProcess reports whether the executable of pid has a Go symbol table.
+func isGoProcess(pid int) bool {
+	exe, err := elf.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return false
+	}
+	defer exe.Close()
+	return exe.Section(".gopclntab") != nil
+}
+
+// showTargets lists the processes of the group, marking the one attached.
+func showTargets() {
+	if len(groupMembers) == 0 {
+		fmt.Println("Not attached to a process group; see -attach-pgid and -attach-cgroup.")
+		return
+	}
+	for _, pid := range groupMembers {
+		mark := " "
+		if pid == processID {
+			mark = "*"
+		}
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+		if err != nil {
+			exe = "(exited)"
+		}
+		fmt.Printf("%v %-7v %v\n", mark, pid, exe)
+	}
+	fmt.Println("Only the current target is attached to; the others run without breakpoints.")
+}
+
+// switchTarget detaches from the process being debugged and attaches to
+// member, another in the group, loading its binary and setting every
+// breakpoint and watchpoint again there.
+func switchTarget(pid int, member int, exe *elf.File, symbolTable *gosym.Table) (int, *elf.File, *gosym.Table, error) {
+	found := false
+	for _, m := range groupMembers {
+		found = found || m == member
+	}
+	if !found {
+		return pid, exe, symbolTable, fmt.Errorf("%v is not one of the targets", member)
+	}
+	if member == processID {
+		return pid, exe, symbolTable, fmt.Errorf("already attached to %v", member)
+	}
+	if err := syscall.Kill(member, 0); err != nil {
+		return pid, exe, symbolTable, fmt.Errorf("%v: %v", member, err)
+	}
+
+	if running {
+		interruptBackground(pid, symbolTable)
+	}
+	detachThreads()
+	stopReaper()
+	exe.Close()
+
+	exe, symbolTable, err := reloadBinary(fmt.Sprintf("/proc/%d/exe", member))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	forgetInsertions()
+	attachTracee(member)
+	rearm(member, symbolTable)
+	pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(member))
+	return currentThread, exe, symbolTable, nil
+}
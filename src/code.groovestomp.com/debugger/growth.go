@@ -0,0 +1,254 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// growthFunction is a runtime function a slice or map grows through, with the
+// register holding the slice's old array or the map at its entry.
+type growthFunction struct {
+	name     string
+	register string
+	isMap    bool
+}
+
+// growthFunctions are where slices and maps get bigger backing stores:
+// growslice for append, the Swiss table maps of Go 1.24 and later growing
+// from one group to a table, a table growing or splitting, and hashGrow
+// for the maps before them.
+var growthFunctions = []growthFunction{
+	{"runtime.growslice", "rax", false},
+	{"internal/runtime/maps.(*Map).growToSmall", "rax", true},
+	{"internal/runtime/maps.(*Map).growToTable", "rax", true},
+	{"internal/runtime/maps.(*table).grow", "rcx", true},
+	{"internal/runtime/maps.(*table).split", "rcx", true},
+	{"runtime.hashGrow", "rbx", true},
+}
+
+// growthWatch is a slice or map watched with watch -growth.  Like a
+// watchpoint, it stays on the memory its expression named when set; the
+// slice header or map pointer there is read at each growth, so it follows
+// the variable to the arrays append moves it to.
+type growthWatch struct {
+	name    string
+	address uint64
+	value   *value // The variable as set, for its type.
+	isMap   bool
+}
+
+var (
+	growthWatches []*growthWatch
+
+	// growthAddresses are the entries of the growthFunctions the binary
+	// has while any growth is watched, mapped to the functions' names.
+	growthAddresses = make(map[uint64]string)
+
+	// grownWatch is the watch the program stopped for.
+	grownWatch *growthWatch
+)
+
+// runWatchGrowthCommand implements "watch -growth <map-or-slice>".
+func runWatchGrowthCommand(pid int, name string, symbolTable *gosym.Table) error {
+	if name == "" {
+		return fmt.Errorf("usage: watch -growth <map-or-slice>")
+	}
+	for _, g := range growthWatches {
+		if g.name == name || g.name == "main."+name {
+			return fmt.Errorf("the growth of %v is already watched", g.name)
+		}
+	}
+	g, err := newGrowthWatch(pid, name, symbolTable)
+	if err != nil {
+		return err
+	}
+	if len(growthAddresses) == 0 {
+		if err := armGrowthCatcher(pid, symbolTable); err != nil {
+			return err
+		}
+	}
+	growthWatches = append(growthWatches, g)
+	fmt.Printf("Watching the growth of %v at 0x%x: %v\n", g.name, g.address, g.describe(pid))
+	if backing, _ := g.backing(pid); backing == 0 && !g.isMap {
+		fmt.Println("It has no array yet; the append that allocates one can't be told from others, so growth is watched from the next.")
+	}
+	return nil
+}
+
+func newGrowthWatch(pid int, name string, symbolTable *gosym.Table) (*growthWatch, error) {
+	var v *value
+	var err error
+	for _, candidate := range []string{name, "main." + name} {
+		if _, ok := globals[candidate]; ok {
+			name = candidate
+			v, err = readGlobal(pid, candidate)
+			break
+		}
+	}
+	if v == nil && err == nil {
+		var ctx *evalContext
+		if ctx, err = newEvalContext(pid, symbolTable); err == nil {
+			v, err = evaluate(ctx, name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	typ := typeName(v.typ)
+	if !strings.HasPrefix(typ, "[]") && !strings.HasPrefix(typ, "map[") {
+		return nil, fmt.Errorf("%v is a %v; -growth watches slices and maps", name, typ)
+	}
+	if v.addr == 0 {
+		return nil, fmt.Errorf("%v has no address to watch", name)
+	}
+	g := &growthWatch{name: name, address: v.addr, value: v, isMap: strings.HasPrefix(typ, "map[")}
+	if backing, _ := g.backing(pid); backing == 0 && g.isMap {
+		return nil, fmt.Errorf("%v is a nil map, which never grows", name)
+	}
+	return g, nil
+}
+
+// read reads the slice header or map pointer again.
+func (g *growthWatch) read(pid int) (*value, error) {
+	data, err := readMemory(pid, g.address, len(g.value.data))
+	if err != nil {
+		return nil, err
+	}
+	return &value{typ: g.value.typ, addr: g.address, data: data}, nil
+}
+
+// backing returns the slice's array or the map's runtime header.
+func (g *growthWatch) backing(pid int) (uint64, error) {
+	v, err := g.read(pid)
+	if err != nil {
+		return 0, err
+	}
+	if g.isMap {
+		return zeroExtend(v.data), nil
+	}
+	return scalarMember(v, "array"), nil
+}
+
+// describe gives the length and capacity of a slice, or how many entries a
+// map has.
+func (g *growthWatch) describe(pid int) string {
+	v, err := g.read(pid)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	if !g.isMap {
+		return fmt.Sprintf("len %v, cap %v", int64(scalarMember(v, "len")), int64(scalarMember(v, "cap")))
+	}
+	header, err := dereference(pid, v)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	for _, field := range []string{"used", "count"} {
+		if member(header, field) != nil {
+			return fmt.Sprintf("%v entries", scalarMember(header, field))
+		}
+	}
+	return formatValue(pid, v)
+}
+
+// armGrowthCatcher puts internal breakpoints on the growthFunctions.
+func armGrowthCatcher(pid int, symbolTable *gosym.Table) error {
+	for _, f := range growthFunctions {
+		fn := symbolTable.LookupFunc(f.name)
+		if fn == nil {
+			continue
+		}
+		if err := setBreakpoint(pid, fn.Entry); err != nil {
+			return err
+		}
+		growthAddresses[fn.Entry] = f.name
+	}
+	if len(growthAddresses) == 0 {
+		return fmt.Errorf("no runtime function slices or maps grow through was found")
+	}
+	return nil
+}
+
+// growthMatches reports whether a thread at one of growthAddresses, in the
+// growth function of this name, is growing a watched slice or map, and which.
+func growthMatches(tid int, name string) bool {
+	var f growthFunction
+	for _, f = range growthFunctions {
+		if f.name == name {
+			break
+		}
+	}
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(tid, &regs); err != nil {
+		return false
+	}
+	growing := map[string]uint64{"rax": regs.Rax, "rbx": regs.Rbx, "rcx": regs.Rcx}[f.register]
+	for _, g := range growthWatches {
+		if backing, err := g.backing(tid); err == nil && backing != 0 && backing == growing && g.isMap == f.isMap {
+			grownWatch = g
+			return true
+		}
+	}
+	return false
+}
+
+// isGrowthAddress reports whether pc is one of growthAddresses.
+func isGrowthAddress(pc uint64) bool {
+	_, ok := growthAddresses[pc]
+	return ok
+}
+
+// showGrowthStop reports a stop for a growing slice or map and selects the
+// frame that made it grow.
+func showGrowthStop(pid int, symbolTable *gosym.Table) {
+	g := grownWatch
+	detail := ""
+	if !g.isMap {
+		var regs syscall.PtraceRegs
+		if err := ptraceGetRegs(pid, &regs); err == nil {
+			detail = fmt.Sprintf(", appending %v past its cap to len %v", int64(regs.Rdi), int64(regs.Rbx))
+		}
+	}
+	fmt.Printf("\n%v grows: %v%v\n", g.name, g.describe(pid), detail)
+	if frame, ok := selectFrameOutside(pid, symbolTable, "runtime.", "internal/runtime/"); ok {
+		fmt.Printf("Grown by %v at %v:%v, frame #%v.\n", frame.fn.Name, frame.file, frame.line, selection.index)
+		showListing(frame.file, frame.line)
+	}
+}
+
+// runUnwatchGrowth removes a growth watch, reporting false if there is none
+// of this name.
+func runUnwatchGrowth(pid int, name string) bool {
+	for i, g := range growthWatches {
+		if g.name == name || g.name == "main."+name {
+			growthWatches = append(growthWatches[:i], growthWatches[i+1:]...)
+			if len(growthWatches) == 0 {
+				disarmCatcher(pid, growthAddresses)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// rearmGrowthWatches evaluates the growth watches again in a new tracee.
+func rearmGrowthWatches(pid int, symbolTable *gosym.Table) {
+	previous := growthWatches
+	growthWatches = nil
+	for _, old := range previous {
+		g, err := newGrowthWatch(pid, old.name, symbolTable)
+		if err != nil {
+			fmt.Printf("  watch -growth %v: %v, removed\n", old.name, err)
+			continue
+		}
+		growthWatches = append(growthWatches, g)
+	}
+	if len(growthWatches) > 0 {
+		if err := armGrowthCatcher(pid, symbolTable); err != nil {
+			fmt.Printf("  watch -growth: %v, removed\n", err)
+			growthWatches = nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+// addHardwareBreakpoint records a user breakpoint that a debug register
+// stops at instead of an INT3, so that no byte of the program's text is
+// changed.  It shares the four registers with the watchpoints.
+func addHardwareBreakpoint(pid int, file string, line int, pc uint64) error {
+	if findBreakpoint(pc) != nil {
+		return errBreakpointExists
+	}
+	if debugRegistersUsed() >= debugRegisters {
+		return fmt.Errorf("all %v debug registers are in use by hardware breakpoints and watchpoints", debugRegisters)
+	}
+	lastBreakpointID++
+	bp := &breakpoint{id: lastBreakpointID, spec: fmt.Sprintf("%v:%v", file, line), file: file, line: line, pc: pc, hardware: true}
+	breakpoints = append(breakpoints, bp)
+	if err := armWatchpoints(); err != nil {
+		breakpoints = breakpoints[:len(breakpoints)-1]
+		lastBreakpointID--
+		armWatchpoints()
+		return err
+	}
+	return nil
+}
+
+// hardwareBreakpoints returns the enabled hardware breakpoints, in the
+// order they take the debug registers after the watchpoints.
+func hardwareBreakpoints() []*breakpoint {
+	var list []*breakpoint
+	for _, bp := range breakpoints {
+		if bp.hardware && !bp.disabled {
+			list = append(list, bp)
+		}
+	}
+	return list
+}
+
+// debugRegistersUsed counts the debug registers the watchpoints and the
+// enabled hardware breakpoints take.
+func debugRegistersUsed() int {
+	used := len(hardwareBreakpoints())
+	for _, w := range watchpoints {
+		used += len(w.slots)
+	}
+	return used
+}
+
+// watchRegisterMask has a bit set for each debug register a watchpoint
+// takes; the hardware breakpoints have the ones above.
+func watchRegisterMask() uint64 {
+	used := 0
+	for _, w := range watchpoints {
+		used += len(w.slots)
+	}
+	return 1<<uint(used) - 1
+}
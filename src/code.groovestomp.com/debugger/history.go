@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The parts of perf_event_open(2) that sample the last branch records.
+const (
+	perfTypeHardware              = 0
+	perfCountHWBranchInstructions = 4
+
+	perfSampleIP          = 1 << 0
+	perfSampleTID         = 1 << 1
+	perfSampleBranchStack = 1 << 11
+
+	perfSampleBranchUser = 1 << 0
+	perfSampleBranchAny  = 1 << 3
+
+	perfAttrExcludeKernel = 1 << 5
+	perfAttrExcludeHV     = 1 << 6
+	perfAttrWriteBackward = 1 << 27
+
+	perfRecordSample = 9
+
+	// historyPages is the size of each thread's ring, in pages.  It only
+	// needs to hold the newest sample.
+	historyPages = 8
+
+	// defaultHistoryPeriod is how many branches apart samples are taken.
+	defaultHistoryPeriod = 1000
+)
+
+// perfEventAttr is struct perf_event_attr, as of PERF_ATTR_SIZE_VER5.
+type perfEventAttr struct {
+	Type             uint32
+	Size             uint32
+	Config           uint64
+	SamplePeriod     uint64
+	SampleType       uint64
+	ReadFormat       uint64
+	Bits             uint64
+	WakeupEvents     uint32
+	BpType           uint32
+	Config1          uint64
+	Config2          uint64
+	BranchSampleType uint64
+	SampleRegsUser   uint64
+	SampleStackUser  uint32
+	ClockID          int32
+	SampleRegsIntr   uint64
+	AuxWatermark     uint32
+	SampleMaxStack   uint16
+	_                uint16
+}
+
+// branchRing is a thread's perf event and the ring its samples go to.  The
+// kernel writes the ring backwards, overwriting the oldest samples, so the
+// newest one always starts at the head.
+type branchRing struct {
+	fd   int
+	ring []byte
+}
+
+// branch is one entry of a last branch record.
+type branch struct {
+	from, to uint64
+}
+
+var (
+	// historyPeriod is the number of branches between samples while
+	// "history on" is set, and zero otherwise.
+	historyPeriod uint64
+
+	// branchRings holds the perf event of every thread being sampled.
+	branchRings = make(map[int]*branchRing)
+)
+
+// runHistoryCommand implements
+//
+//	history on [<period>]
+//	history off
+//	history [<count>]
+func runHistoryCommand(argument string) error {
+	fields := strings.Fields(argument)
+	switch {
+	case len(fields) > 0 && fields[0] == "on":
+		period := uint64(defaultHistoryPeriod)
+		if len(fields) > 1 {
+			n, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil || n == 0 {
+				return fmt.Errorf("expected a number of branches, got %q", fields[1])
+			}
+			period = n
+		}
+		closeBranchRings()
+		historyPeriod = period
+		for tid := range threads {
+			if err := openBranchRing(tid); err != nil {
+				closeBranchRings()
+				historyPeriod = 0
+				return fmt.Errorf("branch history needs a CPU whose last branch records perf can sample: %v", err)
+			}
+		}
+		fmt.Printf("Sampling the last branch records every %v branches.\n", period)
+		return nil
+	case len(fields) > 0 && fields[0] == "off":
+		closeBranchRings()
+		historyPeriod = 0
+		return nil
+	}
+
+	if historyPeriod == 0 {
+		return fmt.Errorf("branch history is off; use history on")
+	}
+	count := -1
+	if len(fields) > 0 {
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("expected a number of branches, got %q", fields[0])
+		}
+		count = n
+	}
+	r := branchRings[currentThread]
+	if r == nil {
+		return fmt.Errorf("thread %v isn't being sampled", currentThread)
+	}
+	ip, branches, ok := r.newestSample()
+	if !ok {
+		fmt.Println("No branches sampled yet.")
+		return nil
+	}
+	if count < 0 || count > len(branches) {
+		count = len(branches)
+	}
+	fmt.Printf("The last %v branches taken before 0x%x%v, at most %v branches before the stop, newest first:\n",
+		count, ip, describePC(ip), historyPeriod)
+	for i, b := range branches[:count] {
+		fmt.Printf("  %3v  0x%x%v -> 0x%x%v\n", i+1, b.from, describePC(b.from), b.to, describePC(b.to))
+	}
+	return nil
+}
+
+// describePC names the function and line of an address, for a listing.
+func describePC(pc uint64) string {
+	file, line, fn := listingSymbols.PCToLine(pc)
+	if fn == nil {
+		if name, ok := nativeSymbol(pc); ok {
+			return fmt.Sprintf(" <%v>", name)
+		}
+		return ""
+	}
+	return fmt.Sprintf(" <%v+%v> %v:%v", fn.Name, pc-fn.Entry, file, line)
+}
+
+// openBranchRing starts sampling a thread's branches, if history is on.
+func openBranchRing(tid int) error {
+	if historyPeriod == 0 || branchRings[tid] != nil {
+		return nil
+	}
+	attr := perfEventAttr{
+		Type:             perfTypeHardware,
+		Config:           perfCountHWBranchInstructions,
+		SamplePeriod:     historyPeriod,
+		SampleType:       perfSampleIP | perfSampleTID | perfSampleBranchStack,
+		Bits:             perfAttrExcludeKernel | perfAttrExcludeHV | perfAttrWriteBackward,
+		BranchSampleType: perfSampleBranchUser | perfSampleBranchAny,
+	}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+	fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&attr)), uintptr(tid), ^uintptr(0), ^uintptr(0), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	// A read-only ring is one the kernel overwrites rather than stops at.
+	ring, err := syscall.Mmap(int(fd), 0, (1+historyPages)*os.Getpagesize(), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		syscall.Close(int(fd))
+		return err
+	}
+	branchRings[tid] = &branchRing{fd: int(fd), ring: ring}
+	return nil
+}
+
+func closeBranchRings() {
+	for tid, r := range branchRings {
+		syscall.Munmap(r.ring)
+		syscall.Close(r.fd)
+		delete(branchRings, tid)
+	}
+}
+
+// newestSample decodes the sample the head of the ring points at.
+func (r *branchRing) newestSample() (uint64, []branch, bool) {
+	page := os.Getpagesize()
+	// data_head sits at offset 1024 of the control page.
+	head := atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.ring[1024])))
+	data := r.ring[page:]
+	if head == 0 {
+		return 0, nil, false
+	}
+	read := func(offset uint64, n int) []byte {
+		out := make([]byte, n)
+		for i := range out {
+			out[i] = data[(head+offset+uint64(i))%uint64(len(data))]
+		}
+		return out
+	}
+	// Records written backwards follow one another from the newest on; skip
+	// any others, such as throttling notices, to the newest sample.
+	var record []byte
+	for offset := uint64(0); offset < uint64(len(data)); {
+		header := read(offset, 8)
+		size := uint64(binary.LittleEndian.Uint16(header[6:]))
+		if size < 8 {
+			return 0, nil, false
+		}
+		if binary.LittleEndian.Uint32(header) == perfRecordSample {
+			record = read(offset, int(size))
+			break
+		}
+		offset += size
+	}
+	if len(record) < 32 {
+		return 0, nil, false
+	}
+	ip := binary.LittleEndian.Uint64(record[8:])
+	count := binary.LittleEndian.Uint64(record[24:])
+	var branches []branch
+	for i := uint64(0); i < count && 32+24*(i+1) <= uint64(len(record)); i++ {
+		entry := record[32+24*i:]
+		branches = append(branches, branch{
+			from: binary.LittleEndian.Uint64(entry),
+			to:   binary.LittleEndian.Uint64(entry[8:]),
+		})
+	}
+	return ip, branches, true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// serveHTTPFunction is where net/http hands every request to the server's
+// handler, with the request in req.
+const serveHTTPFunction = "net/http.serverHandler.ServeHTTP"
+
+// requestFilter limits a breakpoint to HTTP requests with a method and a
+// path matching a pattern.
+type requestFilter struct {
+	method  string // "*" for any.
+	pattern string // As for path.Match.
+}
+
+func (f *requestFilter) String() string {
+	return f.method + " " + f.pattern
+}
+
+// createHTTPBreakpoint sets a breakpoint from "break http <method>
+// <path-pattern> [if <condition>]", stopping in the server's dispatch of
+// matching requests.
+func createHTTPBreakpoint(pid int, argument string, symbolTable *gosym.Table) (*breakpoint, error) {
+	spec, condition := splitCondition(argument)
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("usage: break http <method> <path-pattern> [if <condition>]")
+	}
+	filter := &requestFilter{method: strings.ToUpper(fields[0]), pattern: fields[1]}
+	if _, err := path.Match(filter.pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid path pattern %q: %v", filter.pattern, err)
+	}
+	if symbolTable.LookupFunc(serveHTTPFunction) == nil {
+		return nil, fmt.Errorf("the program has no HTTP server: %v is not in the binary", serveHTTPFunction)
+	}
+
+	location := serveHTTPFunction
+	if condition != "" {
+		location += " if " + condition
+	}
+	bp, err := createBreakpoint(pid, location, symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	bp.request = filter
+	return bp, nil
+}
+
+// matches reads the method and path of the request being dispatched.  A
+// request that can't be read stops the tracee so the user can look.
+func (f *requestFilter) matches(pid int, symbolTable *gosym.Table) bool {
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return true
+	}
+	field := func(expression string) (string, error) {
+		v, err := evaluate(ctx, expression)
+		if err != nil {
+			return "", err
+		}
+		data, _, err := byteContents(pid, v)
+		return string(data), err
+	}
+	method, err := field("req.Method")
+	var requestPath string
+	if err == nil {
+		requestPath, err = field("req.URL.Path")
+	}
+	if err != nil {
+		fmt.Printf("Error reading the request for breakpoint http %v: %v\n", f, err)
+		return true
+	}
+	if f.method != "*" && method != f.method {
+		return false
+	}
+	matched, _ := path.Match(f.pattern, requestPath)
+	if matched {
+		fmt.Printf("HTTP request %v %v\n", method, requestPath)
+	}
+	return matched
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressDelay is how long loading goes on before its progress is shown,
+// so that small programs start without it.
+const progressDelay = 250 * time.Millisecond
+
+// loadSymbols reads what the debugger needs of a binary: the Go symbol
+// table, the frame table, the ELF symbols and the DWARF index, all at once.
+func loadSymbols(exe *elf.File) *gosym.Table {
+	var symbolTable *gosym.Table
+	loads := []func(){
+		func() { symbolTable = getSymbolTable(exe) },
+		func() { loadFrameTable(exe) },
+		func() { loadNativeSymbols(exe) },
+		func() { loadDebugInfo(exe) },
+	}
+	var wg sync.WaitGroup
+	for _, load := range loads {
+		wg.Add(1)
+		go func(load func()) {
+			defer wg.Done()
+			load()
+		}(load)
+	}
+	wg.Wait()
+	return symbolTable
+}
+
+// unitIndex is what indexing one compile unit finds, kept apart until every
+// unit is done so that the result is the same as reading them in order.
+type unitIndex struct {
+	subprograms []*subprogram
+	globals     map[string]*globalVariable
+	types       map[string]dwarf.Offset
+	producer    string // The main package's, if this is it.
+}
+
+// indexDebugInfo fills subprograms, globals and namedTypes from the compile
+// units of .debug_info, which worker goroutines read one unit each at a
+// time.
+func indexDebugInfo(exe *elf.File) {
+	units, whole := compileUnitOffsets(exe), false
+	if len(units) == 0 {
+		// Without headers to go by, such as in the .zdebug_info of old
+		// toolchains, one worker reads the units in order.
+		units, whole = []dwarf.Offset{0}, true
+	}
+	results := make([]unitIndex, len(units))
+	var done int64
+	stop := showIndexProgress(&done, len(units))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = indexCompileUnit(units[i], whole)
+				atomic.AddInt64(&done, 1)
+			}
+		}()
+	}
+	for i := range units {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	stop()
+
+	for _, r := range results {
+		subprograms = append(subprograms, r.subprograms...)
+		for name, g := range r.globals {
+			globals[name] = g
+		}
+		for name, offset := range r.types {
+			namedTypes[name] = offset
+		}
+		if r.producer != "" {
+			mainProducer = r.producer
+		}
+	}
+}
+
+// compileUnitOffsets finds where the entry of each unit of .debug_info is
+// from the unit headers alone, so that the units can be read apart without
+// first going through every entry before them.
+func compileUnitOffsets(exe *elf.File) []dwarf.Offset {
+	section := exe.Section(".debug_info")
+	if section == nil {
+		return nil
+	}
+	r := bufio.NewReader(section.Open())
+	order := binary.LittleEndian
+	var offsets []dwarf.Offset
+	var header [12]byte
+	for start := uint64(0); ; {
+		if _, err := io.ReadFull(r, header[:4]); err != nil {
+			break
+		}
+		length, size, offsetSize := uint64(order.Uint32(header[:4])), uint64(4), uint64(4)
+		if length == 0xffffffff {
+			if _, err := io.ReadFull(r, header[:8]); err != nil {
+				break
+			}
+			length, size, offsetSize = order.Uint64(header[:8]), 12, 8
+		}
+		if _, err := io.ReadFull(r, header[:2]); err != nil {
+			break
+		}
+		// After the version, DWARF 5 has a unit type and the address size
+		// before the abbreviation offset, older versions the size after.
+		entry := start + size + 2 + offsetSize + 1
+		if order.Uint16(header[:2]) >= 5 {
+			entry++
+		}
+		offsets = append(offsets, dwarf.Offset(entry))
+		if _, err := r.Discard(int(length - 2)); err != nil {
+			break
+		}
+		start += size + length
+	}
+	return offsets
+}
+
+// indexCompileUnit reads the top-level entries of the unit whose entry is at
+// offset, or with whole set, of it and every unit after it.
+func indexCompileUnit(offset dwarf.Offset, whole bool) unitIndex {
+	index := unitIndex{globals: make(map[string]*globalVariable), types: make(map[string]dwarf.Offset)}
+	reader := dwarfData.Reader()
+	reader.Seek(offset)
+	var unit *compileUnit
+	for {
+		entry, err := reader.Next()
+		if err != nil || entry == nil {
+			break
+		}
+
+		switch entry.Tag {
+		case dwarf.TagCompileUnit:
+			if unit != nil && !whole {
+				// The reader has gone on to the next unit.
+				return index
+			}
+			unit = &compileUnit{entry: entry, version: 5}
+			if low, ok := entry.Val(dwarf.AttrLowpc).(uint64); ok {
+				unit.base = low
+			}
+			if base, ok := entry.Val(dwarf.AttrAddrBase).(int64); ok {
+				unit.addrBase = base
+			} else {
+				unit.version = 4
+			}
+			if name, _ := entry.Val(dwarf.AttrName).(string); name == "main" {
+				index.producer, _ = entry.Val(dwarf.AttrProducer).(string)
+			}
+		case dwarf.TagSubprogram:
+			ranges, err := dwarfData.Ranges(entry)
+			name, _ := entry.Val(dwarf.AttrName).(string)
+			declLine, _ := entry.Val(dwarf.AttrDeclLine).(int64)
+			if err == nil && len(ranges) > 0 {
+				index.subprograms = append(index.subprograms, &subprogram{
+					name:     name,
+					low:      ranges[0][0],
+					high:     ranges[0][1],
+					offset:   entry.Offset,
+					unit:     unit,
+					declLine: declLine,
+				})
+			}
+			reader.SkipChildren()
+		case dwarf.TagVariable:
+			if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+				index.globals[name] = &globalVariable{entry: entry, unit: unit}
+			}
+		case dwarf.TagBaseType, dwarf.TagTypedef, dwarf.TagStructType, dwarf.TagPointerType,
+			dwarf.TagArrayType, dwarf.TagSubroutineType:
+			if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+				index.types[name] = entry.Offset
+			}
+			if entry.Children {
+				reader.SkipChildren()
+			}
+		default:
+			if entry.Children {
+				reader.SkipChildren()
+			}
+		}
+	}
+	return index
+}
+
+// showIndexProgress shows on a terminal how many of the units have been
+// indexed, once indexing has taken long enough to be worth it, until the
+// function it returns is called.
+func showIndexProgress(done *int64, total int) func() {
+	if !isTerminal(os.Stderr) {
+		return func() {}
+	}
+	quit, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		shown := false
+		delay := time.NewTimer(progressDelay)
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-quit:
+				if shown {
+					fmt.Fprint(os.Stderr, "\r\x1b[K")
+				}
+				return
+			case <-delay.C:
+				shown = true
+			case <-tick.C:
+			}
+			if shown {
+				n := atomic.LoadInt64(done)
+				fmt.Fprintf(os.Stderr, "\rIndexing debug information: %v of %v compile units (%v%%)", n, total, n*100/int64(total))
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-finished
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxInlineValue is how long a value shown beside the current line may be
+// before it is cut short.
+const maxInlineValue = 40
+
+// inlineValues makes a note of the values of the variables the line the
+// program is stopped at refers to, like "   // greeted = true", to put at
+// the end of it in listings.  Identifiers and dotted selectors are read as
+// print would; what isn't a variable, like a package, a keyword or a
+// called function, is left out.
+func inlineValues(line string) string {
+	if !showInlineValues || running {
+		return ""
+	}
+	ctx, err := newEvalContext(currentThread, listingSymbols)
+	if err != nil {
+		return ""
+	}
+	defer endEvaluation()
+
+	var notes []string
+	seen := make(map[string]bool)
+	for _, name := range referencedNames(line) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		v, err := evaluate(ctx, name)
+		if err != nil {
+			continue
+		}
+		text := formatValue(currentThread, v)
+		if len(text) > maxInlineValue {
+			text = text[:maxInlineValue-3] + "..."
+		}
+		notes = append(notes, name+" = "+text)
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return "   // " + strings.Join(notes, ", ")
+}
+
+// referencedNames lists the identifiers and selector chains like a.b.c in
+// a line of Go, in order, leaving out strings, comments, keywords and
+// anything called.
+func referencedNames(line string) []string {
+	var names []string
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			return names
+		case r == '"' || r == '\'' || r == '`':
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && r != '`' {
+					i++
+				}
+			}
+		case unicode.IsDigit(r):
+			for i+1 < len(runes) && (isIdentRune(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+		case isIdentRune(r):
+			start := i
+			for i+1 < len(runes) && (isIdentRune(runes[i+1]) || runes[i+1] == '.' && i+2 < len(runes) && isIdentRune(runes[i+2])) {
+				i++
+			}
+			name := string(runes[start : i+1])
+			called := i+1 < len(runes) && runes[i+1] == '('
+			if start > 0 && runes[start-1] == '.' || called || goKeywords[strings.SplitN(name, ".", 2)[0]] {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+var goKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+	"true": true, "false": true, "nil": true, "iota": true,
+}
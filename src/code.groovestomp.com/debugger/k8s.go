@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// podDebugger is where the k8s command puts a copy of the debugger in the
+// container.
+const podDebugger = "/tmp/godebugger"
+
+// runK8s implements "k8s [<flags>] <namespace>/<pod>": it copies the
+// debugger into the pod's container with kubectl, finds the Go process
+// there and attaches to it, at the terminal through kubectl exec or, with
+// -dap, as a debug adapter served in the pod with a local port forwarded to
+// it.  The container needs tar, for kubectl cp, and the capability to
+// ptrace.
+func runK8s(args []string) {
+	flags := flag.NewFlagSet("k8s", flag.ExitOnError)
+	container := flags.String("c", "", "the container of the pod, if it has more than one")
+	pid := flags.Int("pid", 0, "the process to attach to, when the container runs more than one Go process")
+	dapPort := flags.Int("dap", 0, "serve the Debug Adapter Protocol in the pod and forward this local port to it, rather than debug at the terminal")
+	kubectl := flags.String("kubectl", "kubectl", "the kubectl to run")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v k8s [<flags>] <namespace>/<pod>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || strings.Count(flags.Arg(0), "/") != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	slash := strings.Index(flags.Arg(0), "/")
+	namespace, pod := flags.Arg(0)[:slash], flags.Arg(0)[slash+1:]
+
+	inPod := []string{"-n", namespace, pod}
+	if *container != "" {
+		inPod = append(inPod, "-c", *container)
+	}
+	kube := func(args ...string) *exec.Cmd {
+		cmd := exec.Command(*kubectl, args...)
+		cmd.Stderr = os.Stderr
+		return cmd
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		k8sFatal(err)
+	}
+	copyArgs := []string{"cp", "-n", namespace, self, pod + ":" + podDebugger}
+	if *container != "" {
+		copyArgs = append(copyArgs, "-c", *container)
+	}
+	fmt.Fprintf(os.Stderr, "Copying the debugger to %v/%v...\n", namespace, pod)
+	if err := kube(copyArgs...).Run(); err != nil {
+		k8sFatal(fmt.Errorf("kubectl cp: %v", err))
+	}
+
+	if *pid == 0 {
+		var out bytes.Buffer
+		ps := kube(append(append([]string{"exec"}, inPod...), "--", podDebugger, "ps")...)
+		ps.Stdout = &out
+		if err := ps.Run(); err != nil {
+			k8sFatal(fmt.Errorf("listing the pod's processes: %v", err))
+		}
+		if *pid, err = pickPodProcess(out.String()); err != nil {
+			k8sFatal(err)
+		}
+	}
+
+	if *dapPort == 0 {
+		fmt.Fprintf(os.Stderr, "Attaching to process %v...\n", *pid)
+		session := kube(append(append([]string{"exec", "-it"}, inPod...), "--", podDebugger, "-attach", strconv.Itoa(*pid))...)
+		session.Stdin, session.Stdout = os.Stdin, os.Stdout
+		if err := session.Run(); err != nil {
+			if exit, ok := err.(*exec.ExitError); ok {
+				os.Exit(exit.ExitCode())
+			}
+			k8sFatal(err)
+		}
+		return
+	}
+
+	port := strconv.Itoa(*dapPort)
+	agent := kube(append(append([]string{"exec"}, inPod...), "--", podDebugger, "-dap=127.0.0.1:"+port)...)
+	if err := agent.Start(); err != nil {
+		k8sFatal(err)
+	}
+	forward := kube("port-forward", "-n", namespace, pod, port+":"+port)
+	forward.Stdout = os.Stderr
+	if err := forward.Start(); err != nil {
+		agent.Process.Kill()
+		k8sFatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Debug adapter at localhost:%v; attach to processId %v.\n", port, *pid)
+	err = agent.Wait()
+	forward.Process.Kill()
+	forward.Wait()
+	if err != nil {
+		k8sFatal(err)
+	}
+}
+
+// pickPodProcess chooses the process to attach to from what "ps" printed
+// in the container: the only Go process, or else the container's first
+// process if it is one.
+func pickPodProcess(listing string) (int, error) {
+	var pids []int
+	scanner := bufio.NewScanner(strings.NewReader(listing))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[0]); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	switch {
+	case len(pids) == 0:
+		return 0, fmt.Errorf("no Go process in the container")
+	case len(pids) == 1:
+		return pids[0], nil
+	case pids[0] == 1:
+		return 1, nil
+	}
+	return 0, fmt.Errorf("%v Go processes in the container; pick one with -pid:\n%v", len(pids), strings.TrimSpace(listing))
+}
+
+func k8sFatal(err error) {
+	fmt.Fprintln(os.Stderr, "k8s:", err)
+	os.Exit(1)
+}
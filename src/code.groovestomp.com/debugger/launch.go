@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"code.groovestomp.com/debugger/target"
+)
+
+var (
+	// stdinPath is the file given with -stdin for the program to read.
+	stdinPath string
+
+	// programStdin is what the running program reads as standard input,
+	// kept open until the next launch.
+	programStdin *os.File
+
+	// programInput is the write end of the pipe the program reads when it
+	// shares the debugger's terminal, or nil.  Lines typed while the program
+	// runs in the foreground are passed on to it.
+	programInput *os.File
+)
+
+// envFlag collects the NAME=value entries of repeated -env flags.
+type envFlag []string
+
+func (e *envFlag) String() string {
+	return strings.Join(*e, " ")
+}
+
+func (e *envFlag) Set(entry string) error {
+	if i := strings.Index(entry, "="); i <= 0 {
+		return fmt.Errorf("%q is not NAME=value", entry)
+	}
+	*e = append(*e, entry)
+	return nil
+}
+
+// launchOptions sets up the environment and standard input of a program
+// about to be launched.  It reads the file given with -stdin; otherwise,
+// when the debugger runs in a terminal, a pipe fed with what is typed while
+// the program runs, and /dev/null when it doesn't.
+func launchOptions() target.Options {
+	if programStdin != nil {
+		programStdin.Close()
+		programStdin = nil
+	}
+	if programInput != nil {
+		programInput.Close()
+		programInput = nil
+	}
+
+	options := target.Options{Env: programEnv}
+	switch {
+	case stdinPath != "":
+		f, err := os.Open(stdinPath)
+		if err != nil {
+			fatal(err)
+		}
+		programStdin = f
+	case inputStarted && isTerminal(os.Stdin):
+		r, w, err := os.Pipe()
+		if err != nil {
+			fatal(err)
+		}
+		programStdin, programInput = r, w
+	}
+	options.Stdin = programStdin
+	return options
+}
+
+// forwardInput passes a line typed while the program runs in the foreground
+// on to its standard input.  Ctrl-D ends the program's input; the terminal
+// stays the debugger's.
+func forwardInput() {
+	if programInput == nil {
+		return
+	}
+	select {
+	case line := <-input:
+		programInput.WriteString(line.text)
+		if line.err != nil {
+			programInput.Close()
+			programInput = nil
+			if line.err != io.EOF {
+				go func() { input <- line }() // The command loop sees it too.
+			}
+		}
+	default:
+	}
+}
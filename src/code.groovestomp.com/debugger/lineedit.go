@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+	"unsafe"
+)
+
+var (
+	// terminalInput is set when stdin is a terminal, which is then read as
+	// it comes, so that the prompt can be edited in raw mode.
+	terminalInput bool
+
+	// cookedMode holds the terminal's settings while the prompt has it in
+	// raw mode, and is nil otherwise.
+	cookedMode *syscall.Termios
+
+	// commandHistory holds the commands typed at the prompt, oldest first.
+	commandHistory []string
+)
+
+// lineEditor edits a line typed at a terminal in raw mode, with the keys of
+// readline's emacs mode: the arrows, Home, End, Delete and Backspace, and
+// Ctrl-A, E, B, F, D, H, K, U, W, P and N.
+type lineEditor struct {
+	prompt string
+	line   []rune
+	cursor int
+
+	// pending holds the start of an escape sequence or UTF-8 character
+	// whose rest hasn't been read yet.
+	pending []byte
+
+	// useHistory lets the arrows recall earlier commands and records the
+	// line once it is entered.  shown is the index in commandHistory of
+	// the line being edited, or len(commandHistory) for the new one, which
+	// is kept in draft meanwhile.
+	useHistory bool
+	shown      int
+	draft      []rune
+}
+
+func newLineEditor(prompt string, useHistory bool) *lineEditor {
+	return &lineEditor{prompt: prompt, useHistory: useHistory, shown: len(commandHistory)}
+}
+
+// feed takes keys typed and returns the line, with a newline, once it is
+// entered, or io.EOF for Ctrl-D on an empty line.
+func (e *lineEditor) feed(data []byte) (string, bool, error) {
+	e.pending = append(e.pending, data...)
+	for len(e.pending) > 0 {
+		n := e.key(e.pending)
+		if n == 0 {
+			return "", false, nil // Wait for the rest of the key.
+		}
+		key := string(e.pending[:n])
+		e.pending = e.pending[n:]
+		switch key {
+		case "\r", "\n":
+			fmt.Print("\n")
+			line := string(e.line)
+			if e.useHistory && strings.TrimSpace(line) != "" &&
+				(len(commandHistory) == 0 || commandHistory[len(commandHistory)-1] != line) {
+				commandHistory = append(commandHistory, line)
+			}
+			return line + "\n", true, nil
+		case "\x04":
+			if len(e.line) == 0 {
+				fmt.Print("\n")
+				return "", true, io.EOF
+			}
+			e.delete(e.cursor, e.cursor+1)
+		case "\x03":
+			// The terminal doesn't send SIGINT in raw mode.  A program
+			// running in the background still gets one, as it would have
+			// from the terminal; otherwise the line is discarded.
+			if running {
+				kill(processID, syscall.SIGINT)
+			}
+			fmt.Printf("^C\n%v", e.prompt)
+			e.line, e.cursor, e.shown = nil, 0, len(commandHistory)
+		case "\x7f", "\x08":
+			e.delete(e.cursor-1, e.cursor)
+		case "\x1b[3~":
+			e.delete(e.cursor, e.cursor+1)
+		case "\x0b":
+			e.delete(e.cursor, len(e.line))
+		case "\x15":
+			e.delete(0, e.cursor)
+		case "\x17":
+			start := e.cursor
+			for start > 0 && e.line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.line[start-1] != ' ' {
+				start--
+			}
+			e.delete(start, e.cursor)
+		case "\x01", "\x1b[H", "\x1bOH", "\x1b[1~", "\x1b[7~":
+			e.moveTo(0)
+		case "\x05", "\x1b[F", "\x1bOF", "\x1b[4~", "\x1b[8~":
+			e.moveTo(len(e.line))
+		case "\x02", "\x1b[D", "\x1bOD":
+			e.moveTo(e.cursor - 1)
+		case "\x06", "\x1b[C", "\x1bOC":
+			e.moveTo(e.cursor + 1)
+		case "\x10", "\x1b[A", "\x1bOA":
+			e.recall(e.shown - 1)
+		case "\x0e", "\x1b[B", "\x1bOB":
+			e.recall(e.shown + 1)
+		default:
+			if r, _ := utf8.DecodeRuneInString(key); len(key) > 0 && r >= ' ' && key[0] != '\x1b' {
+				e.insert([]rune(key))
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// key returns the length of the key at the start of data, or zero if it
+// hasn't all been read.  Escape sequences the editor doesn't know are
+// skipped whole.
+func (e *lineEditor) key(data []byte) int {
+	if data[0] != '\x1b' {
+		if !utf8.FullRune(data) {
+			return 0
+		}
+		_, n := utf8.DecodeRune(data)
+		return n
+	}
+	if len(data) < 2 {
+		return 0
+	}
+	switch data[1] {
+	case '[':
+		// A CSI sequence ends with a byte from @ to ~.
+		for i := 2; i < len(data); i++ {
+			if data[i] >= '@' && data[i] <= '~' {
+				return i + 1
+			}
+		}
+		return 0
+	case 'O':
+		if len(data) < 3 {
+			return 0
+		}
+		return 3
+	}
+	return 2 // Alt and a key.
+}
+
+func (e *lineEditor) insert(runes []rune) {
+	e.line = append(e.line[:e.cursor], append(runes, e.line[e.cursor:]...)...)
+	e.cursor += len(runes)
+	fmt.Print(string(e.line[e.cursor-len(runes):]))
+	e.back(len(e.line) - e.cursor)
+}
+
+// delete removes the runes from start to end, clipped to the line.
+func (e *lineEditor) delete(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(e.line) {
+		end = len(e.line)
+	}
+	if start >= end {
+		return
+	}
+	e.back(e.cursor - start)
+	e.line = append(e.line[:start], e.line[end:]...)
+	e.cursor = start
+	fmt.Print(string(e.line[start:]) + "\x1b[K")
+	e.back(len(e.line) - start)
+}
+
+func (e *lineEditor) moveTo(position int) {
+	if position < 0 || position > len(e.line) {
+		return
+	}
+	if position < e.cursor {
+		e.back(e.cursor - position)
+	} else if position > e.cursor {
+		fmt.Printf("\x1b[%dC", position-e.cursor)
+	}
+	e.cursor = position
+}
+
+// redraw shows the line again after the prompt, once something else has
+// been printed over it.
+func (e *lineEditor) redraw() {
+	fmt.Print(string(e.line))
+	e.back(len(e.line) - e.cursor)
+}
+
+// back moves the terminal's cursor left by n columns.
+func (e *lineEditor) back(n int) {
+	if n > 0 {
+		fmt.Printf("\x1b[%dD", n)
+	}
+}
+
+// recall replaces the line with the command at index of the history, the
+// line being typed past the end of it.
+func (e *lineEditor) recall(index int) {
+	if !e.useHistory || index < 0 || index > len(commandHistory) || index == e.shown {
+		return
+	}
+	if e.shown == len(commandHistory) {
+		e.draft = e.line
+	}
+	e.shown = index
+	replacement := e.draft
+	if index < len(commandHistory) {
+		replacement = []rune(commandHistory[index])
+	}
+	e.moveTo(0)
+	e.line = append([]rune(nil), replacement...)
+	e.cursor = len(e.line)
+	fmt.Print(string(e.line) + "\x1b[K")
+}
+
+// enterRawMode has the terminal pass on every key as it is typed, without
+// echoing it, for the line editor.
+func enterRawMode() {
+	if cookedMode != nil {
+		return
+	}
+	var settings syscall.Termios
+	if termios(os.Stdin, syscall.TCGETS, &settings) != nil {
+		return
+	}
+	saved := settings
+	settings.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG | syscall.IEXTEN
+	settings.Iflag &^= syscall.IXON
+	settings.Cc[syscall.VMIN], settings.Cc[syscall.VTIME] = 1, 0
+	if termios(os.Stdin, syscall.TCSETS, &settings) == nil {
+		cookedMode = &saved
+	}
+}
+
+// restoreTerminal gives the terminal its own line editing back, as the
+// program expects it while it runs and as it must be left on exit.
+func restoreTerminal() {
+	if cookedMode == nil {
+		return
+	}
+	termios(os.Stdin, syscall.TCSETS, cookedMode)
+	cookedMode = nil
+}
+
+func isTerminal(f *os.File) bool {
+	var settings syscall.Termios
+	return termios(f, syscall.TCGETS, &settings) == nil
+}
+
+func termios(f *os.File, request uintptr, settings *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(unsafe.Pointer(settings)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxLineSearch bounds how far past a line without code a location may move
+// to find the next line that has instructions.
+const maxLineSearch = 10
+
+// entryPoint is the ELF entry address of the binary being debugged, where
+// the process starts before the Go runtime has set anything up.
+var entryPoint uint64
+
+// location is the result of parsing a location spec.  pc is zero until the
+// location is resolved to code; listings don't need it.
+type location struct {
+	file string
+	line int
+	pc   uint64
+}
+
+// parseLocation implements the location spec grammar shared by break, until,
+// jump and list:
+//
+//	<line>            line in the current file
+//	+<n>, -<n>        line relative to the current line
+//	<file>:<line>     line in a file, matched as in resolveSourceFile
+//	<func>            first line of a function's body
+//	<func>:<offset>   line relative to the start of a function
+//	<func>+<bytes>    instruction at a byte offset into a function
+//	*<address>        instruction address
+//	-entry            the ELF entry point
+func parseLocation(spec string, symbolTable *gosym.Table) (*location, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("missing location")
+	}
+	if spec == "-entry" {
+		file, line, fn := symbolTable.PCToLine(entryPoint)
+		if fn == nil {
+			return nil, fmt.Errorf("no function contains the entry point 0x%x", entryPoint)
+		}
+		return &location{file: file, line: line, pc: entryPoint}, nil
+	}
+
+	switch spec[0] {
+	case '*':
+		address, err := strconv.ParseUint(strings.TrimSpace(spec[1:]), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", spec[1:])
+		}
+		file, line, fn := symbolTable.PCToLine(address)
+		if fn == nil {
+			return nil, fmt.Errorf("no function contains address 0x%x", address)
+		}
+		return &location{file: file, line: line, pc: address}, nil
+	case '+', '-':
+		offset, err := strconv.Atoi(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line offset %q", spec)
+		}
+		return &location{file: pcSourceFile, line: pcSourceLine + offset}, nil
+	}
+
+	if line, err := strconv.Atoi(spec); err == nil {
+		return &location{file: pcSourceFile, line: line}, nil
+	}
+
+	if i := strings.LastIndex(spec, "+"); i > 0 {
+		if bytes, err := strconv.ParseUint(spec[i+1:], 0, 64); err == nil {
+			return functionOffsetLocation(spec[:i], bytes, symbolTable)
+		}
+	}
+
+	name, offset := spec, ""
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		name, offset = spec[:i], spec[i+1:]
+	}
+
+	if offset != "" && isSourceFileName(name) {
+		line, err := strconv.Atoi(offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line number %q", offset)
+		}
+		file, err := resolveSourceFile(name, symbolTable)
+		if err != nil {
+			return nil, err
+		}
+		return &location{file: file, line: line}, nil
+	}
+
+	fn, err := lookupFunction(name, symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	file, line, _ := symbolTable.PCToLine(fn.Entry)
+	if offset == "" {
+		pc := prologueEnd(fn, symbolTable)
+		file, line, _ := symbolTable.PCToLine(pc)
+		return &location{file: file, line: line, pc: pc}, nil
+	}
+
+	delta, err := strconv.Atoi(strings.TrimPrefix(offset, "+"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid line offset %q", offset)
+	}
+	return &location{file: file, line: line + delta}, nil
+}
+
+// prologueEnd returns the address of the first statement of a function,
+// past the stack check and frame setup, where its arguments are in place.
+// The prologue is attributed to the line of the func keyword, so the body
+// starts at the first instruction on another line.  A function written on a
+// single line has no such instruction and is entered at its entry, as is
+// one without a prologue, whose entry DWARF puts on a line of the body.
+func prologueEnd(fn *gosym.Func, symbolTable *gosym.Table) uint64 {
+	_, declaration, _ := symbolTable.PCToLine(fn.Entry)
+	if sub := findSubprogram(fn.Entry); sub != nil && sub.declLine != 0 && sub.declLine != int64(declaration) {
+		return fn.Entry
+	}
+	for pc := fn.Entry; pc < fn.End; pc++ {
+		_, line, owner := symbolTable.PCToLine(pc)
+		if owner == nil || owner.Entry != fn.Entry {
+			break
+		}
+		if line != declaration {
+			return pc
+		}
+	}
+	return fn.Entry
+}
+
+// functionOffsetLocation resolves <func>+<bytes>, which needs nothing but
+// the symbol table and so works without the program's sources.
+func functionOffsetLocation(name string, offset uint64, symbolTable *gosym.Table) (*location, error) {
+	fn, err := lookupFunction(name, symbolTable)
+	if err != nil {
+		return nil, err
+	}
+	pc := fn.Entry + offset
+	if pc >= fn.End {
+		return nil, fmt.Errorf("%v is only %v bytes long", fn.Name, fn.End-fn.Entry)
+	}
+	if !isInstructionStart(currentThread, fn, pc) {
+		return nil, fmt.Errorf("%v+%v is not the start of an instruction", fn.Name, offset)
+	}
+	file, line, _ := symbolTable.PCToLine(pc)
+	return &location{file: file, line: line, pc: pc}, nil
+}
+
+// isSourceFileName reports whether the name before the colon of a location
+// is a file rather than a function.  A function in a package with a slash in
+// its import path has one too, so a path without a .go or .s suffix only
+// names a file if its last element has no dot, as a function's name does.
+func isSourceFileName(name string) bool {
+	if strings.HasSuffix(name, ".go") || strings.HasSuffix(name, ".s") {
+		return true
+	}
+	i := strings.LastIndex(name, "/")
+	return i >= 0 && !strings.Contains(name[i+1:], ".")
+}
+
+// lookupFunction finds a function by its qualified name, falling back to the
+// main package and then to a unique match on the unqualified name.
+func lookupFunction(name string, symbolTable *gosym.Table) (*gosym.Func, error) {
+	if fn := symbolTable.LookupFunc(name); fn != nil {
+		return fn, nil
+	}
+	if fn := symbolTable.LookupFunc("main." + name); fn != nil {
+		return fn, nil
+	}
+
+	var matches []*gosym.Func
+	for i := range symbolTable.Funcs {
+		fn := &symbolTable.Funcs[i]
+		if strings.HasSuffix(fn.Name, "."+name) {
+			matches = append(matches, fn)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no function named %v", name)
+	case 1:
+		return matches[0], nil
+	}
+
+	names := make([]string, len(matches))
+	for i, fn := range matches {
+		names[i] = fn.Name
+	}
+	return nil, fmt.Errorf("%v is ambiguous: %v", name, strings.Join(names, ", "))
+}
+
+// resolvePC finds the instructions for the location, moving forward to the
+// next line with code when the requested line has none.
+func (loc *location) resolvePC(symbolTable *gosym.Table) error {
+	if loc.pc != 0 {
+		return nil
+	}
+	if loc.file == "" {
+		return fmt.Errorf("no current source file")
+	}
+
+	for line := loc.line; line < loc.line+maxLineSearch; line++ {
+		pc, _, err := symbolTable.LineToPC(loc.file, line)
+		if err == nil {
+			loc.pc = pc
+			loc.line = line
+			return nil
+		}
+	}
+	return fmt.Errorf("no code at %v:%v", loc.file, loc.line)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Where catch log reads messages.  The log package formats a line through
+// a closure its print functions pass to Logger.output, after the header;
+// the line is in the closure's result registers once it returns.  Before
+// Go 1.21 every line went through Logger.Output, which has it as its
+// string argument.  slog's Logger.log and Logger.logAttrs take the message
+// as their fourth argument, after the receiver, the context and the
+// level, in RSI and R8.
+const (
+	logOutputFunction = "log.(*Logger).output"
+	logHeaderFunction = "log.formatHeader"
+	logOldOutput      = "log.(*Logger).Output"
+)
+
+var slogFunctions = []string{"log/slog.(*Logger).log", "log/slog.(*Logger).logAttrs"}
+
+var (
+	// catchLog is the pattern of "catch log", nil when it is off.  It
+	// survives restarts.
+	catchLog *regexp.Regexp
+
+	// logCatchAddresses are the internal breakpoints catch log reads
+	// messages at, with how to read them: "line" from the closure's
+	// result, "Output" from Logger.Output's argument, "slog" from slog's.
+	logCatchAddresses = make(map[uint64]string)
+
+	// caughtLogMessage is the message the program stopped for.
+	caughtLogMessage string
+)
+
+// runCatchLogCommand implements "catch log <regexp>" and "catch log off".
+func runCatchLogCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "" {
+		return fmt.Errorf("usage: catch log <regexp>|off")
+	}
+	if argument == "off" {
+		disarmCatcher(pid, logCatchAddresses)
+		catchLog = nil
+		fmt.Println("Catchpoint on log output removed.")
+		return nil
+	}
+	pattern, err := regexp.Compile(argument)
+	if err != nil {
+		return err
+	}
+	disarmCatcher(pid, logCatchAddresses)
+	if err := armLogCatcher(pid, symbolTable); err != nil {
+		return err
+	}
+	catchLog = pattern
+	fmt.Printf("Catchpoint on log and slog messages matching %v.\n", pattern)
+	return nil
+}
+
+// armLogCatcher puts the internal breakpoints catch log reads messages at
+// on the log and slog functions the binary has.
+func armLogCatcher(pid int, symbolTable *gosym.Table) error {
+	set := func(address uint64, kind string) error {
+		if err := setBreakpoint(pid, address); err != nil {
+			return err
+		}
+		logCatchAddresses[address] = kind
+		return nil
+	}
+	if address, ok := logLineAddress(pid, symbolTable); ok {
+		if err := set(address, "line"); err != nil {
+			return err
+		}
+	} else if fn := symbolTable.LookupFunc(logOldOutput); fn != nil {
+		if err := set(fn.Entry, "Output"); err != nil {
+			return err
+		}
+	}
+	for _, name := range slogFunctions {
+		if fn := symbolTable.LookupFunc(name); fn != nil {
+			if err := set(fn.Entry, "slog"); err != nil {
+				return err
+			}
+		}
+	}
+	if len(logCatchAddresses) == 0 {
+		return fmt.Errorf("the program doesn't use log or log/slog")
+	}
+	return nil
+}
+
+// logLineAddress finds where the formatting closure returns to in
+// Logger.output: after the first indirect call following the call to
+// formatHeader.
+func logLineAddress(pid int, symbolTable *gosym.Table) (uint64, bool) {
+	output, header := symbolTable.LookupFunc(logOutputFunction), symbolTable.LookupFunc(logHeaderFunction)
+	if output == nil || header == nil {
+		return 0, false
+	}
+	code, err := readText(pid, output.Entry, int(output.End-output.Entry))
+	if err != nil {
+		return 0, false
+	}
+	headerWritten := false
+	for offset := 0; offset < len(code); {
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			return 0, false
+		}
+		next := output.Entry + uint64(offset+inst.Len)
+		if inst.Op == x86asm.CALL {
+			switch arg := inst.Args[0].(type) {
+			case x86asm.Rel:
+				if uint64(int64(next)+int64(arg)) == header.Entry {
+					headerWritten = true
+				}
+			case x86asm.Reg, x86asm.Mem:
+				if headerWritten {
+					return next, true
+				}
+			}
+		}
+		offset += inst.Len
+	}
+	return 0, false
+}
+
+// logMessageMatches reads the message a thread is logging at one of
+// logCatchAddresses and reports whether catch log should stop for it.  A
+// line the log package writes for slog's default handler is passed over,
+// since the catch already saw its message going into slog.
+func logMessageMatches(tid int, kind string, symbolTable *gosym.Table) bool {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(tid, &regs); err != nil || catchLog == nil {
+		return false
+	}
+	address, length := regs.Rax, regs.Rbx
+	switch kind {
+	case "Output":
+		address, length = regs.Rcx, regs.Rdi
+	case "slog":
+		address, length = regs.Rsi, regs.R8
+	}
+	if length > maxStringLength {
+		length = maxStringLength
+	}
+	data, err := readMemory(tid, address, int(length))
+	if err != nil {
+		return false
+	}
+	message := strings.TrimSuffix(string(data), "\n")
+	if !catchLog.MatchString(message) {
+		return false
+	}
+	if kind != "slog" {
+		for _, frame := range backtrace(tid, symbolTable) {
+			for _, name := range slogFunctions {
+				if frame.fn.Name == name {
+					return false
+				}
+			}
+		}
+	}
+	caughtLogMessage = message
+	return true
+}
+
+// showLogStop reports a stop for a log message and selects the frame the
+// program logged it from: the innermost one outside log, log/slog and the
+// runtime.
+func showLogStop(pid int, symbolTable *gosym.Table) {
+	fmt.Printf("\nLogged a message matching %v: %v\n", catchLog, caughtLogMessage)
+	if frame, ok := selectFrameOutside(pid, symbolTable, "log.", "log/slog.", "runtime."); ok {
+		fmt.Printf("Logged from %v at %v:%v, frame #%v.\n", frame.fn.Name, frame.file, frame.line, selection.index)
+		showListing(frame.file, frame.line)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logpoint is what a breakpoint set with -log prints instead of stopping.
+// -rate and -sample keep one in a hot path from flooding the output; the
+// hits they drop aren't evaluated, and are counted.
+type logpoint struct {
+	message string
+	parts   []logPart
+
+	// rate is how many messages may be printed each per, or zero for any
+	// number, counted from windowStart.
+	rate        int
+	per         time.Duration
+	windowStart time.Time
+	inWindow    int
+
+	// Of every sampleOf hits, the first sample are printed.  sampleOf is
+	// zero without -sample.
+	sample, sampleOf int
+
+	dropped int // Since the last message printed.
+	total   int // Dropped in all.
+}
+
+// logPart is a piece of a log message: text, or an expression to print in
+// its place.
+type logPart struct {
+	text string
+	expr expr
+}
+
+// splitLogOption removes "-log <quoted message>" from a breakpoint's
+// argument.  It comes out before the condition is split off, so the message
+// may hold anything, " if " included.
+func splitLogOption(argument string) (string, string, error) {
+	i := strings.Index(argument, "-log ")
+	if i < 0 || i > 0 && argument[i-1] != ' ' {
+		return argument, "", nil
+	}
+	rest := strings.TrimLeft(argument[i+len("-log "):], " ")
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return "", "", fmt.Errorf("-log needs a quoted message, e.g. -log \"n is {n}\"")
+	}
+	message, _ := strconv.Unquote(quoted)
+	return strings.TrimSpace(argument[:i] + " " + rest[len(quoted):]), message, nil
+}
+
+// newLogpoint parses a message, in which {<expression>} is replaced by the
+// value of the expression, and the -rate and -sample limits.
+func newLogpoint(message, rate, sample string) (*logpoint, error) {
+	l := &logpoint{message: message}
+	for message != "" {
+		open := strings.Index(message, "{")
+		if open < 0 {
+			l.parts = append(l.parts, logPart{text: message})
+			break
+		}
+		end := strings.Index(message[open:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in log message")
+		}
+		e, err := parseExpression(message[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		l.parts = append(l.parts, logPart{text: message[:open]}, logPart{expr: e})
+		message = message[open+end+1:]
+	}
+	if rate != "" {
+		n, per, err := parseRatio(rate, "-rate")
+		if err != nil {
+			return nil, err
+		}
+		l.rate = n
+		switch per {
+		case "s":
+			l.per = time.Second
+		case "m":
+			l.per = time.Minute
+		case "h":
+			l.per = time.Hour
+		default:
+			if l.per, err = time.ParseDuration(per); err != nil || l.per <= 0 {
+				return nil, fmt.Errorf("-rate expects <n>/s, <n>/m, <n>/h or <n>/<duration>, got %q", rate)
+			}
+		}
+	}
+	if sample != "" {
+		n, of, err := parseRatio(sample, "-sample")
+		if err != nil {
+			return nil, err
+		}
+		if l.sampleOf, err = strconv.Atoi(of); err != nil || l.sampleOf < n {
+			return nil, fmt.Errorf("-sample expects <n>/<m> with n no more than m, got %q", sample)
+		}
+		l.sample = n
+	}
+	return l, nil
+}
+
+// parseRatio splits "<n>/<rest>" with a positive n.
+func parseRatio(s string, option string) (int, string, error) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return 0, "", fmt.Errorf("%v expects <n>/<...>, got %q", option, s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n <= 0 {
+		return 0, "", fmt.Errorf("%v expects a positive count, got %q", option, s)
+	}
+	return n, s[i+1:], nil
+}
+
+// hit prints the message for the breakpoint's hits-th hit if the -rate and
+// -sample limits let it through, noting how many were dropped since the
+// last one printed.
+func (l *logpoint) hit(pid int, hits int, symbolTable *gosym.Table) {
+	if l.sampleOf > 0 && (hits-1)%l.sampleOf >= l.sample {
+		l.drop()
+		return
+	}
+	if l.rate > 0 {
+		now := time.Now()
+		if now.Sub(l.windowStart) >= l.per {
+			l.windowStart, l.inWindow = now, 0
+		}
+		if l.inWindow >= l.rate {
+			l.drop()
+			return
+		}
+		l.inWindow++
+	}
+
+	var b strings.Builder
+	ctx, err := newEvalContext(pid, symbolTable)
+	for _, part := range l.parts {
+		b.WriteString(part.text)
+		if part.expr == nil {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "<%v>", err)
+			continue
+		}
+		if v, err := ctx.eval(part.expr); err != nil {
+			fmt.Fprintf(&b, "<%v>", err)
+		} else {
+			b.WriteString(formatValue(pid, v))
+		}
+	}
+	endEvaluation()
+	if l.dropped > 0 {
+		fmt.Fprintf(&b, " (%v dropped)", l.dropped)
+		l.dropped = 0
+	}
+	fmt.Println(b.String())
+}
+
+func (l *logpoint) drop() {
+	l.dropped++
+	l.total++
+}
+
+// describe sums up the logpoint for info breakpoints.
+func (l *logpoint) describe() string {
+	what := fmt.Sprintf("log %q", l.message)
+	if l.rate > 0 {
+		what += fmt.Sprintf(" -rate %v/%v", l.rate, l.per)
+	}
+	if l.sampleOf > 0 {
+		what += fmt.Sprintf(" -sample %v/%v", l.sample, l.sampleOf)
+	}
+	if l.total > 0 {
+		what += fmt.Sprintf(", %v dropped", l.total)
+	}
+	return what
+}
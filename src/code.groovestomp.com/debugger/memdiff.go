@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"debug/gosym"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// memorySnapshot is a range memdiff last read.
+type memorySnapshot struct {
+	address uint64
+	data    []byte
+}
+
+// memorySnapshots are kept by address and length, so several ranges can be
+// compared at once.
+var memorySnapshots = make(map[string]*memorySnapshot)
+
+// runMemdiffCommand implements "memdiff <address> <length>".  The first
+// time it snapshots the range; after that it prints the 16-byte rows that
+// changed since the last time, old above new with the changed bytes marked,
+// and snapshots the range again.
+func runMemdiffCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	fields := strings.Fields(argument)
+	if len(fields) < 2 {
+		return fmt.Errorf("usage: memdiff <address> <length>")
+	}
+	length, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || length <= 0 {
+		return fmt.Errorf("expected a length in bytes, got %q", fields[len(fields)-1])
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, strings.Join(fields[:len(fields)-1], " "))
+	if err != nil {
+		return err
+	}
+	address, err := valueAddress(v)
+	if err != nil {
+		return err
+	}
+	data, err := readMemory(pid, address, length)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("0x%x %v", address, length)
+	old := memorySnapshots[key]
+	memorySnapshots[key] = &memorySnapshot{address: address, data: data}
+	if old == nil {
+		fmt.Printf("Snapshot of %v bytes at 0x%x; memdiff again to see what changed.\n", length, address)
+		return nil
+	}
+
+	changed := 0
+	for i := range data {
+		if data[i] != old.data[i] {
+			changed++
+		}
+	}
+	if changed == 0 {
+		fmt.Printf("No change in %v bytes at 0x%x.\n", length, address)
+		return nil
+	}
+	fmt.Printf("%v of %v bytes at 0x%x changed:\n", changed, length, address)
+	for offset := 0; offset < length; offset += 16 {
+		end := offset + 16
+		if end > length {
+			end = length
+		}
+		if bytes.Equal(old.data[offset:end], data[offset:end]) {
+			continue
+		}
+		fmt.Printf("- %08x %v\n", offset, hexdumpRow(old.data[offset:end]))
+		fmt.Printf("+ %08x %v\n", offset, hexdumpRow(data[offset:end]))
+		var marks strings.Builder
+		for i := offset; i < end; i++ {
+			if (i-offset)%8 == 0 {
+				marks.WriteByte(' ')
+			}
+			if data[i] != old.data[i] {
+				marks.WriteString("^^ ")
+			} else {
+				marks.WriteString("   ")
+			}
+		}
+		fmt.Printf("  %8v %v\n", "", strings.TrimRight(marks.String(), " "))
+	}
+	return nil
+}
+
+// hexdumpRow formats up to 16 bytes as a row of hexdump, without its
+// offset.
+func hexdumpRow(row []byte) string {
+	line := strings.SplitN(hexdump(row), "\n", 2)[0]
+	return strings.TrimPrefix(line, "00000000 ")
+}
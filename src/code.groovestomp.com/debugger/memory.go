@@ -0,0 +1,362 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// examineFormat is the /NFU suffix of an x command: a repeat count, a display
+// format and a unit size, as in gdb.
+type examineFormat struct {
+	count  int
+	format byte
+	unit   int
+}
+
+var examineUnits = map[byte]int{'b': 1, 'h': 2, 'w': 4, 'g': 8}
+
+// lastExamineFormat holds the format and unit of the previous x command,
+// which stick between invocations the way gdb's do.
+var lastExamineFormat = examineFormat{count: 1, format: 'x', unit: 4}
+
+// rawExamineFormats are the formats of "x <expr> <len> [<format>]".
+var rawExamineFormats = map[string]bool{"hex": true, "word": true, "string": true}
+
+// examineMemory implements "x[/NFU] <expr>" and "x <expr> <len> [<format>]".
+func examineMemory(pid int, command string, symbolTable *gosym.Table) error {
+	if strings.HasPrefix(command, "x -type ") {
+		return overlayMemory(pid, strings.TrimSpace(strings.TrimPrefix(command, "x -type ")), symbolTable)
+	}
+	if strings.HasPrefix(command, "x ") {
+		if expression, length, format, ok := parseRawExamine(commandArgument(command)); ok {
+			return dumpMemory(pid, expression, length, format, symbolTable)
+		}
+	}
+	spec := ""
+	if i := strings.Index(command, "/"); i >= 0 {
+		spec = command[i+1:]
+		if j := strings.IndexAny(spec, " \t"); j >= 0 {
+			spec = spec[:j]
+		}
+	}
+	format, err := parseExamineFormat(spec)
+	if err != nil {
+		return err
+	}
+
+	argument := commandArgument(command)
+	if argument == "" {
+		return fmt.Errorf("x needs an address expression")
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, argument)
+	if err != nil {
+		return err
+	}
+	address, err := valueAddress(v)
+	if err != nil {
+		return err
+	}
+
+	if format.format == 's' {
+		return examineStrings(pid, address, format.count)
+	}
+	if format.format == 'a' || format.format == 'c' {
+		// gdb pairs these formats with a fixed unit.
+		if format.format == 'a' {
+			format.unit = 8
+		} else {
+			format.unit = 1
+		}
+	}
+
+	perLine := 16 / format.unit
+	if format.format == 'a' {
+		perLine = 1
+	}
+	data, err := readMemoryPartial(pid, address, format.count*format.unit)
+	if data == nil {
+		return err
+	}
+	for i := 0; i < len(data)/format.unit; i++ {
+		if i%perLine == 0 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("0x%x:", address+uint64(i*format.unit))
+		}
+		fmt.Printf("\t%v", formatUnit(data[i*format.unit:(i+1)*format.unit], format.format, symbolTable))
+	}
+	fmt.Println()
+	return err
+}
+
+// parseRawExamine splits "<expr> <len> [<format>]".  The length is taken to
+// be one only when what comes before it doesn't end in an operator, so
+// "x p + 8" still examines p+8.
+func parseRawExamine(argument string) (expression string, length int, format string, ok bool) {
+	fields := strings.Fields(argument)
+	format = "hex"
+	if n := len(fields); n >= 3 && rawExamineFormats[fields[n-1]] {
+		format, fields = fields[n-1], fields[:n-1]
+	}
+	n := len(fields)
+	if n < 2 || strings.ContainsAny(fields[n-2][len(fields[n-2])-1:], "+-*/%&|^<>(,") {
+		return "", 0, "", false
+	}
+	length, err := strconv.Atoi(fields[n-1])
+	if err != nil || length <= 0 {
+		return "", 0, "", false
+	}
+	return strings.Join(fields[:n-1], " "), length, format, true
+}
+
+// dumpMemory prints length bytes from the address expression evaluates to:
+// as a hexdump with offsets from that address, as 8-byte words, or as a
+// quoted string.
+func dumpMemory(pid int, expression string, length int, format string, symbolTable *gosym.Table) error {
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, expression)
+	if err != nil {
+		return err
+	}
+	address, err := valueAddress(v)
+	if err != nil {
+		return err
+	}
+	// What was read before an interruption is shown, then the error.
+	data, err := readMemoryPartial(pid, address, length)
+	if data == nil {
+		return err
+	}
+
+	switch format {
+	case "string":
+		fmt.Printf("0x%x:\t%q\n", address, data)
+	case "word":
+		for i := 0; i < len(data); i += 8 {
+			if i%16 == 0 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("0x%x:", address+uint64(i))
+			}
+			end := i + 8
+			if end > len(data) {
+				end = len(data)
+			}
+			fmt.Printf("\t%v", formatUnit(data[i:end], 'x', symbolTable))
+		}
+		fmt.Println()
+	default:
+		fmt.Printf("0x%x:\n%v\n", address, hexdump(data))
+	}
+	return err
+}
+
+// overlaySegment is a part of a value overlaid on its hexdump: a field of
+// a struct, the padding between fields, or the whole of any other value.
+type overlaySegment struct {
+	offset, size int
+	label        string
+	typ          dwarf.Type // Nil for padding.
+}
+
+// overlayMemory implements "x -type <type> <expr>": a hexdump of a value of
+// the type at the address expr evaluates to, cut at the boundaries of its
+// fields, with each field's name, type and value beside its bytes.
+func overlayMemory(pid int, argument string, symbolTable *gosym.Table) error {
+	fields := strings.Fields(argument)
+	if len(fields) < 2 {
+		return fmt.Errorf("usage: x -type <type> <expr>")
+	}
+	typ, err := lookupType(fields[0])
+	if err != nil {
+		return err
+	}
+	size := int(typ.Size())
+	if size <= 0 {
+		return fmt.Errorf("%v has no size", typeName(typ))
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, strings.TrimSpace(strings.TrimPrefix(argument, fields[0])))
+	if err != nil {
+		return err
+	}
+	address, err := valueAddress(v)
+	if err != nil {
+		return err
+	}
+	data, err := readMemoryPartial(pid, address, size)
+	if data == nil {
+		return err
+	}
+
+	fmt.Printf("0x%x: %v, %v bytes\n", address, typeName(typ), size)
+	for _, segment := range overlaySegments(typ) {
+		end := segment.offset + segment.size
+		if end > len(data) {
+			end = len(data)
+		}
+		annotation := segment.label
+		if segment.typ != nil && end-segment.offset == segment.size {
+			field := &value{typ: segment.typ, addr: address + uint64(segment.offset), data: data[segment.offset:end]}
+			annotation = strings.TrimSpace(fmt.Sprintf("%v %v = %v", segment.label, typeName(segment.typ), formatValue(pid, field)))
+		}
+		// A row never crosses into the next field, so each field starts a
+		// row of its own.
+		for offset := segment.offset; offset < end; offset += 16 {
+			rowEnd := offset + 16
+			if rowEnd > end {
+				rowEnd = end
+			}
+			row := fmt.Sprintf("  +%04x %-68v  %v", offset, hexdumpRow(data[offset:rowEnd]), annotation)
+			fmt.Println(strings.TrimRight(row, " "))
+			annotation = ""
+		}
+	}
+	return err
+}
+
+// overlaySegments cuts a value of typ into its fields and the padding
+// between them, in the order they are in memory.
+func overlaySegments(typ dwarf.Type) []overlaySegment {
+	size := int(typ.Size())
+	st, ok := resolveTypedef(typ).(*dwarf.StructType)
+	if !ok {
+		return []overlaySegment{{offset: 0, size: size, typ: typ}}
+	}
+	var segments []overlaySegment
+	at := 0
+	for _, field := range st.Field {
+		offset, fieldSize := int(field.ByteOffset), int(field.Type.Size())
+		if offset < at || fieldSize < 0 {
+			// Bit fields and the like share bytes; C has them, Go doesn't.
+			continue
+		}
+		if offset > at {
+			segments = append(segments, overlaySegment{offset: at, size: offset - at, label: "(padding)"})
+		}
+		segments = append(segments, overlaySegment{offset: offset, size: fieldSize, label: field.Name, typ: field.Type})
+		at = offset + fieldSize
+	}
+	if at < size {
+		segments = append(segments, overlaySegment{offset: at, size: size - at, label: "(padding)"})
+	}
+	return segments
+}
+
+func parseExamineFormat(spec string) (examineFormat, error) {
+	format := lastExamineFormat
+	format.count = 1
+
+	digits := 0
+	for digits < len(spec) && spec[digits] >= '0' && spec[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 {
+		count, err := strconv.Atoi(spec[:digits])
+		if err != nil || count <= 0 {
+			return format, fmt.Errorf("invalid count %q", spec[:digits])
+		}
+		format.count = count
+	}
+
+	for _, c := range []byte(spec[digits:]) {
+		if unit, ok := examineUnits[c]; ok {
+			format.unit = unit
+			continue
+		}
+		switch c {
+		case 'x', 'd', 'u', 'o', 't', 'c', 'a', 's':
+			format.format = c
+		default:
+			return format, fmt.Errorf("invalid format letter %q", c)
+		}
+	}
+
+	lastExamineFormat.format = format.format
+	lastExamineFormat.unit = format.unit
+	return format, nil
+}
+
+// valueAddress turns the result of an address expression into an address:
+// pointers and integers are used as is, anything else in memory stands for
+// its own location.
+func valueAddress(v *value) (uint64, error) {
+	switch resolveTypedef(v.typ).(type) {
+	case *dwarf.PtrType, *dwarf.UintType, *dwarf.IntType:
+		return zeroExtend(v.data), nil
+	}
+	if v.addr != 0 {
+		return v.addr, nil
+	}
+	return 0, fmt.Errorf("%v is not an address", typeName(v.typ))
+}
+
+func formatUnit(data []byte, format byte, symbolTable *gosym.Table) string {
+	n := zeroExtend(data)
+	switch format {
+	case 'd':
+		return strconv.FormatInt(signExtend(data), 10)
+	case 'u':
+		return strconv.FormatUint(n, 10)
+	case 'o':
+		return "0" + strconv.FormatUint(n, 8)
+	case 't':
+		return fmt.Sprintf("%0*b", len(data)*8, n)
+	case 'c':
+		return fmt.Sprintf("%v %q", int8(data[0]), rune(data[0]))
+	case 'a':
+		if fn := symbolTable.PCToFunc(n); fn != nil {
+			return fmt.Sprintf("0x%x <%v+%v>", n, fn.Name, n-fn.Entry)
+		}
+		return fmt.Sprintf("0x%x", n)
+	}
+	return fmt.Sprintf("0x%0*x", len(data)*2, n)
+}
+
+// examineStrings prints count NUL-terminated strings starting at address.
+// One longer than maxStringLength is cut short, and the next string starts
+// where it was cut.  Like the other formats, it can be interrupted and
+// counts against eval-read-limit.
+func examineStrings(pid int, address uint64, count int) error {
+	for i := 0; i < count; i++ {
+		var text []byte
+		terminated := false
+		for len(text) < maxStringLength && !terminated {
+			size := maxStringLength - len(text)
+			if size > 8 {
+				size = 8
+			}
+			chunk, err := readMemoryPartial(pid, address+uint64(len(text)), size)
+			if err != nil {
+				return err
+			}
+			if end := strings.IndexByte(string(chunk), 0); end >= 0 {
+				chunk, terminated = chunk[:end], true
+			}
+			text = append(text, chunk...)
+		}
+		if terminated {
+			fmt.Printf("0x%x:\t%q\n", address, text)
+			address += uint64(len(text)) + 1
+		} else {
+			fmt.Printf("0x%x:\t%q...\n", address, text)
+			address += uint64(len(text))
+		}
+	}
+	return nil
+}
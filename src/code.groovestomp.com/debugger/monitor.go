@@ -0,0 +1,138 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// monitor is an expression sampled while the program runs: every period
+// the program is stopped just long enough to evaluate it.
+type monitor struct {
+	expression string
+	every      time.Duration
+	next       time.Time
+	samples    int
+}
+
+var monitors []*monitor
+
+// runMonitorCommand implements "monitor <expression> every <duration>", and
+// lists the monitors without an argument.
+func runMonitorCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "" {
+		if len(monitors) == 0 {
+			fmt.Println("No monitors.")
+		}
+		for _, m := range monitors {
+			fmt.Printf("%v every %v, %v samples\n", m.expression, m.every, m.samples)
+		}
+		return nil
+	}
+	i := strings.LastIndex(argument, " every ")
+	if i < 0 {
+		return fmt.Errorf("usage: monitor <expression> every <duration>")
+	}
+	expression := strings.TrimSpace(argument[:i])
+	every, err := time.ParseDuration(strings.TrimSpace(argument[i+len(" every "):]))
+	if err != nil || every <= 0 {
+		return fmt.Errorf("every needs a duration like 500ms")
+	}
+	if every < 10*time.Millisecond {
+		return fmt.Errorf("monitors sample at most every 10ms")
+	}
+	for _, m := range monitors {
+		if m.expression == expression {
+			m.every = every
+			fmt.Printf("Monitoring %v every %v.\n", expression, every)
+			return nil
+		}
+	}
+
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, expression)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Monitoring %v every %v; now %v.\n", expression, every, formatValue(pid, v))
+	monitors = append(monitors, &monitor{expression: expression, every: every})
+	return nil
+}
+
+// runUnmonitorCommand implements "unmonitor <expression>".
+func runUnmonitorCommand(argument string) error {
+	for i, m := range monitors {
+		if m.expression == argument {
+			monitors = append(monitors[:i], monitors[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%v is not monitored", argument)
+}
+
+// monitorDue reports whether a monitor should be sampled now.  A monitor
+// is first sampled a period after the program starts running.
+func monitorDue() bool {
+	now := time.Now()
+	due := false
+	for _, m := range monitors {
+		if m.next.IsZero() {
+			m.next = now.Add(m.every)
+		}
+		due = due || !now.Before(m.next)
+	}
+	return due
+}
+
+// holdMonitors puts off sampling while the program is stopped, until a
+// period after it runs again.
+func holdMonitors() {
+	for _, m := range monitors {
+		m.next = time.Time{}
+	}
+}
+
+// sampleMonitors stops the running threads, logs the value of each monitor
+// that is due with the time, and lets the threads go again.  Expressions
+// are evaluated on the thread the run was started from.
+func sampleMonitors(pid int, symbolTable *gosym.Table) {
+	var wasRunning []*thread
+	for _, t := range sortedThreads() {
+		if !t.stopped {
+			wasRunning = append(wasRunning, t)
+		}
+	}
+	stopOtherThreads(0)
+
+	now := time.Now()
+	ctx, ctxErr := newEvalContext(pid, symbolTable)
+	for _, m := range monitors {
+		if now.Before(m.next) {
+			continue
+		}
+		for !m.next.After(now) {
+			m.next = m.next.Add(m.every)
+		}
+		m.samples++
+		text := ""
+		if ctxErr != nil {
+			text = fmt.Sprintf("<%v>", ctxErr)
+		} else if v, err := evaluate(ctx, m.expression); err != nil {
+			text = fmt.Sprintf("<%v>", err)
+		} else {
+			text = formatValue(pid, v)
+		}
+		fmt.Printf("%v %v = %v\n", now.Format("15:04:05.000"), m.expression, text)
+	}
+	endEvaluation()
+
+	for _, t := range wasRunning {
+		if _, ok := threads[t.tid]; ok && t.stopped {
+			resumeThread(t)
+		}
+	}
+}
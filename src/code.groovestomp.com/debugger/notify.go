@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// notifyCommand is a shell command run whenever the program stops while
+// the prompt has been handed back, in the background or in non-stop mode,
+// or is empty.
+var notifyCommand string
+
+func parseNotifyCommand(value string) error {
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	} else if strings.HasPrefix(value, "\"") || strings.HasPrefix(value, "'") {
+		return fmt.Errorf("unterminated quote in %v", value)
+	}
+	if value == "off" {
+		value = ""
+	}
+	notifyCommand = value
+	return nil
+}
+
+func formatNotifyCommand() string {
+	if notifyCommand == "" {
+		return "off"
+	}
+	return strconv.Quote(notifyCommand)
+}
+
+// notifyStop runs the notify command for a stop nobody may be watching the
+// terminal for.  It learns what happened from GODEBUGGER_STOP, a message
+// like the one printed, and from GODEBUGGER_PID, GODEBUGGER_FILE and
+// GODEBUGGER_LINE.  The command runs on its own; the debugger doesn't wait
+// for it, and its output goes to stderr.
+func notifyStop(message string, file string, line int) {
+	if notifyCommand == "" {
+		return
+	}
+	cmd := exec.Command("/bin/sh", "-c", notifyCommand)
+	cmd.Env = append(os.Environ(),
+		"GODEBUGGER_STOP="+message,
+		fmt.Sprintf("GODEBUGGER_PID=%v", processID),
+		"GODEBUGGER_FILE="+file,
+		fmt.Sprintf("GODEBUGGER_LINE=%v", line))
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if _, err := startHelper(cmd); err != nil {
+		fmt.Println("notify-cmd:", err)
+	}
+}
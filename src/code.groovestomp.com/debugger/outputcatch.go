@@ -0,0 +1,128 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// outputWriteFunctions are where what the program prints to os.Stdout and
+// os.Stderr goes through, fmt's and log's output included.  Both take the
+// *os.File and then the bytes or string, in RAX, RBX and RCX.
+var outputWriteFunctions = []string{"os.(*File).Write", "os.(*File).WriteString"}
+
+var (
+	// catchOutput is the pattern of "catch output", nil when it is off.  It
+	// survives restarts.
+	catchOutput *regexp.Regexp
+
+	// outputCatchAddresses are the entries of outputWriteFunctions while the
+	// catch is armed.
+	outputCatchAddresses = make(map[uint64]string)
+
+	// pendingOutput is the start of the line each of stdout and stderr is
+	// in the middle of, which the next writes finish.
+	pendingOutput = make(map[string]string)
+
+	// caughtOutput is the stream and line the program stopped for.
+	caughtOutputStream, caughtOutput string
+)
+
+// runCatchOutputCommand implements "catch output <regexp>" and "catch
+// output off".
+func runCatchOutputCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "" {
+		return fmt.Errorf("usage: catch output <regexp>|off")
+	}
+	if argument == "off" {
+		disarmCatcher(pid, outputCatchAddresses)
+		catchOutput = nil
+		fmt.Println("Catchpoint on output removed.")
+		return nil
+	}
+	pattern, err := regexp.Compile(argument)
+	if err != nil {
+		return err
+	}
+	disarmCatcher(pid, outputCatchAddresses)
+	if err := armOutputCatcher(pid, symbolTable); err != nil {
+		return err
+	}
+	catchOutput = pattern
+	fmt.Printf("Catchpoint on lines written to stdout or stderr matching %v.\n", pattern)
+	return nil
+}
+
+// armOutputCatcher puts internal breakpoints on outputWriteFunctions.
+func armOutputCatcher(pid int, symbolTable *gosym.Table) error {
+	pendingOutput = make(map[string]string)
+	for _, name := range outputWriteFunctions {
+		fn := symbolTable.LookupFunc(name)
+		if fn == nil {
+			continue
+		}
+		if err := setBreakpoint(pid, fn.Entry); err != nil {
+			return err
+		}
+		outputCatchAddresses[fn.Entry] = name
+	}
+	if len(outputCatchAddresses) == 0 {
+		return fmt.Errorf("the program doesn't write to files through package os")
+	}
+	return nil
+}
+
+// outputMatches reads what a thread is writing at one of
+// outputCatchAddresses and reports whether it finishes a line of stdout or
+// stderr that catch output should stop for.  Writes to other files are
+// passed over.
+func outputMatches(tid int) bool {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(tid, &regs); err != nil || catchOutput == nil {
+		return false
+	}
+	stream := ""
+	for _, name := range []string{"os.Stdout", "os.Stderr"} {
+		if f, err := readGlobal(tid, name); err == nil && zeroExtend(f.data) == regs.Rax {
+			stream = strings.ToLower(strings.TrimPrefix(name, "os."))
+		}
+	}
+	if stream == "" {
+		return false
+	}
+	length := regs.Rcx
+	if length > maxStringLength {
+		length = maxStringLength
+	}
+	data, err := readMemory(tid, regs.Rbx, int(length))
+	if err != nil {
+		return false
+	}
+
+	lines := strings.Split(pendingOutput[stream]+string(data), "\n")
+	pending := lines[len(lines)-1]
+	if len(pending) > maxStringLength {
+		pending = pending[len(pending)-maxStringLength:]
+	}
+	pendingOutput[stream] = pending
+	for _, line := range lines[:len(lines)-1] {
+		if catchOutput.MatchString(line) {
+			caughtOutputStream, caughtOutput = stream, line
+			return true
+		}
+	}
+	return false
+}
+
+// showOutputStop reports a stop for a line of output, which the program is
+// about to write, and selects the frame that printed it: the innermost one
+// outside os, fmt, log, log/slog, bufio and the runtime.
+func showOutputStop(pid int, symbolTable *gosym.Table) {
+	fmt.Printf("\nWriting a line to %v matching %v: %v\n", caughtOutputStream, catchOutput, caughtOutput)
+	if frame, ok := selectFrameOutside(pid, symbolTable, "os.", "fmt.", "log.", "log/slog.", "bufio.", "internal/", "runtime."); ok {
+		fmt.Printf("Written from %v at %v:%v, frame #%v.\n", frame.fn.Name, frame.file, frame.line, selection.index)
+		showListing(frame.file, frame.line)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// pendingPause is the -pause of the breakpoint the program last
+	// stopped at, until the prompt takes it up.
+	pendingPause time.Duration
+
+	// pendingCommands is the command list of the breakpoint the program
+	// last stopped at, until the prompt queues it.
+	pendingCommands []string
+
+	// pauseDeadline is when the prompt continues the program by itself, or
+	// zero.  Typing anything at the prompt clears it.
+	pauseDeadline time.Time
+)
+
+// startPause has the prompt continue the program after the -pause of the
+// breakpoint it has just stopped at.
+func startPause() {
+	if len(scriptCommands) > 0 && pendingPause > 0 && !running {
+		return // The breakpoint's commands run first.
+	}
+	if pendingPause <= 0 || running {
+		pendingPause = 0
+		return
+	}
+	fmt.Printf("Continuing in %v; type anything to stay stopped.\n", pendingPause)
+	pauseDeadline = time.Now().Add(pendingPause)
+	pendingPause = 0
+}
+
+// pauseOver reports whether the pause at a breakpoint has run out, ending
+// it.
+func pauseOver() bool {
+	if pauseDeadline.IsZero() || time.Now().Before(pauseDeadline) {
+		return false
+	}
+	pauseDeadline = time.Time{}
+	return true
+}
+
+// queueBreakpointCommands puts the command list of the breakpoint the
+// program has just stopped at ahead of any other queued commands,
+// reporting whether there was one.
+func queueBreakpointCommands() bool {
+	commands := pendingCommands
+	pendingCommands = nil
+	if len(commands) == 0 || running {
+		return false
+	}
+	scriptCommands = append(append([]string(nil), commands...), scriptCommands...)
+	return true
+}
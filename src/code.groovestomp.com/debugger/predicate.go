@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/gosym"
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// fastCondition is a breakpoint condition compiled down to integer
+// comparisons over variables at fixed places, read with one
+// process_vm_readv a hit instead of evaluating the expression in full.
+// Only conditions made of comparisons between integer variables and
+// constants, joined by && and ||, compile.
+type fastCondition struct {
+	reads  []fastRead
+	size   int
+	root   *fastNode
+	cfaOff int64
+}
+
+// fastRead is a variable in memory: at address, or at offset from the CFA
+// for a local.
+type fastRead struct {
+	address  uint64
+	relative bool
+	size     int
+}
+
+// fastOperand is a side of a comparison: a constant, a read, or a register.
+type fastOperand struct {
+	constant uint64
+	read     int // Index into reads, or -1.
+	register int // DWARF register number, or -1.
+	size     int
+	signed   bool
+}
+
+// fastNode is a comparison of x and y, or && or || of left and right.
+type fastNode struct {
+	op          string
+	x, y        fastOperand
+	left, right *fastNode
+}
+
+// compileCondition compiles a breakpoint's condition for its address, or
+// returns nil if the condition is beyond what a fastCondition can do.
+func compileCondition(cond expr, pc uint64, symbolTable *gosym.Table) *fastCondition {
+	_, _, fn := symbolTable.PCToLine(pc)
+	sub := findSubprogram(pc)
+	if fn == nil || sub == nil || dwarfData == nil {
+		return nil
+	}
+	c := &fastCondition{}
+	if offset, ok := cfaOffset(pc); ok {
+		c.cfaOff = offset
+	} else {
+		c.cfaOff = -1
+	}
+	compiler := &conditionCompiler{c: c, pc: pc, fn: fn, sub: sub}
+	root, ok := compiler.node(cond)
+	if !ok {
+		return nil
+	}
+	c.root = root
+	return c
+}
+
+type conditionCompiler struct {
+	c   *fastCondition
+	pc  uint64
+	fn  *gosym.Func
+	sub *subprogram
+}
+
+func (cc *conditionCompiler) node(e expr) (*fastNode, bool) {
+	b, ok := e.(*binaryExpr)
+	if !ok {
+		return nil, false
+	}
+	switch b.op {
+	case "&&", "||":
+		left, ok := cc.node(b.x)
+		if !ok {
+			return nil, false
+		}
+		right, ok := cc.node(b.y)
+		if !ok {
+			return nil, false
+		}
+		return &fastNode{op: b.op, left: left, right: right}, true
+	case "==", "!=", "<", "<=", ">", ">=":
+		x, ok := cc.operand(b.x)
+		if !ok {
+			return nil, false
+		}
+		y, ok := cc.operand(b.y)
+		if !ok {
+			return nil, false
+		}
+		return &fastNode{op: b.op, x: x, y: y}, true
+	}
+	return nil, false
+}
+
+// operand compiles a constant, a local or a package-level variable, found
+// the way the evaluator's lookup finds it.
+func (cc *conditionCompiler) operand(e expr) (fastOperand, bool) {
+	switch e := e.(type) {
+	case *numberExpr, *unaryExpr:
+		if u, ok := e.(*unaryExpr); ok {
+			if _, ok := u.x.(*numberExpr); !ok || u.op != "-" {
+				return fastOperand{}, false
+			}
+		}
+		v, err := (&evalContext{}).eval(e)
+		if err != nil || isFloat(v) {
+			return fastOperand{}, false
+		}
+		n, signed, err := integerValue(v)
+		if err != nil {
+			return fastOperand{}, false
+		}
+		return fastOperand{constant: n, read: -1, register: -1, signed: signed}, true
+	case *identExpr:
+		if entry, found := cc.local(e.name); found {
+			return cc.variable(entry, cc.sub.unit, true)
+		}
+		for _, candidate := range []string{e.name, cc.fn.PackageName() + "." + e.name, "main." + e.name} {
+			if global, ok := globals[candidate]; ok {
+				return cc.variable(global.entry, global.unit, false)
+			}
+		}
+	case *selectorExpr:
+		if ident, ok := e.x.(*identExpr); ok {
+			if _, found := cc.local(ident.name); !found {
+				if global, ok := globals[ident.name+"."+e.field]; ok {
+					return cc.variable(global.entry, global.unit, false)
+				}
+			}
+		}
+	}
+	return fastOperand{}, false
+}
+
+// local finds the innermost parameter or local variable called name in
+// scope at the breakpoint.
+func (cc *conditionCompiler) local(name string) (*dwarf.Entry, bool) {
+	var found *dwarf.Entry
+	forEachScopeVariable(cc.sub, cc.pc, func(entry *dwarf.Entry) {
+		if n, _ := entry.Val(dwarf.AttrName).(string); n == name {
+			found = entry
+		}
+	})
+	return found, found != nil
+}
+
+// variable compiles an integer variable whose location at the breakpoint
+// is a register, an address, or an offset from the frame base.
+func (cc *conditionCompiler) variable(entry *dwarf.Entry, unit *compileUnit, local bool) (fastOperand, bool) {
+	typeOffset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return fastOperand{}, false
+	}
+	typ, err := dwarfData.Type(typeOffset)
+	if err != nil {
+		return fastOperand{}, false
+	}
+	size := int(typ.Size())
+	var signed bool
+	switch resolveTypedef(typ).(type) {
+	case *dwarf.IntType, *dwarf.CharType:
+		signed = true
+	case *dwarf.UintType, *dwarf.UcharType, *dwarf.BoolType:
+	default:
+		return fastOperand{}, false
+	}
+	if size != 1 && size != 2 && size != 4 && size != 8 {
+		return fastOperand{}, false
+	}
+
+	field := entry.AttrField(dwarf.AttrLocation)
+	if field == nil {
+		return fastOperand{}, false
+	}
+	var expression []byte
+	switch field.Class {
+	case dwarf.ClassExprLoc:
+		expression = field.Val.([]byte)
+	case dwarf.ClassLocListPtr:
+		if expression, err = locationListEntry(unit, field.Val.(int64), cc.pc); err != nil {
+			return fastOperand{}, false
+		}
+	default:
+		return fastOperand{}, false
+	}
+
+	operand := fastOperand{read: -1, register: -1, size: size, signed: signed}
+	read := fastRead{size: size}
+	switch {
+	case len(expression) == 1 && expression[0] >= 0x50 && expression[0] <= 0x6f: // DW_OP_reg0..31
+		operand.register = int(expression[0] - 0x50)
+		return operand, local
+	case len(expression) == 9 && expression[0] == 0x03: // DW_OP_addr
+		read.address = zeroExtend(expression[1:])
+	case len(expression) > 1 && expression[0] == 0x91 && local && cc.c.cfaOff >= 0: // DW_OP_fbreg
+		buf := bytes.NewBuffer(expression[1:])
+		offset := readSLEB(buf)
+		if buf.Len() != 0 {
+			return fastOperand{}, false
+		}
+		read.address = uint64(cc.c.cfaOff + offset)
+		read.relative = true
+	default:
+		return fastOperand{}, false
+	}
+	operand.read = len(cc.c.reads)
+	cc.c.reads = append(cc.c.reads, read)
+	cc.c.size += size
+	return operand, true
+}
+
+// sysProcessVMReadv is process_vm_readv on amd64, which package syscall
+// has no name for.
+const sysProcessVMReadv = 310
+
+// remoteIovec is a struct iovec naming memory of the tracee.
+type remoteIovec struct {
+	base   uint64
+	length uint64
+}
+
+// holds evaluates the condition for the thread stopped at it.
+func (c *fastCondition) holds(pid int) (bool, error) {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return false, err
+	}
+	data := make([]byte, c.size)
+	if len(c.reads) > 0 {
+		remote := make([]remoteIovec, len(c.reads))
+		for i, r := range c.reads {
+			remote[i] = remoteIovec{base: r.address, length: uint64(r.size)}
+			if r.relative {
+				remote[i].base += regs.Rsp
+			}
+		}
+		local := syscall.Iovec{Base: &data[0], Len: uint64(len(data))}
+		n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(pid),
+			uintptr(unsafe.Pointer(&local)), 1,
+			uintptr(unsafe.Pointer(&remote[0])), uintptr(len(remote)), 0)
+		if errno != 0 {
+			return false, errno
+		}
+		if int(n) != len(data) {
+			return false, errors.New("short read")
+		}
+	}
+
+	offsets := make([]int, len(c.reads))
+	for i, offset := 0, 0; i < len(c.reads); i++ {
+		offsets[i] = offset
+		offset += c.reads[i].size
+	}
+	value := func(o fastOperand) uint64 {
+		var raw []byte
+		switch {
+		case o.read >= 0:
+			raw = data[offsets[o.read] : offsets[o.read]+o.size]
+		case o.register >= 0:
+			word, _ := dwarfRegister(&regs, o.register)
+			raw = make([]byte, 8)
+			for i := range raw {
+				raw[i] = byte(word >> (8 * i))
+			}
+			raw = raw[:o.size]
+		default:
+			return o.constant
+		}
+		if o.signed {
+			return uint64(signExtend(raw))
+		}
+		return zeroExtend(raw)
+	}
+	var eval func(n *fastNode) bool
+	eval = func(n *fastNode) bool {
+		switch n.op {
+		case "&&":
+			return eval(n.left) && eval(n.right)
+		case "||":
+			return eval(n.left) || eval(n.right)
+		}
+		a, b := value(n.x), value(n.y)
+		order := 0
+		switch {
+		case a == b:
+		case n.x.signed && n.y.signed && int64(a) < int64(b), !(n.x.signed && n.y.signed) && a < b:
+			order = -1
+		default:
+			order = 1
+		}
+		switch n.op {
+		case "==":
+			return order == 0
+		case "!=":
+			return order != 0
+		case "<":
+			return order < 0
+		case "<=":
+			return order <= 0
+		case ">":
+			return order > 0
+		}
+		return order >= 0
+	}
+	return eval(c.root), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"debug/gosym"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// presetFile is where a project keeps breakpoints shared by its team,
+// relative to the directory holding its go.mod.
+const presetFile = ".godbg/breakpoints.json"
+
+// breakpointPreset is one entry of the preset file, e.g.
+//
+//	[{"location": "auth.go:42", "condition": "user == nil", "group": "auth"}]
+//
+// calledBy is a pattern the calling function must match, as with -calledby.
+type breakpointPreset struct {
+	Location  string `json:"location"`
+	Condition string `json:"condition"`
+	Group     string `json:"group"`
+	CalledBy  string `json:"calledBy"`
+}
+
+// loadBreakpointPresets offers to set the breakpoints in the preset file of
+// the module mainFile belongs to.
+func loadBreakpointPresets(pid int, mainFile string, symbolTable *gosym.Table) {
+	path := findProjectFile(filepath.Dir(mainFile), presetFile)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var presets []breakpointPreset
+	if err := json.Unmarshal(data, &presets); err != nil {
+		fmt.Printf("%v: %v\n", path, err)
+		return
+	}
+	if len(presets) == 0 {
+		return
+	}
+
+	fmt.Printf("Load %v breakpoints from %v? [y/N] ", len(presets), path)
+	answer, err := readLine()
+	if err != nil {
+		fmt.Println()
+		return
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return
+	}
+
+	for _, preset := range presets {
+		if _, err := createBreakpoint(pid, preset.argument(), symbolTable); err != nil {
+			fmt.Printf("%v: %v\n", preset.Location, err)
+		}
+	}
+}
+
+// argument is the break command argument that sets a preset.
+func (preset breakpointPreset) argument() string {
+	argument := preset.Location
+	if preset.Group != "" {
+		argument += " -group " + preset.Group
+	}
+	if preset.CalledBy != "" {
+		argument += " -calledby " + preset.CalledBy
+	}
+	if preset.Condition != "" {
+		argument += " if " + preset.Condition
+	}
+	return argument
+}
+
+// findProjectFile walks up from dir to the module root and returns the path
+// of the file name there, such as presetFile, if it has one.
+func findProjectFile(dir, name string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// runBreakpointsImport implements "breakpoints import <file>": it sets the
+// breakpoints of a file of break commands, one a line, as a teammate might
+// send or a script write, reporting on each.  Blank lines and lines
+// starting with # are skipped.
+func runBreakpointsImport(pid int, path string, symbolTable *gosym.Table) error {
+	if path == "" {
+		return fmt.Errorf("usage: breakpoints import <file>")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	breakpointsReported = true
+	defer func() { breakpointsReported = false }()
+	imported, tried := 0, 0
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tried++
+		argument := commandArgument(line)
+		if isHardwareBreakpointCommand(line) {
+			argument = "-hardware " + argument
+		} else if !isBreakpointCommand(line) || strings.HasPrefix(argument, "init ") {
+			fmt.Printf("%v:%v: not a break or hbreak command: %v\n", path, number, line)
+			continue
+		}
+		bp, err := createBreakpoint(pid, argument, symbolTable)
+		if err != nil {
+			fmt.Printf("%v:%v: %v\n", path, number, err)
+			continue
+		}
+		imported++
+		fmt.Printf("%v:%v: Breakpoint %v at 0x%x: %v:%v\n", path, number, bp.id, bp.pc, bp.file, bp.line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %v of %v breakpoints from %v.\n", imported, tried, path)
+	return nil
+}
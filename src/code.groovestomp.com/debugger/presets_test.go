@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestBreakpointsImport checks that breakpoints import reports each line of
+// the file, the breakpoints it set as well as the lines it couldn't use.
+func TestBreakpointsImport(t *testing.T) {
+	program := testProgram(t, "threads")
+	path := filepath.Join(testDir, "import.txt")
+	file := "# set by a script\nbreak main.greeting\nbreak main.nosuchfunction\nhbreak main.spin\n"
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := runDebugger(t, program, "breakpoints import "+path)
+	for _, pattern := range []string{
+		`(?m)^> .*import\.txt:2: Breakpoint 1 at 0x[0-9a-f]+: .*main\.go:\d+$`,
+		`(?m)^.*import\.txt:3: .+$`,
+		`(?m)^.*import\.txt:4: Breakpoint 2 at 0x[0-9a-f]+: .*main\.go:\d+$`,
+		`(?m)^Imported 2 of 3 breakpoints from .*import\.txt\.$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Errorf("no line matching %v in:\n%v", pattern, output)
+		}
+	}
+	if strings.Count(output, "Breakpoint 1 at") != 1 {
+		t.Errorf("breakpoint 1 not reported once:\n%v", output)
+	}
+}
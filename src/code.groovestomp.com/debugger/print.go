@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// printModes are the modifiers print accepts before its expression.
+var printModes = map[string]bool{"-s": true, "-utf8": true, "-hexdump": true, "-ctx": true}
+
+// runPrintCommand implements "print [-s|-utf8|-hexdump] <expr>".
+func runPrintCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	mode := ""
+	if fields := strings.SplitN(argument, " ", 2); len(fields) == 2 && printModes[fields[0]] {
+		mode, argument = fields[0], fields[1]
+	}
+
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	result, err := evaluate(ctx, argument)
+	if err != nil {
+		return err
+	}
+
+	if mode == "-ctx" {
+		return showContext(pid, result)
+	}
+	text := formatValue(pid, result)
+	if mode != "" {
+		data, total, err := byteContents(pid, result)
+		if err != nil {
+			return err
+		}
+		switch mode {
+		case "-s":
+			text = strconv.Quote(string(data))
+		case "-utf8":
+			text = formatUTF8(data)
+		case "-hexdump":
+			text = typeName(result.typ) + "\n" + hexdump(data)
+		}
+		if total > int64(len(data)) {
+			text += fmt.Sprintf("...+%v more", total-int64(len(data)))
+		}
+	}
+
+	n := recordLastValue(result)
+	fmt.Printf("$%v = %v%v\n", n, text, provenanceSuffix(ctx))
+	return nil
+}
+
+// showFrameVariables implements "info args" and "info locals" for the
+// selected frame.
+func showFrameVariables(pid int, parameters bool, symbolTable *gosym.Table) error {
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	if ctx.frame.fn == nil {
+		return fmt.Errorf("no function at 0x%x", getPC(pid))
+	}
+	variables, err := frameVariables(pid, ctx.frame, ctx.regs)
+	if err != nil {
+		return err
+	}
+	var selected []variable
+	for _, v := range variables {
+		if v.parameter == parameters {
+			selected = append(selected, v)
+		}
+	}
+	if len(selected) == 0 {
+		if parameters {
+			fmt.Println("No arguments.")
+		} else {
+			fmt.Println("No locals.")
+		}
+		return nil
+	}
+	showVariables(pid, selected)
+	return nil
+}
+
+// showRegisters implements "regs": the whole register set of the current
+// thread, with the function the instruction pointer is in.
+func showRegisters(pid int, symbolTable *gosym.Table) error {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return err
+	}
+	registers := []struct {
+		name  string
+		value uint64
+	}{
+		{"rax", regs.Rax}, {"rbx", regs.Rbx}, {"rcx", regs.Rcx}, {"rdx", regs.Rdx},
+		{"rsi", regs.Rsi}, {"rdi", regs.Rdi}, {"rbp", regs.Rbp}, {"rsp", regs.Rsp},
+		{"r8", regs.R8}, {"r9", regs.R9}, {"r10", regs.R10}, {"r11", regs.R11},
+		{"r12", regs.R12}, {"r13", regs.R13}, {"r14", regs.R14}, {"r15", regs.R15},
+		{"rip", regs.Rip}, {"eflags", regs.Eflags}, {"orig_rax", regs.Orig_rax},
+		{"cs", regs.Cs}, {"ss", regs.Ss}, {"ds", regs.Ds}, {"es", regs.Es},
+		{"fs", regs.Fs}, {"gs", regs.Gs}, {"fs_base", regs.Fs_base}, {"gs_base", regs.Gs_base},
+	}
+	for _, r := range registers {
+		annotation := ""
+		if r.name == "rip" {
+			if fn := symbolTable.PCToFunc(r.value); fn != nil {
+				annotation = fmt.Sprintf(" <%v+%v>", fn.Name, r.value-fn.Entry)
+			}
+		}
+		fmt.Printf("%-9v 0x%016x %20v%v\n", r.name, r.value, int64(r.value), annotation)
+	}
+	return nil
+}
+
+func isByteType(typ dwarf.Type) bool {
+	switch resolveTypedef(typ).(type) {
+	case *dwarf.UintType, *dwarf.UcharType:
+		return typ.Size() == 1
+	}
+	return false
+}
+
+// byteContents returns up to maxStringLength bytes of a string, byte slice or
+// byte array, along with its full length.
+func byteContents(pid int, v *value) ([]byte, int64, error) {
+	if v.typ == untypedStringType {
+		return v.data, int64(len(v.data)), nil
+	}
+
+	var address uint64
+	var length int64
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.ArrayType:
+		if !isByteType(t.Type) {
+			break
+		}
+		return v.data, int64(len(v.data)), nil
+	case *dwarf.StructType:
+		name := typeName(v.typ)
+		switch {
+		case name == "string" && len(v.data) >= 16:
+			address = binary.LittleEndian.Uint64(v.data)
+			length = int64(binary.LittleEndian.Uint64(v.data[8:]))
+		case strings.HasPrefix(name, "[]"):
+			arrayField := structField(t, "array")
+			lenField := structField(t, "len")
+			if arrayField == nil || lenField == nil || !isByteType(arrayField.Type.(*dwarf.PtrType).Type) {
+				return nil, 0, fmt.Errorf("%v is not a byte slice", name)
+			}
+			address = zeroExtend(fieldValue(v, arrayField).data)
+			length = signExtend(fieldValue(v, lenField).data)
+		default:
+			return nil, 0, fmt.Errorf("%v is not a string or byte buffer", name)
+		}
+		if length < 0 {
+			return nil, 0, fmt.Errorf("invalid length %v", length)
+		}
+		shown := length
+		if shown > maxStringLength {
+			shown = maxStringLength
+		}
+		data, err := readMemory(pid, address, int(shown))
+		if err != nil {
+			return nil, 0, err
+		}
+		return data, length, nil
+	}
+	return nil, 0, fmt.Errorf("%v is not a string or byte buffer", typeName(v.typ))
+}
+
+// formatBytes renders a byte buffer as an escaped string next to its hex
+// encoding, which is more useful than a list of decimal numbers.
+func formatBytes(data []byte, total int64) string {
+	more := ""
+	if total > int64(len(data)) {
+		more = fmt.Sprintf("...+%v more", total-int64(len(data)))
+	}
+	return fmt.Sprintf("%v%v (hex: % x%v)", strconv.Quote(string(data)), more, data, more)
+}
+
+// formatUTF8 decodes data as UTF-8, printing valid text as is and escaping
+// only control characters and invalid sequences.
+func formatUTF8(data []byte) string {
+	var b bytes.Buffer
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02x`, data[0])
+		case strconv.IsPrint(r) || r == ' ':
+			b.WriteRune(r)
+		default:
+			b.WriteString(strings.Trim(strconv.QuoteRune(r), "'"))
+		}
+		data = data[size:]
+	}
+	return b.String()
+}
+
+// hexdump renders data in the layout of hexdump -C.
+func hexdump(data []byte) string {
+	var b bytes.Buffer
+	for offset := 0; offset < len(data); offset += 16 {
+		end := offset + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		line := data[offset:end]
+
+		fmt.Fprintf(&b, "%08x ", offset)
+		for i := 0; i < 16; i++ {
+			if i%8 == 0 {
+				b.WriteByte(' ')
+			}
+			if i < len(line) {
+				fmt.Fprintf(&b, "%02x ", line[i])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString(" |")
+		for _, c := range line {
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteString("|\n")
+	}
+	return fmt.Sprintf("%v%08x", b.String(), len(data))
+}
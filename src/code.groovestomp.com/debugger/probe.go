@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// runProbe implements "eval <binary> -at <location> -expr <expression>":
+// it launches the program, runs it to the location, prints the expression
+// there and kills the program again, without a session to type commands
+// in.  The program's arguments follow the flags, set apart by a "--" if
+// they look like flags themselves.
+func runProbe(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	at := flags.String("at", "", "the location to stop at, as for break")
+	expression := flags.String("expr", "", "the expression to print there")
+	flags.BoolVar(&noCalls, "no-calls", false, "never call functions in the program from the expression")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v eval <binary> -at <location> -expr <expression> [-- <args>]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	// The binary may come before the flags, or after them.
+	binary := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		binary, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	rest := flags.Args()
+	if binary == "" && len(rest) > 0 {
+		binary, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	if binary == "" || *at == "" || *expression == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	programArgs = rest
+
+	pid, exe, symbolTable := startTracee(binary, 0, false)
+	defer exe.Close()
+	bp, err := createBreakpoint(pid, *at, symbolTable)
+	if err != nil {
+		killTracee()
+		fatal(err)
+	}
+
+	status := resume(pid, false)
+	if status == nil {
+		status = waitForStop(pid, symbolTable, time.Time{})
+	}
+	pid = currentThread
+	if status.Exited() || status.Signaled() {
+		fatalf("The program ended without reaching %v.", *at)
+	}
+	if getPC(pid) != bp.pc {
+		pcSourceFile, pcSourceLine, _ = symbolTable.PCToLine(getPC(pid))
+		killTracee()
+		fatalf("The program stopped at %v:%v before reaching %v.", pcSourceFile, pcSourceLine, *at)
+	}
+
+	ctx, err := newEvalContext(pid, symbolTable)
+	var result *value
+	if err == nil {
+		result, err = evaluate(ctx, *expression)
+	}
+	if err != nil {
+		killTracee()
+		fatal(err)
+	}
+	fmt.Println(formatValue(pid, result))
+	killTracee()
+}
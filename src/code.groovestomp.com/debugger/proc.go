@@ -0,0 +1,421 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"code.groovestomp.com/debugger/target"
+)
+
+var (
+	// waitExec is the name of the binary given with -wait-exec, which the
+	// program launched, a wrapper, runs at some point.
+	waitExec string
+
+	// programArgs are the arguments the program is launched with, those
+	// after its path on the command line, and programEnv the environment
+	// entries given with -env.  With -wait-exec they go to the wrapper.
+	programArgs []string
+	programEnv  envFlag
+
+	// wrapperPID is the process of the running wrapper.
+	wrapperPID int
+
+	// interruptRequested is set when Ctrl-C arrives during a command that
+	// single-steps, which then stops at the next opportunity.
+	interruptRequested bool
+
+	// stepDeadline, when set, is how long cont waits before returning with
+	// the tracee still running.
+	stepDeadline time.Time
+
+	// stepping is set while next runs, for scheduler-locking step.
+	stepping bool
+)
+
+func init() {
+	// Every ptrace request must come from the thread that started the tracee.
+	runtime.LockOSThread()
+}
+
+// handleInterrupts keeps Ctrl-C from killing the debugger.  While a
+// command works it cancels it; otherwise a launched tracee gets the
+// terminal's SIGINT itself and an attached one is sent it.
+func handleInterrupts(attached bool) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			if interruptCommand() {
+				continue
+			}
+			if attached {
+				kill(processID, syscall.SIGINT)
+			}
+		}
+	}()
+}
+
+// leavingTracee is set once the debugger has started letting go of the
+// tracee, so that a failure on the way out exits at once.
+var leavingTracee bool
+
+// leaveTracee ends the session with the tracee: a process that was
+// attached to is stopped, rid of the debugger's INT3s and left to run on,
+// and one that was launched is killed.
+func leaveTracee(pid int, symbolTable *gosym.Table) {
+	leavingTracee = true
+	if !attachedToProcess {
+		killTracee()
+		return
+	}
+	if running {
+		interruptBackground(pid, symbolTable)
+	}
+	detachThreads()
+}
+
+// fatal reports an error the debugger can't go on after and exits, leaving
+// the tracee first, as quit does, rather than to the kernel: an attached
+// process detached with INT3s still in its code dies at the next one.
+func fatal(v ...interface{}) {
+	if !leavingTracee && processID != 0 {
+		leavingTracee = true
+		if attachedToProcess {
+			detachThreads()
+		} else {
+			killTracee()
+		}
+	}
+	fmt.Fprintln(os.Stderr, v...)
+	os.Exit(1)
+}
+
+// fatalf is fatal with a format.
+func fatalf(format string, v ...interface{}) {
+	fatal(fmt.Sprintf(format, v...))
+}
+
+func initTracee(path string) int {
+	if waitExec != "" {
+		return initWrappedTracee(path)
+	}
+	t, err := target.Launch(path, programArgs, launchOptions())
+	if err != nil {
+		fatal(err)
+	}
+	logPtrace("started %v as %v with PTRACE_TRACEME", path, t.Pid())
+	useTarget(t)
+	return t.Pid()
+}
+
+// initWrappedTracee runs the wrapper at path with programArgs until it
+// starts the binary named by -wait-exec, which becomes the tracee.
+func initWrappedTracee(path string) int {
+	fmt.Printf("Running %v until it executes %v...\n", path, waitExec)
+	t, err := target.LaunchUntilExec(waitExec, path, programArgs, launchOptions())
+	if err != nil {
+		fatal(err)
+	}
+	logPtrace("started %v as %v with PTRACE_TRACEME; it executed %v as %v", path, t.Wrapper(), waitExec, t.Pid())
+	wrapperPID = t.Wrapper()
+	useTarget(t)
+	return t.Pid()
+}
+
+// tracedBinary is the binary to load the symbols of for a program launched
+// from path: the one the wrapper executed, with -wait-exec.
+func tracedBinary(pid int, path string) string {
+	if waitExec == "" {
+		return path
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		fatal(err)
+	}
+	return exe
+}
+
+// attachTracee stops a running process, all of its threads, and takes
+// control of it.
+func attachTracee(pid int) {
+	t, err := target.Attach(pid)
+	if err != nil {
+		fatal(err)
+	}
+	logPtrace("attached to %v", pid)
+	useTarget(t)
+}
+
+// processToAttach picks the process the flags say to attach to: the one
+// given with -attach, the one -attach-name finds, or the first of the group
+// -attach-pgid or -attach-cgroup finds, with groupMembers set to the group.
+// Zero means the program is to be launched.
+func processToAttach(attach int, attachName string, wait bool, attachPgid int, attachCgroup string) (int, error) {
+	if attachName != "" {
+		if attach != 0 {
+			return 0, fmt.Errorf("-attach-name and -attach both pick the process to attach to")
+		}
+		pattern, err := regexp.Compile(attachName)
+		if err != nil {
+			return 0, err
+		}
+		if attach, err = findProcess(pattern, wait); err != nil {
+			return 0, err
+		}
+	} else if wait {
+		return 0, fmt.Errorf("-wait needs -attach-name")
+	}
+	if attachPgid != 0 || attachCgroup != "" {
+		if attach != 0 || attachPgid != 0 && attachCgroup != "" {
+			return 0, fmt.Errorf("-attach-pgid, -attach-cgroup, -attach and -attach-name each pick the process to attach to")
+		}
+		members, err := findGroup(attachPgid, attachCgroup)
+		if err != nil {
+			return 0, err
+		}
+		groupMembers, attach = members, members[0]
+		fmt.Printf("%v Go processes; attaching to %v.  targets lists them and target <pid> switches.\n", len(members), attach)
+	}
+	if waitExec != "" && attach != 0 {
+		return 0, fmt.Errorf("-wait-exec launches a wrapper; it can't be used with -attach")
+	}
+	return attach, nil
+}
+
+// findProcess returns the process whose executable's base name matches
+// pattern.  With wait, the processes matching already are passed over and
+// /proc is polled until a new one appears, so that attaching to it catches
+// its initialization.
+func findProcess(pattern *regexp.Regexp, wait bool) (int, error) {
+	running := matchingProcesses(pattern)
+	if !wait {
+		switch len(running) {
+		case 0:
+			return 0, fmt.Errorf("no process matches %v", pattern)
+		case 1:
+			for pid := range running {
+				return pid, nil
+			}
+		}
+		var names []string
+		for pid, name := range running {
+			names = append(names, fmt.Sprintf("%v (%v)", pid, name))
+		}
+		sort.Strings(names)
+		return 0, fmt.Errorf("%v processes match %v: %v; pick one with -attach", len(running), pattern, strings.Join(names, ", "))
+	}
+	fmt.Printf("Waiting for a process matching %v...\n", pattern)
+	for {
+		for pid := range matchingProcesses(pattern) {
+			if _, ok := running[pid]; !ok {
+				return pid, nil
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// matchingProcesses maps the pid of each process, other than the debugger,
+// whose executable's base name matches pattern to that name.  Where a
+// forked child hasn't executed its binary yet, its executable is still its
+// parent's.
+func matchingProcesses(pattern *regexp.Regexp) map[int]string {
+	matches := make(map[int]string)
+	dir, err := os.Open("/proc")
+	if err != nil {
+		return matches
+	}
+	defer dir.Close()
+	names, _ := dir.Readdirnames(-1)
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+		if err != nil {
+			continue // A kernel thread, someone else's process, or gone.
+		}
+		if base := filepath.Base(exe); pattern.MatchString(base) {
+			matches[pid] = base
+		}
+	}
+	return matches
+}
+
+// tracee is the process being debugged, whose threads' registers, memory
+// and breakpoints are reached through it.
+var tracee *target.Target
+
+// useTarget starts tracking the threads of a newly stopped tracee.  Once it
+// runs, the command loop follows its threads itself, from what the reaper
+// collects.
+func useTarget(t *target.Target) {
+	t.WriteText, t.Log = writeText, logRequest
+	tracee = t
+	closeBranchRings()
+	threads = make(map[int]*thread)
+	for _, tid := range t.Threads() {
+		addThread(tid, true)
+		armThreadWatchpoints(tid)
+	}
+	processID, currentThread = t.Pid(), t.Pid()
+	leaderExit = nil
+	wakeReaper()
+}
+
+// step executes a single machine instruction, first moving off any
+// breakpoint the tracee is stopped on.
+func step(pid int) *syscall.WaitStatus {
+	if status := stepOverBreakpoint(pid); status != nil {
+		return status
+	}
+	return singleStep(pid)
+}
+
+func singleStep(pid int) *syscall.WaitStatus {
+	var pc uint64
+	if recordedPCs != nil {
+		pc = getPC(pid)
+	}
+	markRunning()
+	err := ptraceSingleStep(pid)
+	if err != nil {
+		fatal(err)
+	}
+
+	var ws syscall.WaitStatus
+	_, err = waitFor(pid, &ws, syscall.WALL)
+	if ws.Exited() || ws.Signaled() {
+		markExited(&ws)
+	} else if ws.Stopped() && fatalSignals[ws.StopSignal()] {
+		markStopped("signal")
+	} else {
+		markStopped("")
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	// Stepping over a clone makes the tracee report the new thread first.
+	if isCloneEvent(ws) {
+		noteClone(pid)
+		return singleStep(pid)
+	}
+
+	// A signal that arrives during the step is held back until the next
+	// continue rather than diverting the step into a signal handler.  Ctrl-C
+	// is never delivered; it asks a multi-step command to stop early.
+	if ws.Stopped() && ws.StopSignal() == syscall.SIGINT {
+		interruptRequested = true
+		renewCommand()
+		return singleStep(pid)
+	}
+	if ws.Stopped() && ws.StopSignal() == syscall.SIGSTOP && threads[pid] != nil && threads[pid].stopRequested {
+		threads[pid].stopRequested = false
+		return singleStep(pid)
+	}
+	if ws.Stopped() && ws.StopSignal() != syscall.SIGTRAP && !fatalSignals[ws.StopSignal()] {
+		setPendingSignal(pid, ws.StopSignal())
+		return singleStep(pid)
+	}
+
+	if recordedPCs != nil && ws.Stopped() && ws.StopSignal() == syscall.SIGTRAP {
+		recordPC(pc)
+	}
+	return &ws
+}
+
+// stepOverBreakpoint moves the tracee past the breakpoint it is stopped on by
+// stepping a displaced copy of the instruction, or failing that by putting
+// the original instruction back, single-stepping it and re-inserting the
+// INT3, or with a hardware breakpoint by leaving it out of the thread's
+// debug registers for the step.  It returns nil when the PC is not on a
+// breakpoint.
+func stepOverBreakpoint(pid int) *syscall.WaitStatus {
+	pc := getPC(pid)
+	inserted := isInserted(pc)
+	bp := findBreakpoint(pc)
+	hardware := bp != nil && bp.hardware && !bp.disabled
+	if !inserted && !hardware {
+		return nil
+	}
+
+	if inserted && !hardware && displacedStepping {
+		if status, ok := displacedStep(pid, pc); ok {
+			return status
+		}
+	}
+	if inserted {
+		clearBreakpoint(pid, pc)
+	}
+	if hardware {
+		bp.disabled = true
+		armThreadWatchpoints(pid)
+	}
+	status := singleStep(pid)
+	alive := !status.Exited() && !status.Signaled()
+	if hardware {
+		bp.disabled = false
+		if alive {
+			armThreadWatchpoints(pid)
+		}
+	}
+	if inserted && alive {
+		mustSetBreakpoint(pid, pc)
+	}
+	return status
+}
+
+// cont resumes the tracee until it stops at a breakpoint whose condition
+// holds, receives a signal the debugger cares about, or exits.  It returns
+// nil when stepDeadline passes first, leaving the tracee running.  While
+// recording, it single-steps instead.
+func cont(pid int, symbolTable *gosym.Table) *syscall.WaitStatus {
+	if recordedPCs != nil {
+		return recordSteps(pid, symbolTable)
+	}
+	if status := resume(pid, threadsLocked()); status != nil {
+		return status
+	}
+	return waitForStop(pid, symbolTable, stepDeadline)
+}
+
+// threadsLocked reports whether scheduler-locking keeps the other threads
+// stopped while the current one runs.
+func threadsLocked() bool {
+	return schedulerLocking == "on" || schedulerLocking == "step" && stepping
+}
+
+func setPC(pid int, pc uint64) {
+	var regs syscall.PtraceRegs
+	err := ptraceGetRegs(pid, &regs)
+	if err != nil {
+		fatal(err)
+	}
+	regs.SetPC(pc)
+	err = ptraceSetRegs(pid, &regs)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func getPC(pid int) uint64 {
+	var regs syscall.PtraceRegs
+	err := ptraceGetRegs(pid, &regs)
+	if err != nil {
+		fatal(err)
+	}
+	return regs.PC()
+}
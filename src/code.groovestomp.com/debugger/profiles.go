@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// profileFile is where a project keeps its named debugging setups,
+// relative to the directory holding its go.mod, beside presetFile.
+const profileFile = ".godbg/profiles.json"
+
+// profile is one setup of profileFile, picked with -profile, e.g.
+//
+//	{"server": {
+//	    "program": "bin/server",
+//	    "args": ["-listen", ":8080"],
+//	    "env": ["LOG_LEVEL=debug"],
+//	    "substitutePaths": [{"from": "/build/src", "to": "."}],
+//	    "breakpoints": [{"location": "auth.go:42", "condition": "user == nil"}],
+//	    "settings": {"render-times": "off", "inline-values": "off"}
+//	}}
+//
+// Relative paths are relative to the module root.  The program and its
+// arguments are used unless others are given on the command line; the
+// environment is added to, before -env.  Settings are those of config.
+type profile struct {
+	Program         string             `json:"program"`
+	Args            []string           `json:"args"`
+	Env             []string           `json:"env"`
+	SubstitutePaths []pathSubstitution `json:"substitutePaths"`
+	Breakpoints     []breakpointPreset `json:"breakpoints"`
+	Settings        map[string]string  `json:"settings"`
+}
+
+// pathSubstitution has source files the binary names under from read from
+// under to instead, for a binary built in another directory or machine.
+type pathSubstitution struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// substitutePaths are the path substitutions of the profile in use.
+var substitutePaths []pathSubstitution
+
+// loadProfile reads the profile of this name from the profile file of the
+// module the current directory belongs to.
+func loadProfile(name string) (*profile, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	path := findProjectFile(dir, profileFile)
+	if path == "" {
+		return nil, fmt.Errorf("no %v in this module for -profile %v", profileFile, name)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]*profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	p := profiles[name]
+	if p == nil {
+		var names []string
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%v has no profile %v, only %v", path, name, strings.Join(names, ", "))
+	}
+
+	root := filepath.Dir(filepath.Dir(path))
+	if p.Program != "" && !filepath.IsAbs(p.Program) {
+		p.Program = filepath.Join(root, p.Program)
+	}
+	for i, s := range p.SubstitutePaths {
+		if s.From == "" || s.To == "" {
+			return nil, fmt.Errorf("%v: profile %v: a substitute path needs from and to", path, name)
+		}
+		if !filepath.IsAbs(s.To) {
+			p.SubstitutePaths[i].To = filepath.Join(root, s.To)
+		}
+	}
+	return p, nil
+}
+
+// useProfile applies a profile: its program and arguments if program and
+// args, from the command line, are empty, and the rest as well.  Its
+// breakpoints and settings are queued as break and config commands, to run
+// after those of the init file once the program is started.
+func useProfile(p *profile, program string, args []string) (string, []string) {
+	if program == "" {
+		program, args = p.Program, p.Args
+	}
+	programEnv = append(envFlag(p.Env), programEnv...)
+	substitutePaths = p.SubstitutePaths
+
+	var names []string
+	for name := range p.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profileCommands = append(profileCommands, "config "+name+" "+p.Settings[name])
+	}
+	for _, preset := range p.Breakpoints {
+		profileCommands = append(profileCommands, "break "+preset.argument())
+	}
+	return program, args
+}
+
+// substitutePath returns where a source file the binary names is read
+// from, by the first substitution whose from it is under.
+func substitutePath(file string) (string, bool) {
+	for _, s := range substitutePaths {
+		from := filepath.Clean(s.From)
+		if file == from || strings.HasPrefix(file, from+"/") {
+			return filepath.Join(s.To, strings.TrimPrefix(file, from)), true
+		}
+	}
+	return "", false
+}
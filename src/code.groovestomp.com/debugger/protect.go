@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+const pageSize = 4096
+
+// syscallFunctions are runtime functions that make a system call, any one
+// of which gives remoteSyscall a SYSCALL instruction to run.
+var syscallFunctions = []string{
+	"internal/runtime/syscall.Syscall6",
+	"runtime/internal/syscall.Syscall6",
+	"runtime.usleep",
+	"runtime.write1",
+	"runtime.futex",
+}
+
+// writeText writes bytes of the tracee's code and reads them back, since a
+// write the kernel won't let through can appear to succeed.  ptrace writes
+// through a private mapping's missing write permission by copying the
+// page; PTRACE_POKETEXT is the same request as PTRACE_POKEDATA on Linux, so
+// when that is refused the page is made writable from inside the tracee
+// with an mprotect, and its protection put back once the bytes are in.
+// Text mapped shared is never made writable, since a write there would
+// change the file under every process that maps it.
+func writeText(pid int, address uint64, data []byte) error {
+	err := pokeVerified(pid, address, data)
+	if err == nil {
+		return nil
+	}
+	m, found := findMapping(pid, address)
+	if !found || strings.HasSuffix(m.perms, "s") || strings.Contains(m.perms, "w") {
+		return fmt.Errorf("%v%v", err, describeMapping(pid, address))
+	}
+
+	start := address &^ (pageSize - 1)
+	length := (address+uint64(len(data))+pageSize-1)&^(pageSize-1) - start
+	protection := mappingProtection(m.perms)
+	if _, perr := remoteSyscall(pid, syscall.SYS_MPROTECT, start, length, protection|syscall.PROT_WRITE); perr != nil {
+		return fmt.Errorf("%v, and making the page writable failed: %v%v", err, perr, describeMapping(pid, address))
+	}
+	err = pokeVerified(pid, address, data)
+	if _, perr := remoteSyscall(pid, syscall.SYS_MPROTECT, start, length, protection); perr != nil {
+		fmt.Printf("Warning: the protection of 0x%x-0x%x could not be restored to %v: %v\n", start, start+length, m.perms[:3], perr)
+	}
+	return err
+}
+
+// pokeVerified writes data at address and reads it back, writing back what
+// was there if the write came out wrong.
+func pokeVerified(pid int, address uint64, data []byte) error {
+	original := make([]byte, len(data))
+	if _, err := ptracePeekData(pid, uintptr(address), original); err != nil {
+		return err
+	}
+	if _, err := ptracePokeData(pid, uintptr(address), data); err != nil {
+		return err
+	}
+	written := make([]byte, len(data))
+	if _, err := ptracePeekData(pid, uintptr(address), written); err != nil || !bytes.Equal(written, data) {
+		ptracePokeData(pid, uintptr(address), original)
+		return errors.New("the write did not take effect")
+	}
+	return nil
+}
+
+func findMapping(pid int, address uint64) (mapping, bool) {
+	mappings, err := readMappings(pid)
+	if err != nil {
+		return mapping{}, false
+	}
+	for _, m := range mappings {
+		if address >= m.start && address < m.end {
+			return m, true
+		}
+	}
+	return mapping{}, false
+}
+
+// mappingProtection turns the permissions /proc/<pid>/maps shows into the
+// PROT_ bits mprotect takes.
+func mappingProtection(perms string) uint64 {
+	var protection uint64
+	if strings.Contains(perms, "r") {
+		protection |= syscall.PROT_READ
+	}
+	if strings.Contains(perms, "w") {
+		protection |= syscall.PROT_WRITE
+	}
+	if strings.Contains(perms, "x") {
+		protection |= syscall.PROT_EXEC
+	}
+	return protection
+}
+
+// remoteSyscall makes a system call in a stopped thread of the tracee, by
+// single-stepping one of the runtime's SYSCALL instructions with the
+// number and arguments in the registers, and puts the thread back as it
+// was.
+func remoteSyscall(tid int, number uint64, args ...uint64) (uint64, error) {
+	address, err := syscallInstruction(tid)
+	if err != nil {
+		return 0, err
+	}
+	var saved syscall.PtraceRegs
+	if err := ptraceGetRegs(tid, &saved); err != nil {
+		return 0, err
+	}
+	regs := saved
+	regs.Rax = number
+	for i, r := range []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9} {
+		if i < len(args) {
+			*r = args[i]
+		}
+	}
+	regs.SetPC(address)
+	regs.Orig_rax = ^uint64(0) // Don't restart a system call it was stopped in.
+	if err := ptraceSetRegs(tid, &regs); err != nil {
+		return 0, err
+	}
+
+	status := singleStep(tid)
+	if status.Exited() || status.Signaled() {
+		return 0, errors.New("the program ended during the system call")
+	}
+	var after syscall.PtraceRegs
+	err = ptraceGetRegs(tid, &after)
+	if restoreErr := ptraceSetRegs(tid, &saved); restoreErr != nil {
+		return 0, restoreErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if after.PC() != address+2 {
+		return 0, fmt.Errorf("the system call stopped at 0x%x", after.PC())
+	}
+	if result := int64(after.Rax); result < 0 && result > -4096 {
+		return 0, syscall.Errno(-result)
+	}
+	return after.Rax, nil
+}
+
+// syscallInstruction finds a SYSCALL instruction in the runtime.
+func syscallInstruction(pid int) (uint64, error) {
+	for _, name := range syscallFunctions {
+		fn := listingSymbols.LookupFunc(name)
+		if fn == nil {
+			continue
+		}
+		code, err := readText(pid, fn.Entry, int(fn.End-fn.Entry))
+		if err != nil {
+			continue
+		}
+		for offset := 0; offset < len(code); {
+			inst, err := x86asm.Decode(code[offset:], 64)
+			if err != nil {
+				break
+			}
+			if inst.Op == x86asm.SYSCALL {
+				return fn.Entry + uint64(offset), nil
+			}
+			offset += inst.Len
+		}
+	}
+	return 0, errors.New("no system call instruction found in the runtime")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// protoStateType is the type of the state field protoc-gen-go puts first in
+// every generated message.
+const protoStateType = "google.golang.org/protobuf/internal/impl.MessageState"
+
+// isProtoMessage reports whether a struct is a message generated by
+// protoc-gen-go.
+func isProtoMessage(t *dwarf.StructType) bool {
+	return len(t.Field) > 0 && t.Field[0].Name == "state" && typeName(t.Field[0].Type) == protoStateType
+}
+
+// formatProtoMessage renders a generated message compactly, as
+// pkg.Name{Field=value, ...}.  The unexported bookkeeping fields are left
+// out, and so are fields holding their zero value, which proto3 treats as
+// unset.
+func formatProtoMessage(pid int, v *value, t *dwarf.StructType, depth int) string {
+	name := typeName(v.typ)
+	name = name[strings.LastIndex(name, "/")+1:] // Package paths are long.
+	if depth >= maxValueDepth {
+		return name + "{...}"
+	}
+	var fields []string
+	for _, field := range t.Field {
+		if field.Name == "" || !unicode.IsUpper(rune(field.Name[0])) {
+			continue
+		}
+		fv := fieldValue(v, field)
+		if fv == nil {
+			fields = append(fields, field.Name+"=?")
+			continue
+		}
+		if isZero(fv.data) {
+			continue
+		}
+		fields = append(fields, field.Name+"="+formatValueDepth(pid, fv, depth+1))
+	}
+	return fmt.Sprintf("%v{%v}", name, strings.Join(fields, ", "))
+}
+
+// formatProtoPointer renders a pointer to a generated message as the
+// message itself, the way generated messages are always handled.  It
+// returns false for any other pointer.
+func formatProtoPointer(pid int, v *value, depth int) (string, bool) {
+	ptr, ok := resolveTypedef(v.typ).(*dwarf.PtrType)
+	if !ok || ptr.Type == nil {
+		return "", false
+	}
+	t, ok := resolveTypedef(ptr.Type).(*dwarf.StructType)
+	if !ok || !isProtoMessage(t) {
+		return "", false
+	}
+	target, err := dereference(pid, v)
+	if err != nil {
+		return "", false
+	}
+	return "&" + formatProtoMessage(pid, target, t, depth), true
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// showProvenance has print tag each value with where it was read, so that
+// values read in different frames or goroutines aren't taken for one
+// another.  On unless turned off.
+var showProvenance = true
+
+// valueProvenance is where an expression was evaluated: the goroutine, the
+// frame of its stack and the pc there.
+type valueProvenance struct {
+	Goroutine uint64 `json:"goroutine,omitempty"` // Zero if unknown.
+	Frame     int    `json:"frame"`
+	PC        uint64 `json:"pc"`
+	Function  string `json:"function,omitempty"`
+}
+
+// provenance says where ctx evaluates.  The goroutine is only read now,
+// since breakpoint conditions make contexts without ever asking.
+func (ctx *evalContext) provenance() valueProvenance {
+	p := valueProvenance{Frame: ctx.index, PC: ctx.frame.pc}
+	if ctx.frame.fn != nil {
+		p.Function = ctx.frame.fn.Name
+	}
+	g := ctx.goroutine
+	if g == 0 {
+		g = currentGoroutine(ctx.pid)
+	}
+	if gv, err := readRuntimeStruct(ctx.pid, "runtime.g", g); err == nil {
+		p.Goroutine = scalarMember(gv, "goid")
+	}
+	return p
+}
+
+func (p valueProvenance) String() string {
+	goroutine := "goroutine ?"
+	if p.Goroutine != 0 {
+		goroutine = fmt.Sprintf("goroutine %v", p.Goroutine)
+	}
+	if p.Function == "" {
+		return fmt.Sprintf("%v, frame #%v, pc 0x%x", goroutine, p.Frame, p.PC)
+	}
+	return fmt.Sprintf("%v, frame #%v %v, pc 0x%x", goroutine, p.Frame, p.Function, p.PC)
+}
+
+// provenanceSuffix is what print puts after a value it read in ctx: where,
+// dimmed on a terminal, or nothing with show-provenance off.
+func provenanceSuffix(ctx *evalContext) string {
+	if !showProvenance {
+		return ""
+	}
+	if isTerminal(os.Stdout) {
+		return fmt.Sprintf("  \x1b[2m(%v)\x1b[0m", ctx.provenance())
+	}
+	return fmt.Sprintf("  (%v)", ctx.provenance())
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ptraceLog receives a line for every ptrace request, wait status and
+// signal sent, when -log-ptrace is given.  Ctrl-C is forwarded from another
+// goroutine, hence the lock.
+var (
+	ptraceLog     io.Writer
+	ptraceLogLock sync.Mutex
+)
+
+func openPtraceLog(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	ptraceLog = f
+	logPtrace("log opened by pid %v", os.Getpid())
+	return nil
+}
+
+func logPtrace(format string, args ...interface{}) {
+	if ptraceLog == nil {
+		return
+	}
+	ptraceLogLock.Lock()
+	defer ptraceLogLock.Unlock()
+	fmt.Fprintf(ptraceLog, "%v %v\n", time.Now().Format("15:04:05.000000"), fmt.Sprintf(format, args...))
+}
+
+// logRequest logs a ptrace request and its outcome.
+func logRequest(request string, tid int, detail string, err error) {
+	if ptraceLog == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	if detail != "" {
+		detail = " " + detail
+	}
+	logPtrace("%v %v%v: %v", request, tid, detail, result)
+}
+
+var ptraceEvents = map[int]string{
+	syscall.PTRACE_EVENT_FORK:  "PTRACE_EVENT_FORK",
+	syscall.PTRACE_EVENT_VFORK: "PTRACE_EVENT_VFORK",
+	syscall.PTRACE_EVENT_CLONE: "PTRACE_EVENT_CLONE",
+	syscall.PTRACE_EVENT_EXEC:  "PTRACE_EVENT_EXEC",
+	syscall.PTRACE_EVENT_EXIT:  "PTRACE_EVENT_EXIT",
+}
+
+// describeWaitStatus renders a wait status the way the log shows it.
+func describeWaitStatus(ws syscall.WaitStatus) string {
+	switch {
+	case ws.Exited():
+		return fmt.Sprintf("exited %v", ws.ExitStatus())
+	case ws.Signaled():
+		return fmt.Sprintf("killed by %v", signalName(ws.Signal()))
+	case ws.Stopped() && ws.StopSignal() == syscall.SIGTRAP && ws.TrapCause() > 0:
+		if name, ok := ptraceEvents[ws.TrapCause()]; ok {
+			return "stopped at " + name
+		}
+		return fmt.Sprintf("stopped SIGTRAP event %v", ws.TrapCause())
+	case ws.Stopped():
+		return fmt.Sprintf("stopped %v", signalName(ws.StopSignal()))
+	case ws.Continued():
+		return "continued"
+	}
+	return fmt.Sprintf("status 0x%x", uint32(ws))
+}
+
+// The wrappers below make the ptrace requests and system calls the
+// debugger uses, logging each one.  Those on the tracee's threads go
+// through tracee, which logs them with logRequest; with a core file open,
+// the reads are answered from it instead.
+
+func ptraceAttach(tid int) error {
+	err := syscall.PtraceAttach(tid)
+	logRequest("PTRACE_ATTACH", tid, "", err)
+	return err
+}
+
+func ptraceDetach(tid int) error {
+	err := syscall.PtraceDetach(tid)
+	logRequest("PTRACE_DETACH", tid, "", err)
+	return err
+}
+
+func ptraceSetOptions(tid int, options int) error {
+	err := syscall.PtraceSetOptions(tid, options)
+	logRequest("PTRACE_SETOPTIONS", tid, fmt.Sprintf("0x%x", options), err)
+	return err
+}
+
+func ptraceCont(tid int, signal int) error {
+	return tracee.Continue(tid, syscall.Signal(signal))
+}
+
+func ptraceSingleStep(tid int) error {
+	return tracee.Step(tid)
+}
+
+func ptraceGetEventMsg(tid int) (uint, error) {
+	msg, err := syscall.PtraceGetEventMsg(tid)
+	logRequest("PTRACE_GETEVENTMSG", tid, fmt.Sprint(msg), err)
+	return msg, err
+}
+
+func ptraceGetRegs(tid int, regs *syscall.PtraceRegs) error {
+	if core != nil {
+		return core.registers(tid, regs)
+	}
+	r, err := tracee.Registers(tid)
+	if err != nil {
+		return err
+	}
+	*regs = *r
+	return nil
+}
+
+func ptraceSetRegs(tid int, regs *syscall.PtraceRegs) error {
+	return tracee.SetRegisters(tid, regs)
+}
+
+func ptracePeekData(tid int, address uintptr, out []byte) (int, error) {
+	if core != nil {
+		return core.read(uint64(address), out)
+	}
+	return tracee.ReadMemory(tid, uint64(address), out)
+}
+
+func ptracePokeData(tid int, address uintptr, data []byte) (int, error) {
+	return tracee.WriteMemory(tid, uint64(address), data)
+}
+
+// ptraceRaw makes a request the syscall package has no function for.
+func ptraceRaw(name string, request int, tid int, address, data uintptr) (uintptr, syscall.Errno) {
+	r, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, uintptr(request), uintptr(tid), address, data, 0, 0)
+	var err error
+	if errno != 0 {
+		err = errno
+	}
+	logRequest(name, tid, fmt.Sprintf("0x%x 0x%x", address, data), err)
+	return r, errno
+}
+
+// wait4 logs the statuses the debugger collects, but not the empty polls of
+// WNOHANG.
+func wait4(pid int, ws *syscall.WaitStatus, options int, rusage *syscall.Rusage) (int, error) {
+	tid, err := syscall.Wait4(pid, ws, options, rusage)
+	switch {
+	case err != nil:
+		logPtrace("wait %v: %v", pid, err)
+	case tid != 0:
+		logPtrace("wait %v: %v %v", pid, tid, describeWaitStatus(*ws))
+	}
+	return tid, err
+}
+
+func kill(pid int, signal syscall.Signal) error {
+	err := syscall.Kill(pid, signal)
+	logRequest("kill", pid, signalName(signal), err)
+	return err
+}
+
+func tgkill(tgid, tid int, signal syscall.Signal) error {
+	err := syscall.Tgkill(tgid, tid, signal)
+	logRequest("tgkill", tid, signalName(signal), err)
+	return err
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// waitEvent is a status change of one of the tracee's threads, collected
+// by the reaper.  An event with a zero tid ends a run of the reaper: there
+// are no children left to wait for, or waiting failed.
+type waitEvent struct {
+	tid    int
+	status syscall.WaitStatus
+	err    error
+}
+
+var (
+	// waitEvents carries what the reaper collects to the command loop.
+	waitEvents = make(chan waitEvent, 64)
+
+	// pendingWaits holds events that arrived while the command loop was
+	// waiting for a particular thread, in the order they came.
+	pendingWaits []waitEvent
+
+	// reaperWake sets the reaper collecting for a new tracee, and
+	// reaperStop has it stop once the tracee is gone.
+	reaperWake = make(chan struct{})
+	reaperStop = make(chan struct{})
+
+	// waitOptions are the wait4 flags the reaper collects with, besides
+	// WNOHANG, set with config wait-flags.  It is read with atomic, since
+	// the setting may change while the reaper runs.
+	waitOptions int32 = syscall.WALL
+
+	// reaping is set from waking the reaper until it has stopped, or the
+	// command loop has seen the event that ends its run.
+	reaping bool
+
+	// helpers are the processes the debugger runs for itself, such as
+	// shell commands and the notify command, by pid, each with the channel
+	// its exit status goes to.  helpersLock guards it, since the reaper
+	// may collect a helper too.
+	helpers     = make(map[int]chan syscall.WaitStatus)
+	helpersLock sync.Mutex
+)
+
+// startReaper starts the goroutine that collects every status change of
+// the tracee's threads: stops, thread exits and the zombies they leave.
+// Waiting needn't happen on the ptrace thread, since the kernel reports a
+// tracee to any thread of its tracer.  The reaper is idle until woken; it
+// then collects whatever SIGCHLD says is there, without blocking in wait4,
+// so that it can be stopped while the debugger still has other children.
+func startReaper() {
+	children := make(chan os.Signal, 1)
+	signal.Notify(children, syscall.SIGCHLD)
+	go func() {
+		for range reaperWake {
+			reap(children)
+		}
+	}()
+}
+
+// reap is one run of the reaper, until it is stopped or no child is left.
+// A helper's exit goes to the helper rather than the command loop, and a
+// thread continuing, which WCONTINUED reports, only to the ptrace log.
+func reap(children chan os.Signal) {
+	for {
+		var ws syscall.WaitStatus
+		tid, err := wait4(-1, &ws, int(atomic.LoadInt32(&waitOptions))|syscall.WNOHANG, nil)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err != nil:
+			waitEvents <- waitEvent{err: err}
+			return
+		case tid != 0 && ws.Continued():
+			continue
+		case tid != 0:
+			if !helperExited(tid, ws) {
+				waitEvents <- waitEvent{tid: tid, status: ws}
+			}
+			continue
+		}
+		select {
+		case <-children:
+		case <-reaperStop:
+			return
+		}
+	}
+}
+
+// wakeReaper starts collecting the statuses of a tracee that has just been
+// launched or attached to.
+func wakeReaper() {
+	reaping = true
+	reaperWake <- struct{}{}
+}
+
+// stopReaper ends the reaper's run once the tracee is gone, whether killed
+// and collected or detached from, and drops what it collected of it.
+func stopReaper() {
+	for reaping {
+		select {
+		case reaperStop <- struct{}{}:
+			reaping = false
+		case e := <-waitEvents:
+			if e.tid == 0 {
+				reaping = false // It had already found no child left.
+			}
+		}
+	}
+	for len(waitEvents) > 0 {
+		<-waitEvents
+	}
+	pendingWaits = nil
+}
+
+// startHelper starts cmd, a process the debugger runs for itself, and
+// returns the channel its exit status comes on, which need not be read.
+// The helper's own wait collects it, unless the reaper does so first.
+func startHelper(cmd *exec.Cmd) (<-chan syscall.WaitStatus, error) {
+	helpersLock.Lock()
+	defer helpersLock.Unlock()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	exited := make(chan syscall.WaitStatus, 1)
+	helpers[pid] = exited
+	go func() {
+		var ws syscall.WaitStatus
+		if _, err := syscall.Wait4(pid, &ws, 0, nil); err == nil {
+			helperExited(pid, ws)
+		}
+		cmd.Process.Release()
+	}()
+	return exited, nil
+}
+
+// runHelper runs cmd as a helper and returns its exit status.
+func runHelper(cmd *exec.Cmd) (syscall.WaitStatus, error) {
+	exited, err := startHelper(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return <-exited, nil
+}
+
+// helperExited passes on the exit status of pid if it is a helper,
+// returning false if it isn't.
+func helperExited(pid int, ws syscall.WaitStatus) bool {
+	helpersLock.Lock()
+	defer helpersLock.Unlock()
+	exited, ok := helpers[pid]
+	if !ok || !ws.Exited() && !ws.Signaled() {
+		return ok
+	}
+	delete(helpers, pid)
+	exited <- ws
+	return true
+}
+
+// waitFor returns the next status change of thread pid or, for -1, of any
+// thread, in place of wait4.  With WNOHANG it returns a zero tid if none is
+// waiting.  Events for other threads are kept for later rather than lost.
+func waitFor(pid int, ws *syscall.WaitStatus, options int) (int, error) {
+	for i, e := range pendingWaits {
+		if pid == -1 || e.tid == pid || e.tid == 0 {
+			pendingWaits = append(pendingWaits[:i], pendingWaits[i+1:]...)
+			return takeWaitEvent(e, ws)
+		}
+	}
+	if !reaping {
+		return 0, syscall.ECHILD
+	}
+	for {
+		var e waitEvent
+		if options&syscall.WNOHANG != 0 {
+			select {
+			case e = <-waitEvents:
+			default:
+				return 0, nil
+			}
+		} else {
+			waiting := atomic.SwapInt32(&waitingForTracee, 1)
+			e = <-waitEvents
+			atomic.StoreInt32(&waitingForTracee, waiting)
+		}
+		if pid == -1 || e.tid == pid || e.tid == 0 {
+			return takeWaitEvent(e, ws)
+		}
+		pendingWaits = append(pendingWaits, e)
+	}
+}
+
+func takeWaitEvent(e waitEvent, ws *syscall.WaitStatus) (int, error) {
+	if e.tid == 0 {
+		reaping = false
+		return 0, e.err
+	}
+	*ws = e.status
+	return e.tid, nil
+}
+
+// killTracee kills the tracee and collects it, so the reaper is idle for
+// the next one.  A wrapper that started the tracee is killed too: it is a
+// child of the debugger there would be no end to waiting for, and restart
+// runs it afresh.  Helpers still running are left be.
+func killTracee() {
+	remaining := make(map[int]bool)
+	for _, pid := range []int{processID, wrapperPID} {
+		// One already collected is gone, and can't be killed.
+		if pid != 0 && kill(pid, syscall.SIGKILL) == nil {
+			remaining[pid] = true
+		}
+	}
+	wrapperPID = 0
+	for len(remaining) > 0 {
+		var ws syscall.WaitStatus
+		tid, err := waitFor(-1, &ws, 0)
+		if err != nil {
+			break
+		}
+		if ws.Exited() || ws.Signaled() {
+			delete(remaining, tid)
+		}
+	}
+	stopReaper()
+}
+
+// isGroupStop reports whether a thread stopped with a stop signal has
+// entered a group-stop, the job control stop of the whole process, rather
+// than just having the signal on its way.  Only a signal on its way has
+// siginfo to read.  The debugger resumes group-stops, since it is in
+// control of when the tracee runs.
+func isGroupStop(tid int, ws syscall.WaitStatus) bool {
+	switch ws.StopSignal() {
+	case syscall.SIGSTOP, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU:
+	default:
+		return false
+	}
+	var siginfo [128]byte
+	_, errno := ptraceRaw("PTRACE_GETSIGINFO", syscall.PTRACE_GETSIGINFO, tid, 0, uintptr(unsafe.Pointer(&siginfo[0])))
+	return errno == syscall.EINVAL
+}
+
+// waitFlags are the flags config wait-flags may combine.
+var waitFlags = []struct {
+	name  string
+	value int32
+}{
+	{"__WALL", syscall.WALL},
+	{"WUNTRACED", syscall.WUNTRACED},
+	{"WCONTINUED", syscall.WCONTINUED},
+}
+
+// formatWaitFlags implements getting config wait-flags.
+func formatWaitFlags() string {
+	options := atomic.LoadInt32(&waitOptions)
+	var names []string
+	for _, flag := range waitFlags {
+		if options&flag.value != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// parseWaitFlags implements config wait-flags <flag>|...  __WALL can't be
+// left out: without it the reaper would see nothing of the threads the Go
+// runtime starts, and the program would seem to hang.
+func parseWaitFlags(value string) error {
+	var options int32
+	for _, name := range strings.Split(value, "|") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, flag := range waitFlags {
+			if name == flag.name {
+				options |= flag.value
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("expected flags among __WALL, WUNTRACED and WCONTINUED joined by |, got %q", name)
+		}
+	}
+	if options&syscall.WALL == 0 {
+		return fmt.Errorf("__WALL is needed to see the program's threads")
+	}
+	atomic.StoreInt32(&waitOptions, options)
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// recordedPC is one instruction the tracee executed while recording.
+type recordedPC struct {
+	pc   uint64
+	file string
+	line int
+	fn   string
+}
+
+var (
+	// recordedPCs is a ring of the last instructions single-stepped while
+	// "record pcs" is on, and next the index the following one goes to.
+	// It is nil while recording is off.
+	recordedPCs []recordedPC
+	recordNext  int
+
+	// recordTotal counts every instruction recorded, including those the
+	// ring has dropped since.
+	recordTotal int
+)
+
+// runRecordCommand implements
+//
+//	record pcs <n>
+//	record show [<count>]
+//	record off
+func runRecordCommand(argument string) error {
+	fields := strings.Fields(argument)
+	if len(fields) == 0 {
+		return fmt.Errorf("usage: record pcs <n>, record show [<count>] or record off")
+	}
+	switch fields[0] {
+	case "pcs":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: record pcs <n>")
+		}
+		if fields[1] == "off" {
+			return runRecordCommand("off")
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("expected a number of instructions, got %q", fields[1])
+		}
+		recordedPCs, recordNext, recordTotal = make([]recordedPC, 0, n), 0, 0
+		fmt.Printf("Recording the last %v instructions.  The program is single-stepped until record off.\n", n)
+	case "off":
+		if recordedPCs == nil {
+			fmt.Println("Not recording.")
+			return nil
+		}
+		recordedPCs = nil
+		fmt.Printf("Stopped recording after %v instructions.\n", recordTotal)
+	case "show":
+		count := -1
+		if len(fields) > 1 {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("expected a number of instructions, got %q", fields[1])
+			}
+			count = n
+		}
+		showRecordedPCs(count)
+	default:
+		return fmt.Errorf("unknown record command %v", fields[0])
+	}
+	return nil
+}
+
+// recordPC adds an instruction that has just been stepped to the ring.
+func recordPC(pc uint64) {
+	r := recordedPC{pc: pc}
+	var fn *gosym.Func
+	r.file, r.line, fn = listingSymbols.PCToLine(pc)
+	if fn != nil {
+		r.fn = fmt.Sprintf("%v+%v", fn.Name, pc-fn.Entry)
+	} else if name, ok := nativeSymbol(pc); ok {
+		r.fn = name
+	}
+	if len(recordedPCs) < cap(recordedPCs) {
+		recordedPCs = append(recordedPCs, r)
+	} else {
+		recordedPCs[recordNext] = r
+	}
+	recordNext = (recordNext + 1) % cap(recordedPCs)
+	recordTotal++
+}
+
+// showRecordedPCs lists the last count instructions recorded, or all of
+// them for -1, oldest first and numbered back from the current stop.
+func showRecordedPCs(count int) {
+	if recordedPCs == nil {
+		fmt.Println("Not recording; use record pcs <n>.")
+		return
+	}
+	n := len(recordedPCs)
+	if count < 0 || count > n {
+		count = n
+	}
+	fmt.Printf("The last %v of %v instructions recorded:\n", count, recordTotal)
+	start := 0
+	if n == cap(recordedPCs) {
+		start = recordNext
+	}
+	for i := n - count; i < n; i++ {
+		r := recordedPCs[(start+i)%n]
+		fmt.Printf("  %6v  0x%x  %-32v %v:%v\n", i-n, r.pc, r.fn, r.file, r.line)
+	}
+	pc := getPC(currentThread)
+	file, line, _ := listingSymbols.PCToLine(pc)
+	fmt.Printf("  %6v  0x%x  %-32v %v:%v\n", "=>", pc, "", file, line)
+}
+
+// recordSteps stands in for continuing while recording.  It single-steps
+// the current thread, with the other threads stopped, until it reaches a
+// breakpoint whose condition holds, a watchpoint fires, a signal the
+// debugger cares about arrives, Ctrl-C is pressed, or the program exits.
+func recordSteps(pid int, symbolTable *gosym.Table) *syscall.WaitStatus {
+	interruptRequested = false
+	crashed = false
+	for {
+		status := stepOverBreakpoint(pid)
+		if status == nil {
+			status = singleStep(pid)
+		}
+		if status.Exited() || status.Signaled() {
+			markStopped("exit")
+			return status
+		}
+		if !isTrapStop(status) {
+			return stopped(pid, status, "signal")
+		}
+		if hit := watchTriggered(pid); hit != 0 && checkWatchpoints(pid, hit, symbolTable) {
+			return stopped(pid, status, "watchpoint")
+		}
+		pc := getPC(pid)
+		if isInserted(pc) {
+			bp := findBreakpoint(pc)
+			switch {
+			case bp != nil && bp.shouldStop(pid, symbolTable):
+				bp.hits++
+				return stopped(pid, status, "breakpoint")
+			case bp == nil && isCatchAddress(pc):
+				return stopped(pid, status, "catch")
+			case bp == nil:
+				return stopped(pid, status, "") // A temporary breakpoint ending a step.
+			}
+		}
+		if interruptRequested {
+			fmt.Println("\nInterrupted.")
+			return stopped(pid, status, "interrupt")
+		}
+	}
+}
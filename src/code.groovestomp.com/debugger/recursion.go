@@ -0,0 +1,198 @@
+package main
+
+import (
+	"debug/gosym"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// recursionTrace follows how deeply a function is nested in itself, from a
+// breakpoint on its entry.
+type recursionTrace struct {
+	fn      *gosym.Func
+	stopAt  int // 0 to only log.
+	counter *frameCounter
+	started time.Time
+	calls   int
+	deepest int // Since the outermost call began.
+	record  int // Over the whole trace.
+}
+
+func newRecursionTrace(fn *gosym.Func, stopAt int) *recursionTrace {
+	t := &recursionTrace{fn: fn, stopAt: stopAt, started: time.Now()}
+	t.counter = newFrameCounter(func(returnAddress uint64) bool {
+		return returnAddress > fn.Entry && returnAddress <= fn.End
+	})
+	return t
+}
+
+// runTraceCommand implements "trace recursion", and passes capture traces
+// and "trace dump" on.
+func runTraceCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	fields := strings.Fields(argument)
+	if len(fields) > 0 && fields[0] == "dump" {
+		return runTraceDump(strings.TrimSpace(strings.TrimPrefix(argument, "dump")))
+	}
+	if strings.Contains(argument, "-capture ") {
+		return runCaptureCommand(pid, argument, symbolTable)
+	}
+	if len(fields) == 0 || fields[0] != "recursion" {
+		return errors.New("usage: trace recursion [<func> [-stop <depth>|off]], trace <location> -capture <expr>,... or trace dump")
+	}
+	fields = fields[1:]
+	if len(fields) == 0 {
+		showRecursionTraces()
+		return nil
+	}
+	fn, err := lookupFunction(fields[0], symbolTable)
+	if err != nil {
+		return err
+	}
+	existing := findBreakpoint(fn.Entry)
+
+	switch {
+	case len(fields) == 2 && fields[1] == "off":
+		if existing == nil || existing.trace == nil {
+			return fmt.Errorf("%v is not being traced", fn.Name)
+		}
+		deleteBreakpoint(pid, existing)
+		fmt.Printf("Stopped tracing %v; it nested at most %v deep.\n", fn.Name, existing.trace.record)
+		return nil
+	case len(fields) == 3 && fields[1] == "-stop":
+	case len(fields) != 1:
+		return errors.New("usage: trace recursion <func> [-stop <depth>|off]")
+	}
+	stopAt := 0
+	if len(fields) == 3 {
+		if stopAt, err = strconv.Atoi(fields[2]); err != nil || stopAt < 1 {
+			return fmt.Errorf("invalid depth %q", fields[2])
+		}
+	}
+
+	if existing != nil {
+		if existing.trace == nil {
+			return fmt.Errorf("breakpoint already set at the entry of %v", fn.Name)
+		}
+		existing.trace.stopAt = stopAt
+	} else {
+		file, line, _ := symbolTable.PCToLine(fn.Entry)
+		if err := addBreakpoint(pid, file, line, fn.Entry); err != nil {
+			return err
+		}
+		existing = findBreakpoint(fn.Entry)
+		existing.spec = fn.Name
+		existing.trace = newRecursionTrace(fn, stopAt)
+	}
+	if stopAt > 0 {
+		fmt.Printf("Tracing the recursion of %v, stopping beyond depth %v.\n", fn.Name, stopAt)
+	} else {
+		fmt.Printf("Tracing the recursion of %v.\n", fn.Name)
+	}
+	return nil
+}
+
+func showRecursionTraces() {
+	found := false
+	for _, bp := range breakpoints {
+		if t := bp.trace; t != nil {
+			fmt.Printf("%v: %v calls, nested at most %v deep", t.fn.Name, t.calls, t.record)
+			if t.stopAt > 0 {
+				fmt.Printf(", stops beyond %v", t.stopAt)
+			}
+			fmt.Println()
+			found = true
+		}
+	}
+	if !found {
+		fmt.Println("No recursion traces.")
+	}
+}
+
+// hit is called with the tracee stopped on the entry of the function.  It
+// logs each new maximum nesting depth and reports whether the depth is
+// beyond the threshold, printing the arguments of every nested call if so.
+func (t *recursionTrace) hit(pid int, symbolTable *gosym.Table) bool {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return true
+	}
+	outer, err := t.counter.frames(pid, &regs, symbolTable)
+	if err != nil {
+		fmt.Printf("Tracing %v: %v\n", t.fn.Name, err)
+		return true
+	}
+	depth := outer + 1
+	t.calls++
+	if depth == 1 {
+		t.deepest = 0
+	}
+	if depth > t.deepest {
+		t.deepest = depth
+		if depth > 1 {
+			elapsed := time.Since(t.started)
+			fmt.Printf("%v: depth %v at +%v\n", t.fn.Name, depth, elapsed-elapsed%time.Microsecond)
+		}
+	}
+	if depth > t.record {
+		t.record = depth
+	}
+	if t.stopAt == 0 || depth <= t.stopAt {
+		return false
+	}
+
+	fmt.Printf("%v is nested %v deep, beyond %v:\n", t.fn.Name, depth, t.stopAt)
+	shown := 0
+	for i, frame := range unwind(pid, symbolTable, &regs, maxStackDepth) {
+		if frame.fn == nil || frame.fn.Entry != t.fn.Entry {
+			continue
+		}
+		var frameRegs *syscall.PtraceRegs
+		if i == 0 {
+			frameRegs = &regs
+		}
+		variables, _ := frameVariables(pid, frame, frameRegs)
+		var args []string
+		for _, v := range variables {
+			if !v.parameter || strings.HasPrefix(v.name, "~") {
+				continue // Results are parameters to DWARF too.
+			}
+			if v.err != nil {
+				args = append(args, fmt.Sprintf("%v=<%v>", v.name, v.err))
+			} else {
+				args = append(args, fmt.Sprintf("%v=%v", v.name, formatValue(pid, v.val)))
+			}
+		}
+		fmt.Printf("  #%-4v %v(%v) at %v:%v\n", depth-shown, t.fn.Name, strings.Join(args, ", "), frame.file, frame.line)
+		shown++
+	}
+	if shown < depth {
+		fmt.Printf("  ... %v outer calls not shown\n", depth-shown)
+	}
+	return true
+}
+
+// rearmRecursionTrace sets a trace from the previous run on the function's
+// entry in the new binary, starting its counts afresh.
+func rearmRecursionTrace(pid int, old *breakpoint, symbolTable *gosym.Table) {
+	fn, err := lookupFunction(old.trace.fn.Name, symbolTable)
+	if err != nil {
+		fmt.Printf("  trace recursion %v: could not be resolved (%v), removed\n", old.spec, err)
+		return
+	}
+	file, line, _ := symbolTable.PCToLine(fn.Entry)
+	if err := addBreakpoint(pid, file, line, fn.Entry); err == errBreakpointExists {
+		fmt.Printf("  trace recursion %v: a breakpoint is already at its entry, removed\n", old.spec)
+		return
+	} else if err != nil {
+		fmt.Printf("  trace recursion %v: %v, removed\n", old.spec, err)
+		return
+	}
+	bp := findBreakpoint(fn.Entry)
+	bp.id, bp.hits, bp.spec = old.id, old.hits, old.spec
+	bp.trace = newRecursionTrace(fn, old.trace.stopAt)
+	fmt.Printf("  trace recursion %v: re-armed at 0x%x\n", old.spec, fn.Entry)
+}
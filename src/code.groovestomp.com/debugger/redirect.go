@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// outputRedirect is where the output of the command being run goes instead
+// of the terminal: a file, or the standard input of a shell command.
+type outputRedirect struct {
+	terminal *os.File // The standard output to put back.
+	out      *os.File
+
+	// copied is closed once everything the shell command wrote has been
+	// copied to the terminal.
+	copied chan struct{}
+}
+
+// redirect is the redirection of the current command, or nil.
+var redirect *outputRedirect
+
+// redirectOutput takes a trailing "| <shell command>", "> <path>" or
+// ">> <path>" off a command and sends its output there until
+// restoreOutput.  A | only starts a pipeline when the word after it is a
+// program on the PATH, and a path has to start with /, ./, ../ or ~/, so
+// that expressions like a | b and x > y are left alone.
+func redirectOutput(command string) (string, error) {
+	command, pipeline, path, appending := splitRedirection(command)
+	switch {
+	case pipeline != "":
+		in, w, err := os.Pipe()
+		if err != nil {
+			return "", err
+		}
+		r, out, err := os.Pipe()
+		if err != nil {
+			in.Close()
+			w.Close()
+			return "", err
+		}
+		shell := exec.Command("/bin/sh", "-c", pipeline)
+		shell.Stdin, shell.Stdout, shell.Stderr = in, out, os.Stderr
+		// The pipe closing says when it is done, rather than its exit.
+		_, err = startHelper(shell)
+		in.Close()
+		out.Close()
+		if err != nil {
+			w.Close()
+			r.Close()
+			return "", err
+		}
+		redirect = &outputRedirect{terminal: os.Stdout, out: w, copied: make(chan struct{})}
+		go func(terminal *os.File, copied chan struct{}) {
+			io.Copy(terminal, r)
+			r.Close()
+			close(copied)
+		}(os.Stdout, redirect.copied)
+	case path != "":
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if appending {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		if strings.HasPrefix(path, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			path = filepath.Join(home, path[2:])
+		}
+		f, err := os.OpenFile(path, flags, 0644)
+		if err != nil {
+			return "", err
+		}
+		redirect = &outputRedirect{terminal: os.Stdout, out: f}
+	default:
+		return command, nil
+	}
+	os.Stdout = redirect.out
+	return command, nil
+}
+
+// restoreOutput points output back at the terminal once a redirected
+// command is done, and waits for its pipeline to have written everything.
+func restoreOutput() {
+	if redirect == nil {
+		return
+	}
+	os.Stdout = redirect.terminal
+	redirect.out.Close()
+	if redirect.copied != nil {
+		<-redirect.copied
+	}
+	redirect = nil
+}
+
+// splitRedirection finds the redirection at the end of a command, outside
+// quotes.  The first | that starts a pipeline takes the rest of the line,
+// which is the shell's to interpret.
+func splitRedirection(command string) (rest, pipeline, path string, appending bool) {
+	var quote rune
+	escaped := false
+	for i, r := range command {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case r == '|' && separated(command, i, 1):
+			shell := strings.TrimSpace(command[i+1:])
+			if fields := strings.Fields(shell); len(fields) > 0 {
+				if _, err := exec.LookPath(fields[0]); err == nil {
+					return strings.TrimSpace(command[:i]), shell, "", false
+				}
+			}
+		case r == '>':
+			width := 1
+			if strings.HasPrefix(command[i:], ">>") {
+				width = 2
+			}
+			if !separated(command, i, width) {
+				continue
+			}
+			target := strings.TrimSpace(command[i+width:])
+			if len(strings.Fields(target)) == 1 && isRedirectPath(target) {
+				return strings.TrimSpace(command[:i]), "", target, width == 2
+			}
+		}
+	}
+	return command, "", "", false
+}
+
+// separated reports whether the operator width bytes long at i has space
+// on both sides.
+func separated(command string, i, width int) bool {
+	return i > 0 && command[i-1] == ' ' && i+width < len(command) && command[i+width] == ' '
+}
+
+func isRedirectPath(target string) bool {
+	for _, prefix := range []string{"/", "./", "../", "~/"} {
+		if strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A recorded session is a text file of the commands typed, one a line, each
+// followed by what it is expected to show, indented by a tab: where the
+// program stopped, when that changed, and the values printed.
+//
+//	# godebugger session of /tmp/hello/hello
+//	break main.go:13
+//	continue
+//		at main.go:13
+//	print greeted
+//		= true
+//
+// verify runs the commands again, against the binary rebuilt, and reports
+// where it shows something else.
+
+// sessionStep is a command of a recorded session and what it showed.
+type sessionStep struct {
+	command  string
+	location string // Where the program was stopped afterwards.
+	values   []string
+}
+
+// commandCapture copies the output of the command being run to the
+// terminal and keeps it, for the session being recorded or verified.
+type commandCapture struct {
+	command  string
+	terminal *os.File
+	out      *os.File
+	kept     bytes.Buffer
+	copied   chan struct{}
+}
+
+var (
+	// sessionRecording is the file the session is being recorded to, or
+	// nil.  recordedLocation is the location last written to it.
+	sessionRecording *bufio.Writer
+	sessionFile      *os.File
+	recordedLocation string
+
+	// verifying is set by verify, which runs verifySteps.  verified counts
+	// the steps run, and divergences what went differently.
+	verifying   bool
+	verifySteps []sessionStep
+	verified    int
+	divergences []string
+
+	capture *commandCapture
+)
+
+var printedValue = regexp.MustCompile(`^\$[0-9]+ = (.*)$`)
+
+func isSessionRecordCommand(command string) bool {
+	return strings.HasPrefix(command, "session record ")
+}
+
+// runSessionRecordCommand starts recording the session to a file, or stops
+// with off.
+func runSessionRecordCommand(argument string) error {
+	if sessionRecording != nil {
+		sessionRecording.Flush()
+		sessionFile.Close()
+		fmt.Printf("Stopped recording to %v.\n", sessionFile.Name())
+		sessionRecording, sessionFile = nil, nil
+	}
+	if argument == "off" {
+		return nil
+	}
+	if verifying {
+		return fmt.Errorf("sessions can't be recorded while one is verified")
+	}
+	f, err := os.Create(argument)
+	if err != nil {
+		return err
+	}
+	sessionFile, sessionRecording = f, bufio.NewWriter(f)
+	recordedLocation = stopLocation()
+	fmt.Fprintf(sessionRecording, "# godebugger session of %v\n", buildInfoBinary)
+	if recordedLocation != "" {
+		fmt.Fprintf(sessionRecording, "# starting at %v\n", recordedLocation)
+	}
+	fmt.Printf("Recording the session to %v; session record off stops.\n", argument)
+	return sessionRecording.Flush()
+}
+
+// stopLocation is the file and line the program is stopped at, for
+// comparing sessions across builds in different directories.
+func stopLocation() string {
+	if pcSourceFile == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v:%v", filepath.Base(pcSourceFile), pcSourceLine)
+}
+
+// beginCapture starts keeping the output of a command when the session is
+// recorded or verified.
+func beginCapture(command string) {
+	if sessionRecording == nil && !verifying || isSessionRecordCommand(command) {
+		return
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	capture = &commandCapture{command: command, terminal: os.Stdout, out: w, copied: make(chan struct{})}
+	os.Stdout = w
+	go func(c *commandCapture) {
+		io.Copy(io.MultiWriter(c.terminal, &c.kept), r)
+		r.Close()
+		close(c.copied)
+	}(capture)
+}
+
+// endCapture puts the output back once the command is done, and records or
+// checks what it showed.
+func endCapture() {
+	if capture == nil {
+		return
+	}
+	c := capture
+	capture = nil
+	os.Stdout = c.terminal
+	c.out.Close()
+	<-c.copied
+
+	step := sessionStep{command: c.command, location: stopLocation()}
+	for _, line := range strings.Split(c.kept.String(), "\n") {
+		if m := printedValue.FindStringSubmatch(line); m != nil {
+			step.values = append(step.values, m[1])
+		}
+	}
+	if verifying {
+		checkStep(step)
+	} else if sessionRecording != nil {
+		recordStep(step)
+	}
+}
+
+func recordStep(step sessionStep) {
+	fmt.Fprintln(sessionRecording, step.command)
+	if step.location != recordedLocation {
+		fmt.Fprintf(sessionRecording, "\tat %v\n", step.location)
+		recordedLocation = step.location
+	}
+	for _, v := range step.values {
+		fmt.Fprintf(sessionRecording, "\t= %v\n", v)
+	}
+	sessionRecording.Flush()
+}
+
+// loadSession reads a recorded session.  A step without an at line expects
+// the program to be where the one before left it.
+func loadSession(path string) (binary string, steps []sessionStep, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	location := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "# godebugger session of "):
+			binary = strings.TrimPrefix(line, "# godebugger session of ")
+		case strings.HasPrefix(line, "# starting at "):
+			location = strings.TrimPrefix(line, "# starting at ")
+		case strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "":
+		case strings.HasPrefix(line, "\t"):
+			if len(steps) == 0 {
+				return "", nil, fmt.Errorf("%v:%v: an expectation before any command", path, n)
+			}
+			step := &steps[len(steps)-1]
+			expected := strings.TrimPrefix(line, "\t")
+			switch {
+			case strings.HasPrefix(expected, "at "):
+				step.location = strings.TrimPrefix(expected, "at ")
+				location = step.location
+			case strings.HasPrefix(expected, "= "):
+				step.values = append(step.values, strings.TrimPrefix(expected, "= "))
+			default:
+				return "", nil, fmt.Errorf("%v:%v: expected at or = after the tab", path, n)
+			}
+		default:
+			steps = append(steps, sessionStep{command: line, location: location})
+		}
+	}
+	return binary, steps, scanner.Err()
+}
+
+// prepareVerify reads the session "verify <file> [<binary>] [-- <args>]"
+// names and queues its commands, returning the command line to debug the
+// binary with.  The binary is the one the session was recorded with unless
+// another is given.
+func prepareVerify(args []string) []string {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %v verify <session> [<binary>] [-- <args>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	binary, steps, err := loadSession(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	rest := args[1:]
+	if len(rest) > 0 && rest[0] != "--" {
+		binary, rest = rest[0], rest[1:]
+	}
+	if binary == "" {
+		fmt.Fprintf(os.Stderr, "%v doesn't say which binary it was recorded with; name one\n", args[0])
+		os.Exit(2)
+	}
+	verifying, verifySteps = true, steps
+	for _, step := range steps {
+		scriptCommands = append(scriptCommands, step.command)
+	}
+	return append([]string{os.Args[0], binary}, rest...)
+}
+
+// checkStep compares what a command showed with what it showed when the
+// session was recorded.
+func checkStep(got sessionStep) {
+	if verified >= len(verifySteps) {
+		return
+	}
+	want := verifySteps[verified]
+	verified++
+	diverged := func(format string, a ...interface{}) {
+		d := fmt.Sprintf("step %v (%v): ", verified, want.command) + fmt.Sprintf(format, a...)
+		divergences = append(divergences, d)
+		fmt.Println("Divergence:", d)
+	}
+	if got.location != want.location {
+		diverged("stopped at %v, not %v", got.location, want.location)
+	}
+	for i, v := range want.values {
+		if i >= len(got.values) {
+			diverged("printed nothing in place of %v", v)
+		} else if got.values[i] != v {
+			diverged("printed %v, not %v", got.values[i], v)
+		}
+	}
+	if len(got.values) > len(want.values) {
+		for _, v := range got.values[len(want.values):] {
+			diverged("printed %v as well", v)
+		}
+	}
+}
+
+// finishVerify reports how the session went and exits, with status 1 if
+// anything went differently.
+func finishVerify() {
+	if !verifying {
+		return
+	}
+	if verified < len(verifySteps) {
+		divergences = append(divergences, fmt.Sprintf("steps %v to %v weren't run: the program has exited", verified+1, len(verifySteps)))
+	}
+	if len(divergences) == 0 {
+		fmt.Printf("Verified %v steps: the session went as recorded.\n", len(verifySteps))
+		killTracee()
+		os.Exit(0)
+	}
+	fmt.Printf("%v divergences from the recorded session:\n", len(divergences))
+	for _, d := range divergences {
+		fmt.Printf("  %v\n", d)
+	}
+	killTracee()
+	os.Exit(1)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"os"
+)
+
+// restartTracee kills the tracee and starts the program again.  The binary
+// is loaded afresh, since it has often just been rebuilt, and every user
+// breakpoint is resolved again from the location it was set with.
+func restartTracee(pid int, path string, exe *elf.File) (int, *elf.File, *gosym.Table) {
+	killTracee()
+	exe.Close()
+
+	pid = 0
+	binary := path
+	if waitExec != "" {
+		pid = initTracee(path)
+		binary = tracedBinary(pid, path)
+	}
+	exe, symbolTable, err := reloadBinary(binary)
+	if err != nil {
+		fatal(err)
+	}
+	forgetInsertions()
+
+	if waitExec == "" {
+		pid = initTracee(path)
+	}
+	rearm(pid, symbolTable)
+
+	runToMain(pid, symbolTable)
+	return currentThread, exe, symbolTable
+}
+
+// reloadBinary loads the debug information of the binary of a new tracee
+// in place of the last one's.
+func reloadBinary(binary string) (*elf.File, *gosym.Table, error) {
+	exe, err := elf.Open(binary)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info, err := os.Stat(binary); err == nil {
+		binaryModTime = info.ModTime()
+	}
+	loadBuildInfo(binary)
+	staleSources = make(map[string]bool)
+
+	symbolTable := loadSymbols(exe)
+	entryPoint = exe.Entry
+	return exe, symbolTable, nil
+}
+
+// forgetInsertions drops what the debugger knows of the code of a tracee
+// that is gone: the addresses it catches.  Its INT3s went with its target.
+func forgetInsertions() {
+	displacedBuffer, displacedFailed = 0, false
+	depthCounter = newFrameCounter(nil)
+	crashed = false
+	fatalPanicAddress = 0
+	throwAddresses = make(map[uint64]string)
+	exitAddress = 0
+	runtimeInitAddress = 0
+	goroutineEventAddresses = make(map[uint64]string)
+	logCatchAddresses = make(map[uint64]string)
+	outputCatchAddresses = make(map[uint64]string)
+	growthAddresses = make(map[uint64]string)
+	gcCatchAddresses = make(map[uint64]string)
+	gcStartedCycle, gcMarkedCycle = 0, 0
+	chanCatchAddresses = make(map[uint64]string)
+}
+
+// rearm sets the catchers, breakpoints and watchpoints of the previous
+// tracee again in a new one.
+func rearm(pid int, symbolTable *gosym.Table) {
+	armFatalPanicCatcher(pid, symbolTable)
+	if catchThrow {
+		if err := armThrowCatcher(pid, symbolTable); err != nil {
+			fmt.Printf("  catch throw: %v, removed\n", err)
+			catchThrow = false
+		}
+	}
+	if catchExit {
+		if err := armExitCatcher(pid, symbolTable); err != nil {
+			fmt.Printf("  catch exit: %v, removed\n", err)
+			catchExit = false
+		}
+	}
+	if catchRuntimeInit {
+		if err := armRuntimeInitCatcher(pid, symbolTable); err != nil {
+			fmt.Printf("  catch runtime-init: %v, removed\n", err)
+			catchRuntimeInit = false
+		}
+	}
+	if catchLog != nil {
+		if err := armLogCatcher(pid, symbolTable); err != nil {
+			fmt.Printf("  catch log: %v, removed\n", err)
+			catchLog = nil
+		}
+	}
+	if catchGC != "" {
+		if err := armGCCatcher(pid, symbolTable); err != nil {
+			fmt.Printf("  catch gc: %v, removed\n", err)
+			catchGC = ""
+		}
+	}
+	if catchChan != nil {
+		fmt.Printf("  catch chan %v: the channel was the old process's, removed\n", catchChan.expression)
+		catchChan = nil
+	}
+	if catchOutput != nil {
+		if err := armOutputCatcher(pid, symbolTable); err != nil {
+			fmt.Printf("  catch output: %v, removed\n", err)
+			catchOutput = nil
+		}
+	}
+	if goroutineEvents {
+		if err := armGoroutineEvents(pid, symbolTable); err != nil {
+			fmt.Printf("  goroutine-events: %v, turned off\n", err)
+			goroutineEvents = false
+		}
+	}
+	rearmBreakpoints(pid, symbolTable)
+	rearmWatchpoints(pid, symbolTable)
+	rearmGrowthWatches(pid, symbolTable)
+}
+
+// rearmBreakpoints resolves the breakpoints of the previous run in the new
+// binary and reports which stayed put, which moved and which are gone.
+func rearmBreakpoints(pid int, symbolTable *gosym.Table) {
+	previous := breakpoints
+	breakpoints = nil
+	if len(previous) == 0 {
+		return
+	}
+
+	fmt.Println("Breakpoints:")
+	for _, old := range previous {
+		if old.trace != nil {
+			rearmRecursionTrace(pid, old, symbolTable)
+			continue
+		}
+		loc, err := parseLocation(old.spec, symbolTable)
+		if err == nil {
+			err = loc.resolvePC(symbolTable)
+		}
+		if err != nil {
+			fmt.Printf("  %v: could not be resolved (%v), removed\n", old.spec, err)
+			continue
+		}
+		add := addBreakpoint
+		if old.hardware {
+			add = addHardwareBreakpoint
+		}
+		if err := add(pid, loc.file, loc.line, loc.pc); err == errBreakpointExists {
+			fmt.Printf("  %v: now at the same address as another breakpoint, merged\n", old.spec)
+			continue
+		} else if err != nil {
+			fmt.Printf("  %v: %v, removed\n", old.spec, err)
+			continue
+		}
+
+		bp := findBreakpoint(loc.pc)
+		bp.spec, bp.condition, bp.cond, bp.group = old.spec, old.condition, old.cond, old.group
+		if old.cond != nil {
+			bp.fast = compileCondition(old.cond, loc.pc, symbolTable)
+		}
+		bp.calledBy, bp.request, bp.alloc, bp.pause = old.calledBy, old.request, old.alloc, old.pause
+		bp.capture, bp.assertion, bp.commands = old.capture, old.assertion, old.commands
+		bp.goroutine, bp.ignore = old.goroutine, old.ignore
+		bp.id, bp.hits = old.id, old.hits
+		if bp.alloc != nil {
+			bp.fast = nil
+			if err := bp.alloc.arm(pid, symbolTable); err != nil {
+				fmt.Printf("  %v: %v\n", old.spec, err)
+			}
+		}
+		if old.disabled {
+			disableBreakpoint(pid, bp)
+		}
+		if loc.pc == old.pc {
+			fmt.Printf("  %v: re-armed at 0x%x\n", old.spec, loc.pc)
+		} else {
+			fmt.Printf("  %v: moved from 0x%x (%v:%v) to 0x%x (%v:%v)\n", old.spec, old.pc, old.file, old.line, loc.pc, loc.file, loc.line)
+		}
+	}
+}
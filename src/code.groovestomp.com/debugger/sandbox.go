@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// evalReadLimit caps how many bytes of the tracee's memory evaluating
+	// one expression may read, or zero for no cap, so that printing a
+	// huge or corrupt value can't wedge the debugger.
+	evalReadLimit int64
+
+	// evalBytesRead is how much the evaluation under way has read, while
+	// evaluating is set.
+	evalBytesRead int64
+	evaluating    bool
+
+	// callTimeout is how long a call made from an expression may run before
+	// it is interrupted and the thread put back as it was.
+	callTimeout = 5 * time.Second
+
+	// noCalls, set with -no-calls, refuses every call from an expression,
+	// whatever unsafe-calls says, for processes that mustn't be disturbed.
+	noCalls bool
+)
+
+// beginEvaluation starts counting memory reads against eval-read-limit.
+func beginEvaluation() {
+	evaluating = true
+	evalBytesRead = 0
+}
+
+// endEvaluation stops counting, once what was evaluated has been printed.
+func endEvaluation() {
+	evaluating = false
+}
+
+// chargeRead counts a read made while evaluating, failing it if it would
+// go over the limit.
+func chargeRead(size int) error {
+	if !evaluating || evalReadLimit == 0 {
+		return nil
+	}
+	if evalBytesRead+int64(size) > evalReadLimit {
+		return fmt.Errorf("evaluation stopped: it would read more than %v bytes of memory (config eval-read-limit)", evalReadLimit)
+	}
+	evalBytesRead += int64(size)
+	return nil
+}
+
+// parseCallTimeout sets call-timeout, which unlike step-timeout can't be
+// turned off.
+func parseCallTimeout(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("expected a duration like 5s, got %q", value)
+	}
+	callTimeout = d
+	return nil
+}
+
+// parseByteSize reads a number of bytes with an optional K, M or G suffix,
+// in powers of 1024; 0 or off means no limit.
+func parseByteSize(value string, target *int64) error {
+	if value == "off" {
+		*target = 0
+		return nil
+	}
+	s := strings.TrimSuffix(strings.ToUpper(value), "B")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("expected a size such as 65536, 64K or 16M, or off, got %q", value)
+	}
+	*target = n * multiplier
+	return nil
+}
+
+func formatByteSize(n int64) string {
+	switch {
+	case n == 0:
+		return "off"
+	case n%(1<<30) == 0:
+		return fmt.Sprintf("%vG", n>>30)
+	case n%(1<<20) == 0:
+		return fmt.Sprintf("%vM", n>>20)
+	case n%(1<<10) == 0:
+		return fmt.Sprintf("%vK", n>>10)
+	}
+	return strconv.FormatInt(n, 10)
+}
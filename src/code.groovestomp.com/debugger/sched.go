@@ -0,0 +1,68 @@
+package main
+
+import "fmt"
+
+// pStatusNames are the P states from the runtime's runtime2.go.
+var pStatusNames = map[uint64]string{
+	0: "idle",
+	1: "running",
+	2: "syscall",
+	3: "gcstop",
+	4: "dead",
+}
+
+// showSched implements "info sched", a snapshot of the scheduler: how many
+// Ms are idle or spinning, the global run queue and every P's local queue.
+func showSched(pid int) error {
+	sched, err := readGlobal(pid, "runtime.sched")
+	if err != nil {
+		return err
+	}
+	gomaxprocs, err := readGlobal(pid, "runtime.gomaxprocs")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("GOMAXPROCS %v, %v Ms created: %v idle, %v spinning; %v idle Ps\n",
+		zeroExtend(gomaxprocs.data), scalarMember(sched, "mnext"), scalarMember(sched, "nmidle"),
+		scalarMember(sched, "nmspinning"), scalarMember(sched, "npidle"))
+	fmt.Printf("Global run queue: %v goroutines\n", scalarMember(sched, "runq", "size"))
+	if scalarMember(sched, "gcwaiting") != 0 {
+		fmt.Println("The garbage collector is stopping the world.")
+	}
+
+	allp, err := readGlobal(pid, "runtime.allp")
+	if err != nil {
+		return err
+	}
+	ps, err := sliceElements(pid, allp, 1024)
+	if err != nil {
+		return err
+	}
+	for _, pointer := range ps {
+		p, err := dereference(pid, pointer)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		status := scalarMember(p, "status")
+		name, ok := pStatusNames[status]
+		if !ok {
+			name = fmt.Sprintf("status %v", status)
+		}
+		fmt.Printf("P%-3v %-8v", scalarMember(p, "id"), name)
+
+		if m := scalarMember(p, "m"); m != 0 {
+			if mv, err := readRuntimeStruct(pid, "runtime.m", m); err == nil {
+				fmt.Printf(" M%v (thread %v)", scalarMember(mv, "id"), scalarMember(mv, "procid"))
+			}
+		}
+		queued := uint32(scalarMember(p, "runqtail")) - uint32(scalarMember(p, "runqhead"))
+		fmt.Printf(" local queue %v", queued)
+		if scalarMember(p, "runnext") != 0 {
+			fmt.Print(" + runnext")
+		}
+		fmt.Printf(", schedtick %v\n", scalarMember(p, "schedtick"))
+	}
+	return nil
+}
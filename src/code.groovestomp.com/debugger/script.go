@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// initFile is the file in the home directory whose commands run whenever
+// the debugger starts.
+const initFile = ".godebuggerrc"
+
+var (
+	// scriptCommands are the commands from the init file and -command
+	// still to run, before the user is asked for any.
+	scriptCommands []string
+
+	// lastCommand is the last command typed, which an empty line repeats.
+	lastCommand string
+
+	// profileCommands are the commands that set up the profile in use.
+	profileCommands []string
+)
+
+// loadScripts queues the commands of the init file, if there is one, those
+// of the profile, and then those of the file given with -command.
+func loadScripts(commandFile string) error {
+	if home, err := os.UserHomeDir(); err == nil {
+		if err := loadScript(filepath.Join(home, initFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	scriptCommands = append(scriptCommands, profileCommands...)
+	if commandFile != "" {
+		return loadScript(commandFile)
+	}
+	return nil
+}
+
+// loadScript queues the commands of a file, one a line.  Blank lines and
+// lines starting with # are skipped.
+func loadScript(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			scriptCommands = append(scriptCommands, line)
+		}
+	}
+	return scanner.Err()
+}
+
+// nextScriptCommand takes the next queued command, echoing it after the
+// prompt as if it had been typed.
+func nextScriptCommand() (string, bool) {
+	if len(scriptCommands) == 0 {
+		return "", false
+	}
+	command := scriptCommands[0]
+	scriptCommands = scriptCommands[1:]
+	fmt.Println(command)
+	return command + "\n", true
+}
+
+// isRepeatable reports whether an empty line may run the command again, as
+// it does for stepping and continuing.  Starting the program over again by
+// accident would lose the session, and commands would ask for a list again.
+func isRepeatable(command string) bool {
+	return !isRestartCommand(command) && !isCommandsCommand(command)
+}
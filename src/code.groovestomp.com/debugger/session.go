@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// maxSummaryBreakpoints is how many breakpoints the summary lists.
+const maxSummaryBreakpoints = 5
+
+// sessionStats is what the debugger has measured of the tracee since it
+// started.
+type sessionStats struct {
+	started      time.Time
+	runningSince time.Time // Zero while the tracee is stopped.
+	running      time.Duration
+	stops        map[string]int
+
+	// ran is set once the tracee runs and cleared when the stop it ran to
+	// is counted.  reason is why it stopped, or empty for the end of a
+	// step.
+	ran    bool
+	reason string
+
+	// reported is set when the stop was reported already, by checkCrash.
+	reported bool
+
+	// exit is how the program ended, once it has.
+	exit *targetExitedEvent
+
+	// lines counts the stops on each line of each source file.
+	lines map[string]map[int]int
+}
+
+var session = sessionStats{started: time.Now(), stops: make(map[string]int), lines: make(map[string]map[int]int)}
+
+// markRunning starts the running clock as a thread of the tracee resumes.
+func markRunning() {
+	if session.runningSince.IsZero() {
+		session.runningSince = time.Now()
+	}
+	session.ran = true
+	session.reason = ""
+}
+
+// markStopped stops the running clock, with the reason the tracee stopped
+// if it is one the user sees.
+func markStopped(reason string) {
+	if !session.runningSince.IsZero() {
+		session.running += time.Since(session.runningSince)
+		session.runningSince = time.Time{}
+	}
+	if reason != "" {
+		session.reason = reason
+	}
+}
+
+// markExited notes how the program ended, for the event countStop
+// publishes.
+func markExited(status *syscall.WaitStatus) {
+	markStopped("exit")
+	e := exitEvent(status)
+	session.exit = &e
+}
+
+// countStop counts the stop the tracee last ran to, once the user has it
+// in front of them, and publishes it, returning false if there was none.
+func countStop() bool {
+	if !session.ran || running {
+		return false
+	}
+	reason := session.reason
+	if reason == "" {
+		reason = "step"
+	}
+	session.stops[reason]++
+	if session.lines[pcSourceFile] == nil {
+		session.lines[pcSourceFile] = make(map[int]int)
+	}
+	session.lines[pcSourceFile][pcSourceLine]++
+	session.ran = false
+	if reason == "exit" && session.exit != nil {
+		publish(*session.exit)
+	} else if reason != "exit" {
+		recordStop(reason)
+		publish(stopEvent{tid: currentThread, reason: reason, file: pcSourceFile, line: pcSourceLine, reported: session.reported})
+	}
+	session.reported = false
+	return true
+}
+
+// showSessionSummary implements "session summary".
+func showSessionSummary() {
+	total := time.Since(session.started)
+	runningTime := session.running
+	if !session.runningSince.IsZero() {
+		runningTime += time.Since(session.runningSince)
+	}
+	round := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	percent := func(d time.Duration) float64 { return 100 * float64(d) / float64(total) }
+	fmt.Printf("Session: %v\n", round(total))
+	fmt.Printf("  running  %v (%.1f%%)\n", round(runningTime), percent(runningTime))
+	fmt.Printf("  stopped  %v (%.1f%%)\n", round(total-runningTime), percent(total-runningTime))
+
+	countStop()
+	reasons := make([]string, 0, len(session.stops))
+	stops := 0
+	for reason, n := range session.stops {
+		reasons = append(reasons, reason)
+		stops += n
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		a, b := reasons[i], reasons[j]
+		return session.stops[a] > session.stops[b] || session.stops[a] == session.stops[b] && a < b
+	})
+	fmt.Printf("Stops: %v\n", stops)
+	for _, reason := range reasons {
+		fmt.Printf("  %-10v %v\n", reason, session.stops[reason])
+	}
+
+	var hit []*breakpoint
+	for _, bp := range breakpoints {
+		if bp.hits > 0 {
+			hit = append(hit, bp)
+		}
+	}
+	if len(hit) == 0 {
+		return
+	}
+	sort.SliceStable(hit, func(i, j int) bool { return hit[i].hits > hit[j].hits })
+	if len(hit) > maxSummaryBreakpoints {
+		hit = hit[:maxSummaryBreakpoints]
+	}
+	fmt.Println("Most hit breakpoints:")
+	for _, bp := range hit {
+		fmt.Printf("  %-4v %-6v %v\n", bp.id, bp.hits, bp.describe())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runShell runs a command line with the host's shell, its output and
+// errors going where the debugger's output goes, so that they can be piped
+// or redirected like any command's.  It gets no input: the terminal is the
+// debugger's.
+func runShell(line string) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	shell := exec.Command("/bin/sh", "-c", line)
+	shell.Stdout, shell.Stderr = w, w
+	exited, err := startHelper(shell)
+	w.Close()
+	if err != nil {
+		r.Close()
+		fmt.Println(err)
+		return
+	}
+	// The shell is done with its output once the pipe is closed at its
+	// end.
+	io.Copy(os.Stdout, r)
+	r.Close()
+	status := <-exited
+	if status.Signaled() {
+		fmt.Printf("(%v)\n", status.Signal())
+	} else if status.ExitStatus() != 0 {
+		fmt.Printf("(exit status %v)\n", status.ExitStatus())
+	}
+}
+
+// commandOutput runs a program and returns what it writes to stdout, as
+// exec.Cmd.Output does, but as a helper, like runShell.  A failure is
+// reported with what the program wrote to stderr.
+func commandOutput(name string, args ...string) ([]byte, error) {
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
+		return nil, err
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = stdoutWriter, stderrWriter
+	exited, err := startHelper(cmd)
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, err
+	}
+	messages := make(chan []byte)
+	go func() {
+		message, _ := ioutil.ReadAll(stderr)
+		stderr.Close()
+		messages <- message
+	}()
+	out, _ := ioutil.ReadAll(stdout)
+	stdout.Close()
+	message := <-messages
+
+	status := <-exited
+	if status.Signaled() || status.ExitStatus() != 0 {
+		text := strings.TrimSpace(string(message))
+		if text == "" {
+			text = fmt.Sprintf("exit status %v", status.ExitStatus())
+		}
+		return out, fmt.Errorf("%v", text)
+	}
+	return out, nil
+}
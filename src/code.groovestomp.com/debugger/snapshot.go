@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// A snapshot keeps what is needed to look at a stopped process again: the
+// registers of its threads, the stacks of its threads and goroutines, the
+// binary's data, and the heap pages those refer to, a few pointers deep.
+// Code and read-only data are read from the binary when it is reopened.
+//
+// The file is gzipped.  After snapshotMagic come the path of the binary,
+// the signal the process was stopped with, the threads, each a tid and the
+// registers, and the regions of memory, each an address, a length and the
+// bytes.  Integers are little-endian, and strings and lists start with
+// their length.
+const snapshotMagic = "GDBSNAP1"
+
+const (
+	snapshotPage = 4096
+
+	// maxSnapshotSize is how much memory a snapshot keeps at most, and
+	// snapshotDepth how many pointers from a stack or global it follows.
+	maxSnapshotSize = 64 << 20
+	snapshotDepth   = 4
+
+	// maxThreadStack is how much of a thread's stack above its stack
+	// pointer a snapshot keeps, for threads not running a goroutine.
+	maxThreadStack = 64 << 10
+)
+
+// mapping is a line of /proc/<pid>/maps.
+type mapping struct {
+	start, end uint64
+	perms      string
+}
+
+func isSnapshotSaveCommand(command string) bool {
+	return strings.HasPrefix(command, "snapshot save ")
+}
+
+// saveSnapshot implements "snapshot save <file>".
+func saveSnapshot(pid int, path string) error {
+	if running {
+		return errors.New("the program is running; interrupt it first")
+	}
+	if core != nil {
+		return errors.New("there is no process to take a snapshot of")
+	}
+	mappings, err := readMappings(pid)
+	if err != nil {
+		return err
+	}
+	s := &snapshotter{pid: pid, mappings: mappings, pages: make(map[uint64]bool)}
+
+	// The binary's data and bss hold the globals, among them allgs.
+	if exe, err := elf.Open(buildInfoBinary); err == nil {
+		for _, prog := range exe.Progs {
+			if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_W != 0 {
+				s.add(prog.Vaddr, prog.Vaddr+prog.Memsz, 0)
+			}
+		}
+		exe.Close()
+	}
+
+	var regs []coreThread
+	for _, t := range sortedThreads() {
+		var r syscall.PtraceRegs
+		if err := ptraceGetRegs(t.tid, &r); err != nil {
+			continue
+		}
+		regs = append(regs, coreThread{tid: t.tid, regs: r})
+		s.add(r.Rsp-128, r.Rsp+maxThreadStack, 0)
+	}
+	if len(regs) == 0 {
+		return errors.New("no thread is stopped")
+	}
+	// The current thread goes first, as the one a core names first.
+	for i, t := range regs {
+		if t.tid == currentThread {
+			regs[0], regs[i] = regs[i], regs[0]
+		}
+	}
+
+	goroutines := 0
+	if allgs, err := readGlobal(pid, "runtime.allgs"); err == nil {
+		pointers, _ := sliceElements(pid, allgs, maxGoroutines)
+		for _, pointer := range pointers {
+			s.add(pointer.addr, pointer.addr+8, 0)
+			gv, err := dereference(pid, pointer)
+			if err != nil {
+				continue
+			}
+			s.add(gv.addr, gv.addr+uint64(len(gv.data)), 0)
+			g := readGoroutine(pid, gv)
+			if g.status&^goroutineScan == goroutineDead {
+				continue
+			}
+			lo, hi := scalarMember(gv, "stack", "lo"), scalarMember(gv, "stack", "hi")
+			if g.sp > lo && g.sp < hi {
+				lo = g.sp
+			}
+			s.add(lo, hi, 0)
+			goroutines++
+		}
+	}
+	s.follow()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	z := gzip.NewWriter(f)
+	w := bufio.NewWriter(z)
+	signal := uint32(0)
+	if t := threads[regs[0].tid]; t != nil {
+		signal = uint32(t.signal)
+	}
+	binaryPath := buildInfoBinary
+	if abs, err := filepath.Abs(binaryPath); err == nil {
+		binaryPath = abs
+	}
+	w.WriteString(snapshotMagic)
+	writeString(w, binaryPath)
+	binary.Write(w, binary.LittleEndian, signal)
+	binary.Write(w, binary.LittleEndian, uint32(len(regs)))
+	for _, t := range regs {
+		binary.Write(w, binary.LittleEndian, int64(t.tid))
+		binary.Write(w, binary.LittleEndian, &t.regs)
+	}
+	binary.Write(w, binary.LittleEndian, uint32(len(s.regions)))
+	kept := 0
+	for _, r := range s.regions {
+		binary.Write(w, binary.LittleEndian, r.address)
+		binary.Write(w, binary.LittleEndian, uint32(len(r.data)))
+		w.Write(r.data)
+		kept += len(r.data)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	size := int64(0)
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	fmt.Printf("Saved %v threads, %v goroutines and %v KB of memory to %v (%v KB).\n",
+		len(regs), goroutines, kept>>10, path, size>>10)
+	if s.truncated {
+		fmt.Printf("The snapshot stopped at %v MB; some of the heap is left out.\n", maxSnapshotSize>>20)
+	}
+	return nil
+}
+
+// snapshotter gathers the pages a snapshot keeps.  pages is every page
+// chosen, and depth the pages found at each number of pointers from a
+// stack or global, not yet scanned for pointers of their own.
+type snapshotter struct {
+	pid       int
+	mappings  []mapping
+	pages     map[uint64]bool
+	depth     [snapshotDepth + 1][]uint64
+	regions   []memoryRegion
+	truncated bool
+}
+
+// add chooses the pages from start to end that are mapped writable.
+func (s *snapshotter) add(start, end uint64, depth int) {
+	if end <= start {
+		return
+	}
+	// Counting pages rather than comparing addresses keeps a range at the
+	// top of the address space from wrapping around.
+	first := start &^ (snapshotPage - 1)
+	count := (end-1-first)/snapshotPage + 1
+	for i := uint64(0); i < count; i++ {
+		page := first + i*snapshotPage
+		if s.pages[page] || !s.writable(page) {
+			continue
+		}
+		if len(s.pages)*snapshotPage >= maxSnapshotSize {
+			s.truncated = true
+			return
+		}
+		s.pages[page] = true
+		s.depth[depth] = append(s.depth[depth], page)
+	}
+}
+
+func (s *snapshotter) writable(address uint64) bool {
+	i := sort.Search(len(s.mappings), func(i int) bool { return s.mappings[i].end > address })
+	return i < len(s.mappings) && s.mappings[i].start <= address && strings.Contains(s.mappings[i].perms, "w")
+}
+
+// follow reads the pages chosen, and those their words point to, to
+// snapshotDepth, then gathers them into regions.
+func (s *snapshotter) follow() {
+	data := make(map[uint64][]byte)
+	for depth := 0; depth <= snapshotDepth; depth++ {
+		for _, page := range s.depth[depth] {
+			buf := make([]byte, snapshotPage)
+			if err := readRemote(s.pid, page, buf); err != nil {
+				continue
+			}
+			data[page] = buf
+			if depth == snapshotDepth {
+				continue
+			}
+			for i := 0; i+8 <= len(buf); i += 8 {
+				if word := binary.LittleEndian.Uint64(buf[i:]); word >= snapshotPage {
+					s.add(word, word+1, depth+1)
+				}
+			}
+		}
+	}
+
+	var pages []uint64
+	for page := range data {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	for _, page := range pages {
+		if n := len(s.regions); n > 0 {
+			last := &s.regions[n-1]
+			if last.address+uint64(len(last.data)) == page {
+				last.data = append(last.data, data[page]...)
+				continue
+			}
+		}
+		s.regions = append(s.regions, memoryRegion{address: page, data: data[page]})
+	}
+}
+
+// readRemote reads the tracee's memory with a single process_vm_readv,
+// which for whole pages is far faster than ptrace's word at a time.
+func readRemote(pid int, address uint64, out []byte) error {
+	local := syscall.Iovec{Base: &out[0], Len: uint64(len(out))}
+	remote := remoteIovec{base: address, length: uint64(len(out))}
+	n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(pid),
+		uintptr(unsafe.Pointer(&local)), 1,
+		uintptr(unsafe.Pointer(&remote)), 1, 0)
+	if errno != 0 {
+		return errno
+	}
+	if int(n) != len(out) {
+		return errors.New("short read")
+	}
+	return nil
+}
+
+// readMappings reads /proc/<pid>/maps, in order of address.
+func readMappings(pid int) ([]mapping, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	var mappings []mapping
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		start, err1 := strconv.ParseUint(bounds[0], 16, 64)
+		end, err2 := strconv.ParseUint(bounds[len(bounds)-1], 16, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		mappings = append(mappings, mapping{start: start, end: end, perms: fields[1]})
+	}
+	return mappings, nil
+}
+
+func writeString(w io.Writer, s string) {
+	binary.Write(w, binary.LittleEndian, uint32(len(s)))
+	io.WriteString(w, s)
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+// loadSnapshot reads a snapshot, returning it and the binary it names.
+func loadSnapshot(path string) (*coreDump, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%v is not a snapshot: %v", path, err)
+	}
+	r := bufio.NewReader(z)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, "", fmt.Errorf("%v is not a snapshot", path)
+	}
+	malformed := func(err error) (*coreDump, string, error) {
+		return nil, "", fmt.Errorf("%v is damaged: %v", path, err)
+	}
+
+	c := &coreDump{path: path}
+	binaryPath, err := readString(r)
+	if err != nil {
+		return malformed(err)
+	}
+	var signal, count uint32
+	binary.Read(r, binary.LittleEndian, &signal)
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return malformed(err)
+	}
+	c.signal = syscall.Signal(signal)
+	for i := uint32(0); i < count; i++ {
+		var tid int64
+		var t coreThread
+		binary.Read(r, binary.LittleEndian, &tid)
+		if err := binary.Read(r, binary.LittleEndian, &t.regs); err != nil {
+			return malformed(err)
+		}
+		t.tid = int(tid)
+		c.threads = append(c.threads, t)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return malformed(err)
+	}
+	for i := uint32(0); i < count; i++ {
+		var region memoryRegion
+		var length uint32
+		binary.Read(r, binary.LittleEndian, &region.address)
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return malformed(err)
+		}
+		region.data = make([]byte, length)
+		if _, err := io.ReadFull(r, region.data); err != nil {
+			return malformed(err)
+		}
+		c.regions = append(c.regions, region)
+	}
+	if len(c.threads) == 0 {
+		return malformed(errors.New("no threads"))
+	}
+	return c, binaryPath, nil
+}
+
+// runSnapshot implements "snapshot open <file> [<binary>]", which reports on
+// a snapshot as triage does on a core, and "snapshot diff".  The binary is
+// the one the snapshot was taken of unless another is given.
+func runSnapshot(args []string) {
+	args = takeIgnoreBuildID(args)
+	if len(args) > 0 && args[0] == "diff" {
+		runSnapshotDiff(args[1:])
+		return
+	}
+	if len(args) < 2 || len(args) > 3 || args[0] != "open" {
+		fmt.Fprintf(os.Stderr, "Usage: %v snapshot open [-ignore-build-id] <file> [<binary>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %v snapshot diff [-ignore-build-id] <a> <b> [<binary>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	binaryPath := ""
+	if len(args) == 3 {
+		binaryPath = args[2]
+	}
+	symbolTable, err := openState(args[1], binaryPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot: %v\n", core.path)
+	showTriage(buildInfoBinary, symbolTable)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxDiffStacks is how many of the stacks whose goroutine counts changed
+// snapshot diff shows.
+const maxDiffStacks = 10
+
+// capturedState is what snapshot diff compares of a snapshot or core.
+type capturedState struct {
+	goroutines int
+	stacks     map[string]int // Goroutines by the stack they have.
+	globals    map[string]string
+	order      []string // The globals, sorted.
+	memstats   []statistic
+}
+
+// openState opens a snapshot or a core, which needs the binary named, and
+// makes it the process commands read.
+func openState(path, binaryPath string) (*gosym.Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	f.Read(magic)
+	f.Close()
+
+	var c *coreDump
+	if bytes.Equal(magic, []byte("\x7fELF")) {
+		if binaryPath == "" {
+			return nil, fmt.Errorf("%v is a core file; name the binary it is of", path)
+		}
+	} else {
+		var recorded string
+		if c, recorded, err = loadSnapshot(path); err != nil {
+			return nil, err
+		}
+		if binaryPath == "" {
+			binaryPath = recorded
+		}
+	}
+	exe, symbolTable, err := reloadBinary(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		if c, err = openCore(path, exe); err != nil {
+			return nil, err
+		}
+	}
+	c.exe = exe
+	useCore(c, symbolTable)
+	return symbolTable, nil
+}
+
+// captureState reads what is compared of the core or snapshot open: its
+// goroutines, grouped by stack, the program's own globals and the memory
+// statistics.
+func captureState(symbolTable *gosym.Table) (*capturedState, error) {
+	pid := processID
+	list, err := readGoroutines(pid)
+	if err != nil {
+		return nil, err
+	}
+	s := &capturedState{
+		goroutines: len(list),
+		stacks:     make(map[string]int),
+		globals:    make(map[string]string),
+	}
+	for _, g := range list {
+		s.stacks[stackSignature(goroutineFrames(pid, g, symbolTable))]++
+	}
+	for name := range globals {
+		if !isProgramGlobal(name) {
+			continue
+		}
+		v, err := readGlobal(pid, name)
+		if err != nil {
+			continue
+		}
+		s.globals[name] = formatValue(pid, v)
+		s.order = append(s.order, name)
+	}
+	sort.Strings(s.order)
+	s.memstats, _ = memoryStatistics(pid)
+	return s, nil
+}
+
+// stackSignature names a stack by its innermost frames outside the
+// standard library, the way goroutines places them, and where they are.
+func stackSignature(frames []stackFrame) string {
+	if len(frames) == 0 {
+		return "(no stack)"
+	}
+	var names []string
+	for _, frame := range frames[userFrame(frames):] {
+		if len(names) == 3 {
+			break
+		}
+		names = append(names, fmt.Sprintf("%v (%v:%v)", frame.fn.Name, filepath.Base(frame.file), frame.line))
+	}
+	return strings.Join(names, " <- ")
+}
+
+// isProgramGlobal reports whether a global is the program's own, in main
+// or a package whose path starts with a domain, rather than the runtime's
+// or the standard library's.
+func isProgramGlobal(name string) bool {
+	if strings.HasPrefix(name, "main.") {
+		return true
+	}
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		return false
+	}
+	first := strings.SplitN(name[:dot], "/", 2)[0]
+	return strings.Contains(first, ".") && !strings.HasPrefix(name, "go:") && !strings.HasPrefix(name, "type:")
+}
+
+// runSnapshotDiff implements "snapshot diff <a> <b> [<binary>]", comparing
+// two snapshots or cores of the same binary: how many goroutines there are
+// and on which stacks, the values of the program's globals, and the
+// runtime's memory statistics.  Cores need the binary named; snapshots
+// record it.
+func runSnapshotDiff(args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Fprintf(os.Stderr, "Usage: %v snapshot diff [-ignore-build-id] <a> <b> [<binary>]\n", os.Args[0])
+		os.Exit(2)
+	}
+	binaryPath := ""
+	if len(args) == 3 {
+		binaryPath = args[2]
+	}
+	var states [2]*capturedState
+	for i, path := range args[:2] {
+		symbolTable, err := openState(path, binaryPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if states[i], err = captureState(symbolTable); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+	a, b := states[0], states[1]
+
+	fmt.Printf("Goroutines: %v -> %v (%+d)\n", a.goroutines, b.goroutines, b.goroutines-a.goroutines)
+
+	var changed []string
+	for stack := range a.stacks {
+		if a.stacks[stack] != b.stacks[stack] {
+			changed = append(changed, stack)
+		}
+	}
+	for stack := range b.stacks {
+		if _, ok := a.stacks[stack]; !ok {
+			changed = append(changed, stack)
+		}
+	}
+	change := func(stack string) int {
+		d := b.stacks[stack] - a.stacks[stack]
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		if change(changed[i]) != change(changed[j]) {
+			return change(changed[i]) > change(changed[j])
+		}
+		return changed[i] < changed[j]
+	})
+	if len(changed) > 0 {
+		fmt.Println("\nStacks whose goroutines changed:")
+		for i, stack := range changed {
+			if i == maxDiffStacks {
+				fmt.Printf("  ... and %v more\n", len(changed)-maxDiffStacks)
+				break
+			}
+			fmt.Printf("  %4v -> %-4v %v\n", a.stacks[stack], b.stacks[stack], stack)
+		}
+	}
+
+	var lines []string
+	for _, name := range b.order {
+		before, ok := a.globals[name]
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("  %v: only in %v: %v", name, args[1], b.globals[name]))
+		case before != b.globals[name]:
+			lines = append(lines, fmt.Sprintf("  %v: %v -> %v", name, before, b.globals[name]))
+		}
+	}
+	if len(lines) > 0 {
+		fmt.Println("\nGlobals that changed:")
+		fmt.Println(strings.Join(lines, "\n"))
+	}
+
+	lines = nil
+	before := make(map[string]string)
+	for _, s := range a.memstats {
+		before[s.name] = s.value
+	}
+	for _, s := range b.memstats {
+		if was, ok := before[s.name]; ok && was != s.value {
+			lines = append(lines, fmt.Sprintf("  %-18v %v -> %v", s.name, was, s.value))
+		}
+	}
+	if len(lines) > 0 {
+		fmt.Println("\nMemory statistics that changed:")
+		fmt.Println(strings.Join(lines, "\n"))
+	}
+}
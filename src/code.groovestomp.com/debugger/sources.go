@@ -0,0 +1,417 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/buildinfo"
+	"debug/gosym"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// fetchTimeout bounds each request to the source server.
+const fetchTimeout = 10 * time.Second
+
+var (
+	// sourceServer is the URL template sources missing locally are fetched
+	// from, or empty.  {module}, {version}, {path}, {revision} and {file}
+	// are replaced for each file.
+	sourceServer string
+
+	// binaryBuildInfo is the module information the Go toolchain recorded
+	// in the binary, or nil.
+	binaryBuildInfo *buildinfo.BuildInfo
+	buildInfoBinary string
+
+	// fetchedSources holds every source file fetched, or the error that
+	// stopped it, so that each is only asked for once.
+	fetchedSources = make(map[string]fetchedSource)
+
+	// builderGOROOT is the GOROOT the binary's standard library files are
+	// named under, which is empty when it was built with -trimpath and
+	// they are named relative to GOROOT/src.  localGOROOT is the one here
+	// they are read from instead.  Both are worked out the first time a
+	// standard library file is missing.
+	builderGOROOT, localGOROOT string
+	gorootResolved             bool
+)
+
+type fetchedSource struct {
+	data []byte
+	err  error
+}
+
+// loadBuildInfo reads the modules a binary was built from.
+func loadBuildInfo(binary string) {
+	binaryBuildInfo, _ = buildinfo.ReadFile(binary)
+	buildInfoBinary = binary
+	builderGOROOT, localGOROOT, gorootResolved = "", "", false
+}
+
+func parseSourceServer(value string) error {
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	if value == "off" {
+		value = ""
+	}
+	if value != "" && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return fmt.Errorf("expected an http or https URL template, got %q", value)
+	}
+	sourceServer = value
+	fetchedSources = make(map[string]fetchedSource)
+	return nil
+}
+
+func formatSourceServer() string {
+	if sourceServer == "" {
+		return "off"
+	}
+	return sourceServer
+}
+
+// readSource reads a source file the binary names.  One that isn't on this
+// machine is read from where the profile's substitute paths put it, from
+// the standard library of the local Go installation if it belongs to it,
+// or else fetched from the source server.
+func readSource(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		return data, nil
+	}
+	if local, ok := substitutePath(file); ok {
+		if data, err := ioutil.ReadFile(local); err == nil {
+			return data, nil
+		}
+	}
+	if local := localStdlibPath(file); local != "" {
+		if data, err := ioutil.ReadFile(local); err == nil {
+			return data, nil
+		}
+	}
+	if sourceServer == "" {
+		return data, err
+	}
+	if fetched, ok := fetchedSources[file]; ok {
+		return fetched.data, fetched.err
+	}
+	data, err = fetchSource(file)
+	if err != nil {
+		fmt.Printf("Cannot fetch %v: %v\n", file, err)
+	}
+	fetchedSources[file] = fetchedSource{data, err}
+	return data, err
+}
+
+// fetchSource downloads a file from the source server.  A template ending
+// in .zip names a module zip, as a module proxy serves at
+// https://proxy.golang.org/{module}/@v/{version}.zip, which the file is
+// taken out of; any other names the file itself.  Downloads are cached in
+// the user's cache directory.
+func fetchSource(file string) ([]byte, error) {
+	module, version, path, ok := sourceModule(file)
+	if !ok && strings.Contains(sourceServer, "{module}") {
+		return nil, fmt.Errorf("no module is known for it")
+	}
+	revision := ""
+	if binaryBuildInfo != nil {
+		revision = buildSetting("vcs.revision")
+	}
+	url := strings.NewReplacer(
+		"{module}", escapeModulePath(module),
+		"{version}", version,
+		"{path}", path,
+		"{revision}", revision,
+		"{file}", strings.TrimPrefix(filepath.ToSlash(file), "/"),
+	).Replace(sourceServer)
+
+	data, err := download(url)
+	if err != nil || !strings.HasSuffix(url, ".zip") {
+		return data, err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	name := module + "@" + version + "/" + path
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, fmt.Errorf("%v has no %v", url, name)
+}
+
+// download fetches a URL once, keeping what it got in the cache.
+func download(url string) ([]byte, error) {
+	cache := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cache = filepath.Join(dir, "godebugger", "sources", strings.NewReplacer("://", "/", "?", "_").Replace(url))
+		if data, err := ioutil.ReadFile(cache); err == nil {
+			return data, nil
+		}
+	}
+	fmt.Printf("Fetching %v...\n", url)
+	client := http.Client{Timeout: fetchTimeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", url, response.Status)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if cache != "" && os.MkdirAll(filepath.Dir(cache), 0755) == nil {
+		ioutil.WriteFile(cache, data, 0644)
+	}
+	return data, nil
+}
+
+// sourceModule works out the module a source file comes from, its version
+// and the file's path within it.  A dependency's files are in the module
+// cache, or named module@version/path with -trimpath.  The main module's
+// are found by its path, or the last element of it, among the directories
+// of the file.
+func sourceModule(file string) (module, version, path string, ok bool) {
+	file = filepath.ToSlash(file)
+	if at := strings.Index(file, "@"); at >= 0 {
+		start := strings.LastIndex(file[:at], "/pkg/mod/")
+		if start >= 0 {
+			start += len("/pkg/mod/")
+		} else {
+			start = 0
+		}
+		slash := strings.Index(file[at:], "/")
+		if slash > 0 {
+			module = unescapeModulePath(file[start:at])
+			return module, file[at+1 : at+slash], file[at+slash+1:], true
+		}
+	}
+	if binaryBuildInfo == nil || binaryBuildInfo.Main.Path == "" {
+		return "", "", "", false
+	}
+	main := binaryBuildInfo.Main
+	for _, prefix := range []string{main.Path, filepath.Base(main.Path)} {
+		if strings.HasPrefix(file, prefix+"/") {
+			return main.Path, main.Version, file[len(prefix)+1:], true
+		}
+		if i := strings.LastIndex(file, "/"+prefix+"/"); i >= 0 {
+			return main.Path, main.Version, file[i+len(prefix)+2:], true
+		}
+	}
+	return "", "", "", false
+}
+
+// escapeModulePath writes a module path as the module cache and proxies
+// do, each capital letter as ! and the letter in lower case.
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func unescapeModulePath(path string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range path {
+		switch {
+		case r == '!':
+			upper = true
+			continue
+		case upper:
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// localStdlibPath returns where the standard library file the binary names
+// is in the local GOROOT, or "" if it is not one.
+func localStdlibPath(file string) string {
+	if !gorootResolved {
+		gorootResolved = true
+		resolveGOROOT()
+	}
+	if localGOROOT == "" {
+		return ""
+	}
+	file = filepath.ToSlash(file)
+	var rel string
+	switch {
+	case builderGOROOT != "" && strings.HasPrefix(file, builderGOROOT+"/src/"):
+		rel = file[len(builderGOROOT)+len("/src/"):]
+	case builderGOROOT == "" && !strings.HasPrefix(file, "/"):
+		// Only the standard library's import paths have no dot in their
+		// first element.
+		if first := strings.SplitN(file, "/", 2)[0]; strings.Contains(first, ".") || strings.Contains(first, "@") {
+			return ""
+		}
+		rel = file
+	default:
+		return ""
+	}
+	return filepath.Join(localGOROOT, "src", filepath.FromSlash(rel))
+}
+
+// resolveGOROOT finds where the binary's toolchain had the standard
+// library, from where runtime.main was, and the local Go installation of
+// the same version, from the binary's build info: GOROOT, a toolchain
+// golang.org/dl or the go command downloaded, or a usual place to install
+// Go.  Failing that, any local GOROOT will do, with a warning.
+func resolveGOROOT() {
+	if listingSymbols == nil {
+		return
+	}
+	fn := listingSymbols.LookupFunc("runtime.main")
+	if fn == nil {
+		return
+	}
+	file, _, _ := listingSymbols.PCToLine(fn.Entry)
+	file = filepath.ToSlash(file)
+	switch {
+	case strings.HasSuffix(file, "/src/runtime/proc.go"):
+		builderGOROOT = strings.TrimSuffix(file, "/src/runtime/proc.go")
+	case file == "runtime/proc.go":
+		builderGOROOT = ""
+	default:
+		return
+	}
+
+	version := ""
+	if binaryBuildInfo != nil {
+		version = binaryBuildInfo.GoVersion
+	}
+	home, _ := os.UserHomeDir()
+	modcache := os.Getenv("GOMODCACHE")
+	if modcache == "" {
+		gopath := os.Getenv("GOPATH")
+		if gopath == "" {
+			gopath = filepath.Join(home, "go")
+		}
+		modcache = filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+	}
+	candidates := []string{os.Getenv("GOROOT")}
+	if version != "" {
+		candidates = append(candidates,
+			filepath.Join(home, "sdk", version),
+			filepath.Join(modcache, "golang.org", fmt.Sprintf("toolchain@v0.0.1-%v.%v-%v", version, runtime.GOOS, runtime.GOARCH)))
+	}
+	candidates = append(candidates, runtime.GOROOT(), "/usr/local/go", "/usr/lib/go")
+	if builderGOROOT != "" {
+		candidates = append(candidates, builderGOROOT)
+	}
+
+	fallback, fallbackVersion := "", ""
+	for _, root := range candidates {
+		if root == "" {
+			continue
+		}
+		found := gorootVersion(root)
+		if found == "" {
+			continue
+		}
+		if found == version || version == "" {
+			localGOROOT = root
+			return
+		}
+		if fallback == "" {
+			fallback, fallbackVersion = root, found
+		}
+	}
+	if fallback != "" {
+		fmt.Printf("Warning: no %v installation found; standard library sources come from %v (%v) and may not match.\n", version, fallback, fallbackVersion)
+		localGOROOT = fallback
+	}
+}
+
+// gorootVersion returns the Go version installed at root, from the first
+// line of its VERSION file, or "" if there is none.
+func gorootVersion(root string) string {
+	data, err := ioutil.ReadFile(filepath.Join(root, "VERSION"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+}
+
+// showSources implements "info sources [<regexp>]": it lists the source
+// files of the line table, or those whose path matches, under the package
+// whose functions they hold, for the exact paths break and list take.
+func showSources(pattern string, symbolTable *gosym.Table) error {
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	packageOf := make(map[string]string)
+	for i := range symbolTable.Funcs {
+		fn := &symbolTable.Funcs[i]
+		if file, _, _ := symbolTable.PCToLine(fn.Entry); file != "" && packageOf[file] == "" {
+			packageOf[file] = fn.PackageName()
+		}
+	}
+	files := make(map[string][]string)
+	count := 0
+	for file := range symbolTable.Files {
+		if re != nil && !re.MatchString(file) {
+			continue
+		}
+		pkg := packageOf[file]
+		if pkg == "" {
+			// Only assembly or code inlined into other packages.
+			pkg = "(no functions of its own)"
+		}
+		files[pkg] = append(files[pkg], file)
+		count++
+	}
+	if count == 0 {
+		if re != nil {
+			return fmt.Errorf("no source file matches %v", pattern)
+		}
+		return fmt.Errorf("the line table has no source files")
+	}
+	packages := make([]string, 0, len(files))
+	for pkg := range files {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	for _, pkg := range packages {
+		sort.Strings(files[pkg])
+		fmt.Printf("%v:\n", pkg)
+		for _, file := range files[pkg] {
+			fmt.Printf("  %v\n", file)
+		}
+	}
+	fmt.Printf("%v files in %v packages.\n", count, len(packages))
+	return nil
+}
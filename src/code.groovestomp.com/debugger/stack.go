@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DWARF register numbers for amd64 that the unwinder cares about.
+const (
+	dwarfRegSP = 7
+	dwarfRegPC = 16
+)
+
+const maxStackDepth = 256
+
+// stackFrame is a single activation record found while unwinding the tracee's
+// stack.  For every frame but the innermost, pc is a return address.
+type stackFrame struct {
+	pc   uint64
+	sp   uint64
+	cfa  uint64
+	fn   *gosym.Func
+	file string
+	line int
+}
+
+// frameDescription is an FDE from .debug_frame, together with the initial
+// instructions of the CIE it belongs to.
+type frameDescription struct {
+	start        uint64
+	end          uint64
+	cie          *commonInformation
+	instructions []byte
+}
+
+type commonInformation struct {
+	codeAlign    uint64
+	dataAlign    int64
+	instructions []byte
+}
+
+// frameTable holds every FDE sorted by starting address.
+var frameTable []*frameDescription
+
+func loadFrameTable(exe *elf.File) {
+	frameTable = nil
+	section := exe.Section(".debug_frame")
+	if section == nil {
+		return
+	}
+	data, err := section.Data()
+	if err != nil {
+		return
+	}
+
+	cies := make(map[uint64]*commonInformation)
+	order := binary.LittleEndian
+
+	for offset := uint64(0); offset+4 <= uint64(len(data)); {
+		length := uint64(order.Uint32(data[offset:]))
+		start := offset + 4
+		if length == 0 || start+length > uint64(len(data)) {
+			break
+		}
+		entry := data[start : start+length]
+		offset = start + length
+
+		id := order.Uint32(entry)
+		if id == 0xffffffff {
+			cies[start-4] = parseCIE(entry[4:])
+			continue
+		}
+
+		cie := cies[uint64(id)]
+		if cie == nil || len(entry) < 20 {
+			continue
+		}
+		begin := order.Uint64(entry[4:])
+		size := order.Uint64(entry[12:])
+		frameTable = append(frameTable, &frameDescription{
+			start:        begin,
+			end:          begin + size,
+			cie:          cie,
+			instructions: entry[20:],
+		})
+	}
+
+	sort.Slice(frameTable, func(i, j int) bool {
+		return frameTable[i].start < frameTable[j].start
+	})
+}
+
+func parseCIE(data []byte) *commonInformation {
+	buf := bytes.NewBuffer(data)
+	version, _ := buf.ReadByte()
+	augmentation, _ := buf.ReadString(0)
+	if augmentation != "\x00" {
+		return nil
+	}
+	if version >= 4 {
+		buf.Next(2) // Address and segment selector sizes.
+	}
+	codeAlign := readULEB(buf)
+	dataAlign := readSLEB(buf)
+	if version == 1 {
+		buf.ReadByte()
+	} else {
+		readULEB(buf)
+	}
+	return &commonInformation{
+		codeAlign:    codeAlign,
+		dataAlign:    dataAlign,
+		instructions: buf.Bytes(),
+	}
+}
+
+func findFrameDescription(pc uint64) *frameDescription {
+	i := sort.Search(len(frameTable), func(i int) bool {
+		return frameTable[i].end > pc
+	})
+	if i < len(frameTable) && frameTable[i].start <= pc {
+		return frameTable[i]
+	}
+	return nil
+}
+
+// cfaOffset runs the call frame instructions for the function containing pc
+// and returns the offset of the canonical frame address from the stack
+// pointer.  The Go toolchain always expresses the CFA relative to RSP.
+func cfaOffset(pc uint64) (int64, bool) {
+	fde := findFrameDescription(pc)
+	if fde == nil || fde.cie == nil {
+		return 0, false
+	}
+
+	register := uint64(dwarfRegSP)
+	offset := int64(0)
+	location := fde.start
+
+	run := func(instructions []byte) bool {
+		buf := bytes.NewBuffer(instructions)
+		for buf.Len() > 0 {
+			op, _ := buf.ReadByte()
+			switch op & 0xc0 {
+			case 0x40: // DW_CFA_advance_loc
+				location += uint64(op&0x3f) * fde.cie.codeAlign
+				if location > pc {
+					return false
+				}
+				continue
+			case 0x80: // DW_CFA_offset
+				readULEB(buf)
+				continue
+			case 0xc0: // DW_CFA_restore
+				continue
+			}
+
+			switch op {
+			case 0x00: // DW_CFA_nop
+			case 0x01: // DW_CFA_set_loc
+				location = binary.LittleEndian.Uint64(buf.Next(8))
+			case 0x02, 0x03, 0x04: // DW_CFA_advance_loc1, 2, 4
+				size := 1 << (op - 2)
+				delta := uint64(0)
+				for i, b := range buf.Next(size) {
+					delta |= uint64(b) << (8 * uint(i))
+				}
+				location += delta * fde.cie.codeAlign
+			case 0x05: // DW_CFA_offset_extended
+				readULEB(buf)
+				readULEB(buf)
+			case 0x06, 0x07, 0x08: // DW_CFA_restore_extended, undefined, same_value
+				readULEB(buf)
+			case 0x09: // DW_CFA_register
+				readULEB(buf)
+				readULEB(buf)
+			case 0x0a, 0x0b: // DW_CFA_remember_state, restore_state
+			case 0x0c: // DW_CFA_def_cfa
+				register = readULEB(buf)
+				offset = int64(readULEB(buf))
+			case 0x0d: // DW_CFA_def_cfa_register
+				register = readULEB(buf)
+			case 0x0e: // DW_CFA_def_cfa_offset
+				offset = int64(readULEB(buf))
+			case 0x11: // DW_CFA_offset_extended_sf
+				readULEB(buf)
+				readSLEB(buf)
+			case 0x12: // DW_CFA_def_cfa_sf
+				register = readULEB(buf)
+				offset = readSLEB(buf) * fde.cie.dataAlign
+			case 0x13: // DW_CFA_def_cfa_offset_sf
+				offset = readSLEB(buf) * fde.cie.dataAlign
+			default:
+				return false
+			}
+			if location > pc {
+				return false
+			}
+		}
+		return true
+	}
+
+	if run(fde.cie.instructions) {
+		run(fde.instructions)
+	}
+
+	if register != dwarfRegSP {
+		return 0, false
+	}
+	return offset, true
+}
+
+// backtrace unwinds the stack of the stopped tracee starting at its current
+// registers.
+func backtrace(pid int, symbolTable *gosym.Table) []stackFrame {
+	var regs syscall.PtraceRegs
+	err := ptraceGetRegs(pid, &regs)
+	if err != nil {
+		return nil
+	}
+	return unwind(pid, symbolTable, &regs, maxStackDepth)
+}
+
+// unwind walks up to limit frames of the stack starting from the given
+// register state, which need not be the thread's own, e.g. the saved context
+// of a parked goroutine.
+func unwind(pid int, symbolTable *gosym.Table, regs *syscall.PtraceRegs, limit int) []stackFrame {
+	var frames []stackFrame
+	pc, sp := regs.PC(), regs.Rsp
+	innermost := true
+	for len(frames) < limit {
+		if !innermost {
+			if interrupted, ok := signalContext(pid, symbolTable, pc, sp); ok {
+				// Carry on from where the signal came in, as if that were
+				// the innermost frame.
+				regs, innermost = interrupted, true
+				pc, sp = regs.PC(), regs.Rsp
+			}
+		}
+		lookup := pc
+		if !innermost {
+			lookup = pc - 1 // Attribute return addresses to the call instruction.
+		}
+		file, line, fn := symbolTable.PCToLine(lookup)
+		if fn == nil {
+			break
+		}
+
+		offset, ok := cfaOffset(lookup)
+		if !ok {
+			offset = frameLayoutFallback(fn, pc, regs, innermost)
+		}
+		frame := stackFrame{
+			pc:   pc,
+			sp:   sp,
+			cfa:  sp + uint64(offset),
+			fn:   fn,
+			file: file,
+			line: line,
+		}
+		frames = append(frames, frame)
+
+		if fn.Name == "runtime.goexit" || fn.Name == "runtime.mstart" {
+			break
+		}
+
+		returnAddress, err := readUint64(pid, frame.cfa-8)
+		if err != nil || returnAddress == 0 {
+			break
+		}
+		pc, sp = returnAddress, frame.cfa
+		innermost = false
+	}
+
+	return frames
+}
+
+// signalContext recognizes the return into runtime.sigreturn__sigaction
+// that ends a signal handler's stack, and reads the registers of the code
+// the signal interrupted from the ucontext the kernel put below it.
+func signalContext(pid int, symbolTable *gosym.Table, pc, sp uint64) (*syscall.PtraceRegs, bool) {
+	fn := symbolTable.PCToFunc(pc)
+	if fn == nil || fn.Entry != pc || !strings.HasPrefix(fn.Name, "runtime.sigreturn") {
+		return nil, false
+	}
+	// The ucontext starts with uc_flags, uc_link and a 24-byte uc_stack;
+	// the general registers of uc_mcontext follow in the kernel's order.
+	data, err := readMemory(pid, sp+40, 17*8)
+	if err != nil {
+		return nil, false
+	}
+	greg := func(i int) uint64 { return binary.LittleEndian.Uint64(data[i*8:]) }
+	regs := &syscall.PtraceRegs{
+		R8: greg(0), R9: greg(1), R10: greg(2), R11: greg(3),
+		R12: greg(4), R13: greg(5), R14: greg(6), R15: greg(7),
+		Rdi: greg(8), Rsi: greg(9), Rbp: greg(10), Rbx: greg(11),
+		Rdx: greg(12), Rax: greg(13), Rcx: greg(14), Rsp: greg(15),
+		Rip: greg(16),
+	}
+	return regs, true
+}
+
+// frameLayoutFallback derives the CFA offset from the frame pointer when the
+// binary carries no .debug_frame.  It is only accurate for the innermost
+// frame once the function prologue has run.
+func frameLayoutFallback(fn *gosym.Func, pc uint64, regs *syscall.PtraceRegs, innermost bool) int64 {
+	// The runtime saves a pc in systemstack_switch as the context of a
+	// goroutine that moved to the system stack; the frame is systemstack's,
+	// which has none of its own.
+	if fn.Name == "runtime.systemstack_switch" {
+		return 8
+	}
+	if innermost && pc != fn.Entry && regs.Rbp > regs.Rsp {
+		return int64(regs.Rbp-regs.Rsp) + 16
+	}
+	return 8
+}
+
+// frameRecord is what a frameCounter remembers about a frame it has
+// counted: the saved frame pointer and return address, and the count from
+// that frame outwards.
+type frameRecord struct {
+	next, returnAddress uint64
+	count               int
+}
+
+// frameCounter counts the frames on the current goroutine's stack, or only
+// those whose return address satisfies counts, by following the frame
+// pointer chain rather than unwinding through the frame tables.
+//
+// Frame pointers are remembered along with what was counted at them, so
+// that a breakpoint hit again deeper in a recursion only walks the frames
+// that are new.  A frame's callers can't change while it is live, so a
+// frame whose saved frame pointer and return address match the record is
+// the one counted before.
+type frameCounter struct {
+	counts func(returnAddress uint64) bool
+	cache  map[uint64]frameRecord
+}
+
+func newFrameCounter(counts func(uint64) bool) *frameCounter {
+	return &frameCounter{counts: counts, cache: make(map[uint64]frameRecord)}
+}
+
+// depthCounter counts every frame, for the stack depth of conditions.
+var depthCounter = newFrameCounter(nil)
+
+func (c *frameCounter) count(returnAddress uint64) int {
+	if c.counts == nil || c.counts(returnAddress) {
+		return 1
+	}
+	return 0
+}
+
+// frames returns the count for the stopped thread's stack, not including
+// the innermost frame itself, whose return address the count is based on.
+func (c *frameCounter) frames(pid int, regs *syscall.PtraceRegs, symbolTable *gosym.Table) (int, error) {
+	g, err := readRuntimeStruct(pid, "runtime.g", currentGoroutine(pid))
+	if err != nil {
+		return 0, err
+	}
+	hi := scalarMember(g, "stack", "hi")
+	if len(c.cache) > maxStackDepth*1024 {
+		c.cache = make(map[uint64]frameRecord) // Mostly frames long gone.
+	}
+
+	// Frame pointers from the innermost out, to be recorded once the count
+	// beyond them is known.
+	var chain []uint64
+	var records []frameRecord
+	total := 0
+	for bp := regs.Rbp; bp >= regs.Rsp && bp+16 <= hi; {
+		data, err := readMemory(pid, bp, 16)
+		if err != nil {
+			break
+		}
+		next := binary.LittleEndian.Uint64(data)
+		returnAddress := binary.LittleEndian.Uint64(data[8:])
+		if record, ok := c.cache[bp]; ok && record.next == next && record.returnAddress == returnAddress {
+			total = record.count
+			break
+		}
+		chain = append(chain, bp)
+		records = append(records, frameRecord{next: next, returnAddress: returnAddress})
+		if next <= bp {
+			break
+		}
+		bp = next
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		total += c.count(records[i].returnAddress)
+		records[i].count = total
+		c.cache[chain[i]] = records[i]
+	}
+
+	// Until its prologue has pushed the frame pointer, a function's frame
+	// is not on the chain yet; its return address is still at the top of
+	// the stack.
+	if offset, ok := cfaOffset(regs.PC()); ok && regs.Rbp != regs.Rsp+uint64(offset)-16 {
+		if returnAddress, err := readUint64(pid, regs.Rsp+uint64(offset)-8); err == nil {
+			total += c.count(returnAddress)
+		}
+	}
+	return total, nil
+}
+
+// stackDepth counts the frames on the current goroutine's stack, cheaply
+// enough for the deep stacks of runaway recursion.
+func stackDepth(pid int, regs *syscall.PtraceRegs, symbolTable *gosym.Table) int {
+	n, err := depthCounter.frames(pid, regs, symbolTable)
+	if err != nil {
+		return len(backtrace(pid, symbolTable))
+	}
+	return n + 1
+}
+
+// frameSelection is the goroutine and frame chosen with goroutine, frame,
+// up or down.  It only holds for the stop it was made at: once the thread
+// moves, the innermost frame of the thread's own goroutine is selected
+// again.
+type frameSelection struct {
+	tid       int
+	pc, sp    uint64
+	index     int
+	goroutine uint64              // The runtime.g, or zero for the thread's own.
+	id        uint64              // The goroutine's id.
+	regs      *syscall.PtraceRegs // Where the goroutine's stack is unwound from.
+	live      bool                // Whether regs are a stopped thread's own.
+}
+
+var selection frameSelection
+
+// selectionHolds reports whether the selection was made at this stop of the
+// thread.
+func selectionHolds(pid int, regs *syscall.PtraceRegs) bool {
+	return selection.tid == pid && selection.pc == regs.PC() && selection.sp == regs.Rsp
+}
+
+// selectedStack returns the registers to unwind the selected goroutine from
+// and the index of its selected frame, starting afresh if the thread has
+// moved since the last selection.  live is false when the goroutine is
+// descheduled, so only its saved pc, sp and bp are known.
+func selectedStack(pid int) (regs *syscall.PtraceRegs, index int, live bool, err error) {
+	regs = new(syscall.PtraceRegs)
+	if err := ptraceGetRegs(pid, regs); err != nil {
+		return nil, 0, false, err
+	}
+	if !selectionHolds(pid, regs) {
+		selection = frameSelection{tid: pid, pc: regs.PC(), sp: regs.Rsp}
+		return regs, 0, true, nil
+	}
+	if selection.regs != nil {
+		return selection.regs, selection.index, selection.live, nil
+	}
+	return regs, selection.index, true, nil
+}
+
+// selectFrameOutside selects the innermost frame of the stopped thread whose
+// function is in none of the packages given by prefix, such as "log.", for
+// a stop in a library on behalf of the code that called it.
+func selectFrameOutside(pid int, symbolTable *gosym.Table, prefixes ...string) (stackFrame, bool) {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return stackFrame{}, false
+	}
+frames:
+	for i, frame := range unwind(pid, symbolTable, &regs, maxStackDepth) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(frame.fn.Name, prefix) {
+				continue frames
+			}
+		}
+		selection = frameSelection{tid: pid, pc: regs.PC(), sp: regs.Rsp, index: i}
+		pcSourceFile, pcSourceLine = frame.file, frame.line
+		return frame, true
+	}
+	return stackFrame{}, false
+}
+
+// selectGoroutine makes g the goroutine that bt, frame, list and print work
+// in, at its innermost frame.
+func selectGoroutine(pid int, g *goroutine) error {
+	var regs syscall.PtraceRegs
+	if err := ptraceGetRegs(pid, &regs); err != nil {
+		return err
+	}
+	selection = frameSelection{tid: pid, pc: regs.PC(), sp: regs.Rsp}
+	if g.address == currentGoroutine(pid) {
+		return nil
+	}
+	selection.goroutine, selection.id = g.address, g.id
+	if tid := goroutineThread(g); tid != 0 {
+		selection.regs = new(syscall.PtraceRegs)
+		if err := ptraceGetRegs(tid, selection.regs); err != nil {
+			return err
+		}
+		selection.live = true
+	} else {
+		selection.regs = &syscall.PtraceRegs{Rip: g.pc, Rsp: g.sp, Rbp: g.bp}
+	}
+	return nil
+}
+
+// runFrameCommand implements "frame [<n>]", "up [<n>]" and "down [<n>]",
+// selecting the frame that print, info args, info locals and list work in.
+func runFrameCommand(pid int, command string, symbolTable *gosym.Table) error {
+	regs, index, _, err := selectedStack(pid)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(command)
+	if len(fields) > 2 {
+		return fmt.Errorf("usage: %v [<n>]", fields[0])
+	}
+	n, hasCount := 1, len(fields) == 2
+	if hasCount {
+		var err error
+		if n, err = strconv.Atoi(fields[1]); err != nil || n < 0 {
+			return fmt.Errorf("invalid frame number %q", fields[1])
+		}
+	}
+	switch fields[0] {
+	case "up":
+		index += n
+	case "down":
+		index -= n
+	default:
+		if hasCount {
+			index = n
+		}
+	}
+
+	frames := unwind(pid, symbolTable, regs, maxStackDepth)
+	if len(frames) == 0 {
+		return errors.New("no stack")
+	}
+	switch {
+	case index < 0:
+		return errors.New("already at the innermost frame")
+	case index >= len(frames):
+		return errors.New("already at the outermost frame")
+	}
+	selection.index = index
+	frame := frames[index]
+	pcSourceFile, pcSourceLine = frame.file, frame.line
+	fmt.Printf("#%-2d 0x%016x in %v at %v:%v\n", index, frame.pc, frame.fn.Name, frame.file, frame.line)
+	showListing(frame.file, frame.line)
+	return nil
+}
+
+// selectedLocation is the source line of the selected frame.
+func selectedLocation(pid int, symbolTable *gosym.Table) (string, int) {
+	if regs, index, _, err := selectedStack(pid); err == nil {
+		if frames := unwind(pid, symbolTable, regs, index+1); len(frames) > index {
+			return frames[index].file, frames[index].line
+		}
+	}
+	filename, lineno, _ := symbolTable.PCToLine(getPC(pid))
+	return filename, lineno
+}
+
+// runBacktraceCommand implements "bt [<n>]", showing the innermost <n>
+// frames or all of them, and marking the selected frame.
+func runBacktraceCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	limit := maxStackDepth
+	if argument != "" {
+		n, err := strconv.Atoi(argument)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid frame count %q", argument)
+		}
+		limit = n
+	}
+	regs, index, _, err := selectedStack(pid)
+	if err != nil {
+		return err
+	}
+	frames := unwind(pid, symbolTable, regs, limit+1)
+	if len(frames) == 0 {
+		return errors.New("no stack")
+	}
+	if selection.goroutine != 0 {
+		fmt.Printf("Goroutine %v:\n", selection.id)
+	}
+	for i, frame := range frames {
+		if i == limit {
+			fmt.Println("(more frames follow)")
+			break
+		}
+		marker := " "
+		if i == index {
+			marker = "*"
+		}
+		fmt.Printf("%v#%-2d 0x%016x in %v at %v:%v\n", marker, i, frame.pc, frame.fn.Name, frame.file, frame.line)
+	}
+	return nil
+}
+
+func showBacktrace(frames []stackFrame) {
+	for i, frame := range frames {
+		fmt.Printf("#%-2d 0x%016x in %v at %v:%v\n", i, frame.pc, frame.fn.Name, frame.file, frame.line)
+	}
+}
+
+func readUint64(pid int, address uint64) (uint64, error) {
+	data, err := readMemory(pid, address, 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+func readMemory(pid int, address uint64, size int) ([]byte, error) {
+	data, err := readMemoryPartial(pid, address, size)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readChunk is how much of a large read is made between checks for the
+// command being cancelled.
+const readChunk = 64 << 10
+
+// readMemoryPartial reads like readMemory, except that a read the command
+// is cancelled in the middle of returns what it had read with the error.
+func readMemoryPartial(pid int, address uint64, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if size == 0 {
+		return data, nil
+	}
+	if err := chargeRead(size); err != nil {
+		return nil, err
+	}
+	for done := 0; done < size; {
+		if err := commandCancelled(); err != nil {
+			return data[:done], err
+		}
+		end := done + readChunk
+		if end > size {
+			end = size
+		}
+		n, err := ptracePeekData(pid, uintptr(address)+uintptr(done), data[done:end])
+		if err != nil {
+			return nil, fmt.Errorf("cannot access memory at 0x%x: %v", address+uint64(done), err)
+		}
+		if n != end-done {
+			return nil, fmt.Errorf("cannot access memory at 0x%x", address+uint64(done+n))
+		}
+		done = end
+	}
+	return data, nil
+}
+
+func readULEB(buf *bytes.Buffer) uint64 {
+	var result uint64
+	var shift uint
+	for {
+		b, err := buf.ReadByte()
+		if err != nil {
+			return result
+		}
+		result |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return result
+		}
+	}
+}
+
+func readSLEB(buf *bytes.Buffer) int64 {
+	var result int64
+	var shift uint
+	for {
+		b, err := buf.ReadByte()
+		if err != nil {
+			return result
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			return result
+		}
+	}
+}
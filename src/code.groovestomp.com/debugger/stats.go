@@ -0,0 +1,149 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxStatsElements is how many elements of a slice stats reads.
+	maxStatsElements = 1 << 22
+
+	// histogramBuckets is the width of the sparkline stats draws.
+	histogramBuckets = 32
+)
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// runStatsCommand implements "stats <expression>" for a slice or array of
+// integers or floats: how many elements it has, the smallest and largest,
+// the mean and standard deviation, a few percentiles, and a sparkline of
+// how the values are spread between the smallest and largest.
+func runStatsCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "" {
+		return fmt.Errorf("usage: stats <slice or array>")
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err != nil {
+		return err
+	}
+	v, err := evaluate(ctx, argument)
+	if err != nil {
+		return err
+	}
+	elements, err := numericElements(pid, v)
+	if err != nil {
+		return err
+	}
+
+	var values []float64
+	nans := 0
+	for _, e := range elements {
+		f := toFloat(e)
+		if math.IsNaN(f) {
+			nans++
+			continue
+		}
+		values = append(values, f)
+	}
+	fmt.Printf("%v: %v elements", typeName(v.typ), len(elements))
+	if nans > 0 {
+		fmt.Printf(", %v NaN", nans)
+	}
+	fmt.Println()
+	if len(values) == 0 {
+		return nil
+	}
+
+	sort.Float64s(values)
+	sum := 0.0
+	for _, f := range values {
+		sum += f
+	}
+	mean := sum / float64(len(values))
+	variance := 0.0
+	for _, f := range values {
+		variance += (f - mean) * (f - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(values)))
+	percentile := func(p float64) float64 {
+		return values[int(math.Ceil(p/100*float64(len(values))))-1]
+	}
+	fmt.Printf("  min %v  max %v\n", formatStat(values[0]), formatStat(values[len(values)-1]))
+	fmt.Printf("  mean %v  stddev %v\n", formatStat(mean), formatStat(stddev))
+	fmt.Printf("  p50 %v  p90 %v  p99 %v\n", formatStat(percentile(50)), formatStat(percentile(90)), formatStat(percentile(99)))
+	fmt.Printf("  %v\n", sparkline(values))
+	return nil
+}
+
+// numericElements reads the elements of a slice or array of numbers.
+func numericElements(pid int, v *value) ([]*value, error) {
+	var elements []*value
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.ArrayType:
+		size := t.Type.Size()
+		if size <= 0 {
+			return nil, nil
+		}
+		for start := int64(0); start+size <= int64(len(v.data)); start += size {
+			elements = append(elements, &value{typ: t.Type, addr: v.addr + uint64(start), data: v.data[start : start+size]})
+		}
+	default:
+		var err error
+		if elements, err = sliceElements(pid, v, maxStatsElements); err != nil {
+			return nil, fmt.Errorf("%v is not a slice or array", typeName(v.typ))
+		}
+	}
+	if len(elements) > 0 {
+		switch resolveTypedef(elements[0].typ).(type) {
+		case *dwarf.IntType, *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType, *dwarf.FloatType:
+		default:
+			return nil, fmt.Errorf("%v holds %v, not numbers", typeName(v.typ), typeName(elements[0].typ))
+		}
+	}
+	return elements, nil
+}
+
+func formatStat(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return fmt.Sprintf("%.0f", f)
+	}
+	return fmt.Sprintf("%.6g", f)
+}
+
+// sparkline draws a histogram of sorted values, one bar for each equal
+// part of the range from the smallest to the largest, between the two.
+func sparkline(values []float64) string {
+	low, high := values[0], values[len(values)-1]
+	if low == high || math.IsInf(low, 0) || math.IsInf(high, 0) {
+		return fmt.Sprintf("%v %v %v", formatStat(low), string(sparkBars[len(sparkBars)-1]), formatStat(high))
+	}
+	counts := make([]int, histogramBuckets)
+	for _, f := range values {
+		i := int((f - low) / (high - low) * histogramBuckets)
+		if i == histogramBuckets {
+			i--
+		}
+		counts[i]++
+	}
+	largest := 0
+	for _, n := range counts {
+		if n > largest {
+			largest = n
+		}
+	}
+	var bars strings.Builder
+	for _, n := range counts {
+		switch {
+		case n == 0:
+			bars.WriteRune(' ')
+		default:
+			bars.WriteRune(sparkBars[(n*len(sparkBars)-1)/largest])
+		}
+	}
+	return fmt.Sprintf("%v %v %v", formatStat(low), bars.String(), formatStat(high))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxBigIntWords is how much of a big.Int is read, 64K bits.
+const maxBigIntWords = 1024
+
+// formatStdlibValue renders the standard library types whose fields say
+// little about what they hold as that: the contents of a bytes.Buffer or
+// strings.Builder, a net.IP in text form, a url.URL as the URL and a
+// big.Int in decimal.  It returns false for values of any other type, and
+// ones it can't read, which are then printed field by field.
+func formatStdlibValue(pid int, v *value) (string, bool) {
+	st, _ := resolveTypedef(v.typ).(*dwarf.StructType)
+	switch typeName(v.typ) {
+	case "net.IP":
+		data, _, err := readByteSlice(pid, v, 0)
+		if err != nil || len(data) != net.IPv4len && len(data) != net.IPv6len {
+			return "", false
+		}
+		return net.IP(data).String(), true
+	case "bytes.Buffer":
+		if st == nil || structField(st, "off") == nil {
+			return "", false
+		}
+		return formatBufferContents(pid, v, st, signExtend(fieldValue(v, structField(st, "off")).data))
+	case "strings.Builder":
+		if st == nil {
+			return "", false
+		}
+		return formatBufferContents(pid, v, st, 0)
+	case "net/url.URL":
+		if st == nil {
+			return "", false
+		}
+		return formatURL(pid, v, st)
+	case "math/big.Int":
+		if st == nil {
+			return "", false
+		}
+		return formatBigInt(pid, v, st)
+	}
+	return "", false
+}
+
+// formatStdlibPointer renders a pointer to one of the types
+// formatStdlibValue knows with what it points to.
+func formatStdlibPointer(pid int, v *value) (string, bool) {
+	pt, ok := resolveTypedef(v.typ).(*dwarf.PtrType)
+	if !ok {
+		return "", false
+	}
+	if name := typeName(pt.Type); !isStdlibType(name) || name == "net.IP" {
+		return "", false
+	}
+	target, err := dereference(pid, v)
+	if err != nil {
+		return "", false
+	}
+	text, ok := formatStdlibValue(pid, target)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("(%v)(0x%x) %v", typeName(v.typ), zeroExtend(v.data), text), true
+}
+
+// formatBufferContents quotes the bytes of the buf field of a buffer from
+// offset to its length.
+func formatBufferContents(pid int, v *value, st *dwarf.StructType, offset int64) (string, bool) {
+	field := structField(st, "buf")
+	if field == nil || offset < 0 {
+		return "", false
+	}
+	data, length, err := readByteSlice(pid, fieldValue(v, field), offset)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err), true
+	}
+	text := strconv.Quote(string(data))
+	if length > int64(len(data)) {
+		text += fmt.Sprintf("...+%v more", length-int64(len(data)))
+	}
+	return text, true
+}
+
+// readByteSlice reads up to maxStringLength bytes of a byte slice from
+// offset, whatever its type is named, and returns how many there are from
+// there.
+func readByteSlice(pid int, v *value, offset int64) ([]byte, int64, error) {
+	st, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+	if !ok || structField(st, "array") == nil || structField(st, "len") == nil {
+		return nil, 0, fmt.Errorf("%v is not a slice", typeName(v.typ))
+	}
+	address := zeroExtend(fieldValue(v, structField(st, "array")).data)
+	length := signExtend(fieldValue(v, structField(st, "len")).data) - offset
+	if length <= 0 {
+		return nil, 0, nil
+	}
+	shown := length
+	if shown > maxStringLength {
+		shown = maxStringLength
+	}
+	data, err := readMemory(pid, address+uint64(offset), int(shown))
+	return data, length, err
+}
+
+// formatURL puts a url.URL back together from its fields.
+func formatURL(pid int, v *value, st *dwarf.StructType) (string, bool) {
+	text := func(name string) string {
+		if field := structField(st, name); field != nil {
+			if contents, _, err := byteContents(pid, fieldValue(v, field)); err == nil {
+				return string(contents)
+			}
+		}
+		return ""
+	}
+	flag := func(name string) bool {
+		field := structField(st, name)
+		return field != nil && zeroExtend(fieldValue(v, field).data) != 0
+	}
+	u := &url.URL{
+		Scheme:      text("Scheme"),
+		Opaque:      text("Opaque"),
+		Host:        text("Host"),
+		Path:        text("Path"),
+		RawPath:     text("RawPath"),
+		OmitHost:    flag("OmitHost"),
+		ForceQuery:  flag("ForceQuery"),
+		RawQuery:    text("RawQuery"),
+		Fragment:    text("Fragment"),
+		RawFragment: text("RawFragment"),
+	}
+	if field := structField(st, "User"); field != nil {
+		if user, err := dereference(pid, fieldValue(v, field)); err == nil {
+			if ut, ok := resolveTypedef(user.typ).(*dwarf.StructType); ok {
+				name, password := structField(ut, "username"), structField(ut, "password")
+				set := structField(ut, "passwordSet")
+				if name != nil && password != nil && set != nil {
+					username, _, _ := byteContents(pid, fieldValue(user, name))
+					u.User = url.User(string(username))
+					if zeroExtend(fieldValue(user, set).data) != 0 {
+						secret, _, _ := byteContents(pid, fieldValue(user, password))
+						u.User = url.UserPassword(string(username), string(secret))
+					}
+				}
+			}
+		}
+	}
+	return strconv.Quote(u.String()), true
+}
+
+// formatBigInt reads a big.Int's sign and words into one of the debugger's
+// own to print.
+func formatBigInt(pid int, v *value, st *dwarf.StructType) (string, bool) {
+	negField, absField := structField(st, "neg"), structField(st, "abs")
+	if negField == nil || absField == nil {
+		return "", false
+	}
+	abs := fieldValue(v, absField)
+	at, ok := resolveTypedef(abs.typ).(*dwarf.StructType)
+	if !ok || structField(at, "array") == nil || structField(at, "len") == nil {
+		return "", false
+	}
+	address := zeroExtend(fieldValue(abs, structField(at, "array")).data)
+	length := signExtend(fieldValue(abs, structField(at, "len")).data)
+	if length < 0 || length > maxBigIntWords {
+		return fmt.Sprintf("<%v words>", length), true
+	}
+	data, err := readMemory(pid, address, int(length)*8)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err), true
+	}
+	words := make([]big.Word, length)
+	for i := range words {
+		words[i] = big.Word(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	n := new(big.Int).SetBits(words)
+	if zeroExtend(fieldValue(v, negField).data) != 0 {
+		n.Neg(n)
+	}
+	return n.String(), true
+}
+
+// isStdlibType reports whether formatStdlibValue renders a type name.
+func isStdlibType(name string) bool {
+	return strings.Contains(" net.IP bytes.Buffer strings.Builder net/url.URL math/big.Int ", " "+name+" ")
+}
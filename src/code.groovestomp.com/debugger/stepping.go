@@ -0,0 +1,229 @@
+package main
+
+import (
+	"debug/gosym"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// maxDeferSteps bounds how many instructions next single-steps through the
+// runtime while looking for a deferred function to stop in.
+const maxDeferSteps = 100000
+
+var closureName = regexp.MustCompile(`\.func\d+(\.\d+)*$`)
+
+// lineStep is a next in progress: the line it started on, in what
+// function, and whether that function has begun running its deferred calls.
+type lineStep struct {
+	file      string
+	line      int
+	fn        *gosym.Func
+	exitLines map[int]bool
+	unwinding bool
+}
+
+// backgroundStep is the next whose call over-ran step-timeout, for
+// waitForBackground to finish once the call returns.
+var backgroundStep *lineStep
+
+// next runs the tracee to the next source line of the current function,
+// stepping over calls.  Once a deferred call has been made the function is
+// on its way out, so rather than bouncing between its exit lines next runs
+// on to the caller.  With step-defers on, next stops in deferred functions
+// instead.
+func next(pid int, symbolTable *gosym.Table) *syscall.WaitStatus {
+	startPC := getPC(pid)
+	startFile, startLine, startFn := symbolTable.PCToLine(startPC)
+	if startFn == nil {
+		return step(pid)
+	}
+	s := &lineStep{
+		file:      startFile,
+		line:      startLine,
+		fn:        startFn,
+		exitLines: functionExitLines(pid, startFn, symbolTable),
+	}
+	return s.run(pid, symbolTable)
+}
+
+// run steps the tracee until it leaves the line of s.  A call that doesn't
+// return within step-timeout leaves the program running in the background
+// and s in backgroundStep, and run returns nil.
+func (s *lineStep) run(pid int, symbolTable *gosym.Table) *syscall.WaitStatus {
+	interruptRequested = false
+	stepping = true
+	defer func() { stepping = false }()
+	if stepTimeout > 0 {
+		stepDeadline = time.Now().Add(stepTimeout)
+		defer func() { stepDeadline = time.Time{} }()
+	}
+
+	for {
+		pc := getPC(pid)
+		code, err := readText(pid, pc, 15)
+		if err != nil {
+			return step(pid)
+		}
+		inst, err := x86asm.Decode(code, 64)
+		isCall := err == nil && inst.Op == x86asm.CALL
+		_, callLine, caller := symbolTable.PCToLine(pc)
+
+		var regs syscall.PtraceRegs
+		if err := ptraceGetRegs(pid, &regs); err != nil {
+			return step(pid)
+		}
+		stackPointer := regs.Rsp
+
+		status := step(pid)
+		if !isTrapStop(status) || interruptRequested {
+			return status
+		}
+
+		if isCall {
+			target := getPC(pid)
+			_, _, fn := symbolTable.PCToLine(target)
+			deferred := fn != nil && (isDeferredCall(fn, callLine, s.exitLines) ||
+				isDeferPlumbing(caller) && !strings.HasPrefix(fn.Name, "runtime."))
+			if deferred && stepDefers {
+				return stepIntoDeferred(pid, stackPointer, status, symbolTable)
+			}
+			s.unwinding = s.unwinding || deferred
+
+			returnAddress := pc + uint64(inst.Len)
+			status = finishCall(pid, returnAddress, stackPointer, symbolTable)
+			if status == nil {
+				backgroundStep = s
+				return nil // Timed out; the call is still running.
+			}
+			if !isTrapStop(status) || currentThread != pid || getPC(pid) != returnAddress {
+				return status // Stopped at a breakpoint inside the callee.
+			}
+		}
+
+		if s.done(pid, symbolTable) {
+			return status
+		}
+	}
+}
+
+// done reports whether the tracee has left the line of s: returned to the
+// caller or, unless the function is running its deferred calls, on another
+// line of it.
+func (s *lineStep) done(pid int, symbolTable *gosym.Table) bool {
+	file, line, fn := symbolTable.PCToLine(getPC(pid))
+	if isDeferPlumbing(fn) && !isDeferPlumbing(s.fn) {
+		return false // A deferred function returned into the runtime.
+	}
+	if fn == nil || fn.Entry != s.fn.Entry {
+		return true // Returned to the caller.
+	}
+	return !s.unwinding && (line != s.line || file != s.file)
+}
+
+// finishBackgroundStep takes up the next left in backgroundStep once the
+// program, running in the background, has stopped.  If it stopped where the
+// call next was stepping over returns, next goes on to the end of the line
+// as it would have in the foreground, and the status of that is returned,
+// or nil if another call over-runs step-timeout.  A stop anywhere else ends
+// the next there.
+func finishBackgroundStep(status *syscall.WaitStatus, symbolTable *gosym.Table) *syscall.WaitStatus {
+	s := backgroundStep
+	backgroundStep = nil
+	tid := currentThread
+	if !isTrapStop(status) || tid != runToThread || getPC(tid) != runToAddress {
+		return status
+	}
+	endBackgroundRun(tid, status)
+	if s.done(tid, symbolTable) {
+		return status
+	}
+	return s.run(tid, symbolTable)
+}
+
+// finishCall runs until a call made with the stack pointer at stackPointer
+// returns to returnAddress.  Recursive invocations that pass through the same
+// address deeper in the stack are ignored, by waitForStop, so that a finish
+// left running in the background is checked the same way.
+func finishCall(pid int, returnAddress uint64, stackPointer uint64, symbolTable *gosym.Table) *syscall.WaitStatus {
+	runToStackPointer = stackPointer
+	return runToPC(pid, returnAddress, symbolTable)
+}
+
+// stepIntoDeferred is called with the status of the step that entered a
+// deferred call.  Calls into the runtime's defer machinery are single-stepped
+// until they reach the deferred function; the stop is then moved past that
+// function's prologue.
+func stepIntoDeferred(pid int, stackPointer uint64, status *syscall.WaitStatus, symbolTable *gosym.Table) *syscall.WaitStatus {
+	for i := 0; i < maxDeferSteps; i++ {
+		_, _, fn := symbolTable.PCToLine(getPC(pid))
+		if fn != nil && !strings.HasPrefix(fn.Name, "runtime.") {
+			return skipPrologue(pid, fn, status, symbolTable)
+		}
+
+		var regs syscall.PtraceRegs
+		if err := ptraceGetRegs(pid, &regs); err == nil && regs.Rsp > stackPointer {
+			break // The runtime returned without running anything.
+		}
+		status = step(pid)
+		if !isTrapStop(status) {
+			return status
+		}
+	}
+	return status
+}
+
+// skipPrologue single-steps from a function's entry until it leaves the
+// line the compiler attributes the prologue to.  status is that of the stop
+// at the entry.
+func skipPrologue(pid int, fn *gosym.Func, status *syscall.WaitStatus, symbolTable *gosym.Table) *syscall.WaitStatus {
+	_, entryLine, _ := symbolTable.PCToLine(fn.Entry)
+	for {
+		pc := getPC(pid)
+		_, line, current := symbolTable.PCToLine(pc)
+		if current == nil || current.Entry != fn.Entry || line != entryLine {
+			return status
+		}
+		status = step(pid)
+		if !isTrapStop(status) {
+			return status
+		}
+	}
+}
+
+// isDeferredCall reports whether a call to fn from callLine is part of
+// running deferred functions: either the runtime's deferreturn, a wrapper the
+// compiler generated for a defer statement, or a closure invoked on a line
+// from which the function returns.
+func isDeferredCall(fn *gosym.Func, callLine int, exitLines map[int]bool) bool {
+	if isDeferPlumbing(fn) {
+		return true
+	}
+	return exitLines[callLine] && closureName.MatchString(fn.Name)
+}
+
+// isDeferPlumbing reports whether fn only exists to run deferred calls: the
+// runtime's deferreturn or a wrapper generated for a defer statement.
+func isDeferPlumbing(fn *gosym.Func) bool {
+	return fn != nil && (fn.Name == "runtime.deferreturn" || strings.Contains(fn.Name, ".deferwrap"))
+}
+
+func functionExitLines(pid int, fn *gosym.Func, symbolTable *gosym.Table) map[int]bool {
+	lines := make(map[int]bool)
+	returns, err := functionReturns(pid, fn)
+	if err != nil {
+		return lines
+	}
+	for _, pc := range returns {
+		_, line, _ := symbolTable.PCToLine(pc)
+		lines[line] = true
+	}
+	return lines
+}
+
+func isTrapStop(status *syscall.WaitStatus) bool {
+	return status.Stopped() && status.StopSignal() == syscall.SIGTRAP
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// TestNextInBackground checks that a next whose call over-runs step-timeout
+// still stops on the next line once the call returns, not where it returns
+// to part way through the line.
+func TestNextInBackground(t *testing.T) {
+	program := testProgram(t, "sleep")
+	output := runDebugger(t, program,
+		"break main.main", "continue", "config step-timeout 100ms", "next", "wait")
+	for _, pattern := range []string{
+		`The call didn't return within 100ms`,
+		`(?m)^The program stopped at .*main\.go:17\.$`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Errorf("no match for %v in:\n%v", pattern, output)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxStopHistory is how many stops the debugger remembers, and
+	// defaultStopsShown how many stops lists without a count.
+	maxStopHistory    = 100
+	defaultStopsShown = 10
+)
+
+// stopRecord is a stop the user was shown, for retracing a long stepping
+// session.
+type stopRecord struct {
+	id       int
+	at       time.Time
+	tid      int
+	reason   string
+	file     string
+	line     int
+	function string
+}
+
+var (
+	// stopHistory is the last maxStopHistory stops, oldest first.
+	stopHistory []stopRecord
+	nextStopID  = 1
+)
+
+// recordStop remembers the stop at the current source line.
+func recordStop(reason string) {
+	record := stopRecord{id: nextStopID, at: time.Now(), tid: currentThread, reason: reason, file: pcSourceFile, line: pcSourceLine}
+	nextStopID++
+	if listingSymbols != nil {
+		if pc, _, err := listingSymbols.LineToPC(pcSourceFile, pcSourceLine); err == nil {
+			if fn := listingSymbols.PCToFunc(pc); fn != nil {
+				record.function = fn.Name
+			}
+		}
+	}
+	stopHistory = append(stopHistory, record)
+	if len(stopHistory) > maxStopHistory {
+		stopHistory = stopHistory[len(stopHistory)-maxStopHistory:]
+	}
+}
+
+func (r stopRecord) String() string {
+	where := fmt.Sprintf("%v:%v", r.file, r.line)
+	if r.function != "" {
+		where += " in " + r.function
+	}
+	return fmt.Sprintf("#%-4v %v  thread %v  %-10v %v", r.id, r.at.Format("15:04:05.000"), r.tid, r.reason, where)
+}
+
+// showStops implements "stops [<n>]", listing the last n stops, oldest
+// first.
+func showStops(argument string) error {
+	n := defaultStopsShown
+	if argument != "" {
+		var err error
+		if n, err = strconv.Atoi(argument); err != nil || n <= 0 {
+			return fmt.Errorf("usage: stops [<n>]")
+		}
+	}
+	if len(stopHistory) == 0 {
+		fmt.Println("The program hasn't stopped yet.")
+		return nil
+	}
+	shown := stopHistory
+	if len(shown) > n {
+		shown = shown[len(shown)-n:]
+	}
+	for _, r := range shown {
+		fmt.Println(r)
+	}
+	return nil
+}
+
+// gotoStop implements "stop goto <id>", listing the source where an
+// earlier stop was.  The program has run on since, so what is shown is
+// where it was, not its state then; a snapshot saved at the stop keeps
+// that.
+func gotoStop(argument string) error {
+	id, err := strconv.Atoi(argument)
+	if err != nil {
+		return fmt.Errorf("usage: stop goto <id>")
+	}
+	for _, r := range stopHistory {
+		if r.id != id {
+			continue
+		}
+		fmt.Println(r)
+		showListing(r.file, r.line)
+		if r.id != nextStopID-1 {
+			fmt.Println("The program has run on since; this is where it was, not its state then.")
+		}
+		return nil
+	}
+	if len(stopHistory) > 0 && id > 0 && id < stopHistory[0].id {
+		return fmt.Errorf("stop %v is older than the last %v remembered", id, maxStopHistory)
+	}
+	return fmt.Errorf("no stop %v; stops lists them", id)
+}
@@ -0,0 +1,69 @@
+package target
+
+import (
+	"fmt"
+	"sort"
+)
+
+// int3 is the one-byte trap instruction a breakpoint replaces code with.
+const int3 = 0xcc
+
+// SetBreakpoint writes an INT3 over the instruction byte at address,
+// keeping the byte to put back.  Setting one that is set already does
+// nothing.
+func (t *Target) SetBreakpoint(tid int, address uint64) error {
+	if _, ok := t.breakpoints[address]; ok {
+		return nil
+	}
+	original := make([]byte, 1)
+	if _, err := t.ReadMemory(tid, address, original); err != nil {
+		return fmt.Errorf("cannot set a breakpoint at 0x%x: %v", address, err)
+	}
+	if err := t.writeText(tid, address, []byte{int3}); err != nil {
+		return fmt.Errorf("cannot set a breakpoint at 0x%x: %v", address, err)
+	}
+	t.breakpoints[address] = original[0]
+	return nil
+}
+
+// ClearBreakpoint puts back the instruction byte the INT3 at address hid.
+// Clearing one that isn't set does nothing.
+func (t *Target) ClearBreakpoint(tid int, address uint64) error {
+	original, ok := t.breakpoints[address]
+	if !ok {
+		return nil
+	}
+	if err := t.writeText(tid, address, []byte{original}); err != nil {
+		return fmt.Errorf("cannot clear the breakpoint at 0x%x: %v", address, err)
+	}
+	delete(t.breakpoints, address)
+	return nil
+}
+
+// Breakpoint reports whether an INT3 is set at address, and the instruction
+// byte it hides.
+func (t *Target) Breakpoint(address uint64) (byte, bool) {
+	original, ok := t.breakpoints[address]
+	return original, ok
+}
+
+// Breakpoints returns the addresses of the INT3s set, in order.
+func (t *Target) Breakpoints() []uint64 {
+	addresses := make([]uint64, 0, len(t.breakpoints))
+	for address := range t.breakpoints {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
+
+func (t *Target) writeText(tid int, address uint64, data []byte) error {
+	if t.WriteText != nil {
+		return t.WriteText(tid, address, data)
+	}
+	n, err := t.WriteMemory(tid, address, data)
+	if err == nil && n != len(data) {
+		err = fmt.Errorf("wrote %v of %v bytes", n, len(data))
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package target
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// wrapperOptions follow everything a wrapper starts, so the program can be
+// caught however deep in its process tree it is run.
+const wrapperOptions = syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEFORK |
+	syscall.PTRACE_O_TRACEVFORK | syscall.PTRACE_O_TRACEEXEC
+
+// LaunchUntilExec starts a wrapper, such as a shell script or make, and
+// lets it and its children run until one of them executes a binary named
+// name, either its base name or its full path.  That process is returned
+// stopped just after the exec, and every other process is left to run on
+// untraced.
+func LaunchUntilExec(name string, path string, args []string, options Options) (*Target, error) {
+	cmd := command(path, args, options)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil); err != nil {
+		return nil, err
+	}
+	if !ws.Stopped() {
+		return nil, fmt.Errorf("%v exited before it started", path)
+	}
+	if isExecOf(pid, name) {
+		return launched(pid, pid)
+	}
+	if err := syscall.PtraceSetOptions(pid, wrapperOptions); err != nil {
+		return nil, err
+	}
+
+	// traced holds every thread being followed, and whether it is in a
+	// ptrace-stop.
+	traced := map[int]bool{pid: true}
+	resume := func(tid int, signal syscall.Signal) error {
+		traced[tid] = false
+		return syscall.PtraceCont(tid, int(signal))
+	}
+	if err := resume(pid, 0); err != nil {
+		return nil, err
+	}
+	for {
+		tid, err := syscall.Wait4(-1, &ws, syscall.WALL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%v exited without running %v", path, name)
+		}
+		if ws.Exited() || ws.Signaled() {
+			delete(traced, tid)
+			continue
+		}
+		traced[tid] = true
+		signal := ws.StopSignal()
+		switch {
+		case signal == syscall.SIGTRAP && ws.TrapCause() == syscall.PTRACE_EVENT_EXEC:
+			if isExecOf(tid, name) {
+				delete(traced, tid)
+				if err := releaseAll(traced); err != nil {
+					return nil, err
+				}
+				return launched(tid, pid)
+			}
+			signal = 0
+		case signal == syscall.SIGTRAP && ws.TrapCause() > 0:
+			signal = 0 // A fork, vfork or clone; the child stops by itself.
+		case signal == syscall.SIGSTOP:
+			signal = 0 // A new child's first stop.
+		}
+		if err := resume(tid, signal); err != nil && err != syscall.ESRCH {
+			return nil, err
+		}
+	}
+}
+
+// isExecOf reports whether the process has just executed the binary name.
+func isExecOf(pid int, name string) bool {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	return err == nil && (exe == name || filepath.Base(exe) == filepath.Base(name))
+}
+
+// launched sets up the program the wrapper ran as the traced process.  The
+// exec left it a single thread.
+func launched(pid int, wrapper int) (*Target, error) {
+	if err := syscall.PtraceSetOptions(pid, traceOptions); err != nil {
+		return nil, err
+	}
+	t := newTarget(pid, []int{pid})
+	t.wrapper = wrapper
+	return t, nil
+}
+
+// Wrapper returns the pid of the wrapper LaunchUntilExec started, which is
+// the debugger's child rather than the program's when it forked to run it,
+// or zero for a process launched or attached to directly.
+func (t *Target) Wrapper() int {
+	return t.wrapper
+}
+
+// releaseAll detaches from the wrapper's other threads.  Only a stopped
+// thread can be detached, so running ones are sent a SIGSTOP and let go
+// once it arrives; other signals they stop with on the way are passed on.
+func releaseAll(traced map[int]bool) error {
+	stopping := make(map[int]bool)
+	released := make(map[int]bool)
+	for tid, stopped := range traced {
+		if stopped {
+			ptraceDetach(tid, 0)
+			released[tid] = true
+		} else {
+			syscall.RawSyscall(syscall.SYS_TKILL, uintptr(tid), uintptr(syscall.SIGSTOP), 0)
+			stopping[tid] = true
+		}
+	}
+	for len(stopping) > 0 {
+		var ws syscall.WaitStatus
+		tid, err := syscall.Wait4(-1, &ws, syscall.WALL, nil)
+		if err != nil {
+			return nil // Nothing left to wait for.
+		}
+		if ws.Exited() || ws.Signaled() {
+			delete(stopping, tid)
+			continue
+		}
+		signal := ws.StopSignal()
+		switch {
+		case signal == syscall.SIGSTOP:
+			// Ours, or the first stop of a thread born on the way.
+			ptraceDetach(tid, 0)
+			delete(stopping, tid)
+			released[tid] = true
+			continue
+		case signal == syscall.SIGTRAP && ws.TrapCause() == syscall.PTRACE_EVENT_EXEC:
+			signal = 0
+		case signal == syscall.SIGTRAP && ws.TrapCause() > 0:
+			// A child born on the way is traced too, and stops by itself.
+			if child, err := syscall.PtraceGetEventMsg(tid); err == nil && !released[int(child)] {
+				stopping[int(child)] = true
+			}
+			signal = 0
+		}
+		if err := syscall.PtraceCont(tid, int(signal)); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// ptraceDetach detaches from a thread, delivering signal to it.
+func ptraceDetach(tid int, signal syscall.Signal) {
+	syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_DETACH, uintptr(tid), 0, uintptr(signal), 0, 0)
+}
@@ -0,0 +1,132 @@
+// Package target starts or attaches to a Linux/amd64 process under ptrace
+// and controls it a thread at a time: its registers and memory, single
+// steps and continues, and the INT3s written into its code, reporting
+// failures as errors.  Collecting the stops these lead to, and deciding
+// what they mean, is left to the debugger using it.
+//
+// The kernel accepts ptrace requests only from the thread that became the
+// tracer, so a Target must be used from one goroutine locked to its OS
+// thread with runtime.LockOSThread.
+package target
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// traceOptions has threads the tracee creates traced as well.
+const traceOptions = syscall.PTRACE_O_TRACECLONE
+
+// Target is a traced process, every one of whose threads is stopped when
+// it is handed over.
+type Target struct {
+	pid         int
+	threads     []int
+	wrapper     int
+	breakpoints map[uint64]byte // The instruction byte each INT3 hides.
+
+	// WriteText, when set, writes breakpoints into the program's code in
+	// place of WriteMemory, for a debugger that makes read-only text
+	// writable first.
+	WriteText func(tid int, address uint64, data []byte) error
+
+	// Log, when set, is told of every request made of a thread, with its
+	// outcome.
+	Log func(request string, tid int, detail string, err error)
+}
+
+func newTarget(pid int, threads []int) *Target {
+	return &Target{pid: pid, threads: threads, breakpoints: make(map[uint64]byte)}
+}
+
+// Options are how a launched program is run.
+type Options struct {
+	// Env holds NAME=value entries that override or add to the debugger's
+	// environment.
+	Env []string
+
+	// Stdin is what the program reads as standard input.  Without it the
+	// program reads /dev/null, and the debugger keeps its own.
+	Stdin *os.File
+}
+
+// Launch starts the program at path with the given arguments, stopped
+// before its first instruction.  It writes to the debugger's standard
+// output and error.
+func Launch(path string, args []string, options Options) (*Target, error) {
+	cmd := command(path, args, options)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil); err != nil {
+		return nil, err
+	}
+	if !ws.Stopped() {
+		return nil, fmt.Errorf("%v exited before it started", path)
+	}
+	if err := syscall.PtraceSetOptions(pid, traceOptions); err != nil {
+		return nil, err
+	}
+	return newTarget(pid, []int{pid}), nil
+}
+
+// command prepares a program to be started traced.
+func command(path string, args []string, options Options) *exec.Cmd {
+	cmd := exec.Command(path, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if options.Stdin != nil {
+		cmd.Stdin = options.Stdin
+	}
+	if len(options.Env) > 0 {
+		// The last of duplicate entries wins.
+		cmd.Env = append(os.Environ(), options.Env...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+	return cmd
+}
+
+// Attach stops a running process and every one of its threads.
+func Attach(pid int) (*Target, error) {
+	entries, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	t := newTarget(pid, nil)
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.PtraceAttach(tid); err != nil {
+			return nil, fmt.Errorf("attaching to thread %v: %v", tid, err)
+		}
+		var ws syscall.WaitStatus
+		if _, err := syscall.Wait4(tid, &ws, syscall.WALL, nil); err != nil {
+			return nil, err
+		}
+		if err := syscall.PtraceSetOptions(tid, traceOptions); err != nil {
+			return nil, err
+		}
+		t.threads = append(t.threads, tid)
+	}
+	return t, nil
+}
+
+// Pid returns the process id, that of its main thread.
+func (t *Target) Pid() int {
+	return t.pid
+}
+
+// Threads returns the ids of the process's threads, in order.
+func (t *Target) Threads() []int {
+	tids := append([]int(nil), t.threads...)
+	sort.Ints(tids)
+	return tids
+}
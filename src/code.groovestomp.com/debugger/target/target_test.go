@@ -0,0 +1,55 @@
+package target
+
+import (
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+// TestBreakpoint launches a program and sets and clears a breakpoint where
+// it is stopped, checking the code each time.
+func TestBreakpoint(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	tracee, err := Launch("/bin/true", nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := tracee.Pid()
+	defer func() {
+		syscall.Kill(pid, syscall.SIGKILL)
+		var ws syscall.WaitStatus
+		syscall.Wait4(pid, &ws, syscall.WALL, nil)
+	}()
+
+	regs, err := tracee.Registers(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := regs.PC()
+	code := make([]byte, 1)
+	if _, err := tracee.ReadMemory(pid, pc, code); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tracee.SetBreakpoint(pid, pc); err != nil {
+		t.Fatal(err)
+	}
+	written := make([]byte, 1)
+	if _, err := tracee.ReadMemory(pid, pc, written); err != nil || written[0] != int3 {
+		t.Errorf("read 0x%x, %v at the breakpoint, want an INT3", written[0], err)
+	}
+	if original, ok := tracee.Breakpoint(pc); !ok || original != code[0] {
+		t.Errorf("Breakpoint(0x%x) = 0x%x, %v, want 0x%x, true", pc, original, ok, code[0])
+	}
+
+	if err := tracee.ClearBreakpoint(pid, pc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracee.ReadMemory(pid, pc, written); err != nil || written[0] != code[0] {
+		t.Errorf("read 0x%x, %v after clearing, want 0x%x", written[0], err, code[0])
+	}
+	if len(tracee.Breakpoints()) != 0 {
+		t.Errorf("breakpoints left: %x", tracee.Breakpoints())
+	}
+}
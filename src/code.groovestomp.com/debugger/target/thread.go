@@ -0,0 +1,66 @@
+package target
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Registers returns the registers of thread tid.
+func (t *Target) Registers(tid int) (*syscall.PtraceRegs, error) {
+	regs := new(syscall.PtraceRegs)
+	err := syscall.PtraceGetRegs(tid, regs)
+	t.log("PTRACE_GETREGS", tid, fmt.Sprintf("pc=0x%x sp=0x%x", regs.PC(), regs.Rsp), err)
+	if err != nil {
+		return nil, err
+	}
+	return regs, nil
+}
+
+// SetRegisters changes the registers of thread tid.
+func (t *Target) SetRegisters(tid int, regs *syscall.PtraceRegs) error {
+	err := syscall.PtraceSetRegs(tid, regs)
+	t.log("PTRACE_SETREGS", tid, fmt.Sprintf("pc=0x%x sp=0x%x", regs.PC(), regs.Rsp), err)
+	return err
+}
+
+// ReadMemory reads len(data) bytes at address into data, as thread tid
+// sees them, returning how many it read.  Breakpoints show as INT3s; see
+// Breakpoint for the bytes they hide.
+func (t *Target) ReadMemory(tid int, address uint64, data []byte) (int, error) {
+	n, err := syscall.PtracePeekData(tid, uintptr(address), data)
+	t.log("PTRACE_PEEKDATA", tid, fmt.Sprintf("0x%x %v bytes", address, n), err)
+	return n, err
+}
+
+// WriteMemory writes data at address, returning how many bytes it wrote.
+func (t *Target) WriteMemory(tid int, address uint64, data []byte) (int, error) {
+	n, err := syscall.PtracePokeData(tid, uintptr(address), data)
+	t.log("PTRACE_POKEDATA", tid, fmt.Sprintf("0x%x % x", address, data), err)
+	return n, err
+}
+
+// Step has thread tid execute one instruction.  The stop that ends the
+// step is for the caller to wait for.
+func (t *Target) Step(tid int) error {
+	err := syscall.PtraceSingleStep(tid)
+	t.log("PTRACE_SINGLESTEP", tid, "", err)
+	return err
+}
+
+// Continue lets thread tid run, delivering signal to it unless it is zero.
+// A thread already gone, killed by another's exit_group, gives ESRCH.
+func (t *Target) Continue(tid int, signal syscall.Signal) error {
+	err := syscall.PtraceCont(tid, int(signal))
+	detail := ""
+	if signal != 0 {
+		detail = fmt.Sprintf("delivering signal %d", signal)
+	}
+	t.log("PTRACE_CONT", tid, detail, err)
+	return err
+}
+
+func (t *Target) log(request string, tid int, detail string, err error) {
+	if t.Log != nil {
+		t.Log(request, tid, detail, err)
+	}
+}
@@ -0,0 +1,18 @@
+// Command sleep makes a call that takes a while to return, for next to
+// step over.
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func nap() int {
+	time.Sleep(time.Second)
+	return 1
+}
+
+func main() {
+	naps := nap()
+	fmt.Println("naps:", naps)
+}
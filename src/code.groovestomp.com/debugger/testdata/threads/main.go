@@ -0,0 +1,27 @@
+// Command threads runs on several threads and exits straight after calling
+// greeting, while they are busy.
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func spin() {
+	for {
+		time.Sleep(time.Microsecond)
+	}
+}
+
+func greeting(name string) bool {
+	fmt.Printf("Hello %v\n", name)
+	return true
+}
+
+func main() {
+	for i := 0; i < 4; i++ {
+		go spin()
+	}
+	time.Sleep(10 * time.Millisecond)
+	greeting("threads")
+}
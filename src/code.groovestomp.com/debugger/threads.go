@@ -0,0 +1,466 @@
+package main
+
+import (
+	"debug/gosym"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// thread is one traced thread of the tracee.
+type thread struct {
+	tid     int
+	stopped bool
+
+	// signal is delivered to the thread the next time it is resumed.
+	signal syscall.Signal
+
+	// stopRequested is set while a SIGSTOP the debugger sent to pause the
+	// thread is still outstanding; that SIGSTOP is swallowed when it comes.
+	stopRequested bool
+
+	// starting is set for a new thread until the SIGSTOP it starts with.
+	starting bool
+}
+
+var (
+	// processID is the thread group leader, which identifies the process.
+	processID int
+
+	// currentThread is the thread commands operate on: the last one that
+	// stopped for a reason the user should see, or the one they selected.
+	currentThread int
+
+	threads = make(map[int]*thread)
+
+	// leaderExit is how the process ended, once the leader's exit has been
+	// collected somewhere other than waitForStop, which is to report it.
+	leaderExit *syscall.WaitStatus
+
+	// nonStop leaves the other threads running when one stops.
+	nonStop bool
+)
+
+// addThread starts tracking a thread.  Threads the tracee creates are
+// traced automatically and begin with a SIGSTOP of their own.
+func addThread(tid int, stopped bool) *thread {
+	t, ok := threads[tid]
+	if !ok {
+		t = &thread{tid: tid}
+		threads[tid] = t
+		openBranchRing(tid)
+	}
+	t.stopped = stopped
+	return t
+}
+
+func setPendingSignal(tid int, signal syscall.Signal) {
+	if t, ok := threads[tid]; ok {
+		t.signal = signal
+	}
+}
+
+func anyThreadRunning() bool {
+	for _, t := range threads {
+		if !t.stopped {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedThreads returns the threads ordered by id, the leader first.
+func sortedThreads() []*thread {
+	list := make([]*thread, 0, len(threads))
+	for _, t := range threads {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].tid < list[j].tid })
+	return list
+}
+
+// resumeThread lets a stopped thread run.  One that is gone, killed by
+// another thread's exit_group before it could be resumed, is forgotten;
+// waitForStop collects its exit.
+func resumeThread(t *thread) {
+	markRunning()
+	pendingPause = 0 // A pause is for the stop it came with.
+	err := ptraceCont(t.tid, int(t.signal))
+	if err == syscall.ESRCH {
+		delete(threads, t.tid)
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+	t.signal = 0
+	t.stopped = false
+}
+
+// threadExited forgets a thread whose exit was collected, keeping the
+// status of the leader for waitForStop.
+func threadExited(tid int, ws *syscall.WaitStatus) {
+	delete(threads, tid)
+	if tid == processID {
+		status := *ws
+		leaderExit = &status
+	}
+}
+
+// resume moves the current thread off any breakpoint it is stopped on and
+// lets the tracee run: every stopped thread, unless only the current one
+// may run.  It returns the status of the step off the breakpoint if that
+// step already ended the run, and nil otherwise.
+//
+// In non-stop mode the other stopped threads were each reported at their
+// breakpoint, so they are moved off it too rather than hitting it again.
+func resume(pid int, onlyCurrent bool) *syscall.WaitStatus {
+	if status := stepOverBreakpoint(pid); status != nil && !isTrapStop(status) {
+		return status // The stepped instruction exited or crashed.
+	}
+	crashed = false
+
+	for _, t := range sortedThreads() {
+		if !t.stopped || t.tid != pid && onlyCurrent {
+			continue
+		}
+		if t.tid != pid && nonStop {
+			if status := stepOverBreakpoint(t.tid); status != nil && (status.Exited() || status.Signaled()) {
+				threadExited(t.tid, status)
+				continue
+			}
+		}
+		resumeThread(t)
+	}
+	return nil
+}
+
+// isCloneEvent reports whether a stop is the tracee announcing a new thread.
+func isCloneEvent(ws syscall.WaitStatus) bool {
+	return ws.Stopped() && ws.StopSignal() == syscall.SIGTRAP && ws.TrapCause() == syscall.PTRACE_EVENT_CLONE
+}
+
+// noteClone records the thread a clone event announced.
+func noteClone(tid int) {
+	newTid, err := ptraceGetEventMsg(tid)
+	if err == nil {
+		if _, known := threads[int(newTid)]; !known {
+			addThread(int(newTid), false).starting = true
+		}
+		logThreadEvent(int(newTid), "started")
+	}
+}
+
+// waitForStop waits for the running tracee to stop in a way the user
+// should see, resuming threads past signals the runtime handles itself,
+// thread creation, group-stops and breakpoints whose condition is false.
+// pid is the thread that was resumed by the command.  With a non-zero
+// deadline it polls, and returns nil once the deadline has passed.  It
+// polls as well while the program shares the terminal, to pass on what is
+// typed to it.
+//
+// The thread that stopped becomes the current thread and, unless in
+// non-stop mode, every other thread is stopped too.
+func waitForStop(pid int, symbolTable *gosym.Table, deadline time.Time) *syscall.WaitStatus {
+	atomic.StoreInt32(&waitingForTracee, 1)
+	defer func() {
+		atomic.StoreInt32(&waitingForTracee, 0)
+		renewCommand()
+	}()
+	var ws syscall.WaitStatus
+	for {
+		if leaderExit != nil {
+			// The process ended while its threads were being stopped or
+			// resumed, and the leader was collected then; the reaper may
+			// have nothing left to wait for.
+			status := leaderExit
+			leaderExit = nil
+			threads = make(map[int]*thread)
+			markExited(status)
+			return status
+		}
+		options := syscall.WALL
+		if !deadline.IsZero() || programInput != nil || len(monitors) > 0 {
+			options |= syscall.WNOHANG
+		}
+		tid, err := waitFor(-1, &ws, options)
+		if err != nil {
+			fatal(err)
+		}
+		if tid == 0 {
+			if monitorDue() {
+				sampleMonitors(pid, symbolTable)
+			}
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return nil
+			}
+			forwardInput()
+			time.Sleep(time.Millisecond)
+			continue
+		}
+
+		_, known := threads[tid]
+		if !known && tid != processID && (ws.Exited() || ws.Signaled()) {
+			continue // Not a thread of the tracee, such as a wrapper.
+		}
+		t := addThread(tid, true)
+		if !known {
+			t.starting = true // Its SIGSTOP came before the clone event.
+		}
+		if ws.Exited() || ws.Signaled() {
+			delete(threads, tid)
+			if tid == processID {
+				markExited(&ws)
+				return &ws
+			}
+			logThreadEvent(tid, "exited")
+			continue
+		}
+
+		if isCloneEvent(ws) {
+			noteClone(tid)
+			resumeThread(t)
+			continue
+		}
+
+		signal := ws.StopSignal()
+		if signal == syscall.SIGSTOP && (t.stopRequested || t.starting) {
+			// A pause the debugger asked for, or a new thread starting.
+			if t.starting && len(watchpoints) > 0 {
+				armThreadWatchpoints(tid)
+			}
+			t.stopRequested, t.starting = false, false
+			resumeThread(t)
+			continue
+		}
+
+		// Ctrl-C reaches the tracee as SIGINT; it stops the program rather
+		// than being delivered to it.
+		if signal == syscall.SIGINT {
+			return stopped(tid, &ws, "interrupt")
+		}
+
+		if isGroupStop(tid, ws) {
+			resumeThread(t)
+			continue
+		}
+
+		// Signals the runtime handles itself, like the SIGURG used for
+		// goroutine preemption, are passed straight through.
+		if signal != syscall.SIGTRAP && !fatalSignals[signal] {
+			t.signal = signal
+			resumeThread(t)
+			continue
+		}
+
+		var hit uint64
+		if signal == syscall.SIGTRAP {
+			hit = watchTriggered(tid)
+		}
+		if hit&watchRegisterMask() != 0 {
+			// The write has happened; unless it changed nothing, stop
+			// just after it.
+			if !checkWatchpoints(tid, hit, symbolTable) {
+				resumeThread(t)
+				continue
+			}
+			status := stopped(tid, &ws, "watchpoint")
+			if err := armWatchpoints(); err != nil {
+				fmt.Println("Watchpoints:", err)
+			}
+			return status
+		}
+		reason := "signal"
+		if signal == syscall.SIGTRAP {
+			if hit == 0 {
+				// A hardware breakpoint stops before its instruction
+				// rather than after an INT3.
+				adjustPCAfterTrap(tid)
+			}
+			pc := getPC(tid)
+			bp := findBreakpoint(pc)
+			if bp == nil {
+				bp = allocEntryBreakpoint(pc)
+			}
+			if tid == runToThread && pc == runToAddress && inRunToFrame(tid) {
+				// The command's own stop; a user breakpoint here only
+				// counts a hit if its condition holds.
+				reason = ""
+				if bp != nil && bp.shouldStop(tid, symbolTable) {
+					reason = hitBreakpoint(bp)
+				}
+				return stopped(tid, &ws, reason)
+			}
+			if tid == runToThread && pc == runToAddress && bp == nil {
+				// A recursive call of the function being finished
+				// returning deeper in the stack.
+				if status := stepOverBreakpoint(tid); status != nil && !isTrapStop(status) {
+					return stopped(tid, status, "signal")
+				}
+				resumeThread(t)
+				continue
+			}
+			c, kind := findCatcher(pc)
+			passed := c != nil && bp == nil && c.filter != nil && !c.filter(tid, kind, symbolTable)
+			internal := isInserted(pc)
+			internal = internal && bp == nil && !isCatchAddress(pc)
+			if passed || bp != nil && !bp.shouldStop(tid, symbolTable) || internal && tid != pid {
+				// A catch with nothing to stop for, a false condition, or
+				// another thread crossing a temporary breakpoint set for the
+				// command's own thread.
+				if status := stepOverBreakpoint(tid); status != nil && !isTrapStop(status) {
+					return stopped(tid, status, "signal")
+				}
+				resumeThread(t)
+				continue
+			}
+			switch {
+			case bp != nil:
+				reason = hitBreakpoint(bp)
+			case isCatchAddress(pc):
+				reason = "catch"
+			default:
+				reason = "" // A temporary breakpoint ending a step.
+			}
+		}
+
+		return stopped(tid, &ws, reason)
+	}
+}
+
+// inRunToFrame reports whether a thread at runToAddress has got back to the
+// frame the run is for: with runToStackPointer set, one no deeper in the
+// stack than that.
+func inRunToFrame(tid int) bool {
+	if runToStackPointer == 0 {
+		return true
+	}
+	var regs syscall.PtraceRegs
+	return ptraceGetRegs(tid, &regs) != nil || regs.Rsp >= runToStackPointer
+}
+
+// hitBreakpoint counts a stop at bp and returns the reason for it.
+func hitBreakpoint(bp *breakpoint) string {
+	bp.hits++
+	publish(breakpointEvent{change: "hit", bp: bp})
+	pendingPause = bp.pause
+	pendingCommands = bp.commands
+	return "breakpoint"
+}
+
+// stopped makes tid the current thread and, in all-stop mode, stops the
+// rest of the process to match.  reason is why, for the session summary.
+func stopped(tid int, status *syscall.WaitStatus, reason string) *syscall.WaitStatus {
+	markStopped(reason)
+	holdMonitors()
+	currentThread = tid
+	if !nonStop {
+		stopOtherThreads(tid)
+	}
+	return status
+}
+
+// stopOtherThreads pauses every running thread except tid.  A thread that
+// stops for some other reason first keeps that reason for later: a signal
+// is held for its next resume, and a breakpoint trap is undone so the
+// thread hits the breakpoint again when it runs.
+func stopOtherThreads(tid int) {
+	for _, t := range sortedThreads() {
+		if t.tid == tid || t.stopped {
+			continue
+		}
+		if !t.starting {
+			tgkill(processID, t.tid, syscall.SIGSTOP)
+			t.stopRequested = true
+		}
+	}
+
+	for _, t := range sortedThreads() {
+		for t.tid != tid && !t.stopped {
+			var ws syscall.WaitStatus
+			if _, err := waitFor(t.tid, &ws, syscall.WALL); err != nil {
+				delete(threads, t.tid)
+				break
+			}
+			if ws.Exited() || ws.Signaled() {
+				threadExited(t.tid, &ws)
+				break
+			}
+			t.stopped = true
+
+			switch signal := ws.StopSignal(); {
+			case isCloneEvent(ws):
+				noteClone(t.tid)
+			case signal == syscall.SIGSTOP && t.starting:
+				t.starting = false
+			case signal == syscall.SIGSTOP && t.stopRequested:
+				t.stopRequested = false
+			case signal == syscall.SIGTRAP:
+				pc := getPC(t.tid)
+				if isInserted(pc - 1) {
+					setPC(t.tid, pc-1)
+				}
+			case signal != syscall.SIGSTOP:
+				t.signal = signal
+			}
+		}
+	}
+}
+
+// showThreads lists the threads with where each is stopped.
+func showThreads(symbolTable *gosym.Table) {
+	for _, t := range sortedThreads() {
+		marker := " "
+		if t.tid == currentThread {
+			marker = "*"
+		}
+		if !t.stopped {
+			fmt.Printf("%v %v (running)\n", marker, t.tid)
+			continue
+		}
+		pc := getPC(t.tid)
+		file, line, fn := symbolTable.PCToLine(pc)
+		if fn == nil {
+			fmt.Printf("%v %v 0x%x\n", marker, t.tid, pc)
+			continue
+		}
+		fmt.Printf("%v %v 0x%x in %v at %v:%v\n", marker, t.tid, pc, fn.Name, file, line)
+	}
+}
+
+// selectThread makes a stopped thread the current one.
+func selectThread(argument string) error {
+	tid, err := strconv.Atoi(argument)
+	if err != nil {
+		return fmt.Errorf("invalid thread id %q", argument)
+	}
+	t, ok := threads[tid]
+	if !ok {
+		return fmt.Errorf("no thread %v", tid)
+	}
+	if !t.stopped {
+		return fmt.Errorf("thread %v is running", tid)
+	}
+	currentThread = tid
+	return nil
+}
+
+// detachThreads lets a process that was attached to run on untraced.
+func detachThreads() {
+	for _, t := range sortedThreads() {
+		if !t.stopped {
+			tgkill(processID, t.tid, syscall.SIGSTOP)
+			var ws syscall.WaitStatus
+			waitFor(t.tid, &ws, syscall.WALL)
+		}
+	}
+	for _, address := range tracee.Breakpoints() {
+		clearBreakpoint(currentThread, address)
+	}
+	for _, t := range sortedThreads() {
+		ptraceDetach(t.tid)
+	}
+}
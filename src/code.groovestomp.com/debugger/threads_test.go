@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExitWithThreads runs a program to its exit while its other threads
+// are busy, which can kill them before the debugger has resumed them all.
+func TestExitWithThreads(t *testing.T) {
+	program := testProgram(t, "threads")
+	for i := 0; i < 20; i++ {
+		output := runDebugger(t, program, "break main.greeting", "continue", "continue")
+		if !strings.Contains(output, "Program exited with status 0.") {
+			t.Fatalf("run %v didn't see the program exit:\n%v", i, output)
+		}
+	}
+}
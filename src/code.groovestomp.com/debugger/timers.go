@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// timerZombie is the state bit the runtime's time.go sets on a timer that
+// was stopped but is still in a heap.
+const timerZombie = 4
+
+// pendingTimer is a runtime timer found in a P's heap.
+type pendingTimer struct {
+	address uint64
+	p       int
+	when    int64
+	period  int64
+	state   uint64
+	fn      string
+	arg     string
+}
+
+// showTimers implements "info timers", listing the timers in every P's heap
+// soonest first.
+func showTimers(pid int) error {
+	allp, err := readGlobal(pid, "runtime.allp")
+	if err != nil {
+		return err
+	}
+	ps, err := sliceElements(pid, allp, 1024)
+	if err != nil {
+		return err
+	}
+
+	var list []pendingTimer
+	for i, pointer := range ps {
+		p, err := dereference(pid, pointer)
+		if err != nil {
+			continue
+		}
+		heap := member(p, "timers", "heap")
+		if heap == nil {
+			return fmt.Errorf("unsupported runtime: runtime.p has no timers heap")
+		}
+		entries, err := sliceElements(pid, heap, maxGoroutines)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			address := scalarMember(entry, "timer")
+			t, err := readRuntimeStruct(pid, "runtime.timer", address)
+			if err != nil {
+				continue
+			}
+			list = append(list, readTimer(pid, i, t))
+		}
+	}
+	if len(list) == 0 {
+		fmt.Println("No pending timers.")
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].when < list[j].when })
+
+	now := monotonicNow()
+	for _, t := range list {
+		due := time.Duration(t.when - now)
+		due -= due % time.Millisecond
+		when := "in " + due.String()
+		if due < 0 {
+			when = (-due).String() + " overdue"
+		}
+		fmt.Printf("P%-3v 0x%x  %-16v", t.p, t.address, when)
+		if t.period > 0 {
+			fmt.Printf(" every %v", time.Duration(t.period))
+		}
+		fmt.Printf("  %v", t.fn)
+		if t.arg != "" {
+			fmt.Printf(" (%v)", t.arg)
+		}
+		if t.state&timerZombie != 0 {
+			fmt.Print(" stopped")
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func readTimer(pid int, p int, t *value) pendingTimer {
+	timer := pendingTimer{
+		address: t.addr,
+		p:       p,
+		when:    int64(scalarMember(t, "when")),
+		period:  int64(scalarMember(t, "period")),
+		state:   scalarMember(t, "state"),
+		fn:      formatFunction(pid, scalarMember(t, "f")),
+	}
+
+	// The argument is an interface; which of its forms this is follows from
+	// the callback the time package or runtime installed.
+	var data uint64
+	if arg := member(t, "arg"); arg != nil && len(arg.data) >= 16 {
+		data = binary.LittleEndian.Uint64(arg.data[8:])
+	}
+	switch timer.fn {
+	case "runtime.goroutineReady":
+		if g, err := readRuntimeStruct(pid, "runtime.g", data); err == nil {
+			timer.arg = fmt.Sprintf("wakes goroutine %v", scalarMember(g, "goid"))
+		}
+	case "time.sendTime":
+		timer.arg = fmt.Sprintf("sends on channel 0x%x", data)
+	case "time.goFunc":
+		timer.arg = "runs " + formatFunction(pid, data)
+	}
+	return timer
+}
+
+// monotonicNow reads CLOCK_MONOTONIC, the clock the runtime's nanotime and
+// so every timer's when is based on.
+func monotonicNow() int64 {
+	var ts syscall.Timespec
+	syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 1, uintptr(unsafe.Pointer(&ts)), 0)
+	return ts.Nano()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Constants from the time package's representation of time.Time.
+const (
+	timeHasMonotonic   = 1 << 63
+	timeNsecMask       = 1<<30 - 1
+	timeNsecShift      = 30
+	timeWallToInternal = (1884*365 + 1884/4 - 1884/100 + 1884/400) * 86400
+	timeUnixToInternal = (1969*365 + 1969/4 - 1969/100 + 1969/400) * 86400
+)
+
+// Integers in these ranges are taken to be Unix timestamps in seconds,
+// milliseconds or nanoseconds, covering the years 2001 to 2100.
+var timestampRanges = []struct {
+	low, high int64
+	unit      time.Duration
+}{
+	{1000000000, 4102444800, time.Second},
+	{1000000000000, 4102444800000, time.Millisecond},
+	{1000000000000000000, 4102444800000000000, time.Nanosecond},
+}
+
+// formatTime renders time.Time and time.Duration values in human form.  It
+// returns false for values of any other type.
+func formatTime(pid int, v *value) (string, bool) {
+	switch typeName(v.typ) {
+	case "time.Duration":
+		if len(v.data) != 8 {
+			return "", false
+		}
+		return fmt.Sprintf("time.Duration %v", time.Duration(signExtend(v.data))), true
+	case "time.Time":
+		t, ok := decodeTime(pid, v)
+		if !ok {
+			return "", false
+		}
+		return "time.Time " + t.Format("2006-01-02 15:04:05.999999999 -0700 MST"), true
+	}
+	return "", false
+}
+
+// decodeTime rebuilds a time.Time from its wall, ext and loc fields.
+func decodeTime(pid int, v *value) (time.Time, bool) {
+	st, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+	if !ok {
+		return time.Time{}, false
+	}
+	wallField, extField, locField := structField(st, "wall"), structField(st, "ext"), structField(st, "loc")
+	if wallField == nil || extField == nil || locField == nil {
+		return time.Time{}, false
+	}
+	wall := zeroExtend(fieldValue(v, wallField).data)
+	ext := signExtend(fieldValue(v, extField).data)
+
+	var sec int64
+	nsec := int64(wall & timeNsecMask)
+	if wall&timeHasMonotonic != 0 {
+		sec = timeWallToInternal + int64(wall<<1>>(timeNsecShift+1))
+	} else {
+		sec = ext
+	}
+	t := time.Unix(sec-timeUnixToInternal, nsec).In(timeLocation(pid, zeroExtend(fieldValue(v, locField).data)))
+	return t, true
+}
+
+// timeLocation looks up the tracee's *time.Location by name in the local
+// zone database; a nil location is UTC.
+func timeLocation(pid int, address uint64) *time.Location {
+	if address == 0 {
+		return time.UTC
+	}
+	header, err := readMemory(pid, address, 16)
+	if err != nil {
+		return time.UTC
+	}
+	length := binary.LittleEndian.Uint64(header[8:])
+	if length == 0 || length > maxStringLength {
+		return time.UTC
+	}
+	name, err := readMemory(pid, binary.LittleEndian.Uint64(header), int(length))
+	if err != nil {
+		return time.UTC
+	}
+	if string(name) == "Local" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(string(name))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// formatTimestamp appends the time an integer would be as a Unix timestamp
+// when its value looks like one.
+func formatTimestamp(n int64) string {
+	text := strconv.FormatInt(n, 10)
+	for _, r := range timestampRanges {
+		if n >= r.low && n < r.high {
+			t := time.Unix(0, 0).Add(time.Duration(n) * r.unit).UTC()
+			return fmt.Sprintf("%v (%v)", text, t.Format(time.RFC3339Nano))
+		}
+	}
+	return text
+}
@@ -0,0 +1,873 @@
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Limits applied when rendering values so that a corrupt length field can't
+// make the debugger read the whole address space.
+const (
+	maxStringLength = 1024
+	maxArrayValues  = 64
+	maxValueDepth   = 4
+)
+
+var errOptimizedOut = errors.New("<optimized out>")
+
+var (
+	dwarfData   *dwarf.Data
+	subprograms []*subprogram
+	globals     = make(map[string]*globalVariable)
+	namedTypes  = make(map[string]dwarf.Offset)
+
+	// mainProducer is the DW_AT_producer of the main package, which records
+	// the compiler flags it was built with.
+	mainProducer  string
+	debugLoclists []byte
+	debugLoc      []byte
+	debugAddr     []byte
+)
+
+type compileUnit struct {
+	entry    *dwarf.Entry
+	base     uint64
+	addrBase int64
+	version  int
+}
+
+// subprogram is a function's DWARF entry, indexed by its PC range.
+type subprogram struct {
+	name     string
+	low      uint64
+	high     uint64
+	offset   dwarf.Offset
+	unit     *compileUnit
+	declLine int64 // The line of the func keyword, or zero.
+}
+
+// globalVariable is a package-level variable's DWARF entry.
+type globalVariable struct {
+	entry *dwarf.Entry
+	unit  *compileUnit
+}
+
+// value is a typed piece of tracee state.  addr is zero when the value does
+// not live in memory, e.g. when it is held in registers.
+type value struct {
+	typ  dwarf.Type
+	addr uint64
+	data []byte
+}
+
+type variable struct {
+	name      string
+	parameter bool
+	val       *value
+	err       error
+}
+
+func loadDebugInfo(exe *elf.File) {
+	subprograms = nil
+	globals = make(map[string]*globalVariable)
+	namedTypes = make(map[string]dwarf.Offset)
+	mainProducer = ""
+
+	var err error
+	dwarfData, err = exe.DWARF()
+	if err != nil {
+		dwarfData = nil
+		return
+	}
+
+	sectionData := func(name string) []byte {
+		section := exe.Section(name)
+		if section == nil {
+			return nil
+		}
+		data, err := section.Data()
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	debugLoclists = sectionData(".debug_loclists")
+	debugLoc = sectionData(".debug_loc")
+	debugAddr = sectionData(".debug_addr")
+
+	indexDebugInfo(exe)
+
+	sort.Slice(subprograms, func(i, j int) bool {
+		return subprograms[i].low < subprograms[j].low
+	})
+}
+
+// warnIfOptimized tells the user when the main package was compiled without
+// -N -l, because variables and line stepping are unreliable in that case,
+// and returns true if it did.
+func warnIfOptimized() bool {
+	if mainProducer == "" {
+		return false
+	}
+	flags := strings.Fields(mainProducer[strings.Index(mainProducer, ";")+1:])
+	optimized, inlined := true, true
+	for _, flag := range flags {
+		switch flag {
+		case "-N":
+			optimized = false
+		case "-l":
+			inlined = false
+		}
+	}
+	if !optimized && !inlined {
+		return false
+	}
+
+	fmt.Println("Warning: the program was built with optimizations or inlining enabled.")
+	fmt.Println("Variables may be reported as optimized out and stepping may jump around.")
+	return true
+}
+
+// offerRebuild offers to rebuild binary with -N -l from the sources of its
+// main package, in the directory of mainFile, and returns true once it has.
+// A process attached to or run by a wrapper is only told how to.
+func offerRebuild(binary, mainFile string) bool {
+	dir := filepath.Dir(mainFile)
+	if info, err := os.Stat(dir); attachedToProcess || waitExec != "" || !inputStarted || err != nil || !info.IsDir() {
+		fmt.Println(`For a better experience rebuild it with: go build -gcflags=all="-N -l"`)
+		return false
+	}
+	binary, err := filepath.Abs(binary)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	fmt.Printf(`Rebuild it in %v with -gcflags=all="-N -l" and debug that? [y/N] `, dir)
+	answer, err := readLine()
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return false
+	}
+	cmd := exec.Command("go", "build", "-gcflags=all=-N -l", "-o", binary, ".")
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	status, err := runHelper(cmd)
+	if err != nil || status.ExitStatus() != 0 {
+		if err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println("The rebuild failed; debugging the program as it was.")
+		return false
+	}
+	return true
+}
+
+func findSubprogram(pc uint64) *subprogram {
+	i := sort.Search(len(subprograms), func(i int) bool {
+		return subprograms[i].high > pc
+	})
+	if i < len(subprograms) && subprograms[i].low <= pc {
+		return subprograms[i]
+	}
+	return nil
+}
+
+// frameVariables returns the parameters and local variables in scope at the
+// frame's PC.  Register-resident values can only be recovered for the
+// innermost frame, so regs should be nil for any other frame.
+func frameVariables(pid int, frame stackFrame, regs *syscall.PtraceRegs) ([]variable, error) {
+	if dwarfData == nil {
+		return nil, errors.New("no DWARF debug information in binary")
+	}
+	pc := frame.pc
+	if regs == nil {
+		pc-- // Return addresses belong to the next instruction.
+	}
+	sub := findSubprogram(pc)
+	if sub == nil {
+		return nil, fmt.Errorf("no debug information for %v", frame.fn.Name)
+	}
+
+	var variables []variable
+	err := forEachScopeVariable(sub, pc, func(entry *dwarf.Entry) {
+		name, _ := entry.Val(dwarf.AttrName).(string)
+		v := variable{
+			name:      name,
+			parameter: entry.Tag == dwarf.TagFormalParameter,
+		}
+		v.val, v.err = readVariable(pid, entry, sub.unit, frame, pc, regs)
+		variables = append(variables, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return variables, nil
+}
+
+// forEachScopeVariable calls f with the entry of each parameter and local
+// variable of sub in scope at pc, in the order they are declared.
+func forEachScopeVariable(sub *subprogram, pc uint64, f func(*dwarf.Entry)) error {
+	reader := dwarfData.Reader()
+	reader.Seek(sub.offset)
+	_, err := reader.Next()
+	if err != nil {
+		return err
+	}
+
+	depth := 1
+	for depth > 0 {
+		entry, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag == 0 {
+			depth--
+			continue
+		}
+
+		switch entry.Tag {
+		case dwarf.TagLexDwarfBlock:
+			ranges, _ := dwarfData.Ranges(entry)
+			if !rangesContain(ranges, pc) {
+				reader.SkipChildren()
+				continue
+			}
+		case dwarf.TagFormalParameter, dwarf.TagVariable:
+			f(entry)
+		}
+		if entry.Children {
+			if entry.Tag == dwarf.TagLexDwarfBlock {
+				depth++
+			} else {
+				reader.SkipChildren()
+			}
+		}
+	}
+	return nil
+}
+
+func rangesContain(ranges [][2]uint64, pc uint64) bool {
+	for _, r := range ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func readVariable(pid int, entry *dwarf.Entry, unit *compileUnit, frame stackFrame, pc uint64, regs *syscall.PtraceRegs) (*value, error) {
+	typeOffset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return nil, errors.New("variable has no type")
+	}
+	typ, err := dwarfData.Type(typeOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	var expression []byte
+	field := entry.AttrField(dwarf.AttrLocation)
+	if field == nil {
+		return nil, errOptimizedOut
+	}
+	switch field.Class {
+	case dwarf.ClassExprLoc:
+		expression = field.Val.([]byte)
+	case dwarf.ClassLocListPtr:
+		expression, err = locationListEntry(unit, field.Val.(int64), pc)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported location class %v", field.Class)
+	}
+
+	return evaluateLocation(pid, expression, typ, frame, regs)
+}
+
+// locationListEntry finds the location expression valid at pc in a
+// .debug_loclists (DWARF 5) or .debug_loc (DWARF 4) list.
+func locationListEntry(unit *compileUnit, offset int64, pc uint64) ([]byte, error) {
+	order := binary.LittleEndian
+	base := unit.base
+
+	if unit.version < 5 {
+		if offset >= int64(len(debugLoc)) {
+			return nil, errOptimizedOut
+		}
+		buf := bytes.NewBuffer(debugLoc[offset:])
+		for buf.Len() >= 16 {
+			start := order.Uint64(buf.Next(8))
+			end := order.Uint64(buf.Next(8))
+			if start == 0 && end == 0 {
+				break
+			}
+			if start == math.MaxUint64 {
+				base = end
+				continue
+			}
+			length := int(order.Uint16(buf.Next(2)))
+			expression := buf.Next(length)
+			if base+start <= pc && pc < base+end {
+				return expression, nil
+			}
+		}
+		return nil, errOptimizedOut
+	}
+
+	address := func(index uint64) uint64 {
+		position := unit.addrBase + int64(index)*8
+		if position+8 > int64(len(debugAddr)) {
+			return 0
+		}
+		return order.Uint64(debugAddr[position:])
+	}
+
+	if offset >= int64(len(debugLoclists)) {
+		return nil, errOptimizedOut
+	}
+	buf := bytes.NewBuffer(debugLoclists[offset:])
+	for buf.Len() > 0 {
+		kind, _ := buf.ReadByte()
+		var start, end uint64
+		switch kind {
+		case 0x00: // DW_LLE_end_of_list
+			return nil, errOptimizedOut
+		case 0x01: // DW_LLE_base_addressx
+			base = address(readULEB(buf))
+			continue
+		case 0x02: // DW_LLE_startx_endx
+			start = address(readULEB(buf))
+			end = address(readULEB(buf))
+		case 0x03: // DW_LLE_startx_length
+			start = address(readULEB(buf))
+			end = start + readULEB(buf)
+		case 0x04: // DW_LLE_offset_pair
+			start = base + readULEB(buf)
+			end = base + readULEB(buf)
+		case 0x05: // DW_LLE_default_location
+			start, end = 0, math.MaxUint64
+		case 0x06: // DW_LLE_base_address
+			base = order.Uint64(buf.Next(8))
+			continue
+		case 0x07: // DW_LLE_start_end
+			start = order.Uint64(buf.Next(8))
+			end = order.Uint64(buf.Next(8))
+		case 0x08: // DW_LLE_start_length
+			start = order.Uint64(buf.Next(8))
+			end = start + readULEB(buf)
+		default:
+			return nil, fmt.Errorf("unsupported location list entry 0x%x", kind)
+		}
+		expression := buf.Next(int(readULEB(buf)))
+		if start <= pc && pc < end {
+			return expression, nil
+		}
+	}
+	return nil, errOptimizedOut
+}
+
+// evaluateLocation runs a DWARF location expression and materializes the
+// value it describes.
+func evaluateLocation(pid int, expression []byte, typ dwarf.Type, frame stackFrame, regs *syscall.PtraceRegs) (*value, error) {
+	var stack []uint64
+	var data []byte
+	var address uint64
+	pieces := 0
+	register := -1
+
+	flush := func(size int) error {
+		switch {
+		case register >= 0:
+			if regs == nil {
+				return errOptimizedOut
+			}
+			word, ok := dwarfRegister(regs, register)
+			if !ok {
+				return fmt.Errorf("unsupported DWARF register %v", register)
+			}
+			piece := make([]byte, 8)
+			binary.LittleEndian.PutUint64(piece, word)
+			if size > 8 {
+				return errors.New("register piece larger than register")
+			}
+			data = append(data, piece[:size]...)
+		case len(stack) > 0:
+			piece, err := readMemory(pid, stack[len(stack)-1], size)
+			if err != nil {
+				return err
+			}
+			if pieces == 0 {
+				address = stack[len(stack)-1]
+			}
+			data = append(data, piece...)
+		default:
+			return errOptimizedOut
+		}
+		register = -1
+		stack = stack[:0]
+		pieces++
+		return nil
+	}
+
+	buf := bytes.NewBuffer(expression)
+	for buf.Len() > 0 {
+		op, _ := buf.ReadByte()
+		switch {
+		case op == 0x03: // DW_OP_addr
+			stack = append(stack, binary.LittleEndian.Uint64(buf.Next(8)))
+		case op == 0x23: // DW_OP_plus_uconst
+			if len(stack) == 0 {
+				return nil, errors.New("malformed location expression")
+			}
+			stack[len(stack)-1] += readULEB(buf)
+		case op >= 0x30 && op <= 0x4f: // DW_OP_lit0..31
+			stack = append(stack, uint64(op-0x30))
+		case op >= 0x50 && op <= 0x6f: // DW_OP_reg0..31
+			register = int(op - 0x50)
+		case op >= 0x70 && op <= 0x8f: // DW_OP_breg0..31
+			if regs == nil {
+				return nil, errOptimizedOut
+			}
+			word, _ := dwarfRegister(regs, int(op-0x70))
+			stack = append(stack, uint64(int64(word)+readSLEB(buf)))
+		case op == 0x90: // DW_OP_regx
+			register = int(readULEB(buf))
+		case op == 0x91: // DW_OP_fbreg
+			// Go always describes the frame base as DW_OP_call_frame_cfa.
+			stack = append(stack, uint64(int64(frame.cfa)+readSLEB(buf)))
+		case op == 0x93: // DW_OP_piece
+			size := int(readULEB(buf))
+			if register < 0 && len(stack) == 0 {
+				return nil, errOptimizedOut
+			}
+			if err := flush(size); err != nil {
+				return nil, err
+			}
+		case op == 0x9c: // DW_OP_call_frame_cfa
+			stack = append(stack, frame.cfa)
+		default:
+			return nil, fmt.Errorf("unsupported location operation 0x%x", op)
+		}
+	}
+
+	if pieces == 0 {
+		if register < 0 && len(stack) > 0 {
+			address = stack[len(stack)-1]
+			mem, err := readMemory(pid, address, int(typ.Size()))
+			if err != nil {
+				return nil, err
+			}
+			return &value{typ: typ, addr: address, data: mem}, nil
+		}
+		if err := flush(int(typ.Size())); err != nil {
+			return nil, err
+		}
+		address = 0
+	} else if register >= 0 || len(stack) > 0 {
+		return nil, errors.New("malformed location expression")
+	}
+
+	if pieces > 1 {
+		address = 0 // The value is scattered; it has no single address.
+	}
+	return &value{typ: typ, addr: address, data: data}, nil
+}
+
+func dwarfRegister(regs *syscall.PtraceRegs, number int) (uint64, bool) {
+	switch number {
+	case 0:
+		return regs.Rax, true
+	case 1:
+		return regs.Rdx, true
+	case 2:
+		return regs.Rcx, true
+	case 3:
+		return regs.Rbx, true
+	case 4:
+		return regs.Rsi, true
+	case 5:
+		return regs.Rdi, true
+	case 6:
+		return regs.Rbp, true
+	case 7:
+		return regs.Rsp, true
+	case 8:
+		return regs.R8, true
+	case 9:
+		return regs.R9, true
+	case 10:
+		return regs.R10, true
+	case 11:
+		return regs.R11, true
+	case 12:
+		return regs.R12, true
+	case 13:
+		return regs.R13, true
+	case 14:
+		return regs.R14, true
+	case 15:
+		return regs.R15, true
+	case 16:
+		return regs.Rip, true
+	}
+	return 0, false
+}
+
+// typeName returns the Go spelling of a DWARF type.
+func typeName(typ dwarf.Type) string {
+	if name := typ.Common().Name; name != "" {
+		return name
+	}
+	if st, ok := typ.(*dwarf.StructType); ok && st.StructName != "" {
+		return st.StructName
+	}
+	if ptr, ok := typ.(*dwarf.PtrType); ok {
+		return "*" + typeName(ptr.Type)
+	}
+	return typ.String()
+}
+
+func resolveTypedef(typ dwarf.Type) dwarf.Type {
+	for {
+		typedef, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			return typ
+		}
+		typ = typedef.Type
+	}
+}
+
+func formatValue(pid int, v *value) string {
+	return formatValueDepth(pid, v, 0)
+}
+
+func formatValueDepth(pid int, v *value, depth int) string {
+	if v.typ == untypedStringType {
+		return strconv.Quote(string(v.data))
+	}
+	if renderTimes {
+		if text, ok := formatTime(pid, v); ok {
+			return text
+		}
+	}
+	name := typeName(v.typ)
+	if text, ok := formatStdlibValue(pid, v); ok {
+		return name + " " + text
+	}
+	if text, ok := formatStoredStack(pid, v); ok {
+		return name + " " + text
+	}
+	typ := resolveTypedef(v.typ)
+	order := binary.LittleEndian
+
+	switch t := typ.(type) {
+	case *dwarf.BoolType:
+		return strconv.FormatBool(len(v.data) > 0 && v.data[0] != 0)
+	case *dwarf.IntType:
+		if renderTimes && len(v.data) >= 4 {
+			return formatTimestamp(signExtend(v.data))
+		}
+		return strconv.FormatInt(signExtend(v.data), 10)
+	case *dwarf.UintType:
+		if n := zeroExtend(v.data); renderTimes && len(v.data) >= 4 && n < 1<<63 {
+			return formatTimestamp(int64(n))
+		}
+		return strconv.FormatUint(zeroExtend(v.data), 10)
+	case *dwarf.CharType:
+		return strconv.FormatInt(signExtend(v.data), 10)
+	case *dwarf.UcharType:
+		return strconv.FormatUint(zeroExtend(v.data), 10)
+	case *dwarf.FloatType:
+		if len(v.data) == 4 {
+			return strconv.FormatFloat(float64(math.Float32frombits(order.Uint32(v.data))), 'g', -1, 32)
+		}
+		return strconv.FormatFloat(math.Float64frombits(order.Uint64(v.data)), 'g', -1, 64)
+	case *dwarf.ComplexType:
+		half := len(v.data) / 2
+		real := &value{typ: &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: int64(half)}}}, data: v.data[:half]}
+		imag := &value{typ: real.typ, data: v.data[half:]}
+		return fmt.Sprintf("(%v+%vi)", formatValue(pid, real), formatValue(pid, imag))
+	case *dwarf.PtrType:
+		address := zeroExtend(v.data)
+		if address == 0 {
+			return fmt.Sprintf("%v nil", name)
+		}
+		if strings.HasPrefix(name, "map[") || strings.HasPrefix(name, "chan ") {
+			return formatReference(pid, name, address)
+		}
+		if text, ok := formatProtoPointer(pid, v, depth); ok {
+			return text
+		}
+		if text, ok := formatStdlibPointer(pid, v); ok {
+			return text
+		}
+		return fmt.Sprintf("(%v)(0x%x)", name, address)
+	case *dwarf.StructType:
+		switch {
+		case name == "string" || isStringStruct(t):
+			return formatString(pid, v)
+		case strings.HasPrefix(name, "[]"):
+			return formatSlice(pid, v, t, depth)
+		case name == "error":
+			return formatErrorChain(pid, v, depth)
+		case name == "runtime.iface" || name == "runtime.eface" || t.StructName == "runtime.iface" || t.StructName == "runtime.eface":
+			return formatInterface(pid, name, v)
+		}
+		if isProtoMessage(t) {
+			return formatProtoMessage(pid, v, t, depth)
+		}
+		if depth >= maxValueDepth {
+			return name + " {...}"
+		}
+		fields := make([]string, 0, len(t.Field))
+		for _, field := range t.Field {
+			if commandCancelled() != nil {
+				fields = append(fields, "...interrupted")
+				break
+			}
+			fv := fieldValue(v, field)
+			if fv == nil {
+				fields = append(fields, field.Name+": ?")
+				continue
+			}
+			fields = append(fields, field.Name+": "+formatValueDepth(pid, fv, depth+1))
+		}
+		return fmt.Sprintf("%v {%v}", name, strings.Join(fields, ", "))
+	case *dwarf.ArrayType:
+		if isByteType(t.Type) {
+			return name + " " + formatBytes(v.data, int64(len(v.data)))
+		}
+		count := t.Count
+		if count < 0 {
+			count = 0
+		}
+		elemSize := t.Type.Size()
+		elements := make([]string, 0)
+		for i := int64(0); i < count && i < maxArrayValues; i++ {
+			start := i * elemSize
+			if start+elemSize > int64(len(v.data)) {
+				break
+			}
+			if commandCancelled() != nil {
+				elements = append(elements, "...interrupted")
+				break
+			}
+			elem := &value{typ: t.Type, data: v.data[start : start+elemSize]}
+			if v.addr != 0 {
+				elem.addr = v.addr + uint64(start)
+			}
+			elements = append(elements, formatValueDepth(pid, elem, depth+1))
+		}
+		if count > maxArrayValues && commandCancelled() == nil {
+			elements = append(elements, fmt.Sprintf("...+%v more", count-maxArrayValues))
+		}
+		return fmt.Sprintf("%v [%v]", name, strings.Join(elements, ", "))
+	case *dwarf.FuncType:
+		return formatFunction(pid, zeroExtend(v.data))
+	case *dwarf.UnspecifiedType:
+		return fmt.Sprintf("(%v)(0x%x)", name, zeroExtend(v.data))
+	}
+
+	if strings.HasPrefix(name, "func(") {
+		return formatFunction(pid, zeroExtend(v.data))
+	}
+	return fmt.Sprintf("(%v) %x", name, v.data)
+}
+
+func fieldValue(v *value, field *dwarf.StructField) *value {
+	size := field.Type.Size()
+	if field.ByteOffset+size > int64(len(v.data)) || size < 0 {
+		return nil
+	}
+	fv := &value{typ: field.Type, data: v.data[field.ByteOffset : field.ByteOffset+size]}
+	if v.addr != 0 {
+		fv.addr = v.addr + uint64(field.ByteOffset)
+	}
+	return fv
+}
+
+func structField(t *dwarf.StructType, name string) *dwarf.StructField {
+	for _, field := range t.Field {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+func signExtend(data []byte) int64 {
+	switch len(data) {
+	case 1:
+		return int64(int8(data[0]))
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(data)))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(data)))
+	case 8:
+		return int64(binary.LittleEndian.Uint64(data))
+	}
+	return 0
+}
+
+func zeroExtend(data []byte) uint64 {
+	var result uint64
+	for i := len(data) - 1; i >= 0 && i < 8; i-- {
+		result = result<<8 | uint64(data[i])
+	}
+	return result
+}
+
+func formatString(pid int, v *value) string {
+	if len(v.data) < 16 {
+		return "\"\""
+	}
+	address := binary.LittleEndian.Uint64(v.data)
+	length := int64(binary.LittleEndian.Uint64(v.data[8:]))
+	if length == 0 {
+		return "\"\""
+	}
+	if length < 0 {
+		return fmt.Sprintf("<invalid string length %v>", length)
+	}
+	truncated := length > maxStringLength
+	if truncated {
+		length = maxStringLength
+	}
+	contents, err := readMemory(pid, address, int(length))
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	result := strconv.Quote(string(contents))
+	if truncated {
+		result += "..."
+	}
+	return result
+}
+
+func formatSlice(pid int, v *value, t *dwarf.StructType, depth int) string {
+	name := typeName(v.typ)
+	arrayField := structField(t, "array")
+	lenField := structField(t, "len")
+	capField := structField(t, "cap")
+	if arrayField == nil || lenField == nil || capField == nil {
+		return name + " {?}"
+	}
+
+	address := zeroExtend(fieldValue(v, arrayField).data)
+	length := signExtend(fieldValue(v, lenField).data)
+	capacity := signExtend(fieldValue(v, capField).data)
+	if address == 0 {
+		return fmt.Sprintf("%v nil", name)
+	}
+
+	elemType := arrayField.Type.(*dwarf.PtrType).Type
+	elemSize := elemType.Size()
+	if isByteType(elemType) && length >= 0 {
+		shown := length
+		if shown > maxStringLength {
+			shown = maxStringLength
+		}
+		contents, err := readMemory(pid, address, int(shown))
+		if err != nil {
+			return fmt.Sprintf("%v len: %v, cap: %v, <%v>", name, length, capacity, err)
+		}
+		return fmt.Sprintf("%v len: %v, cap: %v, %v", name, length, capacity, formatBytes(contents, length))
+	}
+	shown := length
+	if shown > maxArrayValues {
+		shown = maxArrayValues
+	}
+	if shown < 0 || elemSize < 0 {
+		return fmt.Sprintf("%v len: %v, cap: %v, <invalid>", name, length, capacity)
+	}
+
+	contents, err := readMemory(pid, address, int(shown*elemSize))
+	if err != nil {
+		return fmt.Sprintf("%v len: %v, cap: %v, <%v>", name, length, capacity, err)
+	}
+	elements := make([]string, 0, shown)
+	for i := int64(0); i < shown; i++ {
+		if commandCancelled() != nil {
+			elements = append(elements, "...interrupted")
+			break
+		}
+		elem := &value{typ: elemType, addr: address + uint64(i*elemSize), data: contents[i*elemSize : (i+1)*elemSize]}
+		elements = append(elements, formatValueDepth(pid, elem, depth+1))
+	}
+	if length > shown && commandCancelled() == nil {
+		elements = append(elements, fmt.Sprintf("...+%v more", length-shown))
+	}
+	return fmt.Sprintf("%v len: %v, cap: %v, [%v]", name, length, capacity, strings.Join(elements, ", "))
+}
+
+// formatReference renders maps and channels, whose Go types are pointers to
+// runtime structures that all keep their element count in the first word.
+func formatReference(pid int, name string, address uint64) string {
+	count, err := readUint64(pid, address)
+	if err != nil {
+		return fmt.Sprintf("%v <%v>", name, err)
+	}
+	return fmt.Sprintf("%v len: %v (0x%x)", name, count, address)
+}
+
+func formatInterface(pid int, name string, v *value) string {
+	if len(v.data) < 16 {
+		return name + " {?}"
+	}
+	tab := binary.LittleEndian.Uint64(v.data)
+	data := binary.LittleEndian.Uint64(v.data[8:])
+	if tab == 0 {
+		return name + " nil"
+	}
+	return fmt.Sprintf("%v {tab: 0x%x, data: 0x%x}", name, tab, data)
+}
+
+func formatFunction(pid int, closure uint64) string {
+	if closure == 0 {
+		return "nil"
+	}
+	entry, err := readUint64(pid, closure)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	if sub := findSubprogram(entry); sub != nil {
+		return sub.name
+	}
+	return fmt.Sprintf("func 0x%x", entry)
+}
+
+func showVariables(pid int, variables []variable) {
+	for _, v := range variables {
+		if v.err != nil {
+			fmt.Printf("%v = %v\n", v.name, v.err)
+			continue
+		}
+		fmt.Printf("%v = %v\n", v.name, formatValue(pid, v.val))
+	}
+}
+
+// isStringStruct reports whether a structure is the representation of a
+// string, as it is for named string types like type key string.
+func isStringStruct(t *dwarf.StructType) bool {
+	return len(t.Field) == 2 && t.Field[0].Name == "str" && t.Field[1].Name == "len"
+}
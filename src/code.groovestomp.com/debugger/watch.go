@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// debugRegisterOffset is the offset of u_debugreg in the kernel's struct
+// user on linux/amd64, which PTRACE_PEEKUSER and PTRACE_POKEUSER address.
+const debugRegisterOffset = 848
+
+// debugRegisters is the number of address registers, DR0 to DR3, and so
+// the number of slots a watchpoint can use.
+const debugRegisters = 4
+
+// watchpoint is a variable watched for writes with the CPU's debug
+// registers.  A global is found by name, anything else by evaluating name
+// in the frame selected when it was set, after which the watchpoint stays
+// on that memory.  With a condition, a write only stops the tracee when the
+// condition evaluates to true.
+type watchpoint struct {
+	name     string
+	address  uint64
+	typ      dwarf.Type
+	snapshot []byte // The typed value as of the last stop.
+	slots    []watchSlot
+
+	condition string
+	cond      expr
+
+	// once is set with -init: the first write stops the tracee, whether it
+	// changes the value or not, and removes the watchpoint.
+	once bool
+}
+
+// watchSlot is an aligned range of 1, 2, 4 or 8 bytes covered by one debug
+// register.
+type watchSlot struct {
+	address uint64
+	size    uint64
+}
+
+var watchpoints []*watchpoint
+
+// runWatchCommand implements "watch <expression> [if <condition>]", "watch
+// -init <global>" and "watch -growth <map-or-slice>", or lists the
+// watchpoints without an argument.
+func runWatchCommand(pid int, argument string, symbolTable *gosym.Table) error {
+	if argument == "-growth" || strings.HasPrefix(argument, "-growth ") {
+		return runWatchGrowthCommand(pid, strings.TrimSpace(strings.TrimPrefix(argument, "-growth")), symbolTable)
+	}
+	if argument == "" {
+		if len(watchpoints) == 0 && len(growthWatches) == 0 {
+			fmt.Println("No watchpoints.")
+		}
+		for _, g := range growthWatches {
+			fmt.Printf("%v at 0x%x, growth: %v\n", g.name, g.address, g.describe(pid))
+		}
+		for _, w := range watchpoints {
+			once := ""
+			if w.once {
+				once = ", until first written"
+			}
+			fmt.Printf("%v at 0x%x, %v bytes%v\n", w.name, w.address, len(w.snapshot), once)
+			if w.condition != "" {
+				fmt.Printf("  if %v\n", w.condition)
+			}
+		}
+		return nil
+	}
+	once := strings.HasPrefix(argument, "-init ")
+	if once {
+		argument = strings.TrimSpace(strings.TrimPrefix(argument, "-init "))
+		if _, ok := globals[argument]; !ok {
+			if _, ok := globals["main."+argument]; !ok {
+				return fmt.Errorf("-init watches a global; there is no global variable named %v", argument)
+			}
+		}
+	}
+	name, condition := splitCondition(argument)
+	if once && condition != "" {
+		return fmt.Errorf("-init stops at the first write; it takes no condition")
+	}
+	for _, w := range watchpoints {
+		if w.name == name || w.name == "main."+name {
+			return fmt.Errorf("%v is already watched", w.name)
+		}
+	}
+	var cond expr
+	if condition != "" {
+		var err error
+		if cond, err = parseExpression(condition); err != nil {
+			return fmt.Errorf("bad condition %q: %v", condition, err)
+		}
+	}
+	w, err := newWatchpoint(pid, name, symbolTable)
+	if err != nil {
+		return err
+	}
+	w.condition, w.cond, w.once = condition, cond, once
+	if used := debugRegistersUsed(); used+len(w.slots) > debugRegisters {
+		return fmt.Errorf("%v needs %v debug registers, %v are free", w.name, len(w.slots), debugRegisters-used)
+	}
+	watchpoints = append(watchpoints, w)
+	if err := armWatchpoints(); err != nil {
+		watchpoints = watchpoints[:len(watchpoints)-1]
+		return err
+	}
+	fmt.Printf("Watchpoint on %v at 0x%x: %v\n", w.name, w.address, formatValue(pid, w.current()))
+	if once && !initializing(pid, symbolTable) {
+		fmt.Println("Packages have been initialized already; restart to catch the first write.")
+	}
+	return nil
+}
+
+// runUnwatchCommand implements "unwatch <expression>".
+func runUnwatchCommand(pid int, argument string) error {
+	if runUnwatchGrowth(pid, argument) {
+		return nil
+	}
+	for i, w := range watchpoints {
+		if w.name == argument || w.name == "main."+argument {
+			watchpoints = append(watchpoints[:i], watchpoints[i+1:]...)
+			return armWatchpoints()
+		}
+	}
+	return fmt.Errorf("%v is not watched", argument)
+}
+
+func newWatchpoint(pid int, name string, symbolTable *gosym.Table) (*watchpoint, error) {
+	var v *value
+	var err error
+	for _, candidate := range []string{name, "main." + name} {
+		if _, ok := globals[candidate]; ok {
+			name = candidate
+			v, err = readGlobal(pid, candidate)
+			break
+		}
+	}
+	if v == nil && err == nil {
+		var ctx *evalContext
+		if ctx, err = newEvalContext(pid, symbolTable); err == nil {
+			v, err = evaluate(ctx, name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.addr == 0 || len(v.data) == 0 {
+		return nil, fmt.Errorf("%v has no address to watch", name)
+	}
+	slots := watchSlots(v.addr, uint64(len(v.data)))
+	if len(slots) > debugRegisters {
+		return nil, fmt.Errorf("%v is %v bytes; the debug registers can watch at most %v", name, len(v.data), debugRegisters*8)
+	}
+	return &watchpoint{name: name, address: v.addr, typ: v.typ, snapshot: v.data, slots: slots}, nil
+}
+
+// watchSlots splits a range into the naturally aligned pieces the debug
+// registers require.
+func watchSlots(address, size uint64) []watchSlot {
+	var slots []watchSlot
+	end := address + size
+	for address < end {
+		n := uint64(8)
+		for address%n != 0 || address+n > end {
+			n /= 2
+		}
+		slots = append(slots, watchSlot{address, n})
+		address += n
+	}
+	return slots
+}
+
+func (w *watchpoint) current() *value {
+	return &value{typ: w.typ, addr: w.address, data: w.snapshot}
+}
+
+// armWatchpoints loads the watchpoints into the debug registers of every
+// thread.
+func armWatchpoints() error {
+	for tid := range threads {
+		if err := armThreadWatchpoints(tid); err != nil {
+			return fmt.Errorf("thread %v: %v", tid, err)
+		}
+	}
+	return nil
+}
+
+// armThreadWatchpoints programs one thread's debug registers, with the
+// watchpoints and then the hardware breakpoints.  Each thread has its own,
+// and new threads start with them clear.
+func armThreadWatchpoints(tid int) error {
+	var control uint64
+	register := 0
+	for _, w := range watchpoints {
+		for _, slot := range w.slots {
+			if err := pokeDebugRegister(tid, register, slot.address); err != nil {
+				return err
+			}
+			// Local enable, break on writes, and the length encoding of DR7.
+			length := map[uint64]uint64{1: 0, 2: 1, 4: 3, 8: 2}[slot.size]
+			control |= 1<<(2*uint(register)) | (1|length<<2)<<(16+4*uint(register))
+			register++
+		}
+	}
+	for _, bp := range hardwareBreakpoints() {
+		if err := pokeDebugRegister(tid, register, bp.pc); err != nil {
+			return err
+		}
+		// Local enable; zero in the other fields breaks on execution.
+		control |= 1 << (2 * uint(register))
+		register++
+	}
+	return pokeDebugRegister(tid, 7, control)
+}
+
+func pokeDebugRegister(tid int, register int, data uint64) error {
+	_, errno := ptraceRaw("PTRACE_POKEUSER", syscall.PTRACE_POKEUSR, tid, uintptr(debugRegisterOffset+register*8), uintptr(data))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func peekDebugRegister(tid int, register int) (uint64, error) {
+	var data uint64
+	_, errno := ptraceRaw("PTRACE_PEEKUSER", syscall.PTRACE_PEEKUSR, tid, uintptr(debugRegisterOffset+register*8), uintptr(unsafe.Pointer(&data)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return data, nil
+}
+
+// watchTriggered returns the debug registers whose watchpoints or hardware
+// breakpoints a SIGTRAP came from, one bit each, or zero if it came from
+// none.  It clears the debug status register for the next one.
+func watchTriggered(tid int) uint64 {
+	if len(watchpoints) == 0 && len(hardwareBreakpoints()) == 0 {
+		return 0
+	}
+	status, err := peekDebugRegister(tid, 6)
+	if err != nil || status&(1<<debugRegisters-1) == 0 {
+		return 0
+	}
+	pokeDebugRegister(tid, 6, 0)
+	return status & (1<<debugRegisters - 1)
+}
+
+// checkWatchpoints compares every watched variable with its snapshot and
+// prints a diff of what changed, field by field.  A -init watchpoint whose
+// registers are among those hit reports the write whatever it wrote, and is
+// removed, for the caller to arm the rest once every thread has stopped.
+// It returns false if a write left the values as they were, or no condition
+// of those it changed was true.
+func checkWatchpoints(pid int, hit uint64, symbolTable *gosym.Table) bool {
+	changed := false
+	register := uint(0)
+	var remaining []*watchpoint
+	for _, w := range watchpoints {
+		mask := uint64(1<<uint(len(w.slots))-1) << register
+		register += uint(len(w.slots))
+		data, err := readMemory(pid, w.address, len(w.snapshot))
+		if err == nil && w.once && hit&mask != 0 {
+			pc := getPC(pid)
+			file, line, fn := symbolTable.PCToLine(pc)
+			where := fmt.Sprintf("0x%x", pc)
+			if fn != nil {
+				where = fmt.Sprintf("%v at %v:%v", fn.Name, file, line)
+			}
+			fmt.Printf("Watchpoint %v first written by %v:\n", w.name, where)
+			if bytes.Equal(data, w.snapshot) {
+				fmt.Printf("  %v: %v (unchanged)\n", w.name, formatValue(pid, w.current()))
+			} else {
+				diffValues(pid, w.name, &value{typ: w.typ, data: w.snapshot}, &value{typ: w.typ, addr: w.address, data: data})
+			}
+			changed = true
+			continue
+		}
+		remaining = append(remaining, w)
+		if err != nil || bytes.Equal(data, w.snapshot) {
+			continue
+		}
+		if !w.shouldStop(pid, symbolTable) {
+			w.snapshot = data
+			continue
+		}
+		fmt.Printf("Watchpoint %v changed:\n", w.name)
+		diffValues(pid, w.name, &value{typ: w.typ, data: w.snapshot}, &value{typ: w.typ, addr: w.address, data: data})
+		w.snapshot = data
+		changed = true
+	}
+	watchpoints = remaining
+	return changed
+}
+
+// shouldStop evaluates the watchpoint's condition in the innermost frame of
+// the thread that made the write.  Like a breakpoint's, a condition that
+// can't be evaluated stops the tracee.
+func (w *watchpoint) shouldStop(pid int, symbolTable *gosym.Table) bool {
+	if w.cond == nil {
+		return true
+	}
+	ctx, err := newEvalContext(pid, symbolTable)
+	if err == nil {
+		var result *value
+		result, err = ctx.eval(w.cond)
+		endEvaluation()
+		if err == nil {
+			return isTrue(result)
+		}
+	}
+	fmt.Printf("Error in watchpoint condition %q: %v\n", w.condition, err)
+	return true
+}
+
+// diffValues prints the leaves of two values of the same type that differ.
+// Structures and arrays are compared member by member; strings, slices and
+// interfaces are leaves, compared by their headers.
+func diffValues(pid int, path string, old, new *value) {
+	if bytes.Equal(old.data, new.data) {
+		return
+	}
+	switch t := resolveTypedef(new.typ).(type) {
+	case *dwarf.StructType:
+		name := typeName(new.typ)
+		if name == "string" || strings.HasPrefix(name, "[]") || strings.HasSuffix(t.StructName, "face") {
+			break
+		}
+		for _, field := range t.Field {
+			of, nf := fieldValue(old, field), fieldValue(new, field)
+			if of != nil && nf != nil {
+				diffValues(pid, path+"."+field.Name, of, nf)
+			}
+		}
+		return
+	case *dwarf.ArrayType:
+		size := t.Type.Size()
+		if isByteType(t.Type) || size <= 0 {
+			break
+		}
+		for i := int64(0); (i+1)*size <= int64(len(new.data)); i++ {
+			element := func(v *value) *value {
+				return &value{typ: t.Type, data: v.data[i*size : (i+1)*size]}
+			}
+			diffValues(pid, fmt.Sprintf("%v[%v]", path, i), element(old), element(new))
+		}
+		return
+	}
+	fmt.Printf("  %v: %v -> %v\n", path, formatValue(pid, old), formatValue(pid, new))
+}
+
+// rearmWatchpoints finds the watched variables again after a restart.  Only
+// globals can be found before the program has run any of its code.
+func rearmWatchpoints(pid int, symbolTable *gosym.Table) {
+	previous := watchpoints
+	watchpoints = nil
+	for _, old := range previous {
+		w, err := newWatchpoint(pid, old.name, symbolTable)
+		if err != nil {
+			fmt.Printf("  watch %v: %v, removed\n", old.name, err)
+			continue
+		}
+		w.condition, w.cond, w.once = old.condition, old.cond, old.once
+		watchpoints = append(watchpoints, w)
+	}
+	if err := armWatchpoints(); err != nil {
+		fmt.Println("Watchpoints:", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchdogGrace is how long after its warning the watchdog continues the
+// program or detaches, for someone at the prompt to stop it.
+const watchdogGrace = 10 * time.Second
+
+var (
+	// maxStopDuration is how long a process that was attached to may stay
+	// stopped with no one typing at the prompt before the watchdog acts, or
+	// 0 for as long as it likes.  maxStopAction is what it does: warn,
+	// continue or detach.
+	maxStopDuration time.Duration
+	maxStopAction   = "warn"
+
+	// attachedToProcess is set when the debugger attached to a running
+	// process, a service whose traffic stalls while it is stopped.
+	attachedToProcess bool
+
+	// idleSince is when the program last stopped or the user last typed
+	// at the prompt, and watchdogWarned when the watchdog last warned.
+	idleSince      = time.Now()
+	watchdogWarned time.Time
+)
+
+// noteActivity restarts the watchdog: the program has just stopped, or
+// someone is at the prompt.
+func noteActivity() {
+	idleSince, watchdogWarned = time.Now(), time.Time{}
+}
+
+// stopWatchdog warns once a process that was attached to has been stopped
+// for maxStopDuration with no one at the prompt, returning true if it did.
+// With maxStopAction continue or detach, it returns the command that does
+// that once watchdogGrace has passed after the warning.
+func stopWatchdog() (string, bool) {
+	if !attachedToProcess || maxStopDuration <= 0 || running {
+		return "", false
+	}
+	if !watchdogWarned.IsZero() {
+		if maxStopAction != "warn" && time.Since(watchdogWarned) >= watchdogGrace {
+			noteActivity()
+			if maxStopAction == "detach" {
+				return "quit", false
+			}
+			return "continue", false
+		}
+		return "", false
+	}
+	idle := time.Since(idleSince)
+	if idle < maxStopDuration {
+		return "", false
+	}
+	fmt.Fprintf(os.Stderr, "\a\n*** WARNING: process %v has been stopped for %v with no one at the prompt; whatever it serves is stalled. ***\n",
+		processID, idle.Round(time.Second))
+	switch maxStopAction {
+	case "continue":
+		fmt.Fprintf(os.Stderr, "*** Continuing it in %v; type anything to stay stopped. ***\n", watchdogGrace)
+	case "detach":
+		fmt.Fprintf(os.Stderr, "*** Detaching from it in %v; type anything to stay attached. ***\n", watchdogGrace)
+	default:
+		// Warned again after as long once more.
+		idleSince = time.Now()
+		return "", true
+	}
+	watchdogWarned = time.Now()
+	return "", true
+}
+
+func parseMaxStopAction(value string) error {
+	switch value {
+	case "warn", "continue", "detach":
+		maxStopAction = value
+		return nil
+	}
+	return fmt.Errorf("expected warn, continue or detach, got %q", value)
+}